@@ -3,13 +3,17 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/NailUsmanov/practicum-shortener-url/internal/app"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/grpcserver"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/service"
 	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
 	"github.com/NailUsmanov/practicum-shortener-url/pkg/config"
 	"go.uber.org/zap"
@@ -23,6 +27,12 @@ var (
 
 func main() {
 	fmt.Printf("Build version: %s\nBuild date: %s\nBuild commit: %s\n", buildVersion, buildDate, buildCommit)
+	if len(os.Args) > 1 && os.Args[1] == migrateDataCommand {
+		if err := runMigrateData(os.Args[2:]); err != nil {
+			log.Fatalf("migrate-data: %v", err)
+		}
+		return
+	}
 	go func() {
 		log.Println("pprof listening on http://localhost:6060")
 		log.Println(http.ListenAndServe("localhost:6060", nil))
@@ -44,33 +54,79 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	keys, err := storage.NewKeyGenerator(cfg.KeyGenerator, cfg.KeyLength, cfg.KeyAlphabet, cfg.KeySecretBytes())
+	if err != nil {
+		log.Fatalf("Failed to configure key generator: %v", err)
+	}
+
 	var store storage.Storage
 
 	if cfg.SaveInFile != "" {
 		sugar.Infof("Using file storage at: %s", cfg.SaveInFile)
-		store, err = storage.NewFileStorage(cfg.SaveInFile)
+		store, err = storage.NewFileStorage(cfg.SaveInFile,
+			storage.WithCompactThreshold(cfg.FileCompactThreshold),
+			storage.WithStrict(cfg.StrictStorage),
+			storage.WithFlushInterval(cfg.FileFlushInterval),
+			storage.WithBatchSize(cfg.FileBatchSize),
+			storage.WithFollower(cfg.FileFollowInterval),
+			storage.WithKeyGenerator(keys),
+		)
 		if err != nil {
 			sugar.Fatalf("failed to initialize file storage: %v", err)
 		}
 		sugar.Info("Using file storage")
+	} else if cfg.BoltStoragePath != "" {
+		store, err = storage.NewBoltStorage(cfg.BoltStoragePath, storage.WithKeyGenerator(keys))
+		if err != nil {
+			sugar.Fatalf("failed to initialize bolt storage: %v", err)
+		}
+		sugar.Infof("Using bolt storage at: %s", cfg.BoltStoragePath)
 	} else if cfg.DataBase != "" {
-		store, err = storage.NewDataBaseStorage(cfg.DataBase)
+		db, err := storage.NewDataBaseStorage(cfg.DataBase, storage.WithKeyGenerator(keys))
 		if err != nil {
 			log.Fatalf("Failed to load DataBase: %v", err)
 		}
+		store = db
+		if cfg.CacheSize > 0 {
+			store = storage.NewCachedStorage(db, cfg.CacheSize, cfg.CacheTTL, cfg.CacheNegativeTTL)
+			sugar.Infof("Using redirect cache for %d links", cfg.CacheSize)
+		}
 
+	} else if cfg.MemoryShards != 0 {
+		store = storage.NewShardedMemoryStorage(cfg.MemoryShards, storage.WithKeyGenerator(keys))
+		sugar.Info("Using sharded in-memory storage")
 	} else {
-		store = storage.NewMemoryStorage()
+		store = storage.NewMemoryStorage(storage.WithKeyGenerator(keys))
 		sugar.Info("Using in-memory storage")
 	}
 
-	application := app.NewApp(store, cfg.BaseURL, sugar)
+	// HTTP и gRPC считают неверные пароли защищённых ссылок вместе
+	attempts := service.NewAttemptLimiter(service.MaxPasswordAttempts, service.PasswordAttemptWindow)
+
+	application := app.NewApp(store, cfg.BaseURL, sugar, cfg.SigningKeys(),
+		app.WithTrustedSubnet(cfg.TrustedNet()),
+		app.WithReaper(cfg.ReaperInterval, cfg.ReaperBatchSize),
+		app.WithTrashRetention(cfg.TrashRetention, cfg.TrashPurgeInterval),
+		app.WithClickAnalytics(cfg.AnalyticsBufferSize, cfg.AnalyticsFlushInterval),
+		app.WithAnalyticsSecret([]byte(cfg.AnalyticsSecret)),
+		app.WithRedirectPolicy(cfg.RedirectType, cfg.RedirectCacheMaxAge),
+		app.WithAttemptLimiter(attempts),
+	)
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
-	// Закрываем соединение только для БД
-	if dbStore, ok := store.(*storage.DataBaseStorage); ok {
-		defer dbStore.Close()
+
+	// gRPC-сервер работает параллельно с HTTP поверх того же хранилища
+	grpcServer := grpcserver.NewServer(store, cfg.BaseURL, sugar, cfg.SigningKeys(), service.WithAttemptLimiter(attempts))
+	go func() {
+		sugar.Infof("gRPC server listening on %s", cfg.GRPCAddr)
+		if err := grpcServer.Run(ctx, cfg.GRPCAddr); err != nil {
+			sugar.Errorf("gRPC server error: %v", err)
+		}
+	}()
+	// Закрываем соединение с БД или дописываем очередь файлового хранилища
+	if closer, ok := store.(io.Closer); ok {
+		defer closer.Close()
 	}
 	// Составляем защищенное соединение
 	if cfg.EnableHTTPS {
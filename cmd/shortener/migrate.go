@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/NailUsmanov/practicum-shortener-url/internal/migrate"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+)
+
+// migrateDataCommand - имя команды переноса данных между хранилищами.
+const migrateDataCommand = "migrate-data"
+
+// migrationStorage - хранилище, которое может быть и источником, и приёмником переноса.
+type migrationStorage interface {
+	storage.RecordScanner
+	storage.RecordLoader
+}
+
+// runMigrateData выполняет команду migrate-data: переносит все записи из хранилища --from в --to.
+//
+// Сервер, использующий эти хранилища, должен быть остановлен.
+func runMigrateData(args []string) error {
+	flags := flag.NewFlagSet(migrateDataCommand, flag.ContinueOnError)
+	from := flags.String("from", "", "source storage: file://path, bolt://path or postgres://dsn")
+	to := flags.String("to", "", "destination storage: file://path, bolt://path or postgres://dsn")
+	statePath := flags.String("state", "migrate-data.state", "file with progress for resuming an interrupted migration")
+	batchSize := flags.Int("batch", migrate.DefaultBatchSize, "records per batch")
+	skipHistory := flags.Bool("skip-history", false, "confirm that link revision history and click events stay behind: they cannot be migrated")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return errors.New("both --from and --to are required")
+	}
+	if *from == *to {
+		return errors.New("--from and --to must be different storages")
+	}
+	if !*skipHistory {
+		return fmt.Errorf("%w: pass --skip-history to copy only link records and leave them in the source", migrate.ErrHistoryNotMigrated)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	src, err := openMigrationStorage(*from)
+	if err != nil {
+		return fmt.Errorf("open source: %w", err)
+	}
+	defer closeStorage(src)
+	dst, err := openMigrationStorage(*to)
+	if err != nil {
+		return fmt.Errorf("open destination: %w", err)
+	}
+	defer closeStorage(dst)
+
+	m := migrate.New(src, dst,
+		migrate.WithBatchSize(*batchSize),
+		migrate.WithState(*statePath, migrate.Job(*from, *to)),
+		migrate.WithProgress(os.Stdout),
+		migrate.WithSkipHistory(*skipHistory),
+	)
+	res, err := m.Run(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("interrupted after %d records, run the command again to resume: %w", res.Copied, err)
+		}
+		return err
+	}
+	fmt.Printf("migrated %d records, verified %d records with checksum %s\n", res.Copied, res.Target.Count, res.Target.Checksum)
+	fmt.Printf("not migrated: %s\n", migrate.NotMigrated)
+	return nil
+}
+
+// openMigrationStorage открывает хранилище по адресу file://путь, bolt://путь или postgres://...
+//
+// Хранилище в памяти не переживает процесс, поэтому переносить из него или в него нечего.
+func openMigrationStorage(addr string) (migrationStorage, error) {
+	switch {
+	case strings.HasPrefix(addr, "file://"):
+		// Повреждённые записи не пропускаются молча: перенос должен быть полным
+		return storage.NewFileStorage(strings.TrimPrefix(addr, "file://"), storage.WithStrict(true))
+	case strings.HasPrefix(addr, "bolt://"):
+		return storage.NewBoltStorage(strings.TrimPrefix(addr, "bolt://"))
+	case strings.HasPrefix(addr, "postgres://"), strings.HasPrefix(addr, "postgresql://"):
+		return storage.NewDataBaseStorage(addr)
+	}
+	return nil, fmt.Errorf("unsupported storage %q: use file://path, bolt://path or postgres://dsn", addr)
+}
+
+// closeStorage закрывает хранилище, если оно держит файл или соединение.
+func closeStorage(s migrationStorage) {
+	if closer, ok := s.(io.Closer); ok {
+		closer.Close()
+	}
+}
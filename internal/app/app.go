@@ -5,12 +5,14 @@ package app
 
 import (
 	"context"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"time"
 
 	"github.com/NailUsmanov/practicum-shortener-url/internal/handlers"
 	"github.com/NailUsmanov/practicum-shortener-url/internal/middleware"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/service"
 	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
 	"github.com/NailUsmanov/practicum-shortener-url/internal/tasks"
 	"github.com/go-chi/chi"
@@ -19,27 +21,85 @@ import (
 
 // App инкапсулирует конфигурацию HTTP-сервера.
 //
-// Включает маршрутизатор chi, хранилище, базовый URL, логгер и канал для фонового удаления URL.
+// Включает маршрутизатор chi, хранилище, базовый URL, логгер, ключи подписи куки и каналы для фонового
+// удаления URL и записи переходов.
 type App struct {
 	router     *chi.Mux
 	storage    storage.Storage
 	baseURL    string
 	sugar      *zap.SugaredLogger
+	secretKeys [][]byte
 	deleteChan chan tasks.DeleteTask
+
+	trustedSubnet *net.IPNet
+
+	reapInterval time.Duration // Период пометки истёкших ссылок, <= 0 - выключено
+	reapBatch    int
+
+	trashRetention time.Duration // Срок хранения удалённых ссылок, <= 0 - хранятся бессрочно
+	purgeInterval  time.Duration
+
+	clickChan          chan tasks.ClickTask // nil, если учёт переходов выключен
+	clickBuffer        int
+	clickFlushInterval time.Duration
+	ipSecret           []byte // Секрет хеширования IP в событиях переходов, пустой - IP не сохраняются
+
+	redirectPolicy handlers.RedirectPolicy // Статус и кеширование редиректа по умолчанию
+	attempts       *service.AttemptLimiter // Общий с gRPC счётчик неверных паролей, nil - собственный
+}
+
+// Option настраивает App при создании.
+type Option func(*App)
+
+// WithTrustedSubnet задаёт подсеть, из которой доступны внутренние эндпоинты /api/internal/*.
+func WithTrustedSubnet(subnet *net.IPNet) Option {
+	return func(a *App) {
+		a.trustedSubnet = subnet
+	}
+}
+
+// WithRedirectPolicy задаёт статус редиректа и время его кеширования в секундах для ссылок,
+// у которых они не заданы при создании. Нулевой status означает 307, нулевой maxAge - не кешировать.
+func WithRedirectPolicy(status int, maxAge int64) Option {
+	return func(a *App) {
+		a.redirectPolicy = handlers.RedirectPolicy{Status: status, CacheMaxAge: maxAge}
+	}
+}
+
+// WithAttemptLimiter задаёт счётчик неверных паролей защищённых ссылок, общий с другими
+// серверами процесса, чтобы подбор пароля через разные API упирался в один лимит.
+func WithAttemptLimiter(l *service.AttemptLimiter) Option {
+	return func(a *App) {
+		a.attempts = l
+	}
 }
 
 // NewApp создаёт и настраивает экземпляр App.
 //
-// Регистрирует маршруты и middleware.
-func NewApp(s storage.Storage, baseURL string, sugar *zap.SugaredLogger) *App {
+// Регистрирует маршруты и middleware. secretKeys - ключи подписи куки пользователя,
+// первый из них основной.
+func NewApp(s storage.Storage, baseURL string, sugar *zap.SugaredLogger, secretKeys [][]byte, opts ...Option) *App {
 	r := chi.NewRouter()
 	app := &App{
 		router:     r, //разыменовываем указатель
 		storage:    s,
 		baseURL:    baseURL,
 		sugar:      sugar,
+		secretKeys: secretKeys,
 		deleteChan: make(chan tasks.DeleteTask, 1000),
+
+		reapInterval: defaultReapInterval,
+		reapBatch:    defaultReapBatch,
+
+		purgeInterval: defaultPurgeInterval,
+
+		clickBuffer:        defaultClickBuffer,
+		clickFlushInterval: defaultClickFlushInterval,
+	}
+	for _, opt := range opts {
+		opt(app)
 	}
+	app.setupClicks()
 	app.setupRoutes()
 	return app
 }
@@ -48,17 +108,36 @@ func (a *App) setupRoutes() {
 
 	// MiddleWare
 	a.router.Use(middleware.LoggingMiddleware(a.sugar))
-	a.router.Use(middleware.AuthMiddleware)
+	a.router.Use(middleware.AuthMiddleware(a.secretKeys))
 	a.router.Use(middleware.GzipMiddleware)
 
 	a.router.Post("/", handlers.NewCreateShortURL(a.storage, a.baseURL, a.sugar))
-	a.router.Get("/{id}", handlers.NewRedirect(a.storage, a.sugar))
+	// GET и POST формы пароля обслуживает один обработчик, чтобы попытки подбора считались вместе
+	policy := a.redirectPolicy
+	policy.Attempts = a.attempts
+	redirect := handlers.NewRedirect(a.storage, a.sugar, a.clickChan, policy)
+	a.router.Get("/{id}", redirect)
+	a.router.Post("/{id}", redirect)
 	a.router.Get("/ping", handlers.NewPingHandler(a.storage, a.sugar))
 
 	a.router.Post("/api/shorten", handlers.NewCreateShortURLJSON(a.storage, a.baseURL, a.sugar))
 	a.router.Post("/api/shorten/batch", handlers.NewCreateBatchJSON(a.storage, a.baseURL, a.sugar))
 	a.router.Get("/api/user/urls", handlers.GetUserURLS(a.storage, a.baseURL, a.sugar))
 	a.router.Delete("/api/user/urls", handlers.DeleteHandler(a.storage, a.sugar, a.deleteChan))
+	a.router.Get("/api/user/urls/export", handlers.ExportHandler(a.storage, a.baseURL, a.sugar))
+	a.router.Post("/api/user/urls/import", handlers.ImportHandler(a.storage, a.baseURL, a.sugar))
+	a.router.Get("/api/user/urls/trash", handlers.GetTrash(a.storage, a.baseURL, a.sugar))
+	a.router.Post("/api/user/urls/restore", handlers.RestoreHandler(a.storage, a.sugar))
+	a.router.Get("/api/user/urls/{id}/stats", handlers.GetLinkStats(a.storage, a.baseURL, a.sugar))
+	a.router.Patch("/api/user/urls/{id}", handlers.UpdateLink(a.storage, a.baseURL, a.sugar))
+	a.router.Get("/api/user/urls/{id}/revisions", handlers.GetLinkRevisions(a.storage, a.baseURL, a.sugar))
+
+	// Внутренние эндпоинты доступны только из доверенной подсети
+	a.router.Group(func(r chi.Router) {
+		r.Use(middleware.TrustedSubnetMiddleware(a.trustedSubnet))
+		r.Post("/api/internal/compact", handlers.NewCompactHandler(a.storage, a.sugar))
+		r.Get("/api/internal/cache", handlers.NewCacheStatsHandler(a.storage, a.sugar))
+	})
 }
 
 // Run запускает HTTP-сервер на указанном адресе.
@@ -79,6 +158,9 @@ func (a *App) Run(ctx context.Context, addr string) error {
 			}
 		}
 	}()
+	a.startReaper(ctx)
+	a.startPurger(ctx)
+	a.startClickWriter(ctx)
 
 	go func() {
 		<-ctx.Done()
@@ -102,6 +184,9 @@ func (a *App) RunHTTPS(ctx context.Context, addr, certFile, keyFile string) erro
 		Addr:    addr,
 		Handler: a.router,
 	}
+	a.startReaper(ctx)
+	a.startPurger(ctx)
+	a.startClickWriter(ctx)
 	go func() {
 		<-ctx.Done()
 		a.sugar.Infof("Shutdown the server")
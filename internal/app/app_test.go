@@ -2,14 +2,19 @@ package app
 
 import (
 	"context"
+	"errors"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/NailUsmanov/practicum-shortener-url/internal/middleware"
 	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/tasks"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
@@ -20,7 +25,7 @@ func TestAppRoutes(t *testing.T) {
 	mockStore := storage.NewMemoryStorage()
 	logger := zap.NewNop()
 	defer logger.Sync()
-	app := NewApp(mockStore, "http://test", logger.Sugar())
+	app := NewApp(mockStore, "http://test", logger.Sugar(), [][]byte{[]byte("test-secret")})
 
 	t.Run("Create and redirect URL", func(t *testing.T) {
 		// Шаг 1: Создаем короткую ссылку
@@ -57,6 +62,40 @@ func TestAppRoutes(t *testing.T) {
 	})
 }
 
+func TestAppInternalCompact(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, err)
+
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "storage.json"))
+	require.NoError(t, err)
+	app := NewApp(store, "http://test", zap.NewNop().Sugar(), [][]byte{[]byte("test-secret")}, WithTrustedSubnet(subnet))
+
+	t.Run("Trusted client", func(t *testing.T) {
+		req := newTestRequest(t, http.MethodPost, "/api/internal/compact", nil)
+		req.Header.Set("X-Real-IP", "127.0.0.1")
+		rec := httptest.NewRecorder()
+		app.router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Untrusted client", func(t *testing.T) {
+		req := newTestRequest(t, http.MethodPost, "/api/internal/compact", nil)
+		req.Header.Set("X-Real-IP", "10.0.0.1")
+		rec := httptest.NewRecorder()
+		app.router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("Storage without compaction", func(t *testing.T) {
+		memApp := NewApp(storage.NewMemoryStorage(), "http://test", zap.NewNop().Sugar(), [][]byte{[]byte("test-secret")}, WithTrustedSubnet(subnet))
+		req := newTestRequest(t, http.MethodPost, "/api/internal/compact", nil)
+		req.Header.Set("X-Real-IP", "127.0.0.1")
+		rec := httptest.NewRecorder()
+		memApp.router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	})
+}
+
 // Вспомогательная функция для создания запросов
 func newTestRequest(t *testing.T, method, path string, body io.Reader) *http.Request {
 	req, err := http.NewRequest(method, path, body)
@@ -70,3 +109,89 @@ func readBody(t *testing.T, res *http.Response) string {
 	require.NoError(t, err, "Failed to read response body")
 	return string(body)
 }
+
+func TestAppReaper(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := storage.NewMemoryStorage()
+	key, err := store.SaveLink(ctx, storage.Link{
+		OriginalURL: "https://example.com",
+		UserID:      "test_user",
+		ExpiresAt:   time.Now().Add(-time.Second),
+	})
+	require.NoError(t, err)
+
+	app := NewApp(store, "http://test", zap.NewNop().Sugar(), [][]byte{[]byte("test-secret")},
+		WithReaper(10*time.Millisecond, 1))
+	app.startReaper(ctx)
+
+	assert.Eventually(t, func() bool {
+		_, err := store.Get(ctx, key)
+		return errors.Is(err, storage.ErrDeleted)
+	}, time.Second, 10*time.Millisecond, "expired link is marked deleted by the reaper")
+}
+
+func TestAppPurger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := storage.NewMemoryStorage()
+	key, err := store.Save(ctx, "https://example.com", "test_user")
+	require.NoError(t, err)
+	require.NoError(t, store.MarkAsDeleted(ctx, []string{key}, "test_user"))
+
+	// Без явного срока хранения корзина не очищается
+	idle := NewApp(store, "http://test", zap.NewNop().Sugar(), [][]byte{[]byte("test-secret")},
+		WithTrashRetention(0, 10*time.Millisecond))
+	idle.startPurger(ctx)
+	time.Sleep(50 * time.Millisecond)
+	_, err = store.Get(ctx, key)
+	require.ErrorIs(t, err, storage.ErrDeleted, "purging is off by default")
+
+	app := NewApp(store, "http://test", zap.NewNop().Sugar(), [][]byte{[]byte("test-secret")},
+		WithTrashRetention(time.Nanosecond, 10*time.Millisecond))
+	app.startPurger(ctx)
+
+	assert.Eventually(t, func() bool {
+		_, err := store.Get(ctx, key)
+		return errors.Is(err, storage.ErrNotFound)
+	}, time.Second, 10*time.Millisecond, "deleted link is purged after the retention period")
+}
+
+func TestAppClickAnalytics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := storage.NewMemoryStorage()
+	key, err := store.Save(ctx, "https://example.com", "test_user")
+	require.NoError(t, err)
+
+	app := NewApp(store, "http://test", zap.NewNop().Sugar(), [][]byte{[]byte("test-secret")},
+		WithClickAnalytics(10, 10*time.Millisecond), WithAnalyticsSecret([]byte("analytics-secret")))
+	app.startClickWriter(ctx)
+
+	req := httptest.NewRequest(http.MethodGet, "/"+key, nil)
+	req.Header.Set("Referer", "https://ref.example")
+	w := httptest.NewRecorder()
+	app.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+	assert.Eventually(t, func() bool {
+		stats, err := store.ClickStats(ctx, key, 10)
+		return err == nil && stats.Total == 1 && len(stats.TopReferrers) == 1
+	}, time.Second, 10*time.Millisecond, "click is flushed to storage in background")
+
+	// Вместо IP сохраняется его хеш
+	click := app.click(tasks.ClickTask{Key: key, IP: "192.0.2.1"})
+	assert.NotEmpty(t, click.IPHash)
+	assert.NotContains(t, click.IPHash, "192.0.2.1")
+	assert.Equal(t, click.IPHash, app.click(tasks.ClickTask{Key: key, IP: "192.0.2.1"}).IPHash)
+
+	// Хеш не зависит от ключей подписи куки, а без секрета аналитики IP не сохраняется
+	rotated := NewApp(store, "http://test", zap.NewNop().Sugar(), [][]byte{[]byte("new-secret"), []byte("test-secret")},
+		WithAnalyticsSecret([]byte("analytics-secret")))
+	assert.Equal(t, click.IPHash, rotated.click(tasks.ClickTask{Key: key, IP: "192.0.2.1"}).IPHash)
+	unset := NewApp(store, "http://test", zap.NewNop().Sugar(), [][]byte{[]byte("test-secret")})
+	assert.Empty(t, unset.click(tasks.ClickTask{Key: key, IP: "192.0.2.1"}).IPHash)
+}
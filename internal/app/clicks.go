@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/tasks"
+)
+
+// Параметры записи переходов по умолчанию.
+const (
+	defaultClickBuffer        = 10000
+	defaultClickFlushInterval = 5 * time.Second
+	defaultClickBatch         = 1000
+
+	// clickFlushTimeout ограничивает последнюю запись буфера при остановке.
+	clickFlushTimeout = 5 * time.Second
+)
+
+// WithClickAnalytics задаёт размер буфера событий переходов и период их записи в хранилище.
+// Нулевые значения оставляют значения по умолчанию, отрицательный buffer выключает учёт переходов.
+func WithClickAnalytics(buffer int, flushInterval time.Duration) Option {
+	return func(a *App) {
+		if buffer != 0 {
+			a.clickBuffer = buffer
+		}
+		if flushInterval > 0 {
+			a.clickFlushInterval = flushInterval
+		}
+	}
+}
+
+// WithAnalyticsSecret задаёт секрет, которым хешируются IP клиентов в событиях переходов.
+// Без него IP не сохраняются: хеши под меняющимся ключом нельзя было бы сравнивать.
+func WithAnalyticsSecret(secret []byte) Option {
+	return func(a *App) {
+		a.ipSecret = secret
+	}
+}
+
+// setupClicks создаёт канал событий переходов, если хранилище поддерживает storage.ClickStorage.
+func (a *App) setupClicks() {
+	if _, ok := a.storage.(storage.ClickStorage); !ok || a.clickBuffer <= 0 {
+		return
+	}
+	a.clickChan = make(chan tasks.ClickTask, a.clickBuffer)
+}
+
+// startClickWriter запускает фоновую запись переходов, если учёт включён.
+func (a *App) startClickWriter(ctx context.Context) {
+	if a.clickChan == nil {
+		return
+	}
+	go a.runClickWriter(ctx, a.storage.(storage.ClickStorage))
+}
+
+// runClickWriter копит события переходов и записывает их пачкой раз в clickFlushInterval
+// или по накоплении defaultClickBatch событий. При остановке дописывает накопленное.
+func (a *App) runClickWriter(ctx context.Context, clicks storage.ClickStorage) {
+	ticker := time.NewTicker(a.clickFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]storage.Click, 0, defaultClickBatch)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := clicks.RecordClicks(ctx, batch); err != nil {
+			a.sugar.Errorf("record clicks error: %v", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Забираем уже принятые события и записываем их вне отменённого контекста
+			for len(a.clickChan) > 0 {
+				batch = append(batch, a.click(<-a.clickChan))
+			}
+			flushCtx, cancel := context.WithTimeout(context.Background(), clickFlushTimeout)
+			flush(flushCtx)
+			cancel()
+			return
+		case task := <-a.clickChan:
+			batch = append(batch, a.click(task))
+			if len(batch) >= defaultClickBatch {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		}
+	}
+}
+
+// click превращает задачу в событие хранилища, заменяя IP клиента его хешем.
+//
+// IP хешируется HMAC-SHA256 с секретом аналитики: уникальных посетителей можно различать,
+// но без секрета по хешу нельзя перебрать адреса. Ключи подписи куки для этого не годятся:
+// после их ротации хеши тех же адресов изменились бы.
+func (a *App) click(task tasks.ClickTask) storage.Click {
+	click := storage.Click{
+		Key:       task.Key,
+		At:        task.At,
+		Referrer:  task.Referrer,
+		UserAgent: task.UserAgent,
+	}
+	if task.IP != "" && len(a.ipSecret) > 0 {
+		mac := hmac.New(sha256.New, a.ipSecret)
+		mac.Write([]byte(task.IP))
+		click.IPHash = hex.EncodeToString(mac.Sum(nil)[:16])
+	}
+	return click
+}
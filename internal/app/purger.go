@@ -0,0 +1,70 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+)
+
+// Параметры окончательного удаления ссылок из корзины по умолчанию.
+const (
+	defaultPurgeInterval = time.Hour
+	defaultPurgeBatch    = 500
+)
+
+// WithTrashRetention включает окончательное удаление ссылок, пролежавших в корзине дольше retention,
+// с периодом interval. Без этой опции и при retention <= 0 удалённые ссылки хранятся бессрочно.
+// Нулевой interval оставляет период по умолчанию, отрицательный выключает удаление.
+func WithTrashRetention(retention, interval time.Duration) Option {
+	return func(a *App) {
+		a.trashRetention = retention
+		if interval != 0 {
+			a.purgeInterval = interval
+		}
+	}
+}
+
+// startPurger запускает фоновое удаление ссылок из корзины, если хранилище поддерживает storage.Purger.
+func (a *App) startPurger(ctx context.Context) {
+	purger, ok := a.storage.(storage.Purger)
+	if !ok || a.trashRetention <= 0 || a.purgeInterval <= 0 {
+		return
+	}
+	go a.runPurger(ctx, purger)
+}
+
+// runPurger раз в purgeInterval окончательно удаляет ссылки, пролежавшие в корзине дольше trashRetention,
+// пачками по defaultPurgeBatch. Ведомое хранилище только для чтения удаление не выполняет.
+func (a *App) runPurger(ctx context.Context, purger storage.Purger) {
+	ticker := time.NewTicker(a.purgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		total := 0
+		before := time.Now().Add(-a.trashRetention)
+		for {
+			n, err := purger.PurgeDeleted(ctx, before, defaultPurgeBatch)
+			if errors.Is(err, storage.ErrReadOnly) {
+				return
+			}
+			if err != nil {
+				a.sugar.Errorf("purge deleted links error: %v", err)
+				break
+			}
+			total += n
+			if n < defaultPurgeBatch || ctx.Err() != nil {
+				break
+			}
+		}
+		if total > 0 {
+			a.sugar.Infof("Purged %d deleted links", total)
+		}
+	}
+}
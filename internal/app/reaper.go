@@ -0,0 +1,70 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+)
+
+// Параметры фоновой пометки истёкших ссылок по умолчанию.
+const (
+	defaultReapInterval = time.Minute
+	defaultReapBatch    = 500
+)
+
+// WithReaper задаёт период фоновой пометки истёкших ссылок удалёнными и размер пачки за один запрос
+// к хранилищу. Нулевые значения оставляют значения по умолчанию, отрицательный interval выключает очистку.
+func WithReaper(interval time.Duration, batch int) Option {
+	return func(a *App) {
+		if interval != 0 {
+			a.reapInterval = interval
+		}
+		if batch > 0 {
+			a.reapBatch = batch
+		}
+	}
+}
+
+// startReaper запускает фоновую очистку, если хранилище поддерживает storage.Expirer.
+func (a *App) startReaper(ctx context.Context) {
+	expirer, ok := a.storage.(storage.Expirer)
+	if !ok || a.reapInterval <= 0 {
+		return
+	}
+	go a.runReaper(ctx, expirer)
+}
+
+// runReaper раз в reapInterval помечает удалёнными истёкшие ссылки пачками по reapBatch,
+// пока не обработает все. Ведомое хранилище только для чтения очистку не выполняет.
+func (a *App) runReaper(ctx context.Context, expirer storage.Expirer) {
+	ticker := time.NewTicker(a.reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		total := 0
+		for {
+			n, err := expirer.ExpireLinks(ctx, time.Now(), a.reapBatch)
+			if errors.Is(err, storage.ErrReadOnly) {
+				return
+			}
+			if err != nil {
+				a.sugar.Errorf("expire links error: %v", err)
+				break
+			}
+			total += n
+			if n < a.reapBatch || ctx.Err() != nil {
+				break
+			}
+		}
+		if total > 0 {
+			a.sugar.Infof("Expired %d links", total)
+		}
+	}
+}
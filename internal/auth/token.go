@@ -0,0 +1,56 @@
+// Package auth содержит выпуск и проверку подписанных токенов пользователя.
+//
+// Токен имеет вид "<userID>.<hex(HMAC-SHA256(userID))>" и используется
+// как в cookie HTTP-API, так и в метаданных gRPC.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ErrInvalidToken возвращается, если токен имеет неверный формат или подпись.
+var ErrInvalidToken = errors.New("invalid auth token")
+
+// Sign формирует токен для userID, подписанный ключом secretKey.
+func Sign(userID string, secretKey []byte) string {
+	return userID + "." + hex.EncodeToString(signature(userID, secretKey))
+}
+
+// Parse проверяет подпись токена каждым из ключей по порядку
+// и возвращает userID и индекс ключа, которым токен был подписан.
+func Parse(token string, secretKeys [][]byte) (string, int, error) {
+	userID, sign, ok := strings.Cut(token, ".")
+	if !ok || userID == "" {
+		return "", 0, ErrInvalidToken
+	}
+	got, err := hex.DecodeString(sign)
+	if err != nil {
+		return "", 0, ErrInvalidToken
+	}
+	for i, key := range secretKeys {
+		if hmac.Equal(got, signature(userID, key)) {
+			return userID, i, nil
+		}
+	}
+	return "", 0, ErrInvalidToken
+}
+
+// NewUserID генерирует новый случайный идентификатор пользователя.
+func NewUserID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.URLEncoding.EncodeToString(b)
+}
+
+// signature вычисляет HMAC-SHA256 от userID.
+func signature(userID string, secretKey []byte) []byte {
+	h := hmac.New(sha256.New, secretKey)
+	h.Write([]byte(userID))
+	return h.Sum(nil)
+}
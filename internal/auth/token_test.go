@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignAndParse(t *testing.T) {
+	primary := []byte("primary")
+	old := []byte("old")
+
+	t.Run("Primary key", func(t *testing.T) {
+		userID, idx, err := Parse(Sign("user1", primary), [][]byte{primary, old})
+		require.NoError(t, err)
+		assert.Equal(t, "user1", userID)
+		assert.Equal(t, 0, idx)
+	})
+
+	t.Run("Old key", func(t *testing.T) {
+		userID, idx, err := Parse(Sign("user1", old), [][]byte{primary, old})
+		require.NoError(t, err)
+		assert.Equal(t, "user1", userID)
+		assert.Equal(t, 1, idx)
+	})
+
+	t.Run("Invalid tokens", func(t *testing.T) {
+		for _, token := range []string{"", "user1", ".abc", "user1.zz", Sign("user1", []byte("other"))} {
+			_, _, err := Parse(token, [][]byte{primary, old})
+			assert.ErrorIs(t, err, ErrInvalidToken, token)
+		}
+	})
+
+	t.Run("Unique user IDs", func(t *testing.T) {
+		assert.NotEqual(t, NewUserID(), NewUserID())
+	})
+}
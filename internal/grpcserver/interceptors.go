@@ -0,0 +1,80 @@
+package grpcserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/NailUsmanov/practicum-shortener-url/internal/auth"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TokenMetadataKey - ключ метаданных, в котором передаётся подписанный токен пользователя.
+const TokenMetadataKey = "auth_token"
+
+// Ключ для хранения userID в контексте.
+type contextKey string
+
+const userIDKey contextKey = "userID"
+
+// UserIDFromContext извлекает userID, добавленный AuthInterceptor.
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(userIDKey).(string)
+	return userID, ok && userID != ""
+}
+
+// AuthInterceptor возвращает перехватчик, определяющий пользователя по токену из метаданных.
+//
+// Работает так же, как middleware.AuthMiddleware: если токена нет или он невалиден,
+// выдаётся новый userID, а токен отправляется клиенту в заголовке ответа.
+// Токен, подписанный устаревшим ключом, перевыпускается основным ключом.
+// Как и AuthMiddleware, паникует при пустом secretKeys.
+func AuthInterceptor(secretKeys [][]byte) grpc.UnaryServerInterceptor {
+	if len(secretKeys) == 0 {
+		panic("grpcserver: AuthInterceptor requires at least one signing key")
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		var (
+			userID   string
+			keyIndex int
+			err      = auth.ErrInvalidToken
+		)
+
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(TokenMetadataKey); len(values) > 0 {
+				userID, keyIndex, err = auth.Parse(values[0], secretKeys)
+			}
+		}
+
+		if err != nil {
+			userID = auth.NewUserID()
+		}
+		if err != nil || keyIndex > 0 {
+			token := auth.Sign(userID, secretKeys[0])
+			if err := grpc.SetHeader(ctx, metadata.Pairs(TokenMetadataKey, token)); err != nil {
+				return nil, err
+			}
+		}
+
+		return handler(context.WithValue(ctx, userIDKey, userID), req)
+	}
+}
+
+// LoggingInterceptor возвращает перехватчик, логирующий gRPC-вызовы.
+//
+// В лог записываются метод, код ответа и длительность обработки.
+func LoggingInterceptor(logger *zap.SugaredLogger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		logger.Infoln(
+			"method", info.FullMethod,
+			"code", status.Code(err),
+			"duration", time.Since(start),
+		)
+		return resp, err
+	}
+}
@@ -0,0 +1,215 @@
+// Package grpcserver реализует gRPC-API сервиса сокращения ссылок.
+//
+// Набор RPC повторяет HTTP-API и работает поверх того же storage.Storage.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	pb "github.com/NailUsmanov/practicum-shortener-url/internal/genproto/shortener/v1"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/service"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/tasks"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server реализует pb.ShortenerServiceServer.
+//
+// Включает сервис сокращения ссылок, логгер, ключи подписи токенов и канал для фонового удаления URL.
+type Server struct {
+	pb.UnimplementedShortenerServiceServer
+
+	svc        *service.Shortener
+	sugar      *zap.SugaredLogger
+	secretKeys [][]byte
+	deleteChan chan tasks.DeleteTask
+}
+
+// NewServer создаёт gRPC-сервер поверх хранилища s.
+//
+// secretKeys - ключи подписи токенов пользователя, первый из них основной. svcOpts настраивают
+// сервис сокращения, например общий с HTTP счётчик неверных паролей.
+func NewServer(s storage.Storage, baseURL string, sugar *zap.SugaredLogger, secretKeys [][]byte, svcOpts ...service.Option) *Server {
+	return &Server{
+		svc:        service.NewShortener(s, baseURL, svcOpts...),
+		sugar:      sugar,
+		secretKeys: secretKeys,
+		deleteChan: make(chan tasks.DeleteTask, 1000),
+	}
+}
+
+// Register создаёт grpc.Server с перехватчиками логирования и аутентификации
+// и регистрирует на нём сервис.
+func (s *Server) Register() *grpc.Server {
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		LoggingInterceptor(s.sugar),
+		AuthInterceptor(s.secretKeys),
+	))
+	pb.RegisterShortenerServiceServer(srv, s)
+	return srv
+}
+
+// Run запускает gRPC-сервер на указанном адресе и останавливает его при отмене ctx.
+func (s *Server) Run(ctx context.Context, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := s.Register()
+
+	go s.runDeleteWorker(ctx)
+
+	go func() {
+		<-ctx.Done()
+		s.sugar.Infow("Shutting down gRPC server")
+		srv.GracefulStop()
+	}()
+
+	return srv.Serve(lis)
+}
+
+// runDeleteWorker помечает URL удалёнными в фоне.
+func (s *Server) runDeleteWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case task := <-s.deleteChan:
+			if err := s.svc.DeleteUserURLs(ctx, task.UserID, task.ShortURLs); err != nil {
+				s.sugar.Errorf("MarkAsDeleted error: %v", err)
+			}
+		}
+	}
+}
+
+// Shorten сокращает один URL.
+func (s *Server) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	userID, _ := UserIDFromContext(ctx)
+
+	res, err := s.svc.Shorten(ctx, req.GetUrl(), userID)
+	switch {
+	case errors.Is(err, service.ErrConflict):
+		return &pb.ShortenResponse{Result: res.ShortURL, AlreadyExists: true}, nil
+	case err != nil:
+		return nil, s.toStatus(err)
+	}
+	return &pb.ShortenResponse{Result: res.ShortURL}, nil
+}
+
+// ShortenBatch сокращает пакет URL.
+func (s *Server) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	userID, _ := UserIDFromContext(ctx)
+
+	items := make([]service.BatchItem, 0, len(req.GetItems()))
+	for _, item := range req.GetItems() {
+		items = append(items, service.BatchItem{
+			CorrelationID: item.GetCorrelationId(),
+			OriginalURL:   item.GetOriginalUrl(),
+		})
+	}
+
+	results, err := s.svc.ShortenBatch(ctx, items, userID)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+
+	resp := &pb.ShortenBatchResponse{Items: make([]*pb.BatchResult, 0, len(results))}
+	for _, res := range results {
+		resp.Items = append(resp.Items, &pb.BatchResult{
+			CorrelationId: res.CorrelationID,
+			ShortUrl:      res.ShortURL,
+		})
+	}
+	return resp, nil
+}
+
+// Resolve возвращает оригинальный URL по короткому ключу.
+func (s *Server) Resolve(ctx context.Context, req *pb.ResolveRequest) (*pb.ResolveResponse, error) {
+	if req.GetShortKey() == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty URL ID")
+	}
+	original, err := s.svc.Resolve(ctx, req.GetShortKey())
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &pb.ResolveResponse{OriginalUrl: original}, nil
+}
+
+// ListUserURLs возвращает все ссылки текущего пользователя, отсортированные по ключу.
+func (s *Server) ListUserURLs(ctx context.Context, _ *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	userID, _ := UserIDFromContext(ctx)
+
+	urls, err := s.svc.UserURLs(ctx, userID)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+
+	resp := &pb.ListUserURLsResponse{Urls: make([]*pb.UserURL, 0, len(urls))}
+	for _, u := range urls {
+		resp.Urls = append(resp.Urls, &pb.UserURL{
+			ShortUrl:    u.ShortURL,
+			OriginalUrl: u.OriginalURL,
+		})
+	}
+	return resp, nil
+}
+
+// DeleteUserURLs ставит ссылки пользователя в очередь на удаление.
+func (s *Server) DeleteUserURLs(ctx context.Context, req *pb.DeleteUserURLsRequest) (*pb.DeleteUserURLsResponse, error) {
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "unauthenticated")
+	}
+	if len(req.GetShortKeys()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "empty request")
+	}
+
+	select {
+	case s.deleteChan <- tasks.DeleteTask{UserID: userID, ShortURLs: req.GetShortKeys()}:
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	return &pb.DeleteUserURLsResponse{}, nil
+}
+
+// Ping проверяет доступность хранилища.
+func (s *Server) Ping(ctx context.Context, _ *pb.PingRequest) (*pb.PingResponse, error) {
+	if err := s.svc.Ping(ctx); err != nil {
+		s.sugar.Errorf("Ping error: %v", err)
+		return nil, status.Error(codes.Unavailable, "storage unavailable")
+	}
+	return &pb.PingResponse{}, nil
+}
+
+// toStatus переводит ошибки сервиса в gRPC-статусы.
+func (s *Server) toStatus(err error) error {
+	switch {
+	case errors.Is(err, service.ErrEmptyURL),
+		errors.Is(err, service.ErrInvalidURL),
+		errors.Is(err, service.ErrEmptyBatch):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, service.ErrConflict):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, service.ErrUnauthorized):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, service.ErrDeleted):
+		return status.Error(codes.NotFound, "URL deleted")
+	case errors.Is(err, service.ErrExpired):
+		return status.Error(codes.NotFound, "URL expired")
+	case errors.Is(err, service.ErrClickLimitReached):
+		return status.Error(codes.NotFound, "URL click limit reached")
+	case errors.Is(err, service.ErrPasswordRequired), errors.Is(err, service.ErrWrongPassword):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, service.ErrNotFound):
+		return status.Error(codes.NotFound, "URL not found")
+	case errors.Is(err, service.ErrReadOnly):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	s.sugar.Errorf("gRPC handler error: %v", err)
+	return status.Error(codes.Internal, "internal server error")
+}
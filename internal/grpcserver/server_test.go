@@ -0,0 +1,149 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/NailUsmanov/practicum-shortener-url/internal/genproto/shortener/v1"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestClient поднимает сервер в памяти и возвращает клиента к нему.
+func newTestClient(t *testing.T, s storage.Storage) pb.ShortenerServiceClient {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lis := bufconn.Listen(1 << 20)
+	server := NewServer(s, "http://test", zap.NewNop().Sugar(), [][]byte{[]byte("test-secret")})
+	srv := server.Register()
+	go server.runDeleteWorker(ctx)
+	go srv.Serve(lis)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Stop()
+		cancel()
+	})
+	return pb.NewShortenerServiceClient(conn)
+}
+
+// authorize выполняет вызов без токена и возвращает контекст с выданным сервером токеном.
+func authorize(t *testing.T, client pb.ShortenerServiceClient) context.Context {
+	t.Helper()
+
+	var header metadata.MD
+	_, err := client.Ping(context.Background(), &pb.PingRequest{}, grpc.Header(&header))
+	require.NoError(t, err)
+	tokens := header.Get(TokenMetadataKey)
+	require.Len(t, tokens, 1)
+
+	return metadata.AppendToOutgoingContext(context.Background(), TokenMetadataKey, tokens[0])
+}
+
+func TestShortenAndResolve(t *testing.T) {
+	client := newTestClient(t, storage.NewMemoryStorage())
+	ctx := authorize(t, client)
+
+	resp, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "http://example.com"})
+	require.NoError(t, err)
+	assert.False(t, resp.GetAlreadyExists())
+	require.Contains(t, resp.GetResult(), "http://test/")
+
+	again, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "http://example.com"})
+	require.NoError(t, err)
+	assert.True(t, again.GetAlreadyExists())
+	assert.Equal(t, resp.GetResult(), again.GetResult())
+
+	key := resp.GetResult()[len("http://test/"):]
+	resolved, err := client.Resolve(ctx, &pb.ResolveRequest{ShortKey: key})
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com", resolved.GetOriginalUrl())
+
+	_, err = client.Resolve(ctx, &pb.ResolveRequest{ShortKey: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "not a url"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestShortenBatch(t *testing.T) {
+	client := newTestClient(t, storage.NewMemoryStorage())
+	ctx := authorize(t, client)
+
+	resp, err := client.ShortenBatch(ctx, &pb.ShortenBatchRequest{Items: []*pb.BatchItem{
+		{CorrelationId: "1", OriginalUrl: "http://example.com/1"},
+		{CorrelationId: "2", OriginalUrl: "http://example.com/2"},
+	}})
+	require.NoError(t, err)
+	require.Len(t, resp.GetItems(), 2)
+	assert.Equal(t, "1", resp.GetItems()[0].GetCorrelationId())
+	assert.Equal(t, "2", resp.GetItems()[1].GetCorrelationId())
+
+	_, err = client.ShortenBatch(ctx, &pb.ShortenBatchRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestUserURLs(t *testing.T) {
+	client := newTestClient(t, storage.NewMemoryStorage())
+	ctx := authorize(t, client)
+	otherCtx := authorize(t, client)
+
+	created, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "http://example.com"})
+	require.NoError(t, err)
+
+	list, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetUrls(), 1)
+	assert.Equal(t, created.GetResult(), list.GetUrls()[0].GetShortUrl())
+
+	other, err := client.ListUserURLs(otherCtx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, other.GetUrls())
+
+	key := created.GetResult()[len("http://test/"):]
+	_, err = client.DeleteUserURLs(ctx, &pb.DeleteUserURLsRequest{ShortKeys: []string{key}})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, err := client.Resolve(ctx, &pb.ResolveRequest{ShortKey: key})
+		return status.Code(err) == codes.NotFound
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	client := newTestClient(t, storage.NewMemoryStorage())
+	assert.Panics(t, func() { AuthInterceptor([][]byte{}) }, "tokens cannot be signed without a key")
+
+	t.Run("valid token is not reissued", func(t *testing.T) {
+		ctx := authorize(t, client)
+		var header metadata.MD
+		_, err := client.Ping(ctx, &pb.PingRequest{}, grpc.Header(&header))
+		require.NoError(t, err)
+		assert.Empty(t, header.Get(TokenMetadataKey))
+	})
+
+	t.Run("forged token is replaced", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), TokenMetadataKey, "user1.deadbeef")
+		var header metadata.MD
+		_, err := client.Ping(ctx, &pb.PingRequest{}, grpc.Header(&header))
+		require.NoError(t, err)
+		assert.Len(t, header.Get(TokenMetadataKey), 1)
+	})
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/NailUsmanov/practicum-shortener-url/internal/models"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+	"go.uber.org/zap"
+)
+
+// NewCompactHandler запускает сжатие журнала хранилища.
+//
+// Если хранилище не поддерживает сжатие, возвращает 501.
+func NewCompactHandler(s storage.Storage, sugar *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		compactor, ok := s.(storage.Compactor)
+		if !ok {
+			http.Error(w, "storage does not support compaction", http.StatusNotImplemented)
+			return
+		}
+		if err := compactor.Compact(r.Context()); err != nil {
+			sugar.Errorf("Compact error: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// NewCacheStatsHandler отдаёт счётчики кеша ссылок.
+//
+// Если хранилище работает без кеша, возвращает 501.
+func NewCacheStatsHandler(s storage.Storage, sugar *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reporter, ok := s.(storage.CacheReporter)
+		if !ok {
+			http.Error(w, "storage cache is disabled", http.StatusNotImplemented)
+			return
+		}
+		stats := reporter.CacheStats()
+		writeJSON(w, http.StatusOK, models.CacheStats{
+			Hits:      stats.Hits,
+			Misses:    stats.Misses,
+			Coalesced: stats.Coalesced,
+			Entries:   stats.Entries,
+		}, sugar)
+	}
+}
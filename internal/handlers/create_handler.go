@@ -3,14 +3,13 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
-	"net/url"
-	"strings"
+	"time"
 
 	"github.com/NailUsmanov/practicum-shortener-url/internal/middleware"
 	"github.com/NailUsmanov/practicum-shortener-url/internal/models"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/service"
 	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
 	"go.uber.org/zap"
 )
@@ -19,6 +18,7 @@ import (
 //
 // Если URL уже есть возвращает его.
 func NewCreateShortURL(s storage.Storage, baseURL string, sugar *zap.SugaredLogger) http.HandlerFunc {
+	svc := service.NewShortener(s, baseURL)
 	return func(w http.ResponseWriter, r *http.Request) {
 		if s == nil {
 			http.Error(w, "storage is nil", http.StatusInternalServerError)
@@ -41,60 +41,38 @@ func NewCreateShortURL(s storage.Storage, baseURL string, sugar *zap.SugaredLogg
 		}
 		defer r.Body.Close()
 
-		// Проверяем чтобы тело было не 0
-		if len(body) == 0 {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
-
 		sugar.Infof("Received request body: %q", body)
-		rawURL := strings.TrimSpace(string(body))
-		sugar.Infof("Received raw URL: %q", rawURL)
-
-		// Проверяем валидность URL
-		_, err = url.ParseRequestURI(rawURL)
-		if err != nil {
-			sugar.Errorf("Invalid URL: %s", rawURL)
-			http.Error(w, "Invalid URL format", http.StatusBadRequest)
-			return
-		}
 		// Получаем userID из контекста
 		userID, _ := r.Context().Value(middleware.UserIDKey).(string)
 
-		// Проверяем наличие оригинального УРЛ в нашей мапе
-		existsKey, err := s.GetByURL(r.Context(), rawURL, userID)
-		if err != nil {
-			if !errors.Is(err, storage.ErrNotFound) {
-				sugar.Errorf("Storage unexpected error: %v", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-		}
-		if existsKey != "" {
-			sugar.Infof("URL exists: %s -> %s", rawURL, existsKey)
+		res, err := svc.Shorten(r.Context(), string(body), userID)
+		switch {
+		case errors.Is(err, service.ErrEmptyURL):
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		case errors.Is(err, service.ErrInvalidURL):
+			sugar.Errorf("Invalid URL: %s", body)
+			http.Error(w, "Invalid URL format", http.StatusBadRequest)
+			return
+		case errors.Is(err, service.ErrConflict):
+			sugar.Infof("URL exists: %s -> %s", body, res.Key)
 			w.Header().Set("Content-Type", "text/plain")
 			w.WriteHeader(http.StatusConflict)
-			fmt.Fprintf(w, "%s/%s", baseURL, existsKey) // Используем fmt.Fprintf вместо Write
+			io.WriteString(w, res.ShortURL)
 			return
-		}
-
-		// Сохраняем URL
-		key, err := s.Save(r.Context(), rawURL, userID)
-		if err != nil {
-			if errors.Is(err, storage.ErrAlreadyHasKey) {
-				w.Header().Set("Content-Type", "text/plain")
-				w.WriteHeader(http.StatusConflict)
-				fmt.Fprintf(w, "%s/%s", baseURL, key)
-				return
-			}
+		case errors.Is(err, service.ErrReadOnly):
+			http.Error(w, "Storage is read-only", http.StatusServiceUnavailable)
+			return
+		case err != nil:
 			sugar.Errorf("Save error: %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+
 		// Возвращаем ответ
 		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(http.StatusCreated)
-		if _, err := w.Write([]byte(baseURL + "/" + key)); err != nil {
+		if _, err := io.WriteString(w, res.ShortURL); err != nil {
 			sugar.Errorf("Failed to write response: %v", err)
 		}
 	}
@@ -102,6 +80,7 @@ func NewCreateShortURL(s storage.Storage, baseURL string, sugar *zap.SugaredLogg
 
 // NewCreateShortURLJSON создает короткую ссылку в формате JSON.
 func NewCreateShortURLJSON(s storage.Storage, baseURL string, sugar *zap.SugaredLogger) http.HandlerFunc {
+	svc := service.NewShortener(s, baseURL)
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		if r.Header.Get("Content-Type") != "application/json" {
@@ -116,56 +95,63 @@ func NewCreateShortURLJSON(s storage.Storage, baseURL string, sugar *zap.Sugared
 			return
 		}
 
-		if len(req.URL) == 0 {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
+		// Получаем UserID из контекста
+		userID, _ := r.Context().Value(middleware.UserIDKey).(string)
 
-		// Проверяем валидность URL
-		_, err := url.ParseRequestURI(req.URL)
+		expiresAt, err := service.ExpiresAt(req.ExpiresAt, req.TTL, time.Now())
 		if err != nil {
-			http.Error(w, "Invalid URL format", http.StatusBadRequest)
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()}, sugar)
 			return
 		}
 
-		// Сохраняем URL
-		// Получаем UserID из контекста
-		userID, _ := r.Context().Value(middleware.UserIDKey).(string)
-		key, err := s.Save(r.Context(), req.URL, userID)
-		if err != nil {
-			if errors.Is(err, storage.ErrAlreadyHasKey) {
-				sugar.Errorf("Save error: %v", err)
-				var resp models.Response
-				resp.Result = baseURL + "/" + key
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusConflict)
-				json.NewEncoder(w).Encode(resp)
-				return
-			}
+		res, err := svc.ShortenWithOptions(r.Context(), req.URL, service.LinkOptions{
+			Alias:     req.Alias,
+			ExpiresAt: expiresAt,
+			MaxClicks: req.MaxClicks,
+			Password:  req.Password,
 
+			RedirectType: req.RedirectType,
+			CacheMaxAge:  req.CacheMaxAge,
+		}, userID)
+		switch {
+		case errors.Is(err, service.ErrEmptyURL):
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		case errors.Is(err, service.ErrInvalidURL):
+			http.Error(w, "Invalid URL format", http.StatusBadRequest)
+			return
+		case errors.Is(err, service.ErrInvalidAlias), errors.Is(err, service.ErrReservedAlias), errors.Is(err, service.ErrInvalidExpiry),
+			errors.Is(err, service.ErrInvalidMaxClicks), errors.Is(err, service.ErrInvalidPassword),
+			errors.Is(err, service.ErrInvalidRedirectType), errors.Is(err, service.ErrInvalidCacheMaxAge):
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()}, sugar)
+			return
+		case errors.Is(err, service.ErrConflict):
+			resp := models.Response{Result: res.ShortURL}
+			if errors.Is(err, service.ErrAliasNotApplied) {
+				resp.Error = service.ErrAliasNotApplied.Error()
+			}
+			writeJSON(w, http.StatusConflict, resp, sugar)
+			return
+		case errors.Is(err, service.ErrAliasTaken):
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()}, sugar)
+			return
+		case errors.Is(err, service.ErrReadOnly):
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()}, sugar)
+			return
+		case err != nil:
 			sugar.Errorf("Failed to save URL: %v", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"}, sugar)
 			return
 		}
 
 		// Возвращаем ответ
-		var resp models.Response
-		resp.Result = baseURL + "/" + key
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-
-		enc := json.NewEncoder(w)
-		if err := enc.Encode(resp); err != nil {
-			sugar.Error("error encoding response")
-		}
-
+		writeJSON(w, http.StatusCreated, models.Response{Result: res.ShortURL}, sugar)
 	}
 }
 
 // NewCreateBatchJSON позволяет обработать сразу пакет URL для сокращения.
 func NewCreateBatchJSON(s storage.Storage, baseURL string, sugar *zap.SugaredLogger) http.HandlerFunc {
+	svc := service.NewShortener(s, baseURL)
 	return func(w http.ResponseWriter, r *http.Request) {
 		sugar.Infof("CreateBatchJSON started, headers: %v", r.Header)
 		// Получаем UserID из контекста
@@ -174,81 +160,80 @@ func NewCreateBatchJSON(s storage.Storage, baseURL string, sugar *zap.SugaredLog
 
 		// Строгая проверка Content-Type
 		if r.Header.Get("Content-Type") != "application/json" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{
+			writeJSON(w, http.StatusBadRequest, map[string]string{
 				"error": "Content-Type must be application/json",
-			})
+			}, sugar)
 			return
 		}
 
 		var req []models.RequestURLMassiv
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			sugar.Error("cannot decode request JSON body:", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON format"})
-			return
-		}
-
-		if len(req) == 0 {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Empty batch request"})
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON format"}, sugar)
 			return
 		}
 
-		var urls []string
+		items := make([]service.BatchItem, 0, len(req))
+		now := time.Now()
 		for _, item := range req {
-			if _, err := url.ParseRequestURI(item.OriginalURL); err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid URL: %s", item.OriginalURL)})
+			expiresAt, err := service.ExpiresAt(item.ExpiresAt, item.TTL, now)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()}, sugar)
 				return
 			}
-			urls = append(urls, item.OriginalURL)
+			items = append(items, service.BatchItem{
+				CorrelationID: item.CorrelationID,
+				OriginalURL:   item.OriginalURL,
+				Alias:         item.Alias,
+				ExpiresAt:     expiresAt,
+				MaxClicks:     item.MaxClicks,
+				Password:      item.Password,
+				RedirectType:  item.RedirectType,
+				CacheMaxAge:   item.CacheMaxAge,
+			})
 		}
 
-		keys, err := s.SaveInBatch(r.Context(), urls, userID)
-		key := ""
-		if err != nil {
-			if errors.Is(err, storage.ErrAlreadyHasKey) {
-
-				for _, url := range urls {
-					if key, err = s.GetByURL(r.Context(), url, userID); err == nil {
-						w.Header().Set("Content-Type", "application/json")
-						w.WriteHeader(http.StatusConflict)
-						json.NewEncoder(w).Encode(map[string]string{
-							"short_url": baseURL + "/" + key,
-						})
-						return
-					}
-				}
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-
+		results, err := svc.ShortenBatch(r.Context(), items, userID)
+		switch {
+		case errors.Is(err, service.ErrEmptyBatch):
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Empty batch request"}, sugar)
+			return
+		case errors.Is(err, service.ErrInvalidURL), errors.Is(err, service.ErrInvalidAlias), errors.Is(err, service.ErrReservedAlias),
+			errors.Is(err, service.ErrInvalidExpiry), errors.Is(err, service.ErrInvalidMaxClicks), errors.Is(err, service.ErrInvalidPassword),
+			errors.Is(err, service.ErrInvalidRedirectType), errors.Is(err, service.ErrInvalidCacheMaxAge):
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()}, sugar)
+			return
+		case errors.Is(err, service.ErrConflict):
+			writeJSON(w, http.StatusConflict, map[string]string{"short_url": results[0].ShortURL}, sugar)
+			return
+		case errors.Is(err, service.ErrAliasTaken):
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()}, sugar)
+			return
+		case errors.Is(err, service.ErrReadOnly):
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()}, sugar)
+			return
+		case err != nil:
 			sugar.Error("failed to save batch:", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()}, sugar)
 			return
 		}
 
-		var resp []models.ResponseMassiv
-		for i, key := range keys {
+		resp := make([]models.ResponseMassiv, 0, len(results))
+		for _, res := range results {
 			resp = append(resp, models.ResponseMassiv{
-				CorrelationID: req[i].CorrelationID,
-				ShortURL:      baseURL + "/" + key,
+				CorrelationID: res.CorrelationID,
+				ShortURL:      res.ShortURL,
 			})
 		}
+		writeJSON(w, http.StatusCreated, resp, sugar)
+	}
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-
-		enc := json.NewEncoder(w)
-		if err := enc.Encode(resp); err != nil {
-			sugar.Error("error encoding response:", err)
-		}
+// writeJSON записывает ответ в формате JSON с указанным статус-кодом.
+func writeJSON(w http.ResponseWriter, status int, v any, sugar *zap.SugaredLogger) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		sugar.Error("error encoding response:", err)
 	}
 }
@@ -85,7 +85,7 @@ func ExampleNewRedirect_correct() {
 	logger, _ := zap.NewDevelopment()
 	defer logger.Sync()
 
-	handlerRedirect := handlers.NewRedirect(stor, logger.Sugar())
+	handlerRedirect := handlers.NewRedirect(stor, logger.Sugar(), nil, handlers.RedirectPolicy{})
 	handlerCreate := handlers.NewCreateShortURL(stor, "http://localhost", logger.Sugar())
 	// для записи в базу данных и дальнейшего поиска в базе для редиректа
 	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("http://example.com"))
@@ -121,7 +121,7 @@ func ExampleNewRedirect_not_found() {
 	logger, _ := zap.NewDevelopment()
 	defer logger.Sync()
 
-	handlerRedirect := handlers.NewRedirect(stor, logger.Sugar())
+	handlerRedirect := handlers.NewRedirect(stor, logger.Sugar(), nil, handlers.RedirectPolicy{})
 	// получаем id из тела ответа
 	id := "test"
 
@@ -147,7 +147,7 @@ func ExampleNewRedirect_deleted() {
 	logger, _ := zap.NewDevelopment()
 	defer logger.Sync()
 
-	handlerRedirect := handlers.NewRedirect(stor, logger.Sugar())
+	handlerRedirect := handlers.NewRedirect(stor, logger.Sugar(), nil, handlers.RedirectPolicy{})
 	handlerCreate := handlers.NewCreateShortURL(stor, "http://localhost", logger.Sugar())
 	// для записи в базу данных и дальнейшего поиска в базе для редиректа
 	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString("http://example.com"))
@@ -2,21 +2,25 @@
 package handlers
 
 import (
-	_ "bytes"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"sort"
+	"strconv"
+	"time"
 
 	"github.com/NailUsmanov/practicum-shortener-url/internal/middleware"
 	"github.com/NailUsmanov/practicum-shortener-url/internal/models"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/service"
 	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+	"github.com/go-chi/chi"
 	"go.uber.org/zap"
 )
 
 // NewPingHandler проверяет работоспособность функции обработчика.
 func NewPingHandler(s storage.Storage, sugar *zap.SugaredLogger) http.HandlerFunc {
+	svc := service.NewShortener(s, "")
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := s.Ping(r.Context()); err != nil {
+		if err := svc.Ping(r.Context()); err != nil {
 			sugar.Errorf("Failed to open DataBase: %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -25,45 +29,134 @@ func NewPingHandler(s storage.Storage, sugar *zap.SugaredLogger) http.HandlerFun
 	}
 }
 
-// GetUserURLS выдает все существующие у пользователя короткие URL.
+// NextCursorHeader - заголовок ответа со значением cursor для следующей страницы ссылок пользователя.
+const NextCursorHeader = "X-Next-Cursor"
+
+// GetUserURLS выдает страницу коротких URL пользователя.
+//
+// Параметры запроса: limit и cursor - размер и начало страницы, sort (key или created) и order (asc или desc) -
+// порядок, created_after (RFC 3339), domain и include_deleted - фильтры. Без limit ссылки выдаются
+// все сразу. Курсор следующей страницы возвращается в заголовке X-Next-Cursor; на последней странице его нет.
+// У ссылок, сохранённых до учёта момента создания, поле created_at отсутствует.
 func GetUserURLS(s storage.Storage, baseURL string, sugar *zap.SugaredLogger) http.HandlerFunc {
+	svc := service.NewShortener(s, baseURL)
 	return func(w http.ResponseWriter, r *http.Request) {
-		userID, ok := r.Context().Value(middleware.UserIDKey).(string)
-		if !ok || userID == "" {
-			w.WriteHeader(http.StatusUnauthorized) // 401 для неавторизованных
-			return
-		}
+		userID, _ := r.Context().Value(middleware.UserIDKey).(string)
 
-		urls, err := s.GetUserURLS(r.Context(), userID)
+		query, err := pageQuery(r)
 		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page, err := svc.UserURLsPage(r.Context(), userID, query)
+		switch {
+		case errors.Is(err, service.ErrUnauthorized):
+			w.WriteHeader(http.StatusUnauthorized) // 401 для неавторизованных
+			return
+		case errors.Is(err, service.ErrInvalidPageQuery):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
 			sugar.Errorf("GetUserURLS error: %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		if len(urls) == 0 {
+		if page.NextCursor != "" {
+			w.Header().Set(NextCursorHeader, page.NextCursor)
+		}
+		if len(page.URLs) == 0 {
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
-		keys := make([]string, 0, len(urls))
-		for k := range urls {
-			keys = append(keys, k)
-		}
-		sort.Strings(keys)
-
-		var resp []models.UserURLs
-		for _, short := range keys {
+		resp := make([]models.UserURLs, 0, len(page.URLs))
+		for _, u := range page.URLs {
 			resp = append(resp, models.UserURLs{
-				ShortURL:    baseURL + "/" + short,
-				OriginalURL: urls[short],
+				ShortURL:    u.ShortURL,
+				OriginalURL: u.OriginalURL,
+				CreatedAt:   timePtr(u.CreatedAt.UTC()),
+				IsDeleted:   u.Deleted,
 			})
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
+		writeJSON(w, http.StatusOK, resp, sugar)
+	}
+}
 
-		enc := json.NewEncoder(w)
-		if err := enc.Encode(resp); err != nil {
-			sugar.Error("error encoding response:", err)
+// pageQuery разбирает параметры страницы ссылок пользователя из строки запроса.
+func pageQuery(r *http.Request) (service.PageQuery, error) {
+	values := r.URL.Query()
+	query := service.PageQuery{
+		Cursor: values.Get("cursor"),
+		Sort:   values.Get("sort"),
+		Order:  values.Get("order"),
+		Domain: values.Get("domain"),
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return service.PageQuery{}, fmt.Errorf("invalid limit: %w", err)
+		}
+		// Нулевой размер в сервисе означает все ссылки, а явный limit должен ограничивать страницу
+		if limit < 1 {
+			return service.PageQuery{}, errors.New("invalid limit: must be positive")
+		}
+		query.Limit = limit
+	}
+	if v := values.Get("created_after"); v != "" {
+		createdAfter, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return service.PageQuery{}, fmt.Errorf("invalid created_after: %w", err)
+		}
+		query.CreatedAfter = createdAfter
+	}
+	if v := values.Get("include_deleted"); v != "" {
+		includeDeleted, err := strconv.ParseBool(v)
+		if err != nil {
+			return service.PageQuery{}, fmt.Errorf("invalid include_deleted: %w", err)
+		}
+		query.IncludeDeleted = includeDeleted
+	}
+	return query, nil
+}
+
+// GetLinkStats выдает статистику переходов по короткой ссылке пользователя.
+//
+// Статистика доступна только владельцу ссылки, для чужих ссылок возвращается 404.
+func GetLinkStats(s storage.Storage, baseURL string, sugar *zap.SugaredLogger) http.HandlerFunc {
+	svc := service.NewShortener(s, baseURL)
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value(middleware.UserIDKey).(string)
+		key := chi.URLParam(r, "id")
+
+		stats, err := svc.LinkStats(r.Context(), userID, key)
+		switch {
+		case errors.Is(err, service.ErrUnauthorized):
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		case errors.Is(err, service.ErrNotFound):
+			http.Error(w, "URL not found", http.StatusNotFound)
+			return
+		case errors.Is(err, service.ErrStatsUnsupported):
+			http.Error(w, "storage does not support click stats", http.StatusNotImplemented)
+			return
+		case err != nil:
+			sugar.Errorf("GetLinkStats error: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		resp := models.LinkStats{
+			ShortURL:     svc.ShortURL(key),
+			TotalClicks:  stats.Total,
+			ClicksPerDay: make([]models.DayClicks, 0, len(stats.PerDay)),
+			TopReferrers: make([]models.ReferrerStats, 0, len(stats.TopReferrers)),
+		}
+		for _, day := range stats.PerDay {
+			resp.ClicksPerDay = append(resp.ClicksPerDay, models.DayClicks{Date: day.Date, Clicks: day.Clicks})
+		}
+		for _, ref := range stats.TopReferrers {
+			resp.TopReferrers = append(resp.TopReferrers, models.ReferrerStats{Referrer: ref.Referrer, Clicks: ref.Clicks})
 		}
+		writeJSON(w, http.StatusOK, resp, sugar)
 	}
 }
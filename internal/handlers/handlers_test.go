@@ -8,10 +8,18 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf8"
 
 	"github.com/NailUsmanov/practicum-shortener-url/internal/middleware"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/models"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/service"
 	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
 	"github.com/NailUsmanov/practicum-shortener-url/internal/tasks"
 	"github.com/go-chi/chi"
@@ -25,6 +33,12 @@ const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 type URLData struct {
 	OriginalURL string
 	UserID      string
+	ExpiresAt   time.Time
+	MaxClicks   int64
+	Clicks      int64
+
+	RedirectType int
+	CacheMaxAge  int64
 }
 
 type MockStorage struct {
@@ -40,13 +54,87 @@ func (m *MockStorage) Save(ctx context.Context, url string, userID string) (stri
 	return key, nil
 }
 
+func (m *MockStorage) SaveLink(ctx context.Context, link storage.Link) (string, error) {
+	key := link.Key
+	if key == "" {
+		key = "mock123"
+	}
+	if _, exists := m.Data[key]; exists {
+		return "", storage.ErrKeyTaken
+	}
+	m.Data[key] = URLData{
+		OriginalURL: link.OriginalURL,
+		UserID:      link.UserID,
+		ExpiresAt:   link.ExpiresAt,
+		MaxClicks:   link.MaxClicks,
+
+		RedirectType: link.RedirectType,
+		CacheMaxAge:  link.CacheMaxAge,
+	}
+	return key, nil
+}
+
 func (m *MockStorage) Get(ctx context.Context, key string) (string, error) {
 	if url, exists := m.Data[key]; exists {
+		if !url.ExpiresAt.IsZero() && time.Now().After(url.ExpiresAt) {
+			return "", storage.ErrExpired
+		}
+		if url.MaxClicks > 0 {
+			if url.Clicks >= url.MaxClicks {
+				return "", storage.ErrClickLimitReached
+			}
+			url.Clicks++
+			m.Data[key] = url
+		}
 		return url.OriginalURL, nil
 	}
 	return "", storage.ErrNotFound
 }
 
+func (m *MockStorage) GetLink(ctx context.Context, key string, verify storage.VerifyFunc) (storage.Link, error) {
+	url, err := m.Get(ctx, key)
+	if err != nil {
+		return storage.Link{}, err
+	}
+	data := m.Data[key]
+	return storage.Link{
+		Key:          key,
+		OriginalURL:  url,
+		UserID:       data.UserID,
+		ExpiresAt:    data.ExpiresAt,
+		MaxClicks:    data.MaxClicks,
+		RedirectType: data.RedirectType,
+		CacheMaxAge:  data.CacheMaxAge,
+	}, nil
+}
+
+func (m *MockStorage) Update(ctx context.Context, key string, userID string, upd storage.LinkUpdate) (storage.Link, error) {
+	data, exists := m.Data[key]
+	if !exists || data.UserID != userID {
+		return storage.Link{}, storage.ErrNotFound
+	}
+	if upd.OriginalURL != nil {
+		data.OriginalURL = *upd.OriginalURL
+	}
+	m.Data[key] = data
+	return storage.Link{Key: key, OriginalURL: data.OriginalURL, UserID: userID}, nil
+}
+
+func (m *MockStorage) Revisions(ctx context.Context, key string, userID string) ([]storage.Revision, error) {
+	if data, exists := m.Data[key]; !exists || data.UserID != userID {
+		return nil, storage.ErrNotFound
+	}
+	return nil, nil
+}
+
+func (m *MockStorage) GetDeletedURLS(ctx context.Context, userID string) ([]storage.DeletedURL, error) {
+	return nil, nil
+}
+
+func (m *MockStorage) RestoreURLs(ctx context.Context, urls []string, userID string) ([]string, error) {
+	return nil, nil
+}
+
 func (m *MockStorage) Ping(ctx context.Context) error {
 	return nil
 }
@@ -77,6 +165,22 @@ func (m *MockStorage) GetUserURLS(ctx context.Context, userID string) (map[strin
 	return result, nil
 }
 
+// GetUserURLsPage выдает ссылки пользователя по возрастанию ключа; фильтры, кроме курсора, не поддерживаются.
+func (m *MockStorage) GetUserURLsPage(ctx context.Context, q storage.UserURLsQuery) (storage.UserURLsPage, error) {
+	var page storage.UserURLsPage
+	for short, data := range m.Data {
+		if data.UserID == q.UserID && (q.After == nil || short > q.After.Key) {
+			page.URLs = append(page.URLs, storage.UserURL{Key: short, OriginalURL: data.OriginalURL})
+		}
+	}
+	sort.Slice(page.URLs, func(i, j int) bool { return page.URLs[i].Key < page.URLs[j].Key })
+	if q.Limit > 0 && len(page.URLs) > q.Limit {
+		page.URLs = page.URLs[:q.Limit]
+		page.Next = &storage.PageCursor{Key: page.URLs[q.Limit-1].Key}
+	}
+	return page, nil
+}
+
 func (m *MockStorage) MarkAsDeleted(ctx context.Context, urls []string, userID string) error {
 	for _, shortURL := range urls {
 		if _, exists := m.Data[shortURL]; !exists {
@@ -196,6 +300,46 @@ func TestURLHandler_Redirect(t *testing.T) {
 			wantStatus: http.StatusNotFound,
 			wantHeader: "",
 		},
+		{
+			name: "Expired short URL",
+			setup: func(s *MockStorage) {
+				s.Data["old123"] = URLData{
+					OriginalURL: "http://test.com",
+					UserID:      "1",
+					ExpiresAt:   time.Now().Add(-time.Minute),
+				}
+			},
+			urlParam:   "old123",
+			wantStatus: http.StatusGone,
+			wantHeader: "",
+		},
+		{
+			name: "One-time short URL",
+			setup: func(s *MockStorage) {
+				s.Data["once123"] = URLData{
+					OriginalURL: "http://test.com",
+					UserID:      "1",
+					MaxClicks:   1,
+				}
+			},
+			urlParam:   "once123",
+			wantStatus: http.StatusTemporaryRedirect,
+			wantHeader: "http://test.com",
+		},
+		{
+			name: "Exhausted short URL",
+			setup: func(s *MockStorage) {
+				s.Data["used123"] = URLData{
+					OriginalURL: "http://test.com",
+					UserID:      "1",
+					MaxClicks:   1,
+					Clicks:      1,
+				}
+			},
+			urlParam:   "used123",
+			wantStatus: http.StatusGone,
+			wantHeader: "",
+		},
 	}
 
 	for _, tt := range tests {
@@ -213,7 +357,7 @@ func TestURLHandler_Redirect(t *testing.T) {
 			// делаем регистратор SugaredLogger
 			sugar := logger.Sugar()
 
-			handler := NewRedirect(storage, sugar)
+			handler := NewRedirect(storage, sugar, nil, RedirectPolicy{})
 
 			router := chi.NewRouter()
 			router.Get("/{id}", handler)
@@ -334,6 +478,124 @@ func TestCreateShortURLJSONErrorCases(t *testing.T) {
 	}
 }
 
+func TestCreateShortURLJSONOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		requestBody string
+		wantStatus  int
+		wantBody    string
+	}{
+		{
+			name:        "Free alias",
+			requestBody: `{"url": "https://example.com/sale", "alias": "spring-sale"}`,
+			wantStatus:  http.StatusCreated,
+			wantBody:    `{"result":"http://test/spring-sale"}`,
+		},
+		{
+			name:        "Taken alias",
+			requestBody: `{"url": "https://example.com/other", "alias": "taken"}`,
+			wantStatus:  http.StatusConflict,
+			wantBody:    `{"error":"alias already taken"}`,
+		},
+		{
+			name:        "Reserved alias",
+			requestBody: `{"url": "https://example.com", "alias": "API"}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "Invalid characters",
+			requestBody: `{"url": "https://example.com", "alias": "spring/sale"}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "Too short",
+			requestBody: `{"url": "https://example.com", "alias": "ab"}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "Alias with ttl",
+			requestBody: `{"url": "https://example.com/promo", "alias": "promo", "ttl": 3600}`,
+			wantStatus:  http.StatusCreated,
+			wantBody:    `{"result":"http://test/promo"}`,
+		},
+		{
+			name:        "Expiration in the past",
+			requestBody: `{"url": "https://example.com", "expires_at": "2000-01-01T00:00:00Z"}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "Both expires_at and ttl",
+			requestBody: `{"url": "https://example.com", "expires_at": "2100-01-01T00:00:00Z", "ttl": 60}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &MockStorage{Data: map[string]URLData{
+				"taken": {OriginalURL: "https://example.com/taken", UserID: "other_user"},
+			}}
+			handler := NewCreateShortURLJSON(storage, "http://test", zap.NewNop().Sugar())
+
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(tt.requestBody))
+			req.Header.Set("Content-Type", "application/json")
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test_user"))
+			w := httptest.NewRecorder()
+
+			handler(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+			if tt.wantBody != "" {
+				body, err := io.ReadAll(res.Body)
+				require.NoError(t, err)
+				assert.JSONEq(t, tt.wantBody, string(body))
+			}
+		})
+	}
+}
+
+func TestCreateShortURLJSONAliasNotApplied(t *testing.T) {
+	handler := NewCreateShortURLJSON(storage.NewMemoryStorage(), "http://test", zap.NewNop().Sugar())
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test_user"))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusCreated, post(`{"url": "https://example.com/sale", "alias": "spring-sale"}`).Code)
+
+	w := post(`{"url": "https://example.com/sale", "alias": "spring-sale"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"result":"http://test/spring-sale"}`, w.Body.String())
+
+	w = post(`{"url": "https://example.com/sale", "alias": "autumn-sale"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"result":"http://test/spring-sale","error":"url is already shortened, alias not applied"}`, w.Body.String())
+}
+
+func TestCreateShortURLJSONReadOnly(t *testing.T) {
+	store, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "storage.json"), storage.WithFollower(time.Second))
+	require.NoError(t, err)
+	defer store.Close()
+
+	handler := NewCreateShortURLJSON(store, "http://test", zap.NewNop().Sugar())
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url": "https://example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test_user"))
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	res := w.Result()
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+}
+
 func TestCreateBatchJSON(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -353,6 +615,26 @@ func TestCreateBatchJSON(t *testing.T) {
                 {"correlation_id": "2", "short_url": "http://test/mock123"}
             ]`,
 		},
+		{
+			name: "Alias in batch",
+			requestBody: `[
+                {"correlation_id": "1", "original_url": "http://test1.com", "alias": "first-link"},
+                {"correlation_id": "2", "original_url": "http://test2.com"}
+            ]`,
+			wantStatus: http.StatusCreated,
+			wantBody: `[
+                {"correlation_id": "1", "short_url": "http://test/first-link"},
+                {"correlation_id": "2", "short_url": "http://test/mock123"}
+            ]`,
+		},
+		{
+			name: "Reserved alias in batch",
+			requestBody: `[
+                {"correlation_id": "1", "original_url": "http://test1.com", "alias": "ping"}
+            ]`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"error":"alias is reserved: ping"}`,
+		},
 		{
 			name:        "Invalid JSON requesttest",
 			requestBody: `[ { invalid json } ]`,
@@ -484,6 +766,449 @@ func TestGetUserURLS(t *testing.T) {
 	})
 }
 
+func TestGetUserURLSPages(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	sugar := zap.NewNop().Sugar()
+	for i := 0; i < 5; i++ {
+		_, err := store.Save(ctx, fmt.Sprintf("http://example.com/%d", i), "user1")
+		require.NoError(t, err)
+	}
+	_, err := store.Save(ctx, "http://other.org/", "user1")
+	require.NoError(t, err)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/urls?"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+		w := httptest.NewRecorder()
+		GetUserURLS(store, "http://test", sugar)(w, req)
+		return w
+	}
+
+	// Проход по страницам с курсором возвращает все ссылки по одному разу
+	seen := map[string]bool{}
+	cursor := ""
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 3)
+		w := get("limit=2&sort=created&order=desc&cursor=" + cursor)
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp []models.UserURLs
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		require.LessOrEqual(t, len(resp), 2)
+		for _, u := range resp {
+			assert.False(t, seen[u.ShortURL])
+			assert.NotNil(t, u.CreatedAt)
+			seen[u.ShortURL] = true
+		}
+		if cursor = w.Header().Get(NextCursorHeader); cursor == "" {
+			break
+		}
+	}
+	assert.Len(t, seen, 6)
+
+	w := get("domain=OTHER.org")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get(NextCursorHeader))
+	var resp []models.UserURLs
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(t, resp, 1)
+	assert.Equal(t, "http://other.org/", resp[0].OriginalURL)
+
+	assert.Equal(t, http.StatusNoContent, get("created_after="+time.Now().Add(time.Minute).Format(time.RFC3339)).Code)
+
+	first := get("limit=1").Header().Get(NextCursorHeader)
+	require.NotEmpty(t, first)
+	for _, query := range []string{
+		"limit=abc",
+		"limit=0",
+		"limit=5000",
+		"sort=popularity",
+		"order=random",
+		"created_after=yesterday",
+		"include_deleted=maybe",
+		"cursor=garbage",
+		"sort=created&cursor=" + first,
+	} {
+		assert.Equal(t, http.StatusBadRequest, get(query).Code, query)
+	}
+}
+
+func TestGetUserURLSWithoutLimit(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	urls := make([]string, 250)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("http://example.com/%d", i)
+	}
+	_, err := store.SaveInBatch(ctx, urls, "user1")
+	require.NoError(t, err)
+	// Ссылка, сохранённая до учёта момента создания
+	require.NoError(t, store.LoadRecords(ctx, []storage.Record{{Key: "legacy",
+		URLData: storage.URLData{OriginalURL: "http://example.com/legacy", UserID: "user1"}}}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+	w := httptest.NewRecorder()
+	GetUserURLS(store, "http://test", zap.NewNop().Sugar())(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get(NextCursorHeader))
+	var resp []map[string]any
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(t, resp, len(urls)+1, "without limit all links are returned")
+	for _, u := range resp {
+		_, hasCreatedAt := u["created_at"]
+		assert.Equal(t, u["short_url"] != "http://test/legacy", hasCreatedAt, "%v", u)
+	}
+}
+
+func TestGetLinkStats(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	sugar := zap.NewNop().Sugar()
+
+	key, err := store.Save(ctx, "http://example.com", "owner")
+	require.NoError(t, err)
+	require.NoError(t, store.RecordClicks(ctx, []storage.Click{
+		{Key: key, At: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC), Referrer: "https://ref.example"},
+		{Key: key, At: time.Date(2026, 10, 17, 13, 0, 0, 0, time.UTC)},
+	}))
+
+	tests := []struct {
+		name       string
+		userID     string
+		storage    storage.Storage
+		wantStatus int
+	}{
+		{name: "Владелец ссылки", userID: "owner", storage: store, wantStatus: http.StatusOK},
+		{name: "Чужая ссылка", userID: "stranger", storage: store, wantStatus: http.StatusNotFound},
+		{name: "Неавторизованный доступ", storage: store, wantStatus: http.StatusUnauthorized},
+		{name: "Хранилище без статистики", userID: "owner", storage: &MockStorage{Data: map[string]URLData{}}, wantStatus: http.StatusNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := chi.NewRouter()
+			r.Get("/api/user/urls/{id}/stats", GetLinkStats(tt.storage, "http://test", sugar))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls/"+key+"/stats", nil)
+			if tt.userID != "" {
+				req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, tt.userID))
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+			require.Equal(t, tt.wantStatus, res.StatusCode)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp models.LinkStats
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&resp))
+			assert.Equal(t, models.LinkStats{
+				ShortURL:     "http://test/" + key,
+				TotalClicks:  2,
+				ClicksPerDay: []models.DayClicks{{Date: "2026-10-17", Clicks: 2}},
+				TopReferrers: []models.ReferrerStats{{Referrer: "https://ref.example", Clicks: 1}},
+			}, resp)
+		})
+	}
+}
+
+func TestUpdateLink(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	sugar := zap.NewNop().Sugar()
+
+	key, err := store.Save(ctx, "http://example.com/old", "owner")
+	require.NoError(t, err)
+	_, err = store.Save(ctx, "http://example.com/taken", "owner")
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Patch("/api/user/urls/{id}", UpdateLink(store, "http://test", sugar))
+	r.Get("/api/user/urls/{id}/revisions", GetLinkRevisions(store, "http://test", sugar))
+	r.Get("/{id}", NewRedirect(store, sugar, nil, RedirectPolicy{}))
+
+	do := func(method, target, body, userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if userID != "" {
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name       string
+		userID     string
+		body       string
+		wantStatus int
+	}{
+		{name: "Неавторизованный доступ", body: `{"url":"http://example.com/new"}`, wantStatus: http.StatusUnauthorized},
+		{name: "Чужая ссылка", userID: "stranger", body: `{"url":"http://example.com/new"}`, wantStatus: http.StatusNotFound},
+		{name: "Пустая правка", userID: "owner", body: `{}`, wantStatus: http.StatusBadRequest},
+		{name: "Некорректный URL", userID: "owner", body: `{"url":"not a url"}`, wantStatus: http.StatusBadRequest},
+		{name: "Некорректный статус", userID: "owner", body: `{"redirect_type":303}`, wantStatus: http.StatusBadRequest},
+		{name: "URL уже сокращён", userID: "owner", body: `{"url":"http://example.com/taken"}`, wantStatus: http.StatusConflict},
+		{name: "Правка владельцем", userID: "owner", body: `{"url":"http://example.com/new","redirect_type":301,"ttl":3600}`, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(http.MethodPatch, "/api/user/urls/"+key, tt.body, tt.userID)
+			require.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp models.UserLink
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			assert.Equal(t, "http://test/"+key, resp.ShortURL)
+			assert.Equal(t, "http://example.com/new", resp.OriginalURL)
+			assert.Equal(t, http.StatusMovedPermanently, resp.RedirectType)
+			assert.NotNil(t, resp.ExpiresAt)
+		})
+	}
+
+	// Ключ прежний, а редирект ведёт на новый URL
+	w := do(http.MethodGet, "/"+key, "", "")
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "http://example.com/new", w.Header().Get("Location"))
+
+	// История правок доступна только владельцу
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/user/urls/"+key+"/revisions", "", "stranger").Code)
+	w = do(http.MethodGet, "/api/user/urls/"+key+"/revisions", "", "owner")
+	require.Equal(t, http.StatusOK, w.Code)
+	var revisions []models.LinkRevision
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&revisions))
+	require.Len(t, revisions, 1)
+	assert.Equal(t, "http://example.com/old", revisions[0].OriginalURL)
+	assert.Zero(t, revisions[0].RedirectType)
+	assert.Nil(t, revisions[0].ExpiresAt)
+	assert.False(t, revisions[0].ChangedAt.IsZero())
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	sugar := zap.NewNop().Sugar()
+
+	key, err := store.Save(ctx, "http://example.com/deleted", "owner")
+	require.NoError(t, err)
+	require.NoError(t, store.MarkAsDeleted(ctx, []string{key}, "owner"))
+	expired, err := store.SaveLink(ctx, storage.Link{OriginalURL: "http://example.com/expired", UserID: "owner",
+		ExpiresAt: time.Now().Add(-time.Minute)})
+	require.NoError(t, err)
+	require.NoError(t, store.MarkAsDeleted(ctx, []string{expired}, "owner"))
+
+	r := chi.NewRouter()
+	r.Get("/api/user/urls/trash", GetTrash(store, "http://test", sugar))
+	r.Post("/api/user/urls/restore", RestoreHandler(store, sugar))
+
+	do := func(method, target, body, userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if userID != "" {
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/user/urls/trash", "", "").Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/urls/trash", "", "stranger").Code)
+
+	w := do(http.MethodGet, "/api/user/urls/trash", "", "owner")
+	require.Equal(t, http.StatusOK, w.Code)
+	var trash []models.TrashURL
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&trash))
+	require.Len(t, trash, 2)
+	sort.Slice(trash, func(i, j int) bool { return trash[i].OriginalURL < trash[j].OriginalURL })
+	assert.Equal(t, "http://test/"+key, trash[0].ShortURL)
+	assert.Equal(t, "http://example.com/deleted", trash[0].OriginalURL)
+	assert.False(t, trash[0].DeletedAt.IsZero())
+
+	tests := []struct {
+		name       string
+		userID     string
+		body       string
+		wantStatus int
+		wantBody   string
+		wantText   string
+	}{
+		{name: "Неавторизованный доступ", body: `["` + key + `"]`, wantStatus: http.StatusUnauthorized},
+		{name: "Пустой список", userID: "owner", body: `[]`, wantStatus: http.StatusBadRequest},
+		{name: "Чужая ссылка", userID: "stranger", body: `["` + key + `"]`, wantStatus: http.StatusOK, wantBody: `[]`},
+		{name: "Истёкшая ссылка", userID: "owner", body: `["` + key + `","` + expired + `"]`, wantStatus: http.StatusConflict,
+			wantText: "url expired and cannot be restored: " + expired},
+		{name: "Восстановление владельцем", userID: "owner", body: `["` + key + `","missing"]`, wantStatus: http.StatusOK, wantBody: `["` + key + `"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(http.MethodPost, "/api/user/urls/restore", tt.body, tt.userID)
+			require.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, w.Body.String())
+			}
+			if tt.wantText != "" {
+				assert.Equal(t, tt.wantText, strings.TrimSpace(w.Body.String()))
+			}
+		})
+	}
+
+	url, err := store.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/deleted", url)
+	w = do(http.MethodGet, "/api/user/urls/trash", "", "owner")
+	require.Equal(t, http.StatusOK, w.Code)
+	trash = nil
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&trash))
+	require.Len(t, trash, 1, "the expired link stays in the trash")
+	assert.Equal(t, "http://test/"+expired, trash[0].ShortURL)
+}
+
+func TestRedirectPasswordProtected(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	res, err := service.NewShortener(store, "").ShortenWithOptions(context.Background(), "http://example.com/doc",
+		service.LinkOptions{Password: "s3cret"}, "owner")
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	redirect := NewRedirect(store, zap.NewNop().Sugar(), nil, RedirectPolicy{})
+	r.Get("/{id}", redirect)
+	r.Post("/{id}", redirect)
+
+	form := func(password string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/"+res.Key, strings.NewReader(url.Values{"password": {password}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+	withHeader := func(password string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/"+res.Key, nil)
+		req.Header.Set(PasswordHeader, password)
+		return req
+	}
+
+	tests := []struct {
+		name         string
+		req          *http.Request
+		wantStatus   int
+		wantLocation string
+		wantForm     bool
+	}{
+		{name: "Форма пароля", req: httptest.NewRequest(http.MethodGet, "/"+res.Key, nil), wantStatus: http.StatusUnauthorized, wantForm: true},
+		{name: "Неверный пароль в форме", req: form("guess"), wantStatus: http.StatusForbidden, wantForm: true},
+		{name: "Верный пароль в форме", req: form("s3cret"), wantStatus: http.StatusSeeOther, wantLocation: "http://example.com/doc"},
+		{name: "Неверный пароль в заголовке", req: withHeader("guess"), wantStatus: http.StatusForbidden},
+		{name: "Верный пароль в заголовке", req: withHeader("s3cret"), wantStatus: http.StatusTemporaryRedirect, wantLocation: "http://example.com/doc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, tt.req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantLocation, w.Header().Get("Location"))
+			if tt.wantForm {
+				assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+				assert.Contains(t, w.Body.String(), `name="password"`)
+			}
+		})
+	}
+
+	// Подбор пароля ограничивается: после лимита не принимается и верный
+	for i := 0; i < service.MaxPasswordAttempts; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), withHeader("guess"))
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, form("s3cret"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	store := &MockStorage{Data: map[string]URLData{
+		"plain":   {OriginalURL: "http://example.com/plain"},
+		"moved":   {OriginalURL: "http://example.com/moved", RedirectType: http.StatusMovedPermanently, CacheMaxAge: 3600},
+		"nocache": {OriginalURL: "http://example.com/nocache", CacheMaxAge: -1},
+		"once":    {OriginalURL: "http://example.com/once", MaxClicks: 1},
+		"soon":    {OriginalURL: "http://example.com/soon", ExpiresAt: time.Now().Add(time.Minute)},
+	}}
+
+	tests := []struct {
+		name       string
+		policy     RedirectPolicy
+		key        string
+		wantStatus int
+		wantCache  string
+	}{
+		{name: "Без политики", key: "plain", wantStatus: http.StatusTemporaryRedirect, wantCache: "no-store"},
+		{name: "Политика сервера", policy: RedirectPolicy{Status: http.StatusFound, CacheMaxAge: 60}, key: "plain",
+			wantStatus: http.StatusFound, wantCache: "public, max-age=60"},
+		{name: "Политика ссылки важнее", policy: RedirectPolicy{Status: http.StatusFound, CacheMaxAge: 60}, key: "moved",
+			wantStatus: http.StatusMovedPermanently, wantCache: "public, max-age=3600"},
+		{name: "Ссылка запрещает кеширование", policy: RedirectPolicy{CacheMaxAge: 60}, key: "nocache",
+			wantStatus: http.StatusTemporaryRedirect, wantCache: "no-store"},
+		{name: "Ссылка с лимитом не кешируется", policy: RedirectPolicy{CacheMaxAge: 60}, key: "once",
+			wantStatus: http.StatusTemporaryRedirect, wantCache: "no-store"},
+		{name: "Кеш не переживает срок ссылки", policy: RedirectPolicy{CacheMaxAge: 3600}, key: "soon",
+			wantStatus: http.StatusTemporaryRedirect, wantCache: "public, max-age=59"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := chi.NewRouter()
+			r.Get("/{id}", NewRedirect(store, zap.NewNop().Sugar(), nil, tt.policy))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+tt.key, nil))
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantCache, w.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestRedirectRecordsClick(t *testing.T) {
+	store := &MockStorage{Data: map[string]URLData{"abc": {OriginalURL: "http://example.com"}}}
+	clicks := make(chan tasks.ClickTask, 1)
+
+	r := chi.NewRouter()
+	r.Get("/{id}", NewRedirect(store, zap.NewNop().Sugar(), clicks, RedirectPolicy{}))
+
+	req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+	req.Header.Set("Referer", "https://ref.example")
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("X-Real-IP", "192.0.2.1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+	task := <-clicks
+	assert.Equal(t, "abc", task.Key)
+	assert.Equal(t, "https://ref.example", task.Referrer)
+	assert.Equal(t, "test-agent", task.UserAgent)
+	assert.Equal(t, "192.0.2.1", task.IP)
+
+	// Длинные заголовки обрезаются по границе символа
+	req = httptest.NewRequest(http.MethodGet, "/abc", nil)
+	req.Header.Set("Referer", "https://ref.example/"+strings.Repeat("я", maxClickHeader))
+	req.Header.Set("User-Agent", strings.Repeat("a", 2*maxClickHeader))
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	task = <-clicks
+	assert.LessOrEqual(t, len(task.Referrer), maxClickHeader)
+	assert.True(t, utf8.ValidString(task.Referrer))
+	assert.Equal(t, strings.Repeat("a", maxClickHeader), task.UserAgent)
+
+	// Переполненный канал не задерживает редирект: событие отбрасывается
+	clicks <- task
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/abc", nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Len(t, clicks, 1)
+}
+
 func TestDeleteHandler(t *testing.T) {
 	logger, _ := zap.NewDevelopment()
 	sugar := logger.Sugar()
@@ -555,3 +1280,122 @@ func TestDeleteHandler(t *testing.T) {
 		require.Equal(t, http.StatusUnauthorized, rr.Code)
 	})
 }
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	sugar := zap.NewNop().Sugar()
+
+	_, err := store.SaveLink(ctx, storage.Link{Key: "first", OriginalURL: "http://example.com/first", UserID: "owner", MaxClicks: 5})
+	require.NoError(t, err)
+	_, err = store.SaveLink(ctx, storage.Link{Key: "second", OriginalURL: "http://example.com/second", UserID: "owner"})
+	require.NoError(t, err)
+	_, err = store.Get(ctx, "first")
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Get("/api/user/urls/export", ExportHandler(store, "http://test", sugar))
+	r.Post("/api/user/urls/import", ImportHandler(store, "http://test", sugar))
+
+	do := func(method, target, contentType, body, userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if userID != "" {
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/user/urls/export", "", "", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/api/user/urls/export?format=xml", "", "", "owner").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/user/urls/import", "", "", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/urls/import?format=xml", "", "", "owner").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/urls/import", "text/csv", "key,short_url\n", "owner").Code)
+
+	w := do(http.MethodGet, "/api/user/urls/export?format=csv", "", "", "owner")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	csvBody := w.Body.String()
+	lines := strings.Split(strings.TrimSpace(csvBody), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, strings.Join(exportColumns, ","), lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "first,http://test/first,http://example.com/first,"), lines[1])
+
+	w = do(http.MethodGet, "/api/user/urls/export", "", "", "owner")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	jsonlBody := w.Body.String()
+	var first models.ExportURL
+	require.NoError(t, json.Unmarshal([]byte(strings.SplitN(jsonlBody, "\n", 2)[0]), &first))
+	assert.Equal(t, "first", first.Key)
+	assert.Equal(t, int64(5), first.MaxClicks)
+	assert.Equal(t, int64(1), first.Clicks)
+	require.NotNil(t, first.CreatedAt)
+
+	// Выгрузка переносится в пустое хранилище с исходными ключами
+	target := storage.NewMemoryStorage()
+	r = chi.NewRouter()
+	r.Post("/api/user/urls/import", ImportHandler(target, "http://test", sugar))
+
+	w = do(http.MethodPost, "/api/user/urls/import", "text/csv", csvBody, "copy")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"created":2,"key_changed":0,"conflicts":0,"invalid":0,"rows":[]}`, w.Body.String())
+	link, err := target.GetLink(ctx, "first", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "copy", link.UserID)
+	assert.Equal(t, int64(5), link.MaxClicks)
+	records, err := target.ScanRecords(ctx, "", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), records[0].Clicks, "consumed clicks are imported, including the one just made")
+
+	// Занятый ключ заменяется новым
+	w = do(http.MethodPost, "/api/user/urls/import", "text/csv", "original_url,key\nhttp://example.com/third,first\n", "copy")
+	require.Equal(t, http.StatusOK, w.Code)
+	var report models.ImportReport
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	assert.Equal(t, 1, report.KeyChanged)
+	require.Len(t, report.Rows, 1)
+	assert.Equal(t, 2, report.Rows[0].Line)
+	assert.Equal(t, "first", report.Rows[0].Key)
+	assert.Equal(t, "key_changed", report.Rows[0].Status)
+	assert.NotEqual(t, "http://test/first", report.Rows[0].ShortURL)
+
+	// Повторная загрузка тех же ссылок не создаёт копий
+	w = do(http.MethodPost, "/api/user/urls/import?format=jsonl", "", jsonlBody+"\n{oops\n", "copy")
+	require.Equal(t, http.StatusOK, w.Code)
+	report = models.ImportReport{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	assert.Equal(t, 2, report.Conflicts)
+	assert.Equal(t, 1, report.Invalid)
+	assert.Zero(t, report.Created+report.KeyChanged)
+
+	w = do(http.MethodPost, "/api/user/urls/import", "", `{"key":"fresh","original_url":"http://example.com/fresh"}`, "copy")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"created":1,"key_changed":0,"conflicts":0,"invalid":0,"rows":[]}`, w.Body.String())
+	url, err := target.Get(ctx, "fresh")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/fresh", url)
+
+	// Недочитанная загрузка - ошибка клиента, а отчёт перечисляет уже сохранённые пачки
+	var bulk strings.Builder
+	for i := 0; i < service.ImportChunkSize; i++ {
+		fmt.Fprintf(&bulk, "{\"original_url\":\"http://example.com/bulk/%d\"}\n", i)
+	}
+	w = do(http.MethodPost, "/api/user/urls/import", "", bulk.String()+strings.Repeat("x", maxJSONLLine+1), "copy")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	report = models.ImportReport{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	assert.Equal(t, service.ImportChunkSize, report.Created)
+	assert.Contains(t, report.Error, fmt.Sprintf("line %d is longer", service.ImportChunkSize+1))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/urls/import",
+		io.MultiReader(strings.NewReader(`{"original_url":"http://example.com/aborted"}`+"\n"), iotest.ErrReader(io.ErrUnexpectedEOF)))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "copy"))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"created":0,"key_changed":0,"conflicts":0,"invalid":0,"rows":[],
+		"error":"cannot read import: unexpected EOF"}`, w.Body.String())
+}
@@ -2,15 +2,92 @@ package handlers
 
 import (
 	"errors"
+	"html/template"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
 
+	"github.com/NailUsmanov/practicum-shortener-url/internal/service"
 	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/tasks"
 	"github.com/go-chi/chi"
 	"go.uber.org/zap"
 )
 
+// maxClickHeader ограничивает длину Referer и User-Agent в событии перехода: сервер принимает
+// заголовки до мегабайта, а хранилища сохраняют события целиком.
+const maxClickHeader = 1024
+
+// PasswordHeader - заголовок, в котором API-клиенты передают пароль защищённой ссылки.
+const PasswordHeader = "X-Link-Password"
+
+// passwordForm - страница ввода пароля защищённой ссылки. Форма отправляется POST на тот же адрес.
+var passwordForm = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Password required</title></head>
+<body>
+<form method="post">
+<p>This link is password protected.</p>
+{{if .}}<p>{{.}}</p>
+{{end}}<input type="password" name="password" autofocus required>
+<button type="submit">Open</button>
+</form>
+</body>
+</html>
+`))
+
+// RedirectPolicy - статус и кеширование редиректа для ссылок, у которых они не заданы.
+type RedirectPolicy struct {
+	Status      int   // Статус редиректа, 0 - 307
+	CacheMaxAge int64 // Сколько секунд клиенты могут кешировать редирект, 0 - не кешировать
+
+	Attempts *service.AttemptLimiter // Общий счётчик неверных паролей, nil - собственный у обработчика
+}
+
+// apply выставляет заголовок Cache-Control для ссылки link и возвращает статус редиректа.
+//
+// Ссылки с лимитом переходов и защищённые паролем не кешируются никогда: иначе повторные
+// переходы не дойдут до сервера. Время кеширования ссылки со сроком действия не выходит за этот срок.
+func (p RedirectPolicy) apply(w http.ResponseWriter, link storage.Link) int {
+	status := link.RedirectType
+	if status == 0 {
+		status = p.Status
+	}
+	if status == 0 {
+		status = http.StatusTemporaryRedirect
+	}
+
+	maxAge := link.CacheMaxAge
+	if maxAge == 0 {
+		maxAge = p.CacheMaxAge
+	}
+	if !link.ExpiresAt.IsZero() {
+		maxAge = min(maxAge, int64(time.Until(link.ExpiresAt).Seconds()))
+	}
+	if maxAge <= 0 || link.MaxClicks > 0 || link.PasswordHash != "" {
+		w.Header().Set("Cache-Control", "no-store")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.FormatInt(maxAge, 10))
+	}
+	return status
+}
+
 // NewRedirect перенаправляет клиента с короткой ссылки на оригинальный URL.
-func NewRedirect(s storage.Storage, sugar *zap.SugaredLogger) http.HandlerFunc {
+//
+// Статус редиректа и Cache-Control берутся из ссылки, а если в ней не заданы - из policy.
+// Для защищённой паролем ссылки GET без пароля отдаёт HTML-форму, а редирект выдаётся
+// после POST формы с верным паролем. API-клиенты могут передать пароль в заголовке
+// PasswordHeader. Каждый успешный переход отправляется в канал clicks для фоновой записи. Отправка
+// не блокирует редирект: при переполненном канале событие отбрасывается. При nil
+// переходы не учитываются.
+func NewRedirect(s storage.Storage, sugar *zap.SugaredLogger, clicks chan<- tasks.ClickTask, policy RedirectPolicy) http.HandlerFunc {
+	var opts []service.Option
+	if policy.Attempts != nil {
+		opts = append(opts, service.WithAttemptLimiter(policy.Attempts))
+	}
+	svc := service.NewShortener(s, "", opts...)
 	return func(w http.ResponseWriter, r *http.Request) {
 		// 1. Получаем ID из URL
 		key := chi.URLParam(r, "id")
@@ -18,24 +95,103 @@ func NewRedirect(s storage.Storage, sugar *zap.SugaredLogger) http.HandlerFunc {
 			http.Error(w, "Empty URL ID", http.StatusBadRequest)
 			return
 		}
-		// 2. Ищем оригинальный URL
-		url, err := s.Get(r.Context(), key)
-		if err != nil {
+		// 2. Ищем оригинальный URL, пароль защищённой ссылки берём из формы или заголовка
+		fromForm := r.Method == http.MethodPost
+		password := r.Header.Get(PasswordHeader)
+		if fromForm {
+			password = r.PostFormValue("password")
+		}
+		link, err := svc.ResolveLink(r.Context(), key, password)
+		if err != nil && !errors.Is(err, service.ErrPasswordRequired) {
 			sugar.Errorf("redirect error: %v", err)
 		}
 		switch {
-		case errors.Is(err, storage.ErrDeleted):
+		case errors.Is(err, service.ErrPasswordRequired):
+			writePasswordForm(w, http.StatusUnauthorized, "", sugar)
+			return
+		case errors.Is(err, service.ErrWrongPassword):
+			if fromForm {
+				writePasswordForm(w, http.StatusForbidden, "Wrong password.", sugar)
+				return
+			}
+			http.Error(w, "Wrong password", http.StatusForbidden)
+			return
+		case errors.Is(err, service.ErrTooManyAttempts):
+			w.Header().Set("Retry-After", strconv.Itoa(int(service.PasswordAttemptWindow.Seconds())))
+			http.Error(w, "Too many password attempts", http.StatusTooManyRequests)
+			return
+		case errors.Is(err, service.ErrDeleted):
 			http.Error(w, "URL deleted", http.StatusGone)
 			return
-		case errors.Is(err, storage.ErrNotFound):
+		case errors.Is(err, service.ErrExpired):
+			http.Error(w, "URL expired", http.StatusGone)
+			return
+		case errors.Is(err, service.ErrClickLimitReached):
+			http.Error(w, "URL click limit reached", http.StatusGone)
+			return
+		case errors.Is(err, service.ErrReadOnly):
+			http.Error(w, "URL is not available on this instance", http.StatusServiceUnavailable)
+			return
+		case errors.Is(err, service.ErrNotFound):
 			http.Error(w, "URL not found", http.StatusNotFound)
 			return
 		case err != nil:
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
-		// 3. Делаем редирект
-		w.Header().Set("Location", url)
-		w.WriteHeader(http.StatusTemporaryRedirect)
+		// 3. Делаем редирект; после формы - 303, чтобы браузер перешёл по ссылке GET-запросом
+		status := policy.apply(w, link)
+		if fromForm {
+			status = http.StatusSeeOther
+		}
+		w.Header().Set("Location", link.OriginalURL)
+		w.WriteHeader(status)
+
+		// 4. Учитываем переход
+		if clicks != nil {
+			select {
+			case clicks <- tasks.ClickTask{
+				Key:       key,
+				At:        time.Now(),
+				Referrer:  clip(r.Referer(), maxClickHeader),
+				UserAgent: clip(r.UserAgent(), maxClickHeader),
+				IP:        clientIP(r),
+			}:
+			default:
+			}
+		}
+	}
+}
+
+// clip обрезает s до n байт, не разрывая символ UTF-8.
+func clip(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// clientIP возвращает IP клиента из заголовка X-Real-IP, а без него - адрес соединения.
+func clientIP(r *http.Request) string {
+	if ip := r.Header.Get("X-Real-IP"); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// writePasswordForm отдаёт форму ввода пароля с сообщением message.
+func writePasswordForm(w http.ResponseWriter, status int, message string, sugar *zap.SugaredLogger) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := passwordForm.Execute(w, message); err != nil {
+		sugar.Errorf("write password form: %v", err)
 	}
 }
@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NailUsmanov/practicum-shortener-url/internal/middleware"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/models"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/service"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Форматы выгрузки и загрузки ссылок пользователя.
+const (
+	formatCSV   = "csv"
+	formatJSONL = "jsonl"
+)
+
+// maxJSONLLine - наибольшая длина строки JSONL при загрузке.
+const maxJSONLLine = 1 << 20
+
+// exportColumns - колонки CSV в порядке выгрузки.
+var exportColumns = []string{"key", "short_url", "original_url", "created_at", "expires_at", "max_clicks", "clicks",
+	"redirect_type", "cache_max_age", "protected"}
+
+// ExportHandler потоково выгружает ссылки пользователя в формате из параметра format: csv или jsonl (по умолчанию).
+//
+// Ответ пишется по мере чтения страниц из хранилища; ошибка после начала ответа только логируется.
+func ExportHandler(s storage.Storage, baseURL string, sugar *zap.SugaredLogger) http.HandlerFunc {
+	svc := service.NewShortener(s, baseURL)
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value(middleware.UserIDKey).(string)
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = formatJSONL
+		}
+		var out linkWriter
+		switch format {
+		case formatCSV:
+			out = &csvLinkWriter{w: csv.NewWriter(w)}
+		case formatJSONL:
+			out = jsonlLinkWriter{enc: json.NewEncoder(w)}
+		default:
+			http.Error(w, "format must be csv or jsonl", http.StatusBadRequest)
+			return
+		}
+
+		// Заголовки отправляются с первой ссылкой, чтобы ошибка до неё получила свой статус
+		started := false
+		start := func() error {
+			started = true
+			w.Header().Set("Content-Type", out.contentType())
+			w.Header().Set("Content-Disposition", `attachment; filename="links.`+format+`"`)
+			w.WriteHeader(http.StatusOK)
+			return out.start()
+		}
+		err := svc.ExportLinks(r.Context(), userID, func(link service.ExportedLink) error {
+			if !started {
+				if err := start(); err != nil {
+					return err
+				}
+			}
+			return out.write(exportURL(link))
+		})
+		if err == nil && !started {
+			err = start()
+		}
+		if err == nil {
+			err = out.flush()
+		}
+		switch {
+		case err == nil:
+		case started:
+			sugar.Errorf("Export interrupted: %v", err)
+		case errors.Is(err, service.ErrUnauthorized):
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			sugar.Errorf("Export error: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
+
+// ImportHandler загружает ссылки пользователя из тела запроса в формате из параметра format
+// (csv или jsonl). Без параметра тело с Content-Type text/csv читается как CSV, остальные - как JSONL.
+//
+// Тело читается потоково; в ответ возвращается отчёт с исходом строк, которые не сохранены под исходным ключом.
+func ImportHandler(s storage.Storage, baseURL string, sugar *zap.SugaredLogger) http.HandlerFunc {
+	svc := service.NewShortener(s, baseURL)
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value(middleware.UserIDKey).(string)
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = formatJSONL
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+				format = formatCSV
+			}
+		}
+		var next func() (service.ImportRow, error)
+		switch format {
+		case formatCSV:
+			reader, err := newCSVLinkReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			next = reader.next
+		case formatJSONL:
+			next = newJSONLLinkReader(r.Body).next
+		default:
+			http.Error(w, "format must be csv or jsonl", http.StatusBadRequest)
+			return
+		}
+
+		// Прерванная загрузка тоже отвечает отчётом: пачки до ошибки уже сохранены
+		report, err := svc.ImportLinks(r.Context(), userID, next)
+		status, message := http.StatusOK, ""
+		switch {
+		case errors.Is(err, service.ErrUnauthorized):
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		case errors.Is(err, service.ErrReadOnly):
+			http.Error(w, "Storage is read-only", http.StatusServiceUnavailable)
+			return
+		case errors.Is(err, service.ErrImportRead):
+			status, message = http.StatusBadRequest, err.Error()
+		case err != nil:
+			sugar.Errorf("Import error after %d created links: %v", report.Created+report.KeyChanged, err)
+			status, message = http.StatusInternalServerError, "Internal server error"
+		}
+
+		resp := models.ImportReport{
+			Created:    report.Created,
+			KeyChanged: report.KeyChanged,
+			Conflicts:  report.Conflicts,
+			Invalid:    report.Invalid,
+			Rows:       make([]models.ImportRow, 0, len(report.Rows)),
+			Error:      message,
+		}
+		for _, row := range report.Rows {
+			resp.Rows = append(resp.Rows, models.ImportRow(row))
+		}
+		writeJSON(w, status, resp, sugar)
+	}
+}
+
+// exportURL возвращает ссылку выгрузки в виде модели ответа.
+func exportURL(link service.ExportedLink) models.ExportURL {
+	return models.ExportURL{
+		Key:          link.Key,
+		ShortURL:     link.ShortURL,
+		OriginalURL:  link.OriginalURL,
+		CreatedAt:    timePtr(link.CreatedAt.UTC()),
+		ExpiresAt:    timePtr(link.ExpiresAt.UTC()),
+		MaxClicks:    link.MaxClicks,
+		Clicks:       link.Clicks,
+		RedirectType: link.RedirectType,
+		CacheMaxAge:  link.CacheMaxAge,
+		Protected:    link.Protected,
+	}
+}
+
+// importRow возвращает загруженную ссылку как строку импорта с номером line.
+func importRow(line int, u models.ExportURL) service.ImportRow {
+	row := service.ImportRow{
+		Line:         line,
+		Key:          u.Key,
+		OriginalURL:  u.OriginalURL,
+		MaxClicks:    u.MaxClicks,
+		Clicks:       u.Clicks,
+		RedirectType: u.RedirectType,
+		CacheMaxAge:  u.CacheMaxAge,
+		Protected:    u.Protected,
+	}
+	if u.ExpiresAt != nil {
+		row.ExpiresAt = *u.ExpiresAt
+	}
+	return row
+}
+
+// linkWriter пишет ссылки выгрузки в одном формате.
+type linkWriter interface {
+	contentType() string
+	start() error
+	write(u models.ExportURL) error
+	flush() error
+}
+
+// jsonlLinkWriter пишет ссылки по одному JSON-объекту в строке.
+type jsonlLinkWriter struct {
+	enc *json.Encoder
+}
+
+func (jsonlLinkWriter) contentType() string { return "application/x-ndjson" }
+
+func (jsonlLinkWriter) start() error { return nil }
+
+func (j jsonlLinkWriter) write(u models.ExportURL) error { return j.enc.Encode(u) }
+
+func (jsonlLinkWriter) flush() error { return nil }
+
+// csvLinkWriter пишет ссылки в CSV с заголовком exportColumns.
+type csvLinkWriter struct {
+	w *csv.Writer
+}
+
+func (*csvLinkWriter) contentType() string { return "text/csv; charset=utf-8" }
+
+func (c *csvLinkWriter) start() error { return c.w.Write(exportColumns) }
+
+func (c *csvLinkWriter) write(u models.ExportURL) error {
+	return c.w.Write([]string{
+		u.Key,
+		u.ShortURL,
+		u.OriginalURL,
+		formatTime(u.CreatedAt),
+		formatTime(u.ExpiresAt),
+		formatInt(u.MaxClicks),
+		formatInt(u.Clicks),
+		formatInt(int64(u.RedirectType)),
+		formatInt(u.CacheMaxAge),
+		formatBool(u.Protected),
+	})
+}
+
+func (c *csvLinkWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// formatTime возвращает момент t в RFC 3339 или пустую строку для nil.
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// formatInt возвращает n в десятичной записи или пустую строку для нуля.
+func formatInt(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// formatBool возвращает true для истинного b или пустую строку.
+func formatBool(b bool) string {
+	if !b {
+		return ""
+	}
+	return strconv.FormatBool(b)
+}
+
+// csvLinkReader читает строки загрузки из CSV с заголовком. Из колонок обязательна только original_url,
+// неизвестные колонки пропускаются.
+type csvLinkReader struct {
+	r       *csv.Reader
+	columns map[string]int // Имя колонки -> её номер
+}
+
+// newCSVLinkReader читает заголовок CSV из r.
+func newCSVLinkReader(r io.Reader) (*csvLinkReader, error) {
+	reader := &csvLinkReader{r: csv.NewReader(r), columns: make(map[string]int)}
+	reader.r.FieldsPerRecord = -1
+	header, err := reader.r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CSV header: %w", err)
+	}
+	for i, name := range header {
+		reader.columns[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	if _, ok := reader.columns["original_url"]; !ok {
+		return nil, errors.New("CSV header must contain original_url")
+	}
+	return reader, nil
+}
+
+// next возвращает очередную строку загрузки. Ошибки разбора строки возвращаются в ImportRow.Err.
+func (c *csvLinkReader) next() (service.ImportRow, error) {
+	record, err := c.r.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return service.ImportRow{Line: parseErr.StartLine, Err: err}, nil
+	}
+	if err != nil {
+		return service.ImportRow{}, err // В том числе io.EOF
+	}
+	line, _ := c.r.FieldPos(0)
+
+	field := func(name string) string {
+		if i, ok := c.columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	var p fieldParser
+	row := importRow(line, models.ExportURL{
+		Key:          field("key"),
+		OriginalURL:  field("original_url"),
+		ExpiresAt:    p.time("expires_at", field("expires_at")),
+		MaxClicks:    p.int("max_clicks", field("max_clicks")),
+		Clicks:       p.int("clicks", field("clicks")),
+		RedirectType: int(p.int("redirect_type", field("redirect_type"))),
+		CacheMaxAge:  p.int("cache_max_age", field("cache_max_age")),
+		Protected:    p.bool("protected", field("protected")),
+	})
+	row.Err = p.err
+	return row, nil
+}
+
+// fieldParser разбирает поля строки CSV, запоминая первую ошибку.
+type fieldParser struct {
+	err error
+}
+
+// time разбирает момент в RFC 3339 из колонки name; пустое значение означает nil.
+func (p *fieldParser) time(name, value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		p.fail(name, value)
+		return nil
+	}
+	return &t
+}
+
+// int разбирает целое число из колонки name; пустое значение означает ноль.
+func (p *fieldParser) int(name, value string) int64 {
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		p.fail(name, value)
+	}
+	return n
+}
+
+// bool разбирает логическое значение из колонки name; пустое значение означает false.
+func (p *fieldParser) bool(name, value string) bool {
+	if value == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		p.fail(name, value)
+	}
+	return b
+}
+
+func (p *fieldParser) fail(name, value string) {
+	if p.err == nil {
+		p.err = fmt.Errorf("invalid %s %q", name, value)
+	}
+}
+
+// jsonlLinkReader читает строки загрузки из JSONL. Пустые строки пропускаются.
+type jsonlLinkReader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func newJSONLLinkReader(r io.Reader) *jsonlLinkReader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), maxJSONLLine)
+	return &jsonlLinkReader{s: s}
+}
+
+// next возвращает очередную строку загрузки. Ошибки разбора строки возвращаются в ImportRow.Err.
+func (j *jsonlLinkReader) next() (service.ImportRow, error) {
+	for j.s.Scan() {
+		j.line++
+		data := j.s.Bytes()
+		if len(strings.TrimSpace(string(data))) == 0 {
+			continue
+		}
+		var u models.ExportURL
+		if err := json.Unmarshal(data, &u); err != nil {
+			return service.ImportRow{Line: j.line, Err: fmt.Errorf("invalid JSON: %w", err)}, nil
+		}
+		return importRow(j.line, u), nil
+	}
+	switch err := j.s.Err(); {
+	case errors.Is(err, bufio.ErrTooLong):
+		return service.ImportRow{}, fmt.Errorf("line %d is longer than %d bytes", j.line+1, maxJSONLLine)
+	case err != nil:
+		return service.ImportRow{}, err
+	}
+	return service.ImportRow{}, io.EOF
+}
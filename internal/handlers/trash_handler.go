@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/NailUsmanov/practicum-shortener-url/internal/middleware"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/models"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/service"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+	"go.uber.org/zap"
+)
+
+// GetTrash выдает удалённые ссылки пользователя, которые ещё можно восстановить.
+func GetTrash(s storage.Storage, baseURL string, sugar *zap.SugaredLogger) http.HandlerFunc {
+	svc := service.NewShortener(s, baseURL)
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value(middleware.UserIDKey).(string)
+
+		urls, err := svc.Trash(r.Context(), userID)
+		switch {
+		case errors.Is(err, service.ErrUnauthorized):
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		case err != nil:
+			sugar.Errorf("GetTrash error: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(urls) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		resp := make([]models.TrashURL, 0, len(urls))
+		for _, u := range urls {
+			resp = append(resp, models.TrashURL{
+				ShortURL:    u.ShortURL,
+				OriginalURL: u.OriginalURL,
+				DeletedAt:   u.DeletedAt,
+			})
+		}
+		writeJSON(w, http.StatusOK, resp, sugar)
+	}
+}
+
+// RestoreHandler восстанавливает ссылки пользователя из корзины.
+//
+// Тело - JSON-массив ключей, как у DeleteHandler; в ответ возвращаются ключи восстановленных ссылок.
+func RestoreHandler(s storage.Storage, sugar *zap.SugaredLogger) http.HandlerFunc {
+	svc := service.NewShortener(s, "")
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value(middleware.UserIDKey).(string)
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "Invalid content type", http.StatusBadRequest)
+			return
+		}
+
+		var keys []string
+		if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+			http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+			return
+		}
+
+		restored, err := svc.RestoreUserURLs(r.Context(), userID, keys)
+		switch {
+		case errors.Is(err, service.ErrUnauthorized):
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		case errors.Is(err, service.ErrEmptyBatch):
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		case errors.Is(err, service.ErrReadOnly):
+			http.Error(w, "Storage is read-only", http.StatusServiceUnavailable)
+			return
+		case errors.Is(err, service.ErrExpired):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			sugar.Errorf("Restore error: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if restored == nil {
+			restored = []string{}
+		}
+		writeJSON(w, http.StatusOK, restored, sugar)
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/NailUsmanov/practicum-shortener-url/internal/middleware"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/models"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/service"
+	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// UpdateLink правит короткую ссылку пользователя, не меняя её ключ.
+//
+// Тело - models.RequestUpdateURL; в ответ возвращается ссылка после правки.
+func UpdateLink(s storage.Storage, baseURL string, sugar *zap.SugaredLogger) http.HandlerFunc {
+	svc := service.NewShortener(s, baseURL)
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value(middleware.UserIDKey).(string)
+		key := chi.URLParam(r, "id")
+
+		var req models.RequestUpdateURL
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		upd := storage.LinkUpdate{OriginalURL: req.URL, RedirectType: req.RedirectType}
+		if req.ExpiresAt != nil || req.TTL != 0 {
+			expiresAt, err := service.ExpiresAt(req.ExpiresAt, req.TTL, time.Now())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			upd.ExpiresAt = &expiresAt
+		}
+
+		link, err := svc.UpdateLink(r.Context(), userID, key, upd)
+		switch {
+		case errors.Is(err, service.ErrUnauthorized):
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		case errors.Is(err, service.ErrEmptyUpdate), errors.Is(err, service.ErrEmptyURL), errors.Is(err, service.ErrInvalidURL),
+			errors.Is(err, service.ErrInvalidRedirectType), errors.Is(err, service.ErrInvalidExpiry):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, service.ErrNotFound):
+			http.Error(w, "URL not found", http.StatusNotFound)
+			return
+		case errors.Is(err, service.ErrDeleted):
+			http.Error(w, "URL deleted", http.StatusGone)
+			return
+		case errors.Is(err, service.ErrConflict):
+			http.Error(w, "URL already shortened as "+svc.ShortURL(link.Key), http.StatusConflict)
+			return
+		case errors.Is(err, service.ErrReadOnly):
+			http.Error(w, "Storage is read-only", http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			sugar.Errorf("UpdateLink error: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		resp := models.UserLink{
+			ShortURL:     svc.ShortURL(key),
+			OriginalURL:  link.OriginalURL,
+			RedirectType: link.RedirectType,
+			ExpiresAt:    timePtr(link.ExpiresAt),
+		}
+		writeJSON(w, http.StatusOK, resp, sugar)
+	}
+}
+
+// GetLinkRevisions выдает историю правок короткой ссылки пользователя от старых версий к новым.
+func GetLinkRevisions(s storage.Storage, baseURL string, sugar *zap.SugaredLogger) http.HandlerFunc {
+	svc := service.NewShortener(s, baseURL)
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value(middleware.UserIDKey).(string)
+		key := chi.URLParam(r, "id")
+
+		revisions, err := svc.LinkRevisions(r.Context(), userID, key)
+		switch {
+		case errors.Is(err, service.ErrUnauthorized):
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		case errors.Is(err, service.ErrNotFound):
+			http.Error(w, "URL not found", http.StatusNotFound)
+			return
+		case err != nil:
+			sugar.Errorf("GetLinkRevisions error: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		resp := make([]models.LinkRevision, 0, len(revisions))
+		for _, rev := range revisions {
+			resp = append(resp, models.LinkRevision{
+				OriginalURL:  rev.OriginalURL,
+				RedirectType: rev.RedirectType,
+				ExpiresAt:    timePtr(rev.ExpiresAt),
+				ChangedAt:    rev.ChangedAt,
+			})
+		}
+		writeJSON(w, http.StatusOK, resp, sugar)
+	}
+}
+
+// timePtr возвращает указатель на t или nil для нулевого времени.
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
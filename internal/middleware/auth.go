@@ -2,9 +2,9 @@ package middleware
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
 	"net/http"
+
+	"github.com/NailUsmanov/practicum-shortener-url/internal/auth"
 )
 
 // Ключ для хранения userID в контексте.
@@ -15,47 +15,62 @@ const (
 	UserIDKey contextKey = "userID"
 )
 
-// AuthMiddleware - HTTP middleware, проводит аутентификацию пользователя.
+// authCookieName - имя куки с токеном пользователя (именно такое имя требует Практикум).
+const authCookieName = "auth_token"
+
+// AuthMiddleware возвращает HTTP middleware, проводящее аутентификацию пользователя.
+//
+// Кука содержит userID и его HMAC-SHA256 подпись. Ключи передаются в порядке приоритета:
+// первым ключом подписываются новые куки, остальные принимаются только для проверки,
+// что позволяет менять ключи без потери привязки ссылок к пользователям.
+// Если кука подписана одним из старых ключей, она незаметно перевыпускается с основным ключом.
+// Если кука отсутствует, подделана или подписана неизвестным ключом, то генерируется новый UserID
+// и выдаётся новая подписанная кука. Затем UserID добавляется в контекст.
 //
-// Использует куки. Если кука отстутствует, то генерируется новый токен и UserID.
-// Если кука есть, то используется ее значение как UserID
-// Затем она добавляется в контекст.
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 1. Проверяем куку auth_token (как требуют тесты Практикума)
-		cookie, err := r.Cookie("auth_token")
-		var userID string
+// Без ключей подписывать куки нечем, поэтому AuthMiddleware паникует при пустом secretKeys
+// ещё при сборке маршрутов, а не на первом запросе.
+func AuthMiddleware(secretKeys [][]byte) func(http.Handler) http.Handler {
+	if len(secretKeys) == 0 {
+		panic("middleware: AuthMiddleware requires at least one signing key")
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var (
+				userID   string
+				keyIndex int
+			)
 
-		if err != nil {
-			// 2. Если куки нет - генерируем новый токен и userID
-			userID = generateUserID()
-			setAuthCookie(w, userID)
-		} else {
-			// 3. Если кука есть - используем её значение как userID
-			userID = cookie.Value
-		}
+			// 1. Проверяем подпись куки auth_token
+			cookie, err := r.Cookie(authCookieName)
+			if err == nil {
+				userID, keyIndex, err = auth.Parse(cookie.Value, secretKeys)
+			}
 
-		// 4. Добавляем userID в контекст
-		ctx := context.WithValue(r.Context(), UserIDKey, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
-}
+			switch {
+			case err != nil:
+				// 2. Если куки нет или она невалидна - это новый анонимный пользователь
+				userID = auth.NewUserID()
+				setAuthCookie(w, auth.Sign(userID, secretKeys[0]))
+			case keyIndex > 0:
+				// 3. Кука подписана устаревшим ключом - перевыпускаем с основным
+				setAuthCookie(w, auth.Sign(userID, secretKeys[0]))
+			}
 
-// Генерация нового userID
-func generateUserID() string {
-	b := make([]byte, 16)
-	rand.Read(b)
-	return base64.URLEncoding.EncodeToString(b)
+			// 4. Добавляем userID в контекст
+			ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
 
 // Установка куки аутентификации
-func setAuthCookie(w http.ResponseWriter, userID string) {
+func setAuthCookie(w http.ResponseWriter, token string) {
 	http.SetCookie(w, &http.Cookie{
-		Name:  "auth_token", // именно такое имя требует Практикум
-		Value: userID,
-		Path:  "/",
+		Name:     authCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true, // защита от XSS
 		// Secure: true, // раскомментировать для HTTPS
-		// HttpOnly: true, // защита от XSS
 	})
 }
 
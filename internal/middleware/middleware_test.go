@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"compress/gzip"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/NailUsmanov/practicum-shortener-url/internal/auth"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
@@ -204,3 +207,118 @@ func TestLoggingMiddleware(t *testing.T) {
 		// Здесь можно добавить проверки логов, если используете zaptest
 	})
 }
+
+func TestAuthMiddleware(t *testing.T) {
+	secret := []byte("test-secret")
+	oldSecret := []byte("old-secret")
+
+	assert.Panics(t, func() { AuthMiddleware(nil) }, "cookies cannot be signed without a key")
+
+	var gotUserID string
+	handler := AuthMiddleware([][]byte{secret, oldSecret})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUserID, _ = GetUserIDFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	t.Run("no cookie issues signed token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		res := rec.Result()
+		defer res.Body.Close()
+
+		require.Len(t, res.Cookies(), 1)
+		cookie := res.Cookies()[0]
+		assert.Equal(t, "auth_token", cookie.Name)
+		assert.NotEmpty(t, gotUserID)
+
+		userID, keyIndex, err := auth.Parse(cookie.Value, [][]byte{secret})
+		require.NoError(t, err)
+		assert.Equal(t, gotUserID, userID)
+		assert.Equal(t, 0, keyIndex)
+	})
+
+	t.Run("valid cookie keeps user", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: auth.Sign("user1", secret)})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		res := rec.Result()
+		defer res.Body.Close()
+
+		assert.Equal(t, "user1", gotUserID)
+		assert.Empty(t, res.Cookies())
+	})
+
+	t.Run("old key cookie is reissued with primary key", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: auth.Sign("user1", oldSecret)})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		res := rec.Result()
+		defer res.Body.Close()
+
+		assert.Equal(t, "user1", gotUserID)
+		require.Len(t, res.Cookies(), 1)
+		assert.Equal(t, auth.Sign("user1", secret), res.Cookies()[0].Value)
+	})
+
+	t.Run("forged cookie becomes new user", func(t *testing.T) {
+		forged := []string{
+			"user1",
+			"user1.deadbeef",
+			auth.Sign("user1", []byte("other-secret")),
+			"user2." + strings.SplitN(auth.Sign("user1", secret), ".", 2)[1],
+		}
+		for _, value := range forged {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(&http.Cookie{Name: "auth_token", Value: value})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			res := rec.Result()
+			res.Body.Close()
+
+			assert.NotEqual(t, "user1", gotUserID, value)
+			assert.NotEqual(t, "user2", gotUserID, value)
+			require.Len(t, res.Cookies(), 1, value)
+		}
+	})
+}
+
+func TestTrustedSubnetMiddleware(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("192.168.1.0/24")
+	require.NoError(t, err)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		subnet     *net.IPNet
+		realIP     string
+		wantStatus int
+	}{
+		{name: "trusted ip", subnet: subnet, realIP: "192.168.1.10", wantStatus: http.StatusOK},
+		{name: "foreign ip", subnet: subnet, realIP: "10.0.0.1", wantStatus: http.StatusForbidden},
+		{name: "no header", subnet: subnet, realIP: "", wantStatus: http.StatusForbidden},
+		{name: "no subnet", subnet: nil, realIP: "192.168.1.10", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/internal/compact", nil)
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			rec := httptest.NewRecorder()
+			TrustedSubnetMiddleware(tt.subnet)(next).ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
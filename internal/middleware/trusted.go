@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+)
+
+// TrustedSubnetMiddleware возвращает middleware, пропускающее только запросы из доверенной подсети.
+//
+// IP клиента берётся из заголовка X-Real-IP. Если подсеть не задана, доступ запрещён всем.
+func TrustedSubnetMiddleware(subnet *net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := net.ParseIP(r.Header.Get("X-Real-IP"))
+			if subnet == nil || ip == nil || !subnet.Contains(ip) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
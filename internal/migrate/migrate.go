@@ -0,0 +1,280 @@
+// Package migrate переносит записи между хранилищами при остановленном сервере.
+//
+// Записи читаются из источника пачками по возрастанию ключа и загружаются в приёмник как есть:
+// с ключами, владельцами, пометками удаления и атрибутами. После каждой пачки последний
+// перенесённый ключ сохраняется в файл состояния, поэтому прерванный перенос продолжается
+// с места остановки. В конце оба хранилища читаются заново и сравниваются по числу записей
+// и контрольной сумме.
+//
+// Переносятся только записи ссылок, в том числе счётчики израсходованных переходов. История правок
+// ссылок и события переходов, из которых строится статистика, не переносятся и в проверку
+// не входят, поэтому Run отказывается начинать перенос, пока потеря не подтверждена WithSkipHistory.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+)
+
+// DefaultBatchSize - сколько записей переносится за одну пачку.
+const DefaultBatchSize = 1000
+
+// NotMigrated - что остаётся в источнике при переносе. Run печатает это в ход переноса.
+const NotMigrated = "link revision history and click events are not migrated, only link records are copied and verified"
+
+var (
+	// ErrNotEmpty возвращается, если новый перенос начинается в непустое хранилище.
+	ErrNotEmpty = errors.New("destination storage is not empty")
+
+	// ErrMismatch возвращается, если после переноса хранилища различаются числом записей или контрольной суммой.
+	ErrMismatch = errors.New("verification failed")
+
+	// ErrForeignState возвращается, если файл состояния принадлежит переносу между другими хранилищами.
+	ErrForeignState = errors.New("state file belongs to another migration")
+
+	// ErrHistoryNotMigrated возвращается, если перенос запущен без WithSkipHistory.
+	ErrHistoryNotMigrated = errors.New("link revision history and click events cannot be migrated")
+)
+
+// Destination - хранилище, в которое переносятся записи. Его записи читаются для проверки.
+type Destination interface {
+	storage.RecordScanner
+	storage.RecordLoader
+}
+
+// Summary - число записей хранилища и контрольная сумма SHA-256 их нормализованного вида в порядке ключей.
+type Summary struct {
+	Count    int
+	Checksum string
+}
+
+// Result - итог переноса.
+type Result struct {
+	Copied  int     // Записей перенесено, включая перенесённые до прерывания
+	Resumed bool    // Перенос продолжен по файлу состояния
+	Source  Summary // Итог проверки источника
+	Target  Summary // Итог проверки приёмника
+}
+
+// state - содержимое файла состояния.
+type state struct {
+	Job     string `json:"job"`      // Отпечаток пары хранилищ, см. WithState
+	LastKey string `json:"last_key"` // Последний перенесённый ключ
+	Copied  int    `json:"copied"`
+}
+
+// Migrator переносит записи из одного хранилища в другое.
+type Migrator struct {
+	from      storage.RecordScanner
+	to        Destination
+	batchSize int
+	statePath string
+	job       string
+	progress  io.Writer
+	// Потеря истории правок и событий переходов подтверждена, см. WithSkipHistory
+	skipHistory bool
+}
+
+// Option настраивает перенос.
+type Option func(*Migrator)
+
+// WithBatchSize задаёт размер пачки. Неположительный размер заменяется на DefaultBatchSize.
+func WithBatchSize(n int) Option {
+	return func(m *Migrator) {
+		if n > 0 {
+			m.batchSize = n
+		}
+	}
+}
+
+// WithState включает продолжение после прерывания: состояние сохраняется в файл path.
+// job - отпечаток пары хранилищ; состояние другого переноса не используется.
+func WithState(path, job string) Option {
+	return func(m *Migrator) {
+		m.statePath, m.job = path, job
+	}
+}
+
+// WithProgress задаёт, куда печатать ход переноса. По умолчанию ход не печатается.
+func WithProgress(w io.Writer) Option {
+	return func(m *Migrator) {
+		m.progress = w
+	}
+}
+
+// WithSkipHistory подтверждает, что история правок и события переходов остаются только в источнике.
+// Без этого Run возвращает ErrHistoryNotMigrated, ничего не перенося.
+func WithSkipHistory(skip bool) Option {
+	return func(m *Migrator) {
+		m.skipHistory = skip
+	}
+}
+
+// New создаёт перенос записей из from в to.
+func New(from storage.RecordScanner, to Destination, opts ...Option) *Migrator {
+	m := &Migrator{from: from, to: to, batchSize: DefaultBatchSize, progress: io.Discard}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Job возвращает отпечаток пары хранилищ по их адресам для WithState. Сами адреса
+// в файл состояния не попадают: они могут содержать пароли.
+func Job(from, to string) string {
+	sum := sha256.Sum256([]byte(from + "\n" + to))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Run переносит записи и проверяет результат.
+//
+// Перенос начинается, только если потеря истории подтверждена WithSkipHistory, а новый
+// перенос к тому же требует пустого приёмника. При ошибке или отмене ctx уже перенесённые пачки
+// остаются в приёмнике, а повторный Run с тем же файлом состояния продолжает с места остановки.
+// После успешной проверки файл состояния удаляется.
+func (m *Migrator) Run(ctx context.Context) (Result, error) {
+	if !m.skipHistory {
+		return Result{}, ErrHistoryNotMigrated
+	}
+	st, resumed, err := m.loadState()
+	if err != nil {
+		return Result{}, err
+	}
+	res := Result{Copied: st.Copied, Resumed: resumed}
+	fmt.Fprintf(m.progress, "note: %s\n", NotMigrated)
+	if resumed {
+		fmt.Fprintf(m.progress, "resuming after key %q, %d records already copied\n", st.LastKey, st.Copied)
+	} else {
+		existing, err := m.to.ScanRecords(ctx, "", 1)
+		if err != nil {
+			return res, fmt.Errorf("check destination: %w", err)
+		}
+		if len(existing) > 0 {
+			return res, ErrNotEmpty
+		}
+	}
+
+	started := time.Now()
+	for {
+		batch, err := m.from.ScanRecords(ctx, st.LastKey, m.batchSize)
+		if err != nil {
+			return res, fmt.Errorf("read source after key %q: %w", st.LastKey, err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		if err := m.to.LoadRecords(ctx, batch); err != nil {
+			return res, fmt.Errorf("load records after key %q: %w", st.LastKey, err)
+		}
+		st.LastKey = batch[len(batch)-1].Key
+		st.Copied += len(batch)
+		res.Copied = st.Copied
+		if err := m.saveState(st); err != nil {
+			return res, err
+		}
+		fmt.Fprintf(m.progress, "copied %d records (last key %q, %s)\n", st.Copied, st.LastKey, time.Since(started).Round(time.Millisecond))
+	}
+
+	fmt.Fprintln(m.progress, "verifying...")
+	if res.Source, err = m.summarize(ctx, m.from); err != nil {
+		return res, fmt.Errorf("verify source: %w", err)
+	}
+	if res.Target, err = m.summarize(ctx, m.to); err != nil {
+		return res, fmt.Errorf("verify destination: %w", err)
+	}
+	if res.Source != res.Target {
+		return res, fmt.Errorf("%w: source has %d records (checksum %s), destination has %d (checksum %s)",
+			ErrMismatch, res.Source.Count, res.Source.Checksum, res.Target.Count, res.Target.Checksum)
+	}
+	if m.statePath != "" {
+		if err := os.Remove(m.statePath); err != nil && !os.IsNotExist(err) {
+			return res, fmt.Errorf("remove state file: %w", err)
+		}
+	}
+	return res, nil
+}
+
+// summarize читает все записи хранилища и считает их число и контрольную сумму.
+func (m *Migrator) summarize(ctx context.Context, s storage.RecordScanner) (Summary, error) {
+	hash := sha256.New()
+	enc := json.NewEncoder(hash)
+	var sum Summary
+	after := ""
+	for {
+		batch, err := s.ScanRecords(ctx, after, m.batchSize)
+		if err != nil {
+			return Summary{}, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		for _, r := range batch {
+			if err := enc.Encode(r.Normalize()); err != nil {
+				return Summary{}, err
+			}
+		}
+		sum.Count += len(batch)
+		after = batch[len(batch)-1].Key
+	}
+	sum.Checksum = hex.EncodeToString(hash.Sum(nil))
+	return sum, nil
+}
+
+// loadState читает файл состояния. Без файла перенос начинается сначала.
+func (m *Migrator) loadState() (state, bool, error) {
+	st := state{Job: m.job}
+	if m.statePath == "" {
+		return st, false, nil
+	}
+	data, err := os.ReadFile(m.statePath)
+	if os.IsNotExist(err) {
+		return st, false, nil
+	}
+	if err != nil {
+		return st, false, fmt.Errorf("read state file: %w", err)
+	}
+	var saved state
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return st, false, fmt.Errorf("parse state file %s: %w", m.statePath, err)
+	}
+	if saved.Job != m.job {
+		return st, false, fmt.Errorf("%w: %s", ErrForeignState, m.statePath)
+	}
+	return saved, true, nil
+}
+
+// saveState атомарно заменяет файл состояния.
+func (m *Migrator) saveState(st state) error {
+	if m.statePath == "" {
+		return nil
+	}
+	data, _ := json.Marshal(st) // Структура из строк и числа кодируется без ошибок
+	tmp, err := os.CreateTemp(filepath.Dir(m.statePath), filepath.Base(m.statePath)+".*")
+	if err != nil {
+		return fmt.Errorf("save state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), m.statePath)
+	}
+	if err != nil {
+		return fmt.Errorf("save state: %w", err)
+	}
+	return nil
+}
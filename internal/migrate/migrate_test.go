@@ -0,0 +1,132 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// source возвращает хранилище с count ссылками, часть которых удалена.
+func source(t *testing.T, count int) *storage.MemoryStorage {
+	ctx := context.Background()
+	s := storage.NewMemoryStorage()
+	for i := 0; i < count; i++ {
+		user := fmt.Sprintf("user%d", i%2)
+		key, err := s.Save(ctx, fmt.Sprintf("http://example.com/%d", i), user)
+		require.NoError(t, err)
+		if i%3 == 0 {
+			require.NoError(t, s.MarkAsDeleted(ctx, []string{key}, user))
+		}
+	}
+	return s
+}
+
+// failingDestination отказывает в загрузке после failAfter успешных вызовов, а с drop молча теряет записи.
+type failingDestination struct {
+	Destination
+	failAfter int
+	drop      bool
+}
+
+func (d *failingDestination) LoadRecords(ctx context.Context, records []storage.Record) error {
+	if d.drop {
+		records = records[1:]
+	}
+	if d.failAfter == 0 {
+		return errors.New("connection lost")
+	}
+	d.failAfter--
+	return d.Destination.LoadRecords(ctx, records)
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	from := source(t, 5)
+	path := filepath.Join(t.TempDir(), "target.json")
+	to, err := storage.NewFileStorage(path)
+	require.NoError(t, err)
+	defer to.Close()
+	statePath := filepath.Join(t.TempDir(), "state")
+
+	var progress bytes.Buffer
+	res, err := New(from, to, WithBatchSize(2), WithState(statePath, "job"), WithProgress(&progress), WithSkipHistory(true)).Run(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 5, res.Copied)
+	assert.False(t, res.Resumed)
+	assert.Equal(t, res.Source, res.Target)
+	assert.Equal(t, 5, res.Target.Count)
+	assert.Contains(t, progress.String(), "copied 4 records")
+	assert.Contains(t, progress.String(), NotMigrated, "history and click events are reported as not migrated")
+	assert.NoFileExists(t, statePath, "state is removed after verification")
+
+	src, err := from.ScanRecords(ctx, "", 0)
+	require.NoError(t, err)
+	dst, err := to.ScanRecords(ctx, "", 0)
+	require.NoError(t, err)
+	require.Len(t, dst, len(src))
+	for i := range src {
+		assert.True(t, src[i].Equal(dst[i]), "record %s", src[i].Key)
+	}
+
+	// Повторный перенос в заполненное хранилище не начинается
+	_, err = New(from, to, WithState(statePath, "job"), WithSkipHistory(true)).Run(ctx)
+	assert.ErrorIs(t, err, ErrNotEmpty)
+}
+
+func TestRunSkipHistory(t *testing.T) {
+	ctx := context.Background()
+	from := source(t, 3)
+	to := storage.NewMemoryStorage()
+	statePath := filepath.Join(t.TempDir(), "state")
+
+	// Без подтверждения ничего не переносится и файл состояния не создаётся
+	res, err := New(from, to, WithState(statePath, "job")).Run(ctx)
+	assert.ErrorIs(t, err, ErrHistoryNotMigrated)
+	assert.Zero(t, res.Copied)
+	assert.NoFileExists(t, statePath)
+	records, err := to.ScanRecords(ctx, "", 0)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestRunResume(t *testing.T) {
+	ctx := context.Background()
+	from := source(t, 5)
+	to := storage.NewMemoryStorage()
+	statePath := filepath.Join(t.TempDir(), "state")
+
+	_, err := New(from, &failingDestination{Destination: to, failAfter: 1}, WithBatchSize(2), WithState(statePath, "job"), WithSkipHistory(true)).Run(ctx)
+	require.Error(t, err)
+	require.FileExists(t, statePath)
+
+	_, err = New(from, to, WithBatchSize(2), WithState(statePath, "other"), WithSkipHistory(true)).Run(ctx)
+	assert.ErrorIs(t, err, ErrForeignState)
+
+	var progress bytes.Buffer
+	res, err := New(from, to, WithBatchSize(2), WithState(statePath, "job"), WithProgress(&progress), WithSkipHistory(true)).Run(ctx)
+	require.NoError(t, err)
+	assert.True(t, res.Resumed)
+	assert.Equal(t, 5, res.Copied)
+	assert.Equal(t, 5, res.Target.Count)
+	assert.Contains(t, progress.String(), "2 records already copied")
+	_, err = os.Stat(statePath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRunMismatch(t *testing.T) {
+	ctx := context.Background()
+	from := source(t, 3)
+
+	res, err := New(from, &failingDestination{Destination: storage.NewMemoryStorage(), failAfter: 10, drop: true}, WithSkipHistory(true)).Run(ctx)
+	assert.ErrorIs(t, err, ErrMismatch)
+	assert.Equal(t, 3, res.Source.Count)
+	assert.Equal(t, 2, res.Target.Count)
+}
@@ -1,20 +1,42 @@
 // Package models описывает структуры запросов и ответов, используемых в эндпоинтах.
 package models
 
-// RequestURL содержит URL для сокращения.
+import "time"
+
+// RequestURL содержит URL для сокращения и необязательные параметры ссылки:
+// алиас - желаемый ключ короткой ссылки, срок действия - момент expires_at (RFC 3339)
+// или ttl в секундах от создания, max_clicks - сколько раз ссылку можно открыть, password -
+// пароль, без которого ссылка не откроется, redirect_type - статус редиректа (301, 302, 307, 308)
+// и cache_max_age - сколько секунд клиенты могут кешировать редирект (-1 - не кешировать).
 type RequestURL struct {
-	URL string `json:"url"`
+	URL       string     `json:"url"`
+	Alias     string     `json:"alias,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	TTL       int64      `json:"ttl,omitempty"`
+	MaxClicks int64      `json:"max_clicks,omitempty"`
+	Password  string     `json:"password,omitempty"`
+
+	RedirectType int   `json:"redirect_type,omitempty"`
+	CacheMaxAge  int64 `json:"cache_max_age,omitempty"`
 }
 
 // Response содержит сокращенный URL.
 type Response struct {
 	Result string `json:"result"`
+	Error  string `json:"error,omitempty"` // Почему запрос выполнен не полностью, например алиас не применён
 }
 
 // RequestURLMassiv содержит массив URL для дальнейшего сокращения.
 type RequestURLMassiv struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	CorrelationID string     `json:"correlation_id"`
+	OriginalURL   string     `json:"original_url"`
+	Alias         string     `json:"alias,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	TTL           int64      `json:"ttl,omitempty"`
+	MaxClicks     int64      `json:"max_clicks,omitempty"`
+	Password      string     `json:"password,omitempty"`
+	RedirectType  int        `json:"redirect_type,omitempty"`
+	CacheMaxAge   int64      `json:"cache_max_age,omitempty"`
 }
 
 // ResponseMassiv содержит ответ с уже сокращенными URL в массиве.
@@ -23,8 +45,110 @@ type ResponseMassiv struct {
 	ShortURL      string `json:"short_url"`
 }
 
-// UserURLs содержит все пары сокращенного и оригинального URL конкретного пользователя.
+// UserURLs содержит пару сокращенного и оригинального URL пользователя, момент создания ссылки
+// (нет у ссылок, сохранённых до его учёта) и флаг удаления (только с include_deleted).
 type UserURLs struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	IsDeleted   bool       `json:"is_deleted,omitempty"`
+}
+
+// LinkStats содержит статистику переходов по короткой ссылке.
+type LinkStats struct {
+	ShortURL     string          `json:"short_url"`
+	TotalClicks  int64           `json:"total_clicks"`
+	ClicksPerDay []DayClicks     `json:"clicks_per_day"`
+	TopReferrers []ReferrerStats `json:"top_referrers"`
+}
+
+// DayClicks содержит число переходов за сутки (UTC).
+type DayClicks struct {
+	Date   string `json:"date"`
+	Clicks int64  `json:"clicks"`
+}
+
+// ReferrerStats содержит число переходов с одного источника.
+type ReferrerStats struct {
+	Referrer string `json:"referrer"`
+	Clicks   int64  `json:"clicks"`
+}
+
+// RequestUpdateURL содержит правку ссылки пользователя. Поля, которых нет в запросе, не меняются:
+// url - новый оригинальный URL, redirect_type - статус редиректа (0 - по умолчанию сервера),
+// срок действия - момент expires_at (RFC 3339) или ttl в секундах от правки.
+type RequestUpdateURL struct {
+	URL          *string    `json:"url,omitempty"`
+	RedirectType *int       `json:"redirect_type,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	TTL          int64      `json:"ttl,omitempty"`
+}
+
+// UserLink содержит ссылку пользователя вместе с правимыми атрибутами.
+type UserLink struct {
+	ShortURL     string     `json:"short_url"`
+	OriginalURL  string     `json:"original_url"`
+	RedirectType int        `json:"redirect_type,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+}
+
+// LinkRevision содержит прежнюю версию ссылки и момент правки, которая её заменила.
+type LinkRevision struct {
+	OriginalURL  string     `json:"original_url"`
+	RedirectType int        `json:"redirect_type,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	ChangedAt    time.Time  `json:"changed_at"`
+}
+
+// TrashURL содержит удалённую ссылку пользователя в корзине.
+type TrashURL struct {
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	DeletedAt   time.Time `json:"deleted_at"`
+}
+
+// ExportURL содержит ссылку пользователя в выгрузке: строку JSONL или одноимённые колонки CSV.
+// Загрузка принимает те же поля, но short_url и created_at в ней не учитываются, а строки
+// с protected отклоняются: пароль ссылки не выгружается.
+type ExportURL struct {
+	Key          string     `json:"key,omitempty"`
+	ShortURL     string     `json:"short_url,omitempty"`
+	OriginalURL  string     `json:"original_url"`
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	MaxClicks    int64      `json:"max_clicks,omitempty"`
+	Clicks       int64      `json:"clicks,omitempty"`
+	RedirectType int        `json:"redirect_type,omitempty"`
+	CacheMaxAge  int64      `json:"cache_max_age,omitempty"`
+	Protected    bool       `json:"protected,omitempty"`
+}
+
+// ImportReport содержит итог загрузки ссылок: число строк по исходам и строки,
+// которые не сохранены под исходным ключом.
+type ImportReport struct {
+	Created    int         `json:"created"`
+	KeyChanged int         `json:"key_changed"`
+	Conflicts  int         `json:"conflicts"`
+	Invalid    int         `json:"invalid"`
+	Rows       []ImportRow `json:"rows"`
+	Error      string      `json:"error,omitempty"` // Причина, по которой загрузка прервана; сохранённое до неё учтено
+}
+
+// ImportRow содержит исход строки загрузки: key_changed - ключ занят и ссылка сохранена под новым,
+// conflict - URL уже сокращён (short_url - существующая ссылка), invalid - строка отклонена.
+type ImportRow struct {
+	Line     int    `json:"line"`
+	Key      string `json:"key,omitempty"`
+	ShortURL string `json:"short_url,omitempty"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// CacheStats содержит счётчики кеша ссылок: попадания, чтения из хранилища и промахи,
+// дождавшиеся чужого чтения того же ключа.
+type CacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Coalesced int64 `json:"coalesced"`
+	Entries   int   `json:"entries"`
 }
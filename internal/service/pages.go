@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+)
+
+// MaxPageLimit - наибольший размер страницы ссылок пользователя.
+const MaxPageLimit = 1000
+
+// Порядки страницы ссылок пользователя.
+const (
+	SortByKey     = "key"
+	SortByCreated = "created"
+	OrderAsc      = "asc"
+	OrderDesc     = "desc"
+)
+
+// PageQuery - параметры страницы ссылок пользователя.
+type PageQuery struct {
+	Limit  int    // Максимум ссылок в странице. Нулевой - все ссылки одной страницей
+	Cursor string // NextCursor предыдущей страницы. Пустой - первая страница
+	Sort   string // SortByKey (по умолчанию) или SortByCreated
+	Order  string // OrderAsc (по умолчанию) или OrderDesc
+
+	CreatedAfter   time.Time // Только ссылки, созданные позже. Нулевой - без фильтра
+	Domain         string    // Только ссылки на этот хост. Пустой - без фильтра
+	IncludeDeleted bool      // Включать ли удалённые ссылки
+}
+
+// UserURLsPage - страница ссылок пользователя.
+type UserURLsPage struct {
+	URLs       []UserURL
+	NextCursor string // Курсор следующей страницы. Пустой - страница последняя
+}
+
+// pageCursor - содержимое курсора страницы. Порядок входит в курсор, чтобы его нельзя было
+// продолжить в другом порядке.
+type pageCursor struct {
+	Sort      string    `json:"s"`
+	Order     string    `json:"o"`
+	CreatedAt time.Time `json:"t"`
+	Key       string    `json:"k"`
+}
+
+// UserURLsPage возвращает страницу ссылок пользователя в порядке q.Sort и q.Order.
+//
+// Следующая страница запрашивается с курсором NextCursor и тем же порядком.
+func (s *Shortener) UserURLsPage(ctx context.Context, userID string, q PageQuery) (UserURLsPage, error) {
+	if userID == "" {
+		return UserURLsPage{}, ErrUnauthorized
+	}
+	query, err := q.storageQuery(userID)
+	if err != nil {
+		return UserURLsPage{}, err
+	}
+
+	page, err := s.storage.GetUserURLsPage(ctx, query)
+	if err != nil {
+		return UserURLsPage{}, fmt.Errorf("get user urls page: %w", err)
+	}
+
+	result := UserURLsPage{URLs: make([]UserURL, 0, len(page.URLs))}
+	for _, u := range page.URLs {
+		result.URLs = append(result.URLs, UserURL{
+			Key:         u.Key,
+			ShortURL:    s.ShortURL(u.Key),
+			OriginalURL: u.OriginalURL,
+			CreatedAt:   u.CreatedAt,
+			Deleted:     u.Deleted,
+		})
+	}
+	if page.Next != nil {
+		result.NextCursor = encodeCursor(pageCursor{Sort: q.Sort, Order: q.Order, CreatedAt: page.Next.CreatedAt, Key: page.Next.Key})
+	}
+	return result, nil
+}
+
+// storageQuery проверяет параметры страницы, подставляет значения по умолчанию
+// и возвращает запрос к хранилищу.
+func (q *PageQuery) storageQuery(userID string) (storage.UserURLsQuery, error) {
+	if q.Limit < 0 || q.Limit > MaxPageLimit {
+		return storage.UserURLsQuery{}, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidPageQuery, MaxPageLimit)
+	}
+	if q.Sort == "" {
+		q.Sort = SortByKey
+	}
+	if q.Order == "" {
+		q.Order = OrderAsc
+	}
+
+	query := storage.UserURLsQuery{
+		UserID:         userID,
+		Limit:          q.Limit,
+		Desc:           q.Order == OrderDesc,
+		CreatedAfter:   q.CreatedAfter,
+		Domain:         strings.ToLower(q.Domain),
+		IncludeDeleted: q.IncludeDeleted,
+	}
+	switch q.Sort {
+	case SortByKey:
+		query.Sort = storage.SortByKey
+	case SortByCreated:
+		query.Sort = storage.SortByCreated
+	default:
+		return storage.UserURLsQuery{}, fmt.Errorf("%w: unknown sort %q", ErrInvalidPageQuery, q.Sort)
+	}
+	if q.Order != OrderAsc && q.Order != OrderDesc {
+		return storage.UserURLsQuery{}, fmt.Errorf("%w: unknown order %q", ErrInvalidPageQuery, q.Order)
+	}
+
+	if q.Cursor != "" {
+		cursor, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return storage.UserURLsQuery{}, err
+		}
+		if cursor.Sort != q.Sort || cursor.Order != q.Order {
+			return storage.UserURLsQuery{}, fmt.Errorf("%w: cursor belongs to another sort order", ErrInvalidPageQuery)
+		}
+		query.After = &storage.PageCursor{CreatedAt: cursor.CreatedAt, Key: cursor.Key}
+	}
+	return query, nil
+}
+
+// encodeCursor кодирует курсор страницы в непрозрачную строку для URL.
+func encodeCursor(c pageCursor) string {
+	data, _ := json.Marshal(c) // Структура из строк и времени кодируется без ошибок
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor разбирает курсор, выданный encodeCursor.
+func decodeCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil || c.Key == "" {
+		return pageCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidPageQuery)
+	}
+	return c, nil
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Ограничения на пароли защищённых ссылок.
+const (
+	// MaxPasswordLength - bcrypt учитывает только первые 72 байта пароля.
+	MaxPasswordLength = 72
+
+	// MaxPasswordAttempts - сколько неверных паролей к одной ссылке допускается за PasswordAttemptWindow.
+	MaxPasswordAttempts   = 5
+	PasswordAttemptWindow = time.Minute
+)
+
+// passwordCost - стоимость bcrypt; тесты уменьшают её, чтобы не тратить время на хеширование.
+var passwordCost = bcrypt.DefaultCost
+
+// hashPassword возвращает bcrypt-хеш пароля.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", fmt.Errorf("hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// ResolveLink возвращает ссылку с атрибутами для редиректа, проверяя пароль защищённой ссылки.
+//
+// Для ссылок без пароля пароль не нужен. Если пароль защищённой ссылки не передан,
+// возвращает ErrPasswordRequired, если неверен - ErrWrongPassword. После MaxPasswordAttempts
+// неверных попыток за PasswordAttemptWindow ссылка временно не проверяет пароли и
+// возвращает ErrTooManyAttempts.
+//
+// Попытка занимает место в лимите до сравнения хеша и возвращается, если пароль оказался верным,
+// поэтому параллельные запросы не проверяют больше MaxPasswordAttempts паролей за окно.
+func (s *Shortener) ResolveLink(ctx context.Context, key string, password string) (storage.Link, error) {
+	if key == "" {
+		return storage.Link{}, ErrNotFound
+	}
+	link, err := s.storage.GetLink(ctx, key, nil)
+	if !errors.Is(err, ErrPasswordRequired) || password == "" {
+		return link, err
+	}
+
+	window, ok := s.attempts.reserve(key, time.Now())
+	if !ok {
+		return storage.Link{}, ErrTooManyAttempts
+	}
+	link, err = s.storage.GetLink(ctx, key, func(passwordHash string) bool {
+		return bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) == nil
+	})
+	if !errors.Is(err, storage.ErrWrongPassword) {
+		s.attempts.refund(key, window)
+	}
+	return link, err
+}
+
+// AttemptLimiter считает неверные пароли по ключам ссылок в фиксированных окнах.
+//
+// Один AttemptLimiter должен разделяться всеми сервисами процесса (HTTP и gRPC), иначе
+// каждый из них допускает свои MaxPasswordAttempts попыток.
+type AttemptLimiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	failures map[string]*attemptWindow
+}
+
+// attemptWindow - число занятых попыток в окне, начавшемся в start.
+type attemptWindow struct {
+	start time.Time
+	count int
+}
+
+// NewAttemptLimiter создаёт счётчик, допускающий max неверных паролей к ссылке за window.
+func NewAttemptLimiter(max int, window time.Duration) *AttemptLimiter {
+	return &AttemptLimiter{max: max, window: window, failures: make(map[string]*attemptWindow)}
+}
+
+// reserve занимает попытку проверить пароль ссылки key и возвращает начало окна, в котором она учтена.
+// false означает, что лимит окна исчерпан.
+func (l *AttemptLimiter) reserve(key string, now time.Time) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.failures[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		// Заодно забываем окна, которые уже закончились, чтобы карта не росла бесконечно
+		for k, old := range l.failures {
+			if now.Sub(old.start) >= l.window {
+				delete(l.failures, k)
+			}
+		}
+		w = &attemptWindow{start: now}
+		l.failures[key] = w
+	}
+	if w.count >= l.max {
+		return time.Time{}, false
+	}
+	w.count++
+	return w.start, true
+}
+
+// refund возвращает попытку, занятую в окне start, если пароль не оказался неверным.
+// Попытки из уже закончившегося окна не возвращаются.
+func (l *AttemptLimiter) refund(key string, start time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.failures[key]
+	if !ok || !w.start.Equal(start) || w.count == 0 {
+		return
+	}
+	w.count--
+	if w.count == 0 {
+		delete(l.failures, key)
+	}
+}
@@ -0,0 +1,775 @@
+// Package service содержит бизнес-правила сервиса сокращения ссылок.
+//
+// Не зависит от транспорта: HTTP-хендлеры и gRPC-сервер являются тонкими адаптерами над Shortener.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+)
+
+// Типизированные ошибки сервиса.
+var (
+	// ErrEmptyURL возникает, если URL для сокращения не передан.
+	ErrEmptyURL = errors.New("empty URL")
+
+	// ErrInvalidURL возникает, если URL не удалось разобрать.
+	ErrInvalidURL = errors.New("invalid URL")
+
+	// ErrEmptyBatch возникает, если пакет для сокращения или удаления пуст.
+	ErrEmptyBatch = errors.New("empty batch request")
+
+	// ErrConflict возникает, если URL уже был сокращён. Результат при этом содержит существующую ссылку.
+	ErrConflict = errors.New("url already exists")
+
+	// ErrUnauthorized возникает, если операция требует пользователя, а он не определён.
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrNotFound возникает, если короткая ссылка не найдена.
+	ErrNotFound = storage.ErrNotFound
+
+	// ErrDeleted возникает, если короткая ссылка удалена.
+	ErrDeleted = storage.ErrDeleted
+
+	// ErrReadOnly возникает при попытке записи в экземпляр, работающий ведомым только для чтения.
+	ErrReadOnly = storage.ErrReadOnly
+
+	// ErrInvalidAlias возникает, если алиас пустой, слишком длинный или содержит недопустимые символы.
+	ErrInvalidAlias = errors.New("invalid alias")
+
+	// ErrReservedAlias возникает, если алиас совпадает со служебным путём сервиса.
+	ErrReservedAlias = errors.New("alias is reserved")
+
+	// ErrAliasTaken возникает, если алиас уже занят другой ссылкой.
+	ErrAliasTaken = errors.New("alias already taken")
+
+	// ErrAliasNotApplied возникает вместе с ErrConflict, если уже сокращённый URL запрошен
+	// с другим алиасом: у URL остаётся прежний ключ, алиас не сохраняется.
+	ErrAliasNotApplied = errors.New("url is already shortened, alias not applied")
+
+	// ErrInvalidExpiry возникает, если срок действия ссылки задан некорректно или уже наступил.
+	ErrInvalidExpiry = errors.New("invalid expiration")
+
+	// ErrExpired возникает, если срок действия короткой ссылки истёк.
+	ErrExpired = storage.ErrExpired
+
+	// ErrInvalidMaxClicks возникает, если лимит переходов отрицательный.
+	ErrInvalidMaxClicks = errors.New("invalid max_clicks")
+
+	// ErrClickLimitReached возникает, если ссылка уже открыта разрешённое число раз.
+	ErrClickLimitReached = storage.ErrClickLimitReached
+
+	// ErrInvalidPassword возникает, если пароль для защиты ссылки длиннее MaxPasswordLength.
+	ErrInvalidPassword = errors.New("invalid password")
+
+	// ErrPasswordRequired возникает, если ссылка защищена паролем, а он не передан.
+	ErrPasswordRequired = storage.ErrPasswordRequired
+
+	// ErrWrongPassword возникает, если пароль защищённой ссылки неверен.
+	ErrWrongPassword = storage.ErrWrongPassword
+
+	// ErrTooManyAttempts возникает, если к ссылке слишком часто подбирают пароль.
+	ErrTooManyAttempts = errors.New("too many password attempts")
+
+	// ErrInvalidRedirectType возникает, если статус редиректа не 301, 302, 307 или 308.
+	ErrInvalidRedirectType = errors.New("invalid redirect_type")
+
+	// ErrInvalidCacheMaxAge возникает, если время кеширования редиректа меньше -1.
+	ErrInvalidCacheMaxAge = errors.New("invalid cache_max_age")
+
+	// ErrEmptyUpdate возникает, если в правке ссылки не задано ни одного поля.
+	ErrEmptyUpdate = errors.New("nothing to update")
+
+	// ErrInvalidPageQuery возникает, если параметры страницы ссылок пользователя заданы некорректно.
+	ErrInvalidPageQuery = errors.New("invalid page query")
+
+	// ErrStatsUnsupported возникает, если хранилище не ведёт статистику переходов.
+	ErrStatsUnsupported = errors.New("click stats are not supported by storage")
+)
+
+// TopReferrers - сколько самых частых источников переходов возвращает LinkStats.
+const TopReferrers = 10
+
+// Ограничения на алиасы - ключи коротких ссылок, выбранные пользователем.
+const (
+	MinAliasLength = 3
+	MaxAliasLength = 64
+)
+
+// reservedAliases - первые сегменты путей сервиса, которые нельзя занять алиасом.
+var reservedAliases = map[string]struct{}{
+	"api":      {},
+	"ping":     {},
+	"admin":    {},
+	"internal": {},
+	"debug":    {},
+	"static":   {},
+	"health":   {},
+}
+
+// Shortener реализует операции над короткими ссылками поверх storage.Storage.
+type Shortener struct {
+	storage  storage.Storage
+	baseURL  string
+	attempts *AttemptLimiter // Неверные пароли защищённых ссылок
+}
+
+// Option настраивает Shortener при создании.
+type Option func(*Shortener)
+
+// WithAttemptLimiter задаёт общий счётчик неверных паролей. Без него сервис заводит собственный.
+func WithAttemptLimiter(l *AttemptLimiter) Option {
+	return func(s *Shortener) {
+		s.attempts = l
+	}
+}
+
+// NewShortener создаёт сервис поверх хранилища s. baseURL используется для построения коротких ссылок.
+func NewShortener(s storage.Storage, baseURL string, opts ...Option) *Shortener {
+	svc := &Shortener{
+		storage: s,
+		baseURL: baseURL,
+	}
+	for _, opt := range opts {
+		opt(svc)
+	}
+	if svc.attempts == nil {
+		svc.attempts = NewAttemptLimiter(MaxPasswordAttempts, PasswordAttemptWindow)
+	}
+	return svc
+}
+
+// ShortenResult - результат сокращения одного URL.
+type ShortenResult struct {
+	Key      string
+	ShortURL string
+}
+
+// BatchItem - элемент пакета для сокращения.
+type BatchItem struct {
+	CorrelationID string
+	OriginalURL   string
+	Alias         string    // Необязательный ключ, выбранный пользователем
+	ExpiresAt     time.Time // Необязательный срок действия
+	MaxClicks     int64     // Необязательный лимит переходов
+	Password      string    // Необязательный пароль
+	RedirectType  int       // Необязательный статус редиректа
+	CacheMaxAge   int64     // Необязательное время кеширования редиректа
+}
+
+func (i BatchItem) options() LinkOptions {
+	return LinkOptions{
+		Alias:        i.Alias,
+		ExpiresAt:    i.ExpiresAt,
+		MaxClicks:    i.MaxClicks,
+		Password:     i.Password,
+		RedirectType: i.RedirectType,
+		CacheMaxAge:  i.CacheMaxAge,
+	}
+}
+
+// BatchResult - результат сокращения элемента пакета.
+type BatchResult struct {
+	CorrelationID string
+	ShortURL      string
+}
+
+// UserURL - пара короткой и оригинальной ссылки пользователя.
+type UserURL struct {
+	Key         string
+	ShortURL    string
+	OriginalURL string
+	CreatedAt   time.Time // Заполняется только в UserURLsPage
+	Deleted     bool      // Заполняется только в UserURLsPage
+}
+
+// TrashedURL - удалённая ссылка пользователя в корзине.
+type TrashedURL struct {
+	Key         string
+	ShortURL    string
+	OriginalURL string
+	DeletedAt   time.Time
+}
+
+// ShortURL строит полную короткую ссылку по ключу.
+func (s *Shortener) ShortURL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+// LinkOptions - необязательные параметры создаваемой ссылки.
+type LinkOptions struct {
+	Alias     string    // Ключ, выбранный пользователем. Пустой - ключ генерируется
+	ExpiresAt time.Time // Срок действия. Нулевой - бессрочная ссылка
+	MaxClicks int64     // Сколько раз ссылку можно открыть. Нулевой - без ограничения
+	Password  string    // Пароль для открытия ссылки. Пустой - ссылка открыта всем
+
+	RedirectType int   // Статус редиректа (см. ValidateRedirectType). Нулевой - по умолчанию сервера
+	CacheMaxAge  int64 // Время кеширования редиректа в секундах. 0 - по умолчанию сервера, -1 - не кешировать
+}
+
+// empty сообщает, что ни один параметр не задан.
+func (o LinkOptions) empty() bool {
+	return o == LinkOptions{}
+}
+
+// validate проверяет параметры ссылки и то, что срок действия ещё не наступил.
+func (o LinkOptions) validate() error {
+	if o.Alias != "" {
+		if err := ValidateAlias(o.Alias); err != nil {
+			return fmt.Errorf("%w: %s", err, o.Alias)
+		}
+	}
+	if !o.ExpiresAt.IsZero() && !o.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("%w: expires_at is in the past", ErrInvalidExpiry)
+	}
+	if o.MaxClicks < 0 {
+		return fmt.Errorf("%w: must not be negative", ErrInvalidMaxClicks)
+	}
+	if len(o.Password) > MaxPasswordLength {
+		return fmt.Errorf("%w: longer than %d bytes", ErrInvalidPassword, MaxPasswordLength)
+	}
+	if o.RedirectType != 0 {
+		if err := ValidateRedirectType(o.RedirectType); err != nil {
+			return err
+		}
+	}
+	if o.CacheMaxAge < -1 {
+		return fmt.Errorf("%w: must be -1 or greater", ErrInvalidCacheMaxAge)
+	}
+	return nil
+}
+
+// Shorten сокращает URL для пользователя.
+//
+// Если URL уже сокращён, возвращает существующую ссылку вместе с ErrConflict.
+func (s *Shortener) Shorten(ctx context.Context, rawURL string, userID string) (ShortenResult, error) {
+	return s.ShortenWithOptions(ctx, rawURL, LinkOptions{}, userID)
+}
+
+// ShortenWithOptions сокращает URL с необязательными параметрами ссылки opts.
+//
+// Если URL уже сокращён, возвращает существующую ссылку вместе с ErrConflict, а если запрос
+// задавал другой алиас - ещё и с ErrAliasNotApplied. Если алиас занят, возвращает ErrAliasTaken.
+func (s *Shortener) ShortenWithOptions(ctx context.Context, rawURL string, opts LinkOptions, userID string) (ShortenResult, error) {
+	rawURL, err := validateURL(rawURL)
+	if err != nil {
+		return ShortenResult{}, err
+	}
+	if err := opts.validate(); err != nil {
+		return ShortenResult{}, err
+	}
+
+	// Проверяем, не сокращал ли пользователь этот URL раньше
+	existsKey, err := s.storage.GetByURL(ctx, rawURL, userID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return ShortenResult{}, fmt.Errorf("get by url: %w", err)
+	}
+	if existsKey != "" {
+		return s.conflict(existsKey, opts)
+	}
+
+	key, err := s.save(ctx, rawURL, opts, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAlreadyHasKey) {
+			return s.conflict(key, opts)
+		}
+		return ShortenResult{}, err
+	}
+	return s.result(key), nil
+}
+
+// conflict возвращает уже существующую ссылку key вместе с ErrConflict. Если opts задавали
+// другой алиас, ошибка несёт и ErrAliasNotApplied.
+func (s *Shortener) conflict(key string, opts LinkOptions) (ShortenResult, error) {
+	if opts.Alias != "" && opts.Alias != key {
+		return s.result(key), fmt.Errorf("%w: %w", ErrConflict, ErrAliasNotApplied)
+	}
+	return s.result(key), ErrConflict
+}
+
+// save сохраняет URL с параметрами opts.
+func (s *Shortener) save(ctx context.Context, rawURL string, opts LinkOptions, userID string) (string, error) {
+	if opts.empty() {
+		key, err := s.storage.Save(ctx, rawURL, userID)
+		if err != nil && !errors.Is(err, storage.ErrAlreadyHasKey) {
+			return "", fmt.Errorf("save: %w", err)
+		}
+		return key, err
+	}
+
+	link := storage.Link{
+		Key:         opts.Alias,
+		OriginalURL: rawURL,
+		UserID:      userID,
+		ExpiresAt:   opts.ExpiresAt,
+		MaxClicks:   opts.MaxClicks,
+
+		RedirectType: opts.RedirectType,
+		CacheMaxAge:  opts.CacheMaxAge,
+	}
+	if opts.Password != "" {
+		hash, err := hashPassword(opts.Password)
+		if err != nil {
+			return "", err
+		}
+		link.PasswordHash = hash
+	}
+	key, err := s.storage.SaveLink(ctx, link)
+	switch {
+	case errors.Is(err, storage.ErrKeyTaken):
+		return "", ErrAliasTaken
+	case err != nil && !errors.Is(err, storage.ErrAlreadyHasKey):
+		return "", fmt.Errorf("save link: %w", err)
+	}
+	return key, err
+}
+
+// ShortenBatch сокращает пакет URL, сохраняя порядок и correlation_id.
+//
+// Если какой-либо URL уже сокращён, возвращает ErrConflict, а в результате - конфликтующий элемент.
+func (s *Shortener) ShortenBatch(ctx context.Context, items []BatchItem, userID string) ([]BatchResult, error) {
+	if len(items) == 0 {
+		return nil, ErrEmptyBatch
+	}
+
+	urls := make([]string, 0, len(items))
+	withOptions := false
+	for _, item := range items {
+		u, err := validateURL(item.OriginalURL)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidURL, item.OriginalURL)
+		}
+		urls = append(urls, u)
+		withOptions = withOptions || !item.options().empty()
+	}
+	if withOptions {
+		return s.shortenBatchWithOptions(ctx, items, urls, userID)
+	}
+
+	keys, err := s.storage.SaveInBatch(ctx, urls, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAlreadyHasKey) {
+			if conflict, err := s.batchConflict(ctx, items, urls, userID); err != nil {
+				return conflict, err
+			}
+		}
+		return nil, fmt.Errorf("save batch: %w", err)
+	}
+
+	results := make([]BatchResult, 0, len(keys))
+	for i, key := range keys {
+		results = append(results, BatchResult{
+			CorrelationID: items[i].CorrelationID,
+			ShortURL:      s.ShortURL(key),
+		})
+	}
+	return results, nil
+}
+
+// shortenBatchWithOptions сокращает пакет, в котором часть элементов задаёт алиас или срок действия.
+//
+// Параметры, алиасы и уже сокращённые URL проверяются до первой записи, поэтому занятый алиас
+// или конфликт отклоняют пакет целиком. Элементы с параметрами сохраняются по одному, остальные -
+// одним SaveInBatch.
+func (s *Shortener) shortenBatchWithOptions(ctx context.Context, items []BatchItem, urls []string, userID string) ([]BatchResult, error) {
+	seen := make(map[string]bool, len(items))
+	for _, item := range items {
+		if err := item.options().validate(); err != nil {
+			return nil, err
+		}
+		if item.Alias == "" {
+			continue
+		}
+		if seen[item.Alias] {
+			return nil, fmt.Errorf("%w: %s is repeated in the batch", ErrInvalidAlias, item.Alias)
+		}
+		seen[item.Alias] = true
+		taken, err := s.keyTaken(ctx, item.Alias)
+		if err != nil {
+			return nil, fmt.Errorf("get alias: %w", err)
+		}
+		if taken {
+			return nil, fmt.Errorf("%w: %s", ErrAliasTaken, item.Alias)
+		}
+	}
+	if conflict, err := s.batchConflict(ctx, items, urls, userID); err != nil {
+		return conflict, err
+	}
+
+	keys := make([]string, len(items))
+	var plain []int
+	for i, item := range items {
+		if item.options().empty() {
+			plain = append(plain, i)
+			continue
+		}
+		key, err := s.save(ctx, urls[i], item.options(), userID)
+		if errors.Is(err, storage.ErrAlreadyHasKey) {
+			return []BatchResult{{CorrelationID: item.CorrelationID, ShortURL: s.ShortURL(key)}}, ErrConflict
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+
+	if len(plain) > 0 {
+		plainURLs := make([]string, len(plain))
+		for j, i := range plain {
+			plainURLs[j] = urls[i]
+		}
+		plainKeys, err := s.storage.SaveInBatch(ctx, plainURLs, userID)
+		if err != nil {
+			if errors.Is(err, storage.ErrAlreadyHasKey) {
+				if conflict, err := s.batchConflict(ctx, items, urls, userID); err != nil {
+					return conflict, err
+				}
+			}
+			return nil, fmt.Errorf("save batch: %w", err)
+		}
+		for j, i := range plain {
+			keys[i] = plainKeys[j]
+		}
+	}
+
+	results := make([]BatchResult, 0, len(keys))
+	for i, key := range keys {
+		results = append(results, BatchResult{
+			CorrelationID: items[i].CorrelationID,
+			ShortURL:      s.ShortURL(key),
+		})
+	}
+	return results, nil
+}
+
+// batchConflict возвращает первый элемент пакета, URL которого уже сокращён, вместе с ErrConflict.
+// Если таких элементов нет, возвращает nil и nil.
+func (s *Shortener) batchConflict(ctx context.Context, items []BatchItem, urls []string, userID string) ([]BatchResult, error) {
+	existing, err := s.existingKeys(ctx, userID, urls)
+	if err != nil {
+		return nil, err
+	}
+	for i, u := range urls {
+		if key, ok := existing[u]; ok {
+			return []BatchResult{{CorrelationID: items[i].CorrelationID, ShortURL: s.ShortURL(key)}}, ErrConflict
+		}
+	}
+	return nil, nil
+}
+
+// existingKeys возвращает ключи уже сокращённых из urls. Хранилища с storage.URLChecker находят
+// одним вызовом ссылки всех пользователей, для остальных по одной ищутся ссылки пользователя.
+func (s *Shortener) existingKeys(ctx context.Context, userID string, urls []string) (map[string]string, error) {
+	if checker, ok := s.storage.(storage.URLChecker); ok {
+		existing, err := checker.KeysByURL(ctx, urls)
+		if err != nil {
+			return nil, fmt.Errorf("get urls: %w", err)
+		}
+		return existing, nil
+	}
+
+	existing := make(map[string]string)
+	for _, u := range urls {
+		key, err := s.storage.GetByURL(ctx, u, userID)
+		if err != nil {
+			return nil, fmt.Errorf("get by url: %w", err)
+		}
+		if key != "" {
+			existing[u] = key
+		}
+	}
+	return existing, nil
+}
+
+// keyTaken сообщает, занят ли ключ. Хранилища с storage.KeyChecker проверяют ключ,
+// не расходуя переходы ссылок с лимитом; для остальных используется Get.
+func (s *Shortener) keyTaken(ctx context.Context, key string) (bool, error) {
+	if checker, ok := s.storage.(storage.KeyChecker); ok {
+		return checker.HasKey(ctx, key)
+	}
+	_, err := s.storage.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err == nil || errors.Is(err, storage.ErrDeleted) || errors.Is(err, storage.ErrExpired) ||
+		errors.Is(err, storage.ErrClickLimitReached) || errors.Is(err, storage.ErrPasswordRequired) {
+		return true, nil
+	}
+	return false, err
+}
+
+// Resolve возвращает оригинальный URL по короткому ключу.
+//
+// Возвращает ErrNotFound, ErrDeleted, ErrExpired или ErrClickLimitReached, если ссылка недоступна,
+// и ErrPasswordRequired для защищённой паролем ссылки (см. ResolveLink).
+// Переход по ссылке с лимитом расходует один переход.
+func (s *Shortener) Resolve(ctx context.Context, key string) (string, error) {
+	if key == "" {
+		return "", ErrNotFound
+	}
+	return s.storage.Get(ctx, key)
+}
+
+// UserURLs возвращает ссылки пользователя, отсортированные по ключу.
+func (s *Shortener) UserURLs(ctx context.Context, userID string) ([]UserURL, error) {
+	if userID == "" {
+		return nil, ErrUnauthorized
+	}
+
+	urls, err := s.storage.GetUserURLS(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("get user urls: %w", err)
+	}
+
+	keys := make([]string, 0, len(urls))
+	for k := range urls {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := make([]UserURL, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, UserURL{
+			Key:         key,
+			ShortURL:    s.ShortURL(key),
+			OriginalURL: urls[key],
+		})
+	}
+	return result, nil
+}
+
+// LinkStats возвращает статистику переходов по ссылке key пользователя.
+//
+// Статистика доступна только владельцу: для чужих и неизвестных ключей возвращается ErrNotFound.
+func (s *Shortener) LinkStats(ctx context.Context, userID string, key string) (storage.ClickStats, error) {
+	if userID == "" {
+		return storage.ClickStats{}, ErrUnauthorized
+	}
+	clicks, ok := s.storage.(storage.ClickStorage)
+	if !ok {
+		return storage.ClickStats{}, ErrStatsUnsupported
+	}
+
+	if err := s.checkOwner(ctx, userID, key); err != nil {
+		return storage.ClickStats{}, err
+	}
+
+	stats, err := clicks.ClickStats(ctx, key, TopReferrers)
+	if err != nil {
+		return storage.ClickStats{}, fmt.Errorf("get click stats: %w", err)
+	}
+	return stats, nil
+}
+
+// checkOwner проверяет, что неудалённая ссылка key принадлежит пользователю: для чужих, удалённых
+// и неизвестных ключей возвращает ErrNotFound.
+//
+// Ссылка читается по ключу через storage.LinkPeeker. Хранилища без него проверяются
+// по списку ссылок пользователя.
+func (s *Shortener) checkOwner(ctx context.Context, userID string, key string) error {
+	peeker, ok := s.storage.(storage.LinkPeeker)
+	if !ok {
+		urls, err := s.storage.GetUserURLS(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("get user urls: %w", err)
+		}
+		if _, ok := urls[key]; !ok {
+			return ErrNotFound
+		}
+		return nil
+	}
+
+	link, err := peeker.PeekLink(ctx, key)
+	switch {
+	case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrDeleted):
+		return ErrNotFound
+	case err != nil:
+		return fmt.Errorf("peek link: %w", err)
+	case link.UserID != userID:
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UpdateLink правит ссылку key пользователя: оригинальный URL, статус редиректа или срок действия.
+//
+// Прежняя версия ссылки сохраняется в истории правок (см. LinkRevisions). Править можно
+// только свои ссылки: для чужих и неизвестных ключей возвращается ErrNotFound. Если новый
+// URL уже сокращён, возвращает ссылку с его ключом вместе с ErrConflict.
+func (s *Shortener) UpdateLink(ctx context.Context, userID string, key string, upd storage.LinkUpdate) (storage.Link, error) {
+	if userID == "" {
+		return storage.Link{}, ErrUnauthorized
+	}
+	if upd == (storage.LinkUpdate{}) {
+		return storage.Link{}, ErrEmptyUpdate
+	}
+	if upd.OriginalURL != nil {
+		rawURL, err := validateURL(*upd.OriginalURL)
+		if err != nil {
+			return storage.Link{}, err
+		}
+		upd.OriginalURL = &rawURL
+	}
+	if upd.RedirectType != nil && *upd.RedirectType != 0 {
+		if err := ValidateRedirectType(*upd.RedirectType); err != nil {
+			return storage.Link{}, err
+		}
+	}
+	if upd.ExpiresAt != nil && !upd.ExpiresAt.IsZero() && !upd.ExpiresAt.After(time.Now()) {
+		return storage.Link{}, fmt.Errorf("%w: expires_at is in the past", ErrInvalidExpiry)
+	}
+
+	link, err := s.storage.Update(ctx, key, userID, upd)
+	switch {
+	case errors.Is(err, storage.ErrAlreadyHasKey):
+		return link, ErrConflict
+	case err != nil && !errors.Is(err, storage.ErrNotFound) && !errors.Is(err, storage.ErrDeleted) && !errors.Is(err, storage.ErrReadOnly):
+		return storage.Link{}, fmt.Errorf("update link: %w", err)
+	}
+	return link, err
+}
+
+// LinkRevisions возвращает историю правок ссылки key пользователя от старых версий к новым.
+//
+// История доступна только владельцу: для чужих и неизвестных ключей возвращается ErrNotFound.
+func (s *Shortener) LinkRevisions(ctx context.Context, userID string, key string) ([]storage.Revision, error) {
+	if userID == "" {
+		return nil, ErrUnauthorized
+	}
+	revisions, err := s.storage.Revisions(ctx, key, userID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("get revisions: %w", err)
+	}
+	return revisions, err
+}
+
+// DeleteUserURLs помечает ссылки пользователя удалёнными.
+func (s *Shortener) DeleteUserURLs(ctx context.Context, userID string, keys []string) error {
+	if userID == "" {
+		return ErrUnauthorized
+	}
+	if len(keys) == 0 {
+		return ErrEmptyBatch
+	}
+	return s.storage.MarkAsDeleted(ctx, keys, userID)
+}
+
+// Trash возвращает удалённые ссылки пользователя, начиная с удалённых последними.
+func (s *Shortener) Trash(ctx context.Context, userID string) ([]TrashedURL, error) {
+	if userID == "" {
+		return nil, ErrUnauthorized
+	}
+
+	deleted, err := s.storage.GetDeletedURLS(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("get deleted urls: %w", err)
+	}
+	sort.Slice(deleted, func(i, j int) bool {
+		if !deleted[i].DeletedAt.Equal(deleted[j].DeletedAt) {
+			return deleted[i].DeletedAt.After(deleted[j].DeletedAt)
+		}
+		return deleted[i].Key < deleted[j].Key
+	})
+
+	result := make([]TrashedURL, 0, len(deleted))
+	for _, link := range deleted {
+		result = append(result, TrashedURL{
+			Key:         link.Key,
+			ShortURL:    s.ShortURL(link.Key),
+			OriginalURL: link.OriginalURL,
+			DeletedAt:   link.DeletedAt,
+		})
+	}
+	return result, nil
+}
+
+// RestoreUserURLs восстанавливает удалённые ссылки пользователя из корзины и возвращает
+// ключи восстановленных. Чужие, неизвестные и неудалённые ключи пропускаются.
+// Если срок какой-либо из ссылок истёк, ничего не восстанавливается и возвращается ErrExpired
+// со списком таких ключей.
+func (s *Shortener) RestoreUserURLs(ctx context.Context, userID string, keys []string) ([]string, error) {
+	if userID == "" {
+		return nil, ErrUnauthorized
+	}
+	if len(keys) == 0 {
+		return nil, ErrEmptyBatch
+	}
+
+	restored, err := s.storage.RestoreURLs(ctx, keys, userID)
+	if err != nil && !errors.Is(err, storage.ErrReadOnly) && !errors.Is(err, storage.ErrExpired) {
+		return nil, fmt.Errorf("restore urls: %w", err)
+	}
+	return restored, err
+}
+
+// Ping проверяет доступность хранилища.
+func (s *Shortener) Ping(ctx context.Context) error {
+	return s.storage.Ping(ctx)
+}
+
+func (s *Shortener) result(key string) ShortenResult {
+	return ShortenResult{Key: key, ShortURL: s.ShortURL(key)}
+}
+
+// ExpiresAt вычисляет срок действия ссылки по абсолютному моменту expiresAt или по ttl
+// в секундах от now. Одновременно задавать оба нельзя; без них ссылка бессрочная.
+func ExpiresAt(expiresAt *time.Time, ttl int64, now time.Time) (time.Time, error) {
+	switch {
+	case expiresAt != nil && ttl != 0:
+		return time.Time{}, fmt.Errorf("%w: expires_at and ttl are mutually exclusive", ErrInvalidExpiry)
+	case ttl < 0 || ttl > math.MaxInt64/int64(time.Second):
+		return time.Time{}, fmt.Errorf("%w: ttl is out of range", ErrInvalidExpiry)
+	case ttl > 0:
+		return now.Add(time.Duration(ttl) * time.Second), nil
+	case expiresAt != nil:
+		return *expiresAt, nil
+	}
+	return time.Time{}, nil
+}
+
+// ValidateAlias проверяет алиас: длина от MinAliasLength до MaxAliasLength,
+// только латинские буквы, цифры, '-' и '_', и не служебное слово.
+func ValidateAlias(alias string) error {
+	if len(alias) < MinAliasLength || len(alias) > MaxAliasLength {
+		return fmt.Errorf("%w: length must be between %d and %d", ErrInvalidAlias, MinAliasLength, MaxAliasLength)
+	}
+	for _, c := range alias {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("%w: unexpected character %q", ErrInvalidAlias, c)
+		}
+	}
+	if _, reserved := reservedAliases[strings.ToLower(alias)]; reserved {
+		return ErrReservedAlias
+	}
+	return nil
+}
+
+// ValidateRedirectType проверяет статус редиректа: допустимы 301, 302, 307 и 308.
+func ValidateRedirectType(code int) error {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return nil
+	}
+	return fmt.Errorf("%w: %d", ErrInvalidRedirectType, code)
+}
+
+// validateURL обрезает пробелы и проверяет, что URL разбирается.
+func validateURL(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return "", ErrEmptyURL
+	}
+	if _, err := url.ParseRequestURI(rawURL); err != nil {
+		return "", ErrInvalidURL
+	}
+	return rawURL, nil
+}
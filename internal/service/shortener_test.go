@@ -0,0 +1,707 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestShorten(t *testing.T) {
+	ctx := context.Background()
+	svc := NewShortener(storage.NewMemoryStorage(), "http://test")
+
+	t.Run("Created", func(t *testing.T) {
+		res, err := svc.Shorten(ctx, " http://example.com\n", "user1")
+		require.NoError(t, err)
+		assert.Equal(t, "http://test/"+res.Key, res.ShortURL)
+
+		url, err := svc.Resolve(ctx, res.Key)
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com", url)
+	})
+
+	t.Run("Conflict for same user", func(t *testing.T) {
+		first, err := svc.Shorten(ctx, "http://conflict.com", "user1")
+		require.NoError(t, err)
+
+		second, err := svc.Shorten(ctx, "http://conflict.com", "user1")
+		assert.ErrorIs(t, err, ErrConflict)
+		assert.Equal(t, first, second)
+	})
+
+	t.Run("Conflict for other user", func(t *testing.T) {
+		first, err := svc.Shorten(ctx, "http://shared.com", "user1")
+		require.NoError(t, err)
+
+		second, err := svc.Shorten(ctx, "http://shared.com", "user2")
+		assert.ErrorIs(t, err, ErrConflict)
+		assert.Equal(t, first.ShortURL, second.ShortURL)
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		_, err := svc.Shorten(ctx, "  ", "user1")
+		assert.ErrorIs(t, err, ErrEmptyURL)
+
+		_, err = svc.Shorten(ctx, "not a url", "user1")
+		assert.ErrorIs(t, err, ErrInvalidURL)
+	})
+}
+
+func TestShortenBatch(t *testing.T) {
+	ctx := context.Background()
+	svc := NewShortener(storage.NewMemoryStorage(), "http://test")
+
+	results, err := svc.ShortenBatch(ctx, []BatchItem{
+		{CorrelationID: "a", OriginalURL: "http://example.com/1"},
+		{CorrelationID: "b", OriginalURL: "http://example.com/2"},
+	}, "user1")
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "a", results[0].CorrelationID)
+	assert.Equal(t, "b", results[1].CorrelationID)
+
+	_, err = svc.ShortenBatch(ctx, nil, "user1")
+	assert.ErrorIs(t, err, ErrEmptyBatch)
+
+	_, err = svc.ShortenBatch(ctx, []BatchItem{{CorrelationID: "c", OriginalURL: "bad"}}, "user1")
+	assert.ErrorIs(t, err, ErrInvalidURL)
+}
+
+func TestShortenWithAlias(t *testing.T) {
+	ctx := context.Background()
+	svc := NewShortener(storage.NewMemoryStorage(), "http://test")
+
+	res, err := svc.ShortenWithOptions(ctx, "http://example.com/sale", LinkOptions{Alias: "spring-sale"}, "user1")
+	require.NoError(t, err)
+	assert.Equal(t, "http://test/spring-sale", res.ShortURL)
+
+	_, err = svc.ShortenWithOptions(ctx, "http://example.com/other", LinkOptions{Alias: "spring-sale"}, "user2")
+	assert.ErrorIs(t, err, ErrAliasTaken)
+
+	again, err := svc.ShortenWithOptions(ctx, "http://example.com/sale", LinkOptions{Alias: "another"}, "user2")
+	assert.ErrorIs(t, err, ErrConflict, "already shortened URL keeps its key")
+	assert.Equal(t, res, again)
+
+	for alias, want := range map[string]error{
+		"ab":            ErrInvalidAlias,
+		"spring sale":   ErrInvalidAlias,
+		"распродажа":    ErrInvalidAlias,
+		"Ping":          ErrReservedAlias,
+		"api":           ErrReservedAlias,
+		"summer_sale-1": nil,
+	} {
+		assert.ErrorIs(t, ValidateAlias(alias), want, alias)
+	}
+
+	// Занятый алиас отклоняет пакет до записи остальных элементов
+	_, err = svc.ShortenBatch(ctx, []BatchItem{
+		{CorrelationID: "a", OriginalURL: "http://example.com/a"},
+		{CorrelationID: "b", OriginalURL: "http://example.com/b", Alias: "spring-sale"},
+	}, "user1")
+	assert.ErrorIs(t, err, ErrAliasTaken)
+	urls, err := svc.UserURLs(ctx, "user1")
+	require.NoError(t, err)
+	assert.Len(t, urls, 1)
+
+	_, err = svc.ShortenBatch(ctx, []BatchItem{
+		{CorrelationID: "a", OriginalURL: "http://example.com/a", Alias: "dup"},
+		{CorrelationID: "b", OriginalURL: "http://example.com/b", Alias: "dup"},
+	}, "user1")
+	assert.ErrorIs(t, err, ErrInvalidAlias)
+
+	results, err := svc.ShortenBatch(ctx, []BatchItem{
+		{CorrelationID: "a", OriginalURL: "http://example.com/a"},
+		{CorrelationID: "b", OriginalURL: "http://example.com/b", Alias: "autumn"},
+	}, "user1")
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "a", results[0].CorrelationID)
+	assert.Equal(t, "http://test/autumn", results[1].ShortURL)
+}
+
+func TestShortenExistingURLWithAlias(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range map[string]storage.Storage{
+		"memory":  storage.NewMemoryStorage(),
+		"sharded": storage.NewShardedMemoryStorage(4),
+	} {
+		t.Run(name, func(t *testing.T) {
+			svc := NewShortener(store, "http://test")
+			res, err := svc.ShortenWithOptions(ctx, "http://example.com/sale", LinkOptions{Alias: "spring-sale"}, "user1")
+			require.NoError(t, err)
+
+			// Тот же алиас - обычный конфликт
+			again, err := svc.ShortenWithOptions(ctx, "http://example.com/sale", LinkOptions{Alias: "spring-sale"}, "user1")
+			assert.ErrorIs(t, err, ErrConflict)
+			assert.NotErrorIs(t, err, ErrAliasNotApplied)
+			assert.Equal(t, res, again)
+
+			// Другой алиас не сохраняется, и ошибка об этом сообщает - и для своего, и для чужого URL
+			for _, userID := range []string{"user1", "user2"} {
+				again, err = svc.ShortenWithOptions(ctx, "http://example.com/sale", LinkOptions{Alias: "autumn-sale"}, userID)
+				assert.ErrorIs(t, err, ErrConflict, userID)
+				assert.ErrorIs(t, err, ErrAliasNotApplied, userID)
+				assert.Equal(t, res, again, userID)
+			}
+			_, err = svc.Resolve(ctx, "autumn-sale")
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+func TestShortenBatchWithOptionsConflict(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range map[string]storage.Storage{
+		"memory":  storage.NewMemoryStorage(),
+		"sharded": storage.NewShardedMemoryStorage(4),
+	} {
+		t.Run(name, func(t *testing.T) {
+			svc := NewShortener(store, "http://test")
+			existing, err := svc.Shorten(ctx, "http://example.com/existing", "other")
+			require.NoError(t, err)
+
+			// Конфликт в конце пакета отклоняет его до записи элементов перед ним
+			results, err := svc.ShortenBatch(ctx, []BatchItem{
+				{CorrelationID: "a", OriginalURL: "http://example.com/a", Alias: "first"},
+				{CorrelationID: "b", OriginalURL: "http://example.com/b", MaxClicks: 3},
+				{CorrelationID: "c", OriginalURL: "http://example.com/existing"},
+			}, "user1")
+			assert.ErrorIs(t, err, ErrConflict)
+			assert.Equal(t, []BatchResult{{CorrelationID: "c", ShortURL: existing.ShortURL}}, results)
+
+			urls, err := svc.UserURLs(ctx, "user1")
+			require.NoError(t, err)
+			assert.Empty(t, urls, "nothing is saved from a conflicting batch")
+			_, err = svc.Resolve(ctx, "first")
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+
+	// Конфликт, о котором сообщает SaveInBatch, отображается так же, как при сокращении одной ссылки
+	svc := NewShortener(racingBatch{storage.NewMemoryStorage()}, "http://test")
+	for name, items := range map[string][]BatchItem{
+		"plain": {{CorrelationID: "a", OriginalURL: "http://example.com/raced"}},
+		"with options": {
+			{CorrelationID: "a", OriginalURL: "http://example.com/raced/opts"},
+			{CorrelationID: "b", OriginalURL: "http://example.com/limited", MaxClicks: 1},
+		},
+	} {
+		results, err := svc.ShortenBatch(ctx, items, "user1")
+		assert.ErrorIs(t, err, ErrConflict, name)
+		require.Len(t, results, 1, name)
+		assert.Equal(t, "a", results[0].CorrelationID, name)
+	}
+}
+
+func TestShortenWithExpiration(t *testing.T) {
+	ctx := context.Background()
+	svc := NewShortener(storage.NewMemoryStorage(), "http://test")
+
+	res, err := svc.ShortenWithOptions(ctx, "http://example.com/soon", LinkOptions{ExpiresAt: time.Now().Add(50 * time.Millisecond)}, "user1")
+	require.NoError(t, err)
+	url, err := svc.Resolve(ctx, res.Key)
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/soon", url)
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = svc.Resolve(ctx, res.Key)
+	assert.ErrorIs(t, err, ErrExpired)
+
+	_, err = svc.ShortenWithOptions(ctx, "http://example.com/past", LinkOptions{ExpiresAt: time.Now().Add(-time.Second)}, "user1")
+	assert.ErrorIs(t, err, ErrInvalidExpiry)
+
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	at, err := ExpiresAt(nil, 90, now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(90*time.Second), at)
+	at, err = ExpiresAt(&now, 0, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, now, at)
+	at, err = ExpiresAt(nil, 0, now)
+	require.NoError(t, err)
+	assert.True(t, at.IsZero())
+	_, err = ExpiresAt(&now, 90, now)
+	assert.ErrorIs(t, err, ErrInvalidExpiry)
+	_, err = ExpiresAt(nil, -1, now)
+	assert.ErrorIs(t, err, ErrInvalidExpiry)
+}
+
+func TestShortenWithMaxClicks(t *testing.T) {
+	ctx := context.Background()
+	svc := NewShortener(storage.NewMemoryStorage(), "http://test")
+
+	res, err := svc.ShortenWithOptions(ctx, "http://example.com/invite", LinkOptions{MaxClicks: 2}, "user1")
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		url, err := svc.Resolve(ctx, res.Key)
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com/invite", url)
+	}
+	_, err = svc.Resolve(ctx, res.Key)
+	assert.ErrorIs(t, err, ErrClickLimitReached)
+
+	_, err = svc.ShortenWithOptions(ctx, "http://example.com/bad", LinkOptions{MaxClicks: -1}, "user1")
+	assert.ErrorIs(t, err, ErrInvalidMaxClicks)
+
+	// Проверка алиаса в пакете не расходует переходы чужой ссылки
+	once, err := svc.ShortenWithOptions(ctx, "http://example.com/once", LinkOptions{Alias: "once-only", MaxClicks: 1}, "user1")
+	require.NoError(t, err)
+	_, err = svc.ShortenBatch(ctx, []BatchItem{{CorrelationID: "1", OriginalURL: "http://example.com/other", Alias: "once-only"}}, "user2")
+	assert.ErrorIs(t, err, ErrAliasTaken)
+	_, err = svc.Resolve(ctx, once.Key)
+	assert.NoError(t, err)
+}
+
+func TestShortenWithRedirectPolicy(t *testing.T) {
+	ctx := context.Background()
+	svc := NewShortener(storage.NewMemoryStorage(), "http://test")
+
+	res, err := svc.ShortenWithOptions(ctx, "http://example.com/moved", LinkOptions{RedirectType: 301, CacheMaxAge: 600}, "user1")
+	require.NoError(t, err)
+	link, err := svc.ResolveLink(ctx, res.Key, "")
+	require.NoError(t, err)
+	assert.Equal(t, 301, link.RedirectType)
+	assert.Equal(t, int64(600), link.CacheMaxAge)
+
+	_, err = svc.ShortenWithOptions(ctx, "http://example.com/bad", LinkOptions{RedirectType: 303}, "user1")
+	assert.ErrorIs(t, err, ErrInvalidRedirectType)
+	_, err = svc.ShortenWithOptions(ctx, "http://example.com/bad", LinkOptions{CacheMaxAge: -2}, "user1")
+	assert.ErrorIs(t, err, ErrInvalidCacheMaxAge)
+}
+
+func TestResolveLinkWithPassword(t *testing.T) {
+	passwordCost = bcrypt.MinCost
+	ctx := context.Background()
+	svc := NewShortener(storage.NewMemoryStorage(), "http://test")
+
+	res, err := svc.ShortenWithOptions(ctx, "http://example.com/doc", LinkOptions{Password: "s3cret"}, "user1")
+	require.NoError(t, err)
+
+	_, err = svc.Resolve(ctx, res.Key)
+	assert.ErrorIs(t, err, ErrPasswordRequired)
+	_, err = svc.ResolveLink(ctx, res.Key, "")
+	assert.ErrorIs(t, err, ErrPasswordRequired)
+	link, err := svc.ResolveLink(ctx, res.Key, "s3cret")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/doc", link.OriginalURL)
+
+	// После MaxPasswordAttempts неверных паролей не принимается и верный
+	for i := 0; i < MaxPasswordAttempts; i++ {
+		_, err = svc.ResolveLink(ctx, res.Key, "guess")
+		assert.ErrorIs(t, err, ErrWrongPassword)
+	}
+	_, err = svc.ResolveLink(ctx, res.Key, "s3cret")
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+
+	// Ссылки без пароля открываются с любым паролем
+	plain, err := svc.Shorten(ctx, "http://example.com/plain", "user1")
+	require.NoError(t, err)
+	link, err = svc.ResolveLink(ctx, plain.Key, "whatever")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/plain", link.OriginalURL)
+
+	_, err = svc.ShortenWithOptions(ctx, "http://example.com/long", LinkOptions{Password: strings.Repeat("x", MaxPasswordLength+1)}, "user1")
+	assert.ErrorIs(t, err, ErrInvalidPassword)
+}
+
+func TestAttemptLimiter(t *testing.T) {
+	l := NewAttemptLimiter(2, time.Minute)
+	now := time.Now()
+
+	start, ok := l.reserve("a", now)
+	assert.True(t, ok)
+	l.refund("a", start)
+	assert.Empty(t, l.failures, "refunded attempts are forgotten")
+
+	_, ok = l.reserve("a", now)
+	assert.True(t, ok)
+	_, ok = l.reserve("a", now)
+	assert.True(t, ok)
+	_, ok = l.reserve("a", now)
+	assert.False(t, ok)
+	_, ok = l.reserve("b", now)
+	assert.True(t, ok, "limits are per key")
+
+	// Новое окно сбрасывает счётчик и забывает закончившиеся окна
+	later := now.Add(time.Minute)
+	_, ok = l.reserve("a", later)
+	assert.True(t, ok, "window expires")
+	assert.Len(t, l.failures, 1)
+
+	// Попытка из закончившегося окна не уменьшает счётчик нового
+	l.refund("a", now)
+	assert.Equal(t, 1, l.failures["a"].count)
+}
+
+func TestResolveLinkConcurrentGuesses(t *testing.T) {
+	ctx := context.Background()
+	attempts := NewAttemptLimiter(MaxPasswordAttempts, PasswordAttemptWindow)
+	store := storage.NewMemoryStorage()
+	httpSvc := NewShortener(store, "http://test", WithAttemptLimiter(attempts))
+	grpcSvc := NewShortener(store, "http://test", WithAttemptLimiter(attempts))
+
+	res, err := httpSvc.ShortenWithOptions(ctx, "http://example.com/secret", LinkOptions{Password: "s3cret"}, "user1")
+	require.NoError(t, err)
+
+	// Параллельные попытки через два сервиса с общим счётчиком не проверяют больше лимита паролей
+	var wrong atomic.Int32
+	var wg sync.WaitGroup
+	for i := range 4 * MaxPasswordAttempts {
+		svc := httpSvc
+		if i%2 == 1 {
+			svc = grpcSvc
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := svc.ResolveLink(ctx, res.Key, "guess"); errors.Is(err, ErrWrongPassword) {
+				wrong.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(MaxPasswordAttempts), wrong.Load())
+	_, err = grpcSvc.ResolveLink(ctx, res.Key, "s3cret")
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+}
+
+func TestUpdateLink(t *testing.T) {
+	ctx := context.Background()
+	svc := NewShortener(storage.NewMemoryStorage(), "http://test")
+
+	res, err := svc.Shorten(ctx, "http://example.com/old", "user1")
+	require.NoError(t, err)
+	other, err := svc.Shorten(ctx, "http://example.com/other", "user1")
+	require.NoError(t, err)
+
+	newURL, badURL, badRedirect := "  http://example.com/new ", "::bad", 303
+	past := time.Now().Add(-time.Hour)
+	_, err = svc.UpdateLink(ctx, "", res.Key, storage.LinkUpdate{OriginalURL: &newURL})
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	_, err = svc.UpdateLink(ctx, "user1", res.Key, storage.LinkUpdate{})
+	assert.ErrorIs(t, err, ErrEmptyUpdate)
+	_, err = svc.UpdateLink(ctx, "user1", res.Key, storage.LinkUpdate{OriginalURL: &badURL})
+	assert.ErrorIs(t, err, ErrInvalidURL)
+	_, err = svc.UpdateLink(ctx, "user1", res.Key, storage.LinkUpdate{RedirectType: &badRedirect})
+	assert.ErrorIs(t, err, ErrInvalidRedirectType)
+	_, err = svc.UpdateLink(ctx, "user1", res.Key, storage.LinkUpdate{ExpiresAt: &past})
+	assert.ErrorIs(t, err, ErrInvalidExpiry)
+	_, err = svc.UpdateLink(ctx, "user2", res.Key, storage.LinkUpdate{OriginalURL: &newURL})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	link, err := svc.UpdateLink(ctx, "user1", res.Key, storage.LinkUpdate{OriginalURL: &newURL})
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/new", link.OriginalURL)
+
+	otherURL := "http://example.com/other"
+	link, err = svc.UpdateLink(ctx, "user1", res.Key, storage.LinkUpdate{OriginalURL: &otherURL})
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.Equal(t, other.Key, link.Key)
+
+	revisions, err := svc.LinkRevisions(ctx, "user1", res.Key)
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	assert.Equal(t, "http://example.com/old", revisions[0].OriginalURL)
+	_, err = svc.LinkRevisions(ctx, "user2", res.Key)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestUserURLsAndDelete(t *testing.T) {
+	ctx := context.Background()
+	svc := NewShortener(storage.NewMemoryStorage(), "http://test")
+
+	first, err := svc.Shorten(ctx, "http://example.com/1", "user1")
+	require.NoError(t, err)
+	_, err = svc.Shorten(ctx, "http://example.com/2", "user1")
+	require.NoError(t, err)
+
+	urls, err := svc.UserURLs(ctx, "user1")
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+	assert.Less(t, urls[0].Key, urls[1].Key)
+
+	_, err = svc.UserURLs(ctx, "")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+
+	require.NoError(t, svc.DeleteUserURLs(ctx, "user1", []string{first.Key}))
+	_, err = svc.Resolve(ctx, first.Key)
+	assert.ErrorIs(t, err, ErrDeleted)
+
+	assert.ErrorIs(t, svc.DeleteUserURLs(ctx, "user1", nil), ErrEmptyBatch)
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	ctx := context.Background()
+	svc := NewShortener(storage.NewMemoryStorage(), "http://test")
+
+	first, err := svc.Shorten(ctx, "http://example.com/first", "user1")
+	require.NoError(t, err)
+	second, err := svc.Shorten(ctx, "http://example.com/second", "user1")
+	require.NoError(t, err)
+	require.NoError(t, svc.DeleteUserURLs(ctx, "user1", []string{first.Key}))
+	time.Sleep(time.Millisecond)
+	require.NoError(t, svc.DeleteUserURLs(ctx, "user1", []string{second.Key}))
+
+	_, err = svc.Trash(ctx, "")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	trash, err := svc.Trash(ctx, "user1")
+	require.NoError(t, err)
+	require.Len(t, trash, 2)
+	assert.Equal(t, second.ShortURL, trash[0].ShortURL, "recently deleted links go first")
+	assert.Equal(t, first.ShortURL, trash[1].ShortURL)
+
+	_, err = svc.RestoreUserURLs(ctx, "", []string{first.Key})
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	_, err = svc.RestoreUserURLs(ctx, "user1", nil)
+	assert.ErrorIs(t, err, ErrEmptyBatch)
+	restored, err := svc.RestoreUserURLs(ctx, "user1", []string{first.Key})
+	require.NoError(t, err)
+	assert.Equal(t, []string{first.Key}, restored)
+
+	trash, err = svc.Trash(ctx, "user1")
+	require.NoError(t, err)
+	require.Len(t, trash, 1)
+	assert.Equal(t, second.Key, trash[0].Key)
+}
+
+func TestUserURLsPage(t *testing.T) {
+	ctx := context.Background()
+	svc := NewShortener(storage.NewMemoryStorage(), "http://test")
+	for i := 0; i < 3; i++ {
+		_, err := svc.Shorten(ctx, fmt.Sprintf("http://example.com/%d", i), "user1")
+		require.NoError(t, err)
+	}
+
+	_, err := svc.UserURLsPage(ctx, "", PageQuery{})
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	for _, q := range []PageQuery{
+		{Limit: -1},
+		{Limit: MaxPageLimit + 1},
+		{Sort: "clicks"},
+		{Order: "up"},
+		{Cursor: "!!!"},
+	} {
+		_, err := svc.UserURLsPage(ctx, "user1", q)
+		assert.ErrorIs(t, err, ErrInvalidPageQuery, "%+v", q)
+	}
+
+	all, err := svc.UserURLsPage(ctx, "user1", PageQuery{})
+	require.NoError(t, err)
+	assert.Len(t, all.URLs, 3, "without limit all links are returned")
+	assert.Empty(t, all.NextCursor)
+
+	page, err := svc.UserURLsPage(ctx, "user1", PageQuery{Limit: 2, Sort: SortByCreated})
+	require.NoError(t, err)
+	require.Len(t, page.URLs, 2)
+	require.NotEmpty(t, page.NextCursor)
+
+	// Курсор нельзя продолжить в другом порядке
+	_, err = svc.UserURLsPage(ctx, "user1", PageQuery{Limit: 2, Cursor: page.NextCursor})
+	assert.ErrorIs(t, err, ErrInvalidPageQuery)
+
+	next, err := svc.UserURLsPage(ctx, "user1", PageQuery{Limit: 2, Sort: SortByCreated, Cursor: page.NextCursor})
+	require.NoError(t, err)
+	require.Len(t, next.URLs, 1)
+	assert.Empty(t, next.NextCursor)
+	assert.NotContains(t, []string{page.URLs[0].Key, page.URLs[1].Key}, next.URLs[0].Key)
+}
+
+func TestExportAndImportLinks(t *testing.T) {
+	ctx := context.Background()
+	svc := NewShortener(storage.NewMemoryStorage(), "http://test")
+
+	plain, err := svc.Shorten(ctx, "http://example.com/plain", "owner")
+	require.NoError(t, err)
+	limited, err := svc.ShortenWithOptions(ctx, "http://example.com/limited", LinkOptions{MaxClicks: 5, RedirectType: 307}, "owner")
+	require.NoError(t, err)
+	secret, err := svc.ShortenWithOptions(ctx, "http://example.com/secret", LinkOptions{Password: "secret"}, "owner")
+	require.NoError(t, err)
+	_, err = svc.Resolve(ctx, limited.Key)
+	require.NoError(t, err)
+	deleted, err := svc.Shorten(ctx, "http://example.com/deleted", "owner")
+	require.NoError(t, err)
+	require.NoError(t, svc.DeleteUserURLs(ctx, "owner", []string{deleted.Key}))
+	stranger, err := svc.Shorten(ctx, "http://example.com/stranger", "stranger")
+	require.NoError(t, err)
+	keyedStranger, err := svc.Shorten(ctx, "http://example.com/stranger/keyed", "stranger")
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, svc.ExportLinks(ctx, "", func(ExportedLink) error { return nil }), ErrUnauthorized)
+	var exported []ExportedLink
+	require.NoError(t, svc.ExportLinks(ctx, "owner", func(l ExportedLink) error {
+		exported = append(exported, l)
+		return nil
+	}))
+	require.Len(t, exported, 3, "deleted links are not exported")
+	byKey := map[string]ExportedLink{}
+	for _, l := range exported {
+		byKey[l.Key] = l
+	}
+	assert.Equal(t, plain.ShortURL, byKey[plain.Key].ShortURL)
+	assert.False(t, byKey[plain.Key].Protected)
+	assert.Equal(t, int64(5), byKey[limited.Key].MaxClicks)
+	assert.Equal(t, int64(1), byKey[limited.Key].Clicks)
+	assert.Equal(t, 307, byKey[limited.Key].RedirectType)
+	assert.True(t, byKey[secret.Key].Protected, "password-protected links are exported with a marker")
+
+	rows := []ImportRow{
+		{Line: 1, Key: "kept-key", OriginalURL: "http://example.com/a"},
+		{Line: 2, Key: plain.Key, OriginalURL: "http://example.com/b"},
+		{Line: 3, Key: "with-clicks", OriginalURL: "http://example.com/c", MaxClicks: 3},
+		{Line: 4, OriginalURL: "http://example.com/a"},
+		{Line: 5, OriginalURL: "http://example.com/plain"},
+		{Line: 6, OriginalURL: "not a url"},
+		{Line: 7, OriginalURL: "http://example.com/old", ExpiresAt: time.Now().Add(-time.Hour)},
+		{Line: 8, Err: fmt.Errorf("invalid JSON")},
+		{Line: 9, Key: "kept-key", OriginalURL: "http://example.com/d"},
+		{Line: 10, Key: "secret-key", OriginalURL: "http://example.com/e", Protected: true},
+		{Line: 11, Key: "used", OriginalURL: "http://example.com/f", MaxClicks: 2, Clicks: 2},
+		{Line: 12, OriginalURL: "http://example.com/g", Clicks: -1},
+		{Line: 13, OriginalURL: "http://example.com/stranger"},
+		{Line: 14, Key: "not-theirs", OriginalURL: "http://example.com/stranger/keyed"},
+	}
+	next := func(rows []ImportRow) func() (ImportRow, error) {
+		return func() (ImportRow, error) {
+			if len(rows) == 0 {
+				return ImportRow{}, io.EOF
+			}
+			row := rows[0]
+			rows = rows[1:]
+			return row, nil
+		}
+	}
+
+	_, err = svc.ImportLinks(ctx, "", next(rows))
+	assert.ErrorIs(t, err, ErrUnauthorized)
+
+	report, err := svc.ImportLinks(ctx, "owner", next(rows))
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Created)
+	assert.Equal(t, 2, report.KeyChanged)
+	assert.Equal(t, 4, report.Conflicts)
+	assert.Equal(t, 5, report.Invalid)
+
+	results := map[int]ImportResult{}
+	for _, res := range report.Rows {
+		results[res.Line] = res
+	}
+	require.Len(t, results, 11, "rows saved under their own key are not listed")
+	assert.Equal(t, ImportKeyChanged, results[2].Status)
+	assert.NotEqual(t, plain.ShortURL, results[2].ShortURL)
+	assert.Equal(t, ImportConflict, results[4].Status)
+	assert.Equal(t, "http://test/kept-key", results[4].ShortURL)
+	assert.Equal(t, ImportConflict, results[5].Status)
+	assert.Equal(t, plain.ShortURL, results[5].ShortURL)
+	// URL другого пользователя не сокращается повторно, как и при сокращении одной ссылки
+	assert.Equal(t, ImportConflict, results[13].Status)
+	assert.Equal(t, stranger.ShortURL, results[13].ShortURL)
+	assert.Equal(t, ImportConflict, results[14].Status)
+	assert.Equal(t, keyedStranger.ShortURL, results[14].ShortURL)
+	assert.ErrorContains(t, ErrProtectedImport, results[10].Error)
+	for _, line := range []int{6, 7, 8, 10, 12} {
+		assert.Equal(t, ImportInvalid, results[line].Status, "line %d", line)
+		assert.NotEmpty(t, results[line].Error, "line %d", line)
+	}
+	assert.Equal(t, ImportKeyChanged, results[9].Status)
+
+	url, err := svc.Resolve(ctx, "kept-key")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/a", url)
+	link, err := svc.ResolveLink(ctx, "with-clicks", "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), link.MaxClicks)
+	_, err = svc.Resolve(ctx, "used")
+	assert.ErrorIs(t, err, ErrClickLimitReached, "consumed clicks are carried over")
+
+	// Больше строк, чем в одной пачке
+	many := make([]ImportRow, ImportChunkSize+10)
+	for i := range many {
+		many[i] = ImportRow{Line: i + 1, OriginalURL: fmt.Sprintf("http://example.com/many/%d", i)}
+	}
+	report, err = svc.ImportLinks(ctx, "other", next(many))
+	require.NoError(t, err)
+	assert.Equal(t, len(many), report.Created)
+	assert.Empty(t, report.Rows)
+}
+
+// racingBatch - хранилище, в котором первый URL пакета успевает сократить другой пользователь.
+type racingBatch struct {
+	*storage.MemoryStorage
+}
+
+func (r racingBatch) SaveInBatch(ctx context.Context, urls []string, _ string) ([]string, error) {
+	if _, err := r.Save(ctx, urls[0], "racer"); err != nil {
+		return nil, err
+	}
+	return nil, storage.ErrAlreadyHasKey
+}
+
+func TestImportBatchConflict(t *testing.T) {
+	ctx := context.Background()
+	svc := NewShortener(racingBatch{storage.NewMemoryStorage()}, "http://test")
+
+	rows := []ImportRow{
+		{Line: 1, OriginalURL: "http://example.com/raced"},
+		{Line: 2, OriginalURL: "http://example.com/free"},
+	}
+	report, err := svc.ImportLinks(ctx, "owner", func() (ImportRow, error) {
+		if len(rows) == 0 {
+			return ImportRow{}, io.EOF
+		}
+		row := rows[0]
+		rows = rows[1:]
+		return row, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 1, report.Conflicts)
+	require.Len(t, report.Rows, 1)
+	assert.Equal(t, ImportConflict, report.Rows[0].Status)
+	assert.NotEmpty(t, report.Rows[0].ShortURL)
+}
+
+// userListFails - хранилище, в котором полный список ссылок пользователя недоступен.
+type userListFails struct {
+	*storage.MemoryStorage
+}
+
+func (userListFails) GetUserURLS(context.Context, string) (map[string]string, error) {
+	return nil, errors.New("user list must not be read")
+}
+
+func TestLinkStatsOwner(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	svc := NewShortener(userListFails{store}, "http://test")
+
+	key, err := store.Save(ctx, "http://example.com", "owner")
+	require.NoError(t, err)
+	deleted, err := store.Save(ctx, "http://deleted.com", "owner")
+	require.NoError(t, err)
+	require.NoError(t, store.MarkAsDeleted(ctx, []string{deleted}, "owner"))
+	require.NoError(t, store.RecordClicks(ctx, []storage.Click{{Key: key, At: time.Now()}}))
+
+	// Владелец проверяется по ключу, без чтения всех ссылок пользователя
+	stats, err := svc.LinkStats(ctx, "owner", key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Total)
+	for name, tt := range map[string]struct{ userID, key string }{
+		"stranger": {"stranger", key},
+		"deleted":  {"owner", deleted},
+		"unknown":  {"owner", "missing"},
+	} {
+		_, err := svc.LinkStats(ctx, tt.userID, tt.key)
+		assert.ErrorIs(t, err, ErrNotFound, name)
+	}
+}
@@ -0,0 +1,420 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/NailUsmanov/practicum-shortener-url/internal/storage"
+)
+
+// ImportChunkSize - сколько строк импорта сохраняется за один проход, в том числе одним SaveInBatch.
+const ImportChunkSize = 500
+
+// Ошибки строк импорта.
+var (
+	// ErrProtectedImport возникает для ссылки, выгруженной защищённой паролем: пароль не выгружается,
+	// а без него ссылка стала бы открытой.
+	ErrProtectedImport = errors.New("password-protected link is not imported, its password is not exported")
+
+	// ErrInvalidClicks возникает, если число израсходованных переходов отрицательное.
+	ErrInvalidClicks = errors.New("invalid clicks")
+)
+
+// ErrImportRead возникает, если загрузку не удалось дочитать: строка слишком длинная
+// или тело запроса оборвалось. Это ошибка клиента, а не хранилища.
+var ErrImportRead = errors.New("cannot read import")
+
+// ExportedLink - ссылка пользователя в выгрузке вместе с атрибутами.
+type ExportedLink struct {
+	Key          string
+	ShortURL     string
+	OriginalURL  string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	MaxClicks    int64
+	Clicks       int64 // Сколько переходов уже израсходовано
+	RedirectType int
+	CacheMaxAge  int64
+	Protected    bool // Ссылка защищена паролем; сам пароль не выгружается
+}
+
+// ImportRow - строка загружаемых ссылок.
+type ImportRow struct {
+	Line         int    // Номер строки во входных данных, для отчёта
+	Key          string // Желаемый ключ. Пустой или занятый - ключ генерируется
+	OriginalURL  string
+	ExpiresAt    time.Time
+	MaxClicks    int64
+	Clicks       int64 // Израсходованные переходы, восстанавливаются у строк, сохранённых под исходным ключом
+	RedirectType int
+	CacheMaxAge  int64
+	Protected    bool // Ссылка выгружена защищённой паролем; такая строка не сохраняется
+
+	Err error // Ошибка разбора строки: такая строка не сохраняется, а попадает в отчёт
+}
+
+// Исходы строк импорта.
+const (
+	ImportCreated    = "created"     // Ссылка сохранена под исходным ключом или под новым, если ключа не было
+	ImportKeyChanged = "key_changed" // Исходный ключ занят или недопустим, ссылка сохранена под новым
+	ImportConflict   = "conflict"    // URL уже сокращён, ссылка не сохранена; ShortURL - существующая ссылка
+	ImportInvalid    = "invalid"     // Строка не разобрана или не прошла проверку
+)
+
+// ImportResult - исход строки импорта.
+type ImportResult struct {
+	Line     int
+	Key      string // Ключ из строки
+	ShortURL string
+	Status   string
+	Error    string
+}
+
+// ImportReport - итог импорта: число строк по исходам и все строки, кроме сохранённых под исходным ключом.
+type ImportReport struct {
+	Created    int
+	KeyChanged int
+	Conflicts  int
+	Invalid    int
+	Rows       []ImportResult
+}
+
+// add учитывает исход строки.
+func (r *ImportReport) add(res ImportResult) {
+	switch res.Status {
+	case ImportCreated:
+		r.Created++
+		return
+	case ImportKeyChanged:
+		r.KeyChanged++
+	case ImportConflict:
+		r.Conflicts++
+	case ImportInvalid:
+		r.Invalid++
+	}
+	r.Rows = append(r.Rows, res)
+}
+
+// ExportLinks передаёт в fn все неудалённые ссылки пользователя по возрастанию ключа,
+// читая их страницами по MaxPageLimit. Ссылки, защищённые паролем, выгружаются с пометкой
+// Protected, но без пароля: хеш пароля не покидает хранилище.
+//
+// Ошибка fn прерывает выгрузку и возвращается как есть.
+func (s *Shortener) ExportLinks(ctx context.Context, userID string, fn func(ExportedLink) error) error {
+	if userID == "" {
+		return ErrUnauthorized
+	}
+
+	q := storage.UserURLsQuery{UserID: userID, Limit: MaxPageLimit}
+	for {
+		page, err := s.storage.GetUserURLsPage(ctx, q)
+		if err != nil {
+			return fmt.Errorf("get user urls page: %w", err)
+		}
+		for _, u := range page.URLs {
+			if err := fn(ExportedLink{
+				Key:          u.Key,
+				ShortURL:     s.ShortURL(u.Key),
+				OriginalURL:  u.OriginalURL,
+				CreatedAt:    u.CreatedAt,
+				ExpiresAt:    u.ExpiresAt,
+				MaxClicks:    u.MaxClicks,
+				Clicks:       u.Clicks,
+				RedirectType: u.RedirectType,
+				CacheMaxAge:  u.CacheMaxAge,
+				Protected:    u.Protected,
+			}); err != nil {
+				return err
+			}
+		}
+		if page.Next == nil {
+			return nil
+		}
+		q.After = page.Next
+	}
+}
+
+// ImportLinks сохраняет ссылки пользователя из строк, которые возвращает next, пачками по ImportChunkSize.
+//
+// Строка сохраняется под своим ключом, если он свободен, иначе - под новым. Строки без ключа
+// и без атрибутов сохраняются одним SaveInBatch на пачку, строки со своим ключом - одним LoadRecords,
+// если хранилище его поддерживает, остальные - по одной. Уже сокращённые URL, защищённые паролем
+// и некорректные строки не прерывают импорт, а попадают в отчёт. next возвращает io.EOF после
+// последней строки; другая его ошибка прерывает импорт с ErrImportRead, ошибка хранилища - как есть.
+// Уже сохранённые пачки при этом остаются, а отчёт о них возвращается вместе с ошибкой;
+// недочитанная пачка не сохраняется.
+func (s *Shortener) ImportLinks(ctx context.Context, userID string, next func() (ImportRow, error)) (ImportReport, error) {
+	var report ImportReport
+	if userID == "" {
+		return report, ErrUnauthorized
+	}
+
+	chunk := make([]ImportRow, 0, ImportChunkSize)
+	for {
+		row, err := next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return report, fmt.Errorf("%w: %w", ErrImportRead, err)
+		}
+		if err == nil {
+			chunk = append(chunk, row)
+		}
+		if len(chunk) == ImportChunkSize || (errors.Is(err, io.EOF) && len(chunk) > 0) {
+			if err := s.importChunk(ctx, userID, chunk, &report); err != nil {
+				return report, err
+			}
+			chunk = chunk[:0]
+		}
+		if errors.Is(err, io.EOF) {
+			return report, nil
+		}
+	}
+}
+
+// importLink - проверенная строка импорта.
+type importLink struct {
+	row    int // Номер строки в пачке
+	url    string
+	opts   LinkOptions
+	clicks int64
+}
+
+// importChunk сохраняет пачку строк импорта и дописывает их исходы в report.
+func (s *Shortener) importChunk(ctx context.Context, userID string, rows []ImportRow, report *ImportReport) error {
+	results := make([]ImportResult, len(rows))
+	var links []importLink   // Проверенные строки с первым вхождением URL
+	urls := map[string]int{} // URL -> первая строка пачки с ним
+	repeats := map[int]int{} // Строка с повтором URL -> первая строка
+
+	for i, row := range rows {
+		res := &results[i]
+		res.Line, res.Key = row.Line, row.Key
+		if row.Err != nil {
+			res.Status, res.Error = ImportInvalid, row.Err.Error()
+			continue
+		}
+		if row.Protected {
+			res.Status, res.Error = ImportInvalid, ErrProtectedImport.Error()
+			continue
+		}
+		u, err := validateURL(row.OriginalURL)
+		if err != nil {
+			res.Status, res.Error = ImportInvalid, fmt.Errorf("%w: %s", ErrInvalidURL, row.OriginalURL).Error()
+			continue
+		}
+
+		res.Status = ImportCreated
+		opts := LinkOptions{
+			ExpiresAt:    row.ExpiresAt,
+			MaxClicks:    row.MaxClicks,
+			RedirectType: row.RedirectType,
+			CacheMaxAge:  row.CacheMaxAge,
+		}
+		if err := opts.validate(); err != nil {
+			res.Status, res.Error = ImportInvalid, err.Error()
+			continue
+		}
+		if row.Clicks < 0 {
+			res.Status, res.Error = ImportInvalid, fmt.Errorf("%w: must not be negative", ErrInvalidClicks).Error()
+			continue
+		}
+
+		// Повторный импорт того же файла не должен плодить копии ссылок
+		if first, seen := urls[u]; seen {
+			res.Status, repeats[i] = ImportConflict, first
+			continue
+		}
+		urls[u] = i
+		links = append(links, importLink{row: i, url: u, opts: opts, clicks: row.Clicks})
+	}
+
+	urlsToCheck := make([]string, len(links))
+	for j, l := range links {
+		urlsToCheck[j] = l.url
+	}
+	existing, err := s.existingKeys(ctx, userID, urlsToCheck)
+	if err != nil {
+		return err
+	}
+
+	var plain []int        // Строки для SaveInBatch
+	var plainURLs []string // Их проверенные URL
+	var keyed []importLink // Строки для LoadRecords
+	loader, canLoad := s.storage.(storage.RecordLoader)
+	aliases := map[string]bool{}
+	for _, l := range links {
+		res := &results[l.row]
+		if key, ok := existing[l.url]; ok {
+			res.Status, res.ShortURL = ImportConflict, s.ShortURL(key)
+			continue
+		}
+
+		if key := rows[l.row].Key; key != "" {
+			free := !aliases[key]
+			if free && canLoad {
+				// Занятость ключей проверяется, только если их отклонит LoadRecords
+				free = ValidateAlias(key) == nil
+			} else if free {
+				if free, err = s.importKeyFree(ctx, key); err != nil {
+					return err
+				}
+			}
+			if free {
+				aliases[key], l.opts.Alias = true, key
+			} else {
+				res.Status = ImportKeyChanged
+			}
+		}
+		switch {
+		case l.opts.empty():
+			plain = append(plain, l.row)
+			plainURLs = append(plainURLs, l.url)
+		case l.opts.Alias != "" && canLoad:
+			keyed = append(keyed, l)
+		default:
+			if err := s.importSave(ctx, res, l.url, l.opts, userID); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Пачка с исходными ключами сохраняется раньше сгенерированных, чтобы новые ключи не заняли исходные
+	if len(keyed) > 0 {
+		if err := s.importKeyed(ctx, loader, userID, keyed, results); err != nil {
+			return err
+		}
+	}
+	if len(plainURLs) > 0 {
+		if err := s.importPlain(ctx, userID, plain, plainURLs, results); err != nil {
+			return err
+		}
+	}
+	for i, first := range repeats {
+		results[i].ShortURL = results[first].ShortURL
+	}
+
+	for _, res := range results {
+		report.add(res)
+	}
+	return nil
+}
+
+// importPlain сохраняет строки без ключа и атрибутов одним SaveInBatch. Если он сообщает
+// об уже сокращённом URL, строки сохраняются по одной, и такие URL попадают в отчёт конфликтами.
+func (s *Shortener) importPlain(ctx context.Context, userID string, plain []int, urls []string, results []ImportResult) error {
+	keys, err := s.storage.SaveInBatch(ctx, urls, userID)
+	switch {
+	case errors.Is(err, storage.ErrAlreadyHasKey):
+		for j, i := range plain {
+			if err := s.importSave(ctx, &results[i], urls[j], LinkOptions{}, userID); err != nil {
+				return err
+			}
+		}
+		return nil
+	case err != nil:
+		return fmt.Errorf("save batch: %w", err)
+	}
+	for j, i := range plain {
+		results[i].ShortURL = s.ShortURL(keys[j])
+	}
+	return nil
+}
+
+// importKeyed сохраняет строки под их исходными ключами одним LoadRecords вместе с израсходованными
+// переходами. Если часть ключей занята, занятые строки сохраняются по одной под новыми ключами,
+// а остальные - ещё одним LoadRecords; если не удаётся и он, строки сохраняются по одной.
+func (s *Shortener) importKeyed(ctx context.Context, loader storage.RecordLoader, userID string, links []importLink, results []ImportResult) error {
+	err := s.loadKeyed(ctx, loader, userID, links, results)
+	if errors.Is(err, storage.ErrKeyTaken) {
+		free := links[:0:0]
+		for _, l := range links {
+			taken, err := s.keyTaken(ctx, l.opts.Alias)
+			if err != nil {
+				return fmt.Errorf("get key: %w", err)
+			}
+			if !taken {
+				free = append(free, l)
+				continue
+			}
+			opts := l.opts
+			opts.Alias, results[l.row].Status = "", ImportKeyChanged
+			if err := s.importSave(ctx, &results[l.row], l.url, opts, userID); err != nil {
+				return err
+			}
+		}
+		links = free
+		err = s.loadKeyed(ctx, loader, userID, links, results)
+	}
+	if !errors.Is(err, storage.ErrKeyTaken) && !errors.Is(err, storage.ErrAlreadyHasKey) {
+		return err
+	}
+
+	for _, l := range links {
+		if err := s.importSave(ctx, &results[l.row], l.url, l.opts, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadKeyed сохраняет строки под их исходными ключами одним LoadRecords.
+func (s *Shortener) loadKeyed(ctx context.Context, loader storage.RecordLoader, userID string, links []importLink, results []ImportResult) error {
+	if len(links) == 0 {
+		return nil
+	}
+	now := time.Now()
+	records := make([]storage.Record, len(links))
+	for j, l := range links {
+		records[j] = storage.Record{Key: l.opts.Alias, URLData: storage.URLData{
+			OriginalURL:  l.url,
+			UserID:       userID,
+			CreatedAt:    now,
+			ExpiresAt:    l.opts.ExpiresAt,
+			MaxClicks:    l.opts.MaxClicks,
+			Clicks:       l.clicks,
+			RedirectType: l.opts.RedirectType,
+			CacheMaxAge:  l.opts.CacheMaxAge,
+		}}
+	}
+
+	if err := loader.LoadRecords(ctx, records); err != nil {
+		return fmt.Errorf("load records: %w", err)
+	}
+	for _, l := range links {
+		results[l.row].ShortURL = s.ShortURL(l.opts.Alias)
+	}
+	return nil
+}
+
+// importSave сохраняет строку импорта отдельно и записывает её исход в res. Если ключ строки заняли
+// после проверки, строка сохраняется под новым.
+func (s *Shortener) importSave(ctx context.Context, res *ImportResult, u string, opts LinkOptions, userID string) error {
+	key, err := s.save(ctx, u, opts, userID)
+	if errors.Is(err, ErrAliasTaken) {
+		opts.Alias, res.Status = "", ImportKeyChanged
+		key, err = s.save(ctx, u, opts, userID)
+	}
+	switch {
+	case errors.Is(err, storage.ErrAlreadyHasKey):
+		res.Status, res.ShortURL = ImportConflict, s.ShortURL(key)
+	case err != nil:
+		return err
+	default:
+		res.ShortURL = s.ShortURL(key)
+	}
+	return nil
+}
+
+// importKeyFree сообщает, можно ли сохранить строку импорта под ключом key.
+func (s *Shortener) importKeyFree(ctx context.Context, key string) (bool, error) {
+	if ValidateAlias(key) != nil {
+		return false, nil
+	}
+	taken, err := s.keyTaken(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("get key: %w", err)
+	}
+	return !taken, nil
+}
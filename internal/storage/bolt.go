@@ -0,0 +1,679 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
+)
+
+// Бакеты BoltStorage. links хранит ссылки, остальные - индексы и история.
+// Составные ключи разделяются нулевым байтом, моменты кодируются boltTime.
+var (
+	boltLinks      = []byte("links")       // ключ -> URLData в JSON
+	boltByURL      = []byte("by_url")      // URL \0 ключ
+	boltByUser     = []byte("by_user")     // пользователь \0 ключ
+	boltByCreated  = []byte("by_created")  // пользователь \0 момент создания, ключ
+	boltByExpiry   = []byte("by_expiry")   // момент истечения, ключ - только неудалённые ссылки со сроком
+	boltByDeletion = []byte("by_deletion") // момент удаления, ключ - только удалённые ссылки
+	boltRevisions  = []byte("revisions")   // ключ \0 номер -> Revision в JSON
+	boltClicks     = []byte("clicks")      // ключ \0 номер -> Click в JSON
+	boltMeta       = []byte("meta")
+
+	boltBuckets = [][]byte{boltLinks, boltByURL, boltByUser, boltByCreated, boltByExpiry, boltByDeletion,
+		boltRevisions, boltClicks, boltMeta}
+)
+
+// boltFormatVersion - версия раскладки данных по бакетам, записанная в meta.
+const boltFormatVersion = "1"
+
+// boltKeyPosition - ключ meta с позицией последовательного генератора ключей.
+var boltKeyPosition = []byte("key_position")
+
+// boltTimeSize - длина момента, закодированного boltTime.
+const boltTimeSize = 12
+
+// BoltStorage - хранилище сокращённых URL во встроенной базе bbolt на диске.
+//
+// Подходит для установок на одном сервере с миллионами ссылок: в памяти не держится ничего,
+// а выборки по URL, пользователю, сроку действия и моменту удаления идут по индексам.
+// Каждое изменение - отдельная транзакция, поэтому пакет сохраняется целиком или не сохраняется вовсе.
+// Файл базы открывается эксклюзивно: второй процесс получит ErrLocked.
+type BoltStorage struct {
+	db   *bolt.DB
+	keys KeyGenerator
+}
+
+// NewBoltStorage открывает или создаёт базу bbolt в файле path.
+func NewBoltStorage(path string, opts ...Option) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolterrors.ErrTimeout) {
+		return nil, fmt.Errorf("%w: %s", ErrLocked, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open bolt storage: %w", err)
+	}
+
+	var position uint64
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		meta := tx.Bucket(boltMeta)
+		switch version := meta.Get([]byte("version")); {
+		case version == nil:
+			if err := meta.Put([]byte("version"), []byte(boltFormatVersion)); err != nil {
+				return err
+			}
+		case string(version) != boltFormatVersion:
+			return fmt.Errorf("unsupported bolt storage version %s", version)
+		}
+		// Базы без сохранённой позиции генератора продолжают с числа ссылок
+		if n := meta.Get(boltKeyPosition); n != nil {
+			position = binary.BigEndian.Uint64(n)
+		} else {
+			position = uint64(tx.Bucket(boltLinks).Stats().KeyN)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init bolt storage: %w", err)
+	}
+
+	// Последовательный генератор продолжает с номера после уже выданных ключей
+	o := newOptions(opts)
+	seekKeys(o.keyGenerator, position)
+	return &BoltStorage{db: db, keys: o.keyGenerator}, nil
+}
+
+// boltTime кодирует момент так, что побайтовый порядок совпадает с порядком моментов,
+// включая нулевой.
+func boltTime(t time.Time) []byte {
+	b := make([]byte, boltTimeSize)
+	binary.BigEndian.PutUint64(b, uint64(t.Unix())^(1<<63))
+	binary.BigEndian.PutUint32(b[8:], uint32(t.Nanosecond()))
+	return b
+}
+
+// boltPrefix возвращает начало составного ключа: значение и нулевой байт.
+func boltPrefix(value string) []byte {
+	return append([]byte(value), 0)
+}
+
+// boltSeq кодирует номер записи истории или перехода.
+func boltSeq(n uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, n)
+}
+
+// boltIndexEntry - запись индекса ссылки: бакет и ключ.
+type boltIndexEntry struct {
+	bucket []byte
+	key    []byte
+}
+
+func (e boltIndexEntry) equal(o boltIndexEntry) bool {
+	return bytes.Equal(e.bucket, o.bucket) && bytes.Equal(e.key, o.key)
+}
+
+// boltIndexes возвращает записи индексов ссылки key с данными data.
+func boltIndexes(key string, data URLData) []boltIndexEntry {
+	entries := []boltIndexEntry{
+		{boltByURL, append(boltPrefix(data.OriginalURL), key...)},
+		{boltByUser, append(boltPrefix(data.UserID), key...)},
+		{boltByCreated, append(append(boltPrefix(data.UserID), boltTime(data.CreatedAt)...), key...)},
+	}
+	if data.Deleted {
+		entries = append(entries, boltIndexEntry{boltByDeletion, append(boltTime(data.DeletedAt), key...)})
+	} else if !data.ExpiresAt.IsZero() {
+		entries = append(entries, boltIndexEntry{boltByExpiry, append(boltTime(data.ExpiresAt), key...)})
+	}
+	return entries
+}
+
+// boltTx - транзакция bbolt с операциями над ссылками и индексами.
+type boltTx struct {
+	*bolt.Tx
+}
+
+// link читает данные ссылки key.
+func (tx boltTx) link(key string) (URLData, bool, error) {
+	raw := tx.Bucket(boltLinks).Get([]byte(key))
+	if raw == nil {
+		return URLData{}, false, nil
+	}
+	var data URLData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return URLData{}, false, fmt.Errorf("decode link %s: %w", key, err)
+	}
+	return data, true, nil
+}
+
+// lookup возвращает данные работающей ссылки.
+func (tx boltTx) lookup(key string) (URLData, error) {
+	data, exists, err := tx.link(key)
+	switch {
+	case err != nil:
+		return URLData{}, err
+	case !exists:
+		return URLData{}, ErrNotFound
+	case data.Deleted:
+		return URLData{}, ErrDeleted
+	case expired(data.ExpiresAt, time.Now()):
+		return URLData{}, ErrExpired
+	case exhausted(data.MaxClicks, data.Clicks):
+		return URLData{}, ErrClickLimitReached
+	}
+	return data, nil
+}
+
+// put сохраняет данные ссылки key и меняет только те записи индексов, которые от них зависят.
+func (tx boltTx) put(key string, data URLData) error {
+	old, exists, err := tx.link(key)
+	if err != nil {
+		return err
+	}
+	var stale []boltIndexEntry
+	if exists {
+		stale = boltIndexes(key, old)
+	}
+	fresh := boltIndexes(key, data)
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("encode link %s: %w", key, err)
+	}
+	if err := tx.Bucket(boltLinks).Put([]byte(key), raw); err != nil {
+		return err
+	}
+	for _, e := range stale {
+		if !slices.ContainsFunc(fresh, e.equal) {
+			if err := tx.Bucket(e.bucket).Delete(e.key); err != nil {
+				return err
+			}
+		}
+	}
+	for _, e := range fresh {
+		if !slices.ContainsFunc(stale, e.equal) {
+			if err := tx.Bucket(e.bucket).Put(e.key, []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// drop удаляет ссылку вместе с записями индексов, историей правок и переходами.
+func (tx boltTx) drop(key string) error {
+	data, exists, err := tx.link(key)
+	if err != nil || !exists {
+		return err
+	}
+	for _, e := range boltIndexes(key, data) {
+		if err := tx.Bucket(e.bucket).Delete(e.key); err != nil {
+			return err
+		}
+	}
+	if err := tx.Bucket(boltLinks).Delete([]byte(key)); err != nil {
+		return err
+	}
+	for _, name := range [][]byte{boltRevisions, boltClicks} {
+		if err := tx.deletePrefix(name, boltPrefix(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deletePrefix удаляет из бакета все ключи с префиксом prefix.
+func (tx boltTx) deletePrefix(bucket, prefix []byte) error {
+	c := tx.Bucket(bucket).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan вызывает fn для записей бакета с префиксом prefix по возрастанию, передавая остаток ключа
+// после префикса и значение. Обход прекращается, когда fn возвращает false или ошибку.
+func (tx boltTx) scan(bucket, prefix []byte, fn func(rest, value []byte) (bool, error)) error {
+	c := tx.Bucket(bucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		more, err := fn(k[len(prefix):], v)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyByURL возвращает любой ключ с таким оригинальным URL, в том числе удалённый.
+func (tx boltTx) keyByURL(url string) (string, bool) {
+	prefix := boltPrefix(url)
+	k, _ := tx.Bucket(boltByURL).Cursor().Seek(prefix)
+	if k == nil || !bytes.HasPrefix(k, prefix) {
+		return "", false
+	}
+	return string(k[len(prefix):]), true
+}
+
+// newKey выдаёт ключ, которого ещё нет в базе, и сохраняет в той же транзакции позицию генератора.
+func (s *BoltStorage) newKey(tx boltTx) (string, error) {
+	links := tx.Bucket(boltLinks)
+	key, err := uniqueKey(s.keys, func(key string) bool {
+		return links.Get([]byte(key)) != nil
+	})
+	if err != nil {
+		return "", err
+	}
+	position := keyPosition(s.keys)
+	if position == 0 {
+		return key, nil
+	}
+	// Отметка только растёт, даже если генератор сдвинули назад
+	meta := tx.Bucket(boltMeta)
+	if old := meta.Get(boltKeyPosition); old != nil && binary.BigEndian.Uint64(old) >= position {
+		return key, nil
+	}
+	return key, meta.Put(boltKeyPosition, boltSeq(position))
+}
+
+// Save сохраняет оригинальный URL и его сокращение в базу.
+//
+// Если URL уже существует — возвращает уже существующий короткий ключ.
+func (s *BoltStorage) Save(ctx context.Context, url string, userID string) (string, error) {
+	return s.SaveLink(ctx, Link{OriginalURL: url, UserID: userID})
+}
+
+// SaveLink сохраняет ссылку с атрибутами под заданным или сгенерированным ключом.
+func (s *BoltStorage) SaveLink(ctx context.Context, link Link) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	var key string
+	err := s.db.Update(func(btx *bolt.Tx) error {
+		tx := boltTx{btx}
+		if existing, exists := tx.keyByURL(link.OriginalURL); exists {
+			key = existing
+			return ErrAlreadyHasKey
+		}
+		newKey := link.Key
+		if newKey == "" {
+			var err error
+			if newKey, err = s.newKey(tx); err != nil {
+				return err
+			}
+		} else if _, exists, err := tx.link(newKey); err != nil || exists {
+			if err != nil {
+				return err
+			}
+			return ErrKeyTaken
+		}
+		if err := tx.put(newKey, URLData{
+			OriginalURL:  link.OriginalURL,
+			UserID:       link.UserID,
+			CreatedAt:    time.Now(),
+			ExpiresAt:    link.ExpiresAt,
+			MaxClicks:    link.MaxClicks,
+			PasswordHash: link.PasswordHash,
+			RedirectType: link.RedirectType,
+			CacheMaxAge:  link.CacheMaxAge,
+		}); err != nil {
+			return err
+		}
+		key = newKey
+		return nil
+	})
+	return key, err
+}
+
+// SaveInBatch сохраняет пакет URL в одной транзакции: при ошибке не сохраняется ни один URL.
+//
+// Для уже сокращённых URL, как и в PostgreSQL, возвращает их существующие ключи.
+func (s *BoltStorage) SaveInBatch(ctx context.Context, urls []string, userID string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(urls))
+	err := s.db.Update(func(btx *bolt.Tx) error {
+		tx := boltTx{btx}
+		now := time.Now()
+		for i, url := range urls {
+			if existing, exists := tx.keyByURL(url); exists {
+				keys[i] = existing
+				continue
+			}
+			key, err := s.newKey(tx)
+			if err != nil {
+				return err
+			}
+			if err := tx.put(key, URLData{OriginalURL: url, UserID: userID, CreatedAt: now}); err != nil {
+				return err
+			}
+			keys[i] = key
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Get выдает полный URL по его сокращенному варианту.
+func (s *BoltStorage) Get(ctx context.Context, key string) (string, error) {
+	link, err := s.GetLink(ctx, key, nil)
+	return link.OriginalURL, err
+}
+
+// GetLink выдает ссылку с атрибутами, проверяя пароль защищённой ссылки функцией verify.
+//
+// Переход ссылки с лимитом расходуется в транзакции на запись, которая заново проверяет лимит.
+func (s *BoltStorage) GetLink(ctx context.Context, key string, verify VerifyFunc) (Link, error) {
+	if err := ctx.Err(); err != nil {
+		return Link{}, err
+	}
+
+	var data URLData
+	err := s.db.View(func(btx *bolt.Tx) error {
+		var err error
+		data, err = boltTx{btx}.lookup(key)
+		return err
+	})
+	if err != nil {
+		return Link{}, err
+	}
+	// Проверка пароля медленная, поэтому выполняется вне транзакции
+	if err := checkPassword(data.PasswordHash, verify); err != nil {
+		return Link{}, err
+	}
+	if data.MaxClicks == 0 {
+		return data.link(key), nil
+	}
+
+	err = s.db.Update(func(btx *bolt.Tx) error {
+		tx := boltTx{btx}
+		var err error
+		if data, err = tx.lookup(key); err != nil {
+			return err
+		}
+		data.Clicks++
+		return tx.put(key, data)
+	})
+	if err != nil {
+		return Link{}, err
+	}
+	return data.link(key), nil
+}
+
+// PeekLink выдает ссылку с атрибутами, не расходуя переход и не проверяя пароль.
+func (s *BoltStorage) PeekLink(ctx context.Context, key string) (Link, error) {
+	if err := ctx.Err(); err != nil {
+		return Link{}, err
+	}
+
+	var link Link
+	err := s.db.View(func(btx *bolt.Tx) error {
+		data, exists, err := boltTx{btx}.link(key)
+		switch {
+		case err != nil:
+			return err
+		case !exists:
+			return ErrNotFound
+		case data.Deleted:
+			return ErrDeleted
+		}
+		link = data.link(key)
+		return nil
+	})
+	return link, err
+}
+
+// HasKey сообщает, занят ли ключ, не расходуя переходы.
+func (s *BoltStorage) HasKey(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	var exists bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket(boltLinks).Get([]byte(key)) != nil
+		return nil
+	})
+	return exists, err
+}
+
+// KeysByURL возвращает ключи тех из urls, что уже сокращены, в том числе чужих и удалённых ссылок.
+func (s *BoltStorage) KeysByURL(ctx context.Context, urls []string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]string)
+	err := s.db.View(func(btx *bolt.Tx) error {
+		tx := boltTx{btx}
+		for _, url := range urls {
+			if key, exists := tx.keyByURL(url); exists {
+				keys[url] = key
+			}
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// Update правит ссылку владельца и сохраняет её прежнюю версию в истории в одной транзакции.
+func (s *BoltStorage) Update(ctx context.Context, key string, userID string, upd LinkUpdate) (Link, error) {
+	if err := ctx.Err(); err != nil {
+		return Link{}, err
+	}
+
+	var link Link
+	err := s.db.Update(func(btx *bolt.Tx) error {
+		tx := boltTx{btx}
+		old, exists, err := tx.link(key)
+		switch {
+		case err != nil:
+			return err
+		case !exists || old.UserID != userID:
+			return ErrNotFound
+		case old.Deleted:
+			return ErrDeleted
+		}
+		data := upd.apply(old)
+		if data.OriginalURL != old.OriginalURL {
+			if other, taken := tx.keyByURL(data.OriginalURL); taken {
+				link = Link{Key: other}
+				return ErrAlreadyHasKey
+			}
+		}
+		if err := tx.put(key, data); err != nil {
+			return err
+		}
+		if err := tx.append(boltRevisions, key, revisionOf(old, time.Now())); err != nil {
+			return err
+		}
+		link = data.link(key)
+		return nil
+	})
+	return link, err
+}
+
+// append дописывает значение v в JSON под следующим номером в историю ключа key в бакете bucket.
+func (tx boltTx) append(bucket []byte, key string, v any) error {
+	b := tx.Bucket(bucket)
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(append(boltPrefix(key), boltSeq(seq)...), raw)
+}
+
+// Revisions возвращает прежние версии ссылки пользователя.
+func (s *BoltStorage) Revisions(ctx context.Context, key string, userID string) ([]Revision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var revisions []Revision
+	err := s.db.View(func(btx *bolt.Tx) error {
+		tx := boltTx{btx}
+		if data, exists, err := tx.link(key); err != nil || !exists || data.UserID != userID {
+			if err != nil {
+				return err
+			}
+			return ErrNotFound
+		}
+		return tx.scan(boltRevisions, boltPrefix(key), func(_, value []byte) (bool, error) {
+			var rev Revision
+			if err := json.Unmarshal(value, &rev); err != nil {
+				return false, fmt.Errorf("decode revision of %s: %w", key, err)
+			}
+			revisions = append(revisions, rev)
+			return true, nil
+		})
+	})
+	return revisions, err
+}
+
+// Ping проверяет, что база открыта.
+func (s *BoltStorage) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.View(func(*bolt.Tx) error { return nil })
+}
+
+// Close закрывает базу и снимает блокировку файла.
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
+
+// GetByURL позволяет получить сокращенный URL по его оригиналу.
+func (s *BoltStorage) GetByURL(ctx context.Context, originalURL string, userID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	var short string
+	err := s.db.View(func(btx *bolt.Tx) error {
+		tx := boltTx{btx}
+		return tx.scan(boltByURL, boltPrefix(originalURL), func(rest, _ []byte) (bool, error) {
+			data, _, err := tx.link(string(rest))
+			if err != nil {
+				return false, err
+			}
+			if data.UserID == userID && !data.Deleted {
+				short = string(rest)
+				return false, nil
+			}
+			return true, nil
+		})
+	})
+	return short, err
+}
+
+// GetUserURLS выдает все пары (сокращенные URL и его оригинал), отправленные  когда-либо пользователем.
+func (s *BoltStorage) GetUserURLS(ctx context.Context, userID string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	urls := map[string]string{}
+	err := s.db.View(func(btx *bolt.Tx) error {
+		tx := boltTx{btx}
+		return tx.scan(boltByUser, boltPrefix(userID), func(rest, _ []byte) (bool, error) {
+			data, _, err := tx.link(string(rest))
+			if err == nil && !data.Deleted {
+				urls[string(rest)] = data.OriginalURL
+			}
+			return err == nil, err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return urls, nil
+}
+
+// GetUserURLsPage выдает страницу ссылок пользователя, читая индекс by_user или by_created
+// от позиции курсора в нужную сторону. Фильтры применяются к прочитанным ссылкам.
+func (s *BoltStorage) GetUserURLsPage(ctx context.Context, q UserURLsQuery) (UserURLsPage, error) {
+	if err := ctx.Err(); err != nil {
+		return UserURLsPage{}, err
+	}
+
+	bucket, start := boltByUser, boltPrefix(q.UserID)
+	prefix := boltPrefix(q.UserID)
+	if q.Sort == SortByCreated {
+		bucket = boltByCreated
+	}
+	if q.After != nil {
+		if q.Sort == SortByCreated {
+			start = append(start, boltTime(q.After.CreatedAt)...)
+		}
+		start = append(start, q.After.Key...)
+	} else if q.Desc {
+		// Ключ сразу после всех ключей пользователя
+		start = append([]byte(q.UserID), 1)
+	}
+
+	var page UserURLsPage
+	err := s.db.View(func(btx *bolt.Tx) error {
+		tx := boltTx{btx}
+		c := tx.Bucket(bucket).Cursor()
+		k, _ := c.Seek(start)
+		next := c.Next
+		if q.Desc {
+			// Seek встаёт на первый ключ не меньше start, а обход назад начинается не дальше start
+			next = c.Prev
+			if k == nil {
+				k, _ = c.Last()
+			} else if bytes.Compare(k, start) > 0 {
+				k, _ = c.Prev()
+			}
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, _ = next() {
+			key := k[len(prefix):]
+			if q.Sort == SortByCreated {
+				key = key[boltTimeSize:]
+			}
+			data, _, err := tx.link(string(key))
+			if err != nil {
+				return err
+			}
+			u := data.userURL(string(key))
+			if !q.match(u) {
+				continue
+			}
+			// Читаем на одну ссылку больше лимита, чтобы узнать, есть ли следующая страница
+			if q.Limit > 0 && len(page.URLs) == q.Limit {
+				page.Next = q.cursor(page.URLs[q.Limit-1])
+				break
+			}
+			page.URLs = append(page.URLs, u)
+		}
+		return nil
+	})
+	if err != nil {
+		return UserURLsPage{}, err
+	}
+	return page, nil
+}
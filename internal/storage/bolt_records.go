@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// RecordClicks сохраняет пачку событий переходов в одной транзакции.
+func (s *BoltStorage) RecordClicks(ctx context.Context, clicks []Click) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(clicks) == 0 {
+		return nil
+	}
+
+	return s.db.Update(func(btx *bolt.Tx) error {
+		tx := boltTx{btx}
+		for _, click := range clicks {
+			if err := tx.append(boltClicks, click.Key, click); err != nil {
+				return fmt.Errorf("save click: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ClickStats возвращает статистику переходов по ключу, агрегируя события ссылки при чтении.
+func (s *BoltStorage) ClickStats(ctx context.Context, key string, topReferrers int) (ClickStats, error) {
+	if err := ctx.Err(); err != nil {
+		return ClickStats{}, err
+	}
+
+	agg := &clickAggregate{perDay: make(map[string]int64), referrers: make(map[string]int64)}
+	err := s.db.View(func(btx *bolt.Tx) error {
+		return boltTx{btx}.scan(boltClicks, boltPrefix(key), func(_, value []byte) (bool, error) {
+			var click Click
+			if err := json.Unmarshal(value, &click); err != nil {
+				return false, fmt.Errorf("decode click of %s: %w", key, err)
+			}
+			agg.add(click)
+			return true, nil
+		})
+	})
+	if err != nil || agg.total == 0 {
+		return ClickStats{}, err
+	}
+	return agg.stats(topReferrers), nil
+}
+
+// ScanRecords выдаёт записи всех пользователей, включая удалённые, с ключами больше after.
+func (s *BoltStorage) ScanRecords(ctx context.Context, after string, limit int) ([]Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	err := s.db.View(func(btx *bolt.Tx) error {
+		c := btx.Bucket(boltLinks).Cursor()
+		k, v := c.Seek([]byte(after))
+		if k != nil && string(k) == after {
+			k, v = c.Next()
+		}
+		for ; k != nil && (limit <= 0 || len(records) < limit); k, v = c.Next() {
+			r := Record{Key: string(k)}
+			if err := json.Unmarshal(v, &r.URLData); err != nil {
+				return fmt.Errorf("decode link %s: %w", k, err)
+			}
+			records = append(records, r)
+		}
+		return nil
+	})
+	return records, err
+}
+
+// LoadRecords сохраняет записи с их ключами в одной транзакции. Повторы оригинальных URL
+// допускаются, как и в хранилище в памяти.
+func (s *BoltStorage) LoadRecords(ctx context.Context, records []Record) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.db.Update(func(btx *bolt.Tx) error {
+		tx := boltTx{btx}
+		// Повтор ключа внутри вызова сравнивается с уже записанной в транзакции записью
+		for _, r := range records {
+			old, exists, err := tx.link(r.Key)
+			switch {
+			case err != nil:
+				return err
+			case exists && !r.Equal(Record{Key: r.Key, URLData: old}):
+				return fmt.Errorf("%w: %s", ErrKeyTaken, r.Key)
+			case exists:
+				continue
+			}
+			if err := tx.put(r.Key, r.URLData); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// MarkAsDeleted помечает удалёнными ссылки пользователя в одной транзакции. Чужие и неизвестные ключи пропускаются.
+//
+// Момент удаления уже удалённой ссылки не меняется: срок хранения в корзине отсчитывается от первого удаления.
+func (s *BoltStorage) MarkAsDeleted(ctx context.Context, urls []string, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.db.Update(func(btx *bolt.Tx) error {
+		tx := boltTx{btx}
+		now := time.Now()
+		for _, key := range urls {
+			data, exists, err := tx.link(key)
+			if err != nil {
+				return err
+			}
+			if !exists || data.UserID != userID || data.Deleted {
+				continue
+			}
+			data.Deleted, data.DeletedAt = true, now
+			if err := tx.put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ExpireLinks помечает удалёнными не больше limit ссылок, срок которых истёк к моменту now.
+//
+// Истёкшие ссылки читаются из начала индекса by_expiry, поэтому вызов не перебирает живые ссылки.
+func (s *BoltStorage) ExpireLinks(ctx context.Context, now time.Time, limit int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var n int
+	err := s.db.Update(func(btx *bolt.Tx) error {
+		tx := boltTx{btx}
+		keys := tx.before(boltByExpiry, now.Add(time.Nanosecond), limit)
+		for _, key := range keys {
+			data, _, err := tx.link(key)
+			if err != nil {
+				return err
+			}
+			data.Deleted, data.DeletedAt = true, now
+			if err := tx.put(key, data); err != nil {
+				return err
+			}
+		}
+		n = len(keys)
+		return nil
+	})
+	return n, err
+}
+
+// before возвращает не больше limit ключей из начала индекса bucket, упорядоченного по моменту,
+// с моментом раньше t.
+func (tx boltTx) before(bucket []byte, t time.Time, limit int) []string {
+	bound := boltTime(t)
+	var keys []string
+	c := tx.Bucket(bucket).Cursor()
+	for k, _ := c.First(); k != nil && len(keys) < limit; k, _ = c.Next() {
+		if bytes.Compare(k[:boltTimeSize], bound) >= 0 {
+			break
+		}
+		keys = append(keys, string(k[boltTimeSize:]))
+	}
+	return keys
+}
+
+// GetDeletedURLS выдает удалённые ссылки пользователя из корзины.
+func (s *BoltStorage) GetDeletedURLS(ctx context.Context, userID string) ([]DeletedURL, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var deleted []DeletedURL
+	err := s.db.View(func(btx *bolt.Tx) error {
+		tx := boltTx{btx}
+		return tx.scan(boltByUser, boltPrefix(userID), func(rest, _ []byte) (bool, error) {
+			data, _, err := tx.link(string(rest))
+			if err == nil && data.Deleted {
+				deleted = append(deleted, DeletedURL{Key: string(rest), OriginalURL: data.OriginalURL, DeletedAt: data.DeletedAt})
+			}
+			return err == nil, err
+		})
+	})
+	return deleted, err
+}
+
+// RestoreURLs снимает пометку удаления с удалённых ссылок пользователя в одной транзакции.
+func (s *BoltStorage) RestoreURLs(ctx context.Context, urls []string, userID string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var restored []string
+	err := s.db.Update(func(btx *bolt.Tx) error {
+		tx := boltTx{btx}
+		var links []URLData
+		var keys, expiredKeys []string
+		for _, key := range urls {
+			data, exists, err := tx.link(key)
+			if err != nil {
+				return err
+			}
+			if !exists || data.UserID != userID || !data.Deleted {
+				continue
+			}
+			if expired(data.ExpiresAt, now) {
+				expiredKeys = append(expiredKeys, key)
+			}
+			keys, links = append(keys, key), append(links, data)
+		}
+		if len(expiredKeys) > 0 {
+			return errRestoreExpired(expiredKeys)
+		}
+
+		for i, key := range keys {
+			data := links[i]
+			data.Deleted, data.DeletedAt = false, time.Time{}
+			if err := tx.put(key, data); err != nil {
+				return err
+			}
+			restored = append(restored, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+// PurgeDeleted окончательно удаляет не больше limit ссылок, удалённых раньше before,
+// вместе с их историей правок и переходами.
+func (s *BoltStorage) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var n int
+	err := s.db.Update(func(btx *bolt.Tx) error {
+		tx := boltTx{btx}
+		keys := tx.before(boltByDeletion, before, limit)
+		for _, key := range keys {
+			if err := tx.drop(key); err != nil {
+				return err
+			}
+		}
+		n = len(keys)
+		return nil
+	})
+	return n, err
+}
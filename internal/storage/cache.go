@@ -0,0 +1,299 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Сроки жизни записей кеша по умолчанию. Изменения, сделанные другими экземплярами сервиса
+// в обход кеша, становятся видны не позже этих сроков.
+const (
+	DefaultCacheTTL         = time.Minute
+	DefaultCacheNegativeTTL = 5 * time.Second
+)
+
+// CacheBackend - хранилище, перед которым можно поставить CachedStorage.
+//
+// Кроме Storage оно должно поддерживать возможности, которые приложение находит по приведению
+// типов, чтобы кеш их не скрывал, и PeekLink для чтения ссылок без побочных эффектов.
+type CacheBackend interface {
+	Storage
+	LinkPeeker
+	KeyChecker
+	URLChecker
+	Expirer
+	Purger
+	ClickStorage
+}
+
+// CacheStats - счётчики кеша ссылок.
+type CacheStats struct {
+	Hits      int64 // Ответ найден в кеше, в том числе отрицательный
+	Misses    int64 // Ссылка прочитана из хранилища
+	Coalesced int64 // Промах дождался чтения, уже начатого другим запросом
+	Entries   int   // Записей в кеше сейчас
+}
+
+// CacheReporter описывает хранилища с кешем, счётчики которого можно получить.
+type CacheReporter interface {
+	CacheStats() CacheStats
+}
+
+// cacheEntry - ссылка или ошибка ErrNotFound/ErrDeleted для ключа.
+type cacheEntry struct {
+	key     string
+	link    Link
+	err     error
+	expires time.Time
+}
+
+// CachedStorage - декоратор хранилища с ограниченным LRU-кешем открытия ссылок (Get и GetLink).
+//
+// Кешируются найденные ссылки и отрицательные ответы для неизвестных и удалённых ключей.
+// Одновременные промахи по одному ключу читают хранилище один раз. Записи сбрасываются,
+// когда ссылку меняют через декоратор: удаление, восстановление, правка, сохранение под ключом.
+// Срок действия и пароль проверяются по кешированной ссылке, а переходы ссылок с лимитом
+// всегда расходуются в хранилище.
+type CachedStorage struct {
+	CacheBackend
+
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Недавно использованные записи в начале
+	epoch   uint64     // Растёт при каждом сбросе; чтение, начатое до сброса, в кеш не попадает
+
+	loads     singleflight.Group
+	hits      atomic.Int64
+	misses    atomic.Int64
+	coalesced atomic.Int64
+}
+
+// NewCachedStorage создаёт кеш на size ссылок перед хранилищем inner. ttl - срок жизни найденной
+// ссылки, negativeTTL - ответа для неизвестного или удалённого ключа; нулевые сроки заменяются
+// на DefaultCacheTTL и DefaultCacheNegativeTTL.
+func NewCachedStorage(inner CacheBackend, size int, ttl, negativeTTL time.Duration) *CachedStorage {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	if negativeTTL <= 0 {
+		negativeTTL = DefaultCacheNegativeTTL
+	}
+	return &CachedStorage{
+		CacheBackend: inner,
+		size:         size,
+		ttl:          ttl,
+		negativeTTL:  negativeTTL,
+		entries:      make(map[string]*list.Element),
+		lru:          list.New(),
+	}
+}
+
+// Get выдает полный URL по его сокращенному варианту, по возможности из кеша.
+func (c *CachedStorage) Get(ctx context.Context, key string) (string, error) {
+	link, err := c.GetLink(ctx, key, nil)
+	return link.OriginalURL, err
+}
+
+// GetLink выдает ссылку с атрибутами, по возможности из кеша.
+func (c *CachedStorage) GetLink(ctx context.Context, key string, verify VerifyFunc) (Link, error) {
+	link, err := c.peek(ctx, key)
+	if err != nil {
+		return Link{}, err
+	}
+	if expired(link.ExpiresAt, time.Now()) {
+		return Link{}, ErrExpired
+	}
+	// Переход ссылки с лимитом расходуется атомарно только в хранилище
+	if link.MaxClicks > 0 {
+		return c.CacheBackend.GetLink(ctx, key, verify)
+	}
+	if err := checkPassword(link.PasswordHash, verify); err != nil {
+		return Link{}, err
+	}
+	return link, nil
+}
+
+// peek возвращает ссылку из кеша или читает её из хранилища.
+func (c *CachedStorage) peek(ctx context.Context, key string) (Link, error) {
+	if entry, ok := c.lookup(key); ok {
+		c.hits.Add(1)
+		return entry.link, entry.err
+	}
+
+	// Чтение идёт с отдельным контекстом: отмена запроса, начавшего его, не должна
+	// обрывать ожидающих того же ключа
+	leader := false // Выполняет ли чтение именно этот вызов; видно после получения результата из канала
+	ch := c.loads.DoChan(key, func() (any, error) {
+		leader = true
+		c.misses.Add(1)
+		c.mu.Lock()
+		epoch := c.epoch
+		c.mu.Unlock()
+
+		link, err := c.CacheBackend.PeekLink(context.WithoutCancel(ctx), key)
+		if err == nil || errors.Is(err, ErrNotFound) || errors.Is(err, ErrDeleted) {
+			c.store(epoch, key, link, err)
+		}
+		return link, err
+	})
+	select {
+	case <-ctx.Done():
+		return Link{}, ctx.Err()
+	case res := <-ch:
+		if !leader {
+			c.coalesced.Add(1)
+		}
+		link, _ := res.Val.(Link)
+		return link, res.Err
+	}
+}
+
+// lookup возвращает живую запись кеша и поднимает её в начало LRU.
+func (c *CachedStorage) lookup(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !time.Now().Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry, true
+}
+
+// store кладёт результат чтения в кеш, если с начала чтения кеш не сбрасывался.
+func (c *CachedStorage) store(epoch uint64, key string, link Link, err error) {
+	ttl := c.ttl
+	if err != nil {
+		ttl = c.negativeTTL
+	}
+	entry := &cacheEntry{key: key, link: link, err: err, expires: time.Now().Add(ttl)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.epoch != epoch {
+		return
+	}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// invalidate сбрасывает записи ключей keys и не даёт начатым чтениям вернуть их в кеш.
+func (c *CachedStorage) invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	for _, key := range keys {
+		if elem, ok := c.entries[key]; ok {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// invalidateAll сбрасывает весь кеш.
+func (c *CachedStorage) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// CacheStats возвращает счётчики кеша.
+func (c *CachedStorage) CacheStats() CacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Coalesced: c.coalesced.Load(),
+		Entries:   entries,
+	}
+}
+
+// Save сохраняет URL и сбрасывает отрицательный ответ для нового ключа.
+func (c *CachedStorage) Save(ctx context.Context, url string, userID string) (string, error) {
+	key, err := c.CacheBackend.Save(ctx, url, userID)
+	if err == nil {
+		c.invalidate(key)
+	}
+	return key, err
+}
+
+// SaveLink сохраняет ссылку и сбрасывает отрицательный ответ для её ключа.
+func (c *CachedStorage) SaveLink(ctx context.Context, link Link) (string, error) {
+	key, err := c.CacheBackend.SaveLink(ctx, link)
+	if err == nil {
+		c.invalidate(key)
+	}
+	return key, err
+}
+
+// SaveInBatch сохраняет пакет URL и сбрасывает отрицательные ответы для новых ключей.
+func (c *CachedStorage) SaveInBatch(ctx context.Context, urls []string, userID string) ([]string, error) {
+	keys, err := c.CacheBackend.SaveInBatch(ctx, urls, userID)
+	c.invalidate(keys...)
+	return keys, err
+}
+
+// Update правит ссылку и сбрасывает её запись в кеше.
+func (c *CachedStorage) Update(ctx context.Context, key string, userID string, upd LinkUpdate) (Link, error) {
+	defer c.invalidate(key)
+	return c.CacheBackend.Update(ctx, key, userID, upd)
+}
+
+// MarkAsDeleted помечает ссылки удалёнными и сбрасывает их записи в кеше.
+func (c *CachedStorage) MarkAsDeleted(ctx context.Context, urls []string, userID string) error {
+	defer c.invalidate(urls...)
+	return c.CacheBackend.MarkAsDeleted(ctx, urls, userID)
+}
+
+// RestoreURLs восстанавливает ссылки и сбрасывает их записи в кеше.
+func (c *CachedStorage) RestoreURLs(ctx context.Context, urls []string, userID string) ([]string, error) {
+	defer c.invalidate(urls...)
+	return c.CacheBackend.RestoreURLs(ctx, urls, userID)
+}
+
+// PurgeDeleted окончательно удаляет ссылки. Какие именно, неизвестно, поэтому кеш сбрасывается целиком.
+func (c *CachedStorage) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	n, err := c.CacheBackend.PurgeDeleted(ctx, before, limit)
+	if n > 0 {
+		c.invalidateAll()
+	}
+	return n, err
+}
+
+// Close закрывает хранилище, если оно держит файл или соединение.
+func (c *CachedStorage) Close() error {
+	if closer, ok := c.CacheBackend.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
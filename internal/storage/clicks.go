@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Click - событие перехода по короткой ссылке.
+type Click struct {
+	Key       string    `json:"short_url"`
+	At        time.Time `json:"at"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IPHash    string    `json:"ip_hash,omitempty"` // Хеш IP клиента; сам IP не хранится
+}
+
+// DayClicks - число переходов за сутки (UTC).
+type DayClicks struct {
+	Date   string // Дата в формате 2006-01-02
+	Clicks int64
+}
+
+// ReferrerClicks - число переходов с одного источника.
+type ReferrerClicks struct {
+	Referrer string
+	Clicks   int64
+}
+
+// ClickStats - сводная статистика переходов по ссылке.
+type ClickStats struct {
+	Total        int64
+	PerDay       []DayClicks      // По возрастанию даты
+	TopReferrers []ReferrerClicks // По убыванию числа переходов, переходы без Referer не учитываются
+}
+
+// ClickStorage описывает хранилища событий переходов.
+//
+// RecordClicks сохраняет пачку событий, ClickStats возвращает статистику ссылки
+// с topReferrers самыми частыми источниками.
+type ClickStorage interface {
+	RecordClicks(ctx context.Context, clicks []Click) error
+	ClickStats(ctx context.Context, key string, topReferrers int) (ClickStats, error)
+}
+
+// clickCounter - агрегаты переходов в памяти: сами события не хранятся.
+type clickCounter struct {
+	mu    sync.Mutex
+	links map[string]*clickAggregate
+}
+
+// clickAggregate - счётчики переходов по одной ссылке.
+type clickAggregate struct {
+	total     int64
+	perDay    map[string]int64
+	referrers map[string]int64
+}
+
+func newClickCounter() *clickCounter {
+	return &clickCounter{links: make(map[string]*clickAggregate)}
+}
+
+// add учитывает события в агрегатах.
+func (c *clickCounter) add(clicks []Click) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, click := range clicks {
+		agg, ok := c.links[click.Key]
+		if !ok {
+			agg = &clickAggregate{perDay: make(map[string]int64), referrers: make(map[string]int64)}
+			c.links[click.Key] = agg
+		}
+		agg.add(click)
+	}
+}
+
+// forget удаляет агрегаты ссылки key.
+func (c *clickCounter) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.links, key)
+}
+
+// stats возвращает статистику ссылки key.
+func (c *clickCounter) stats(key string, topReferrers int) ClickStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	agg, ok := c.links[key]
+	if !ok {
+		return ClickStats{}
+	}
+	return agg.stats(topReferrers)
+}
+
+func (a *clickAggregate) add(click Click) {
+	a.total++
+	a.perDay[click.At.UTC().Format(time.DateOnly)]++
+	if click.Referrer != "" {
+		a.referrers[click.Referrer]++
+	}
+}
+
+func (a *clickAggregate) stats(topReferrers int) ClickStats {
+	stats := ClickStats{Total: a.total}
+	for day, n := range a.perDay {
+		stats.PerDay = append(stats.PerDay, DayClicks{Date: day, Clicks: n})
+	}
+	sort.Slice(stats.PerDay, func(i, j int) bool { return stats.PerDay[i].Date < stats.PerDay[j].Date })
+
+	for referrer, n := range a.referrers {
+		stats.TopReferrers = append(stats.TopReferrers, ReferrerClicks{Referrer: referrer, Clicks: n})
+	}
+	sort.Slice(stats.TopReferrers, func(i, j int) bool {
+		if stats.TopReferrers[i].Clicks != stats.TopReferrers[j].Clicks {
+			return stats.TopReferrers[i].Clicks > stats.TopReferrers[j].Clicks
+		}
+		return stats.TopReferrers[i].Referrer < stats.TopReferrers[j].Referrer
+	})
+	if len(stats.TopReferrers) > topReferrers {
+		stats.TopReferrers = stats.TopReferrers[:topReferrers]
+	}
+	return stats
+}
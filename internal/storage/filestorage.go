@@ -1,48 +1,194 @@
 package storage
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // FileStorage - хранилище сокращенных URL в файле.
 //
 // Использует мьютекс для потокобезопасного доступа.
 type FileStorage struct {
-	memory    *MemoryStorage
-	filePath  string
-	lastUUID  int
-	saveMutex sync.Mutex
+	memory   *MemoryStorage
+	filePath string
+	lastUUID int
+	// keyPosition - наибольшая позиция генератора ключей из журнала, заполняется при загрузке
+	keyPosition uint64
+	saveMutex   sync.Mutex // Сериализует изменения памяти и постановку записей в очередь писателя
+
+	opts           options
+	fileSize       int64       // Текущий размер файла журнала
+	nextCompactAt  int64       // Размер, по достижении которого запускается сжатие
+	compactRunning atomic.Bool // Защита от параллельных фоновых сжатий
+
+	// Групповой коммит: файл, размеры и счётчик UUID принадлежат горутине писателя
+	file       *os.File
+	writeCh    chan *writeRequest
+	writerDone chan struct{}
+	closed     bool // Защищено saveMutex
+
+	lock *os.File // Файл с эксклюзивной блокировкой flock, nil у ведомого
+
+	// Режим ведомого: позиция и версия прочитанного журнала принадлежат горутине отслеживания
+	version      int
+	followOffset int64
+	followInfo   os.FileInfo
+	stopFollow   chan struct{}
+	followDone   chan struct{}
+
+	// Журнал переходов: дозапись и чтение сериализует clicksMutex
+	clicksMutex  sync.Mutex
+	clicks       *clickCounter // Агрегаты прочитанной части журнала переходов
+	clicksOffset int64         // Сколько байт журнала переходов учтено в clicks
+	clicksInfo   os.FileInfo   // Прочитанный журнал переходов: подменённый читается заново
 }
 
 // NewFileStorage - создает новое файл-хранилище.
-func NewFileStorage(filePath string) (*FileStorage, error) {
+//
+// Файл открывается эксклюзивно: если его уже использует другой процесс, возвращается ErrLocked.
+// С опцией WithFollower хранилище открывается ведомым только для чтения и подхватывает
+// записи процесса-владельца.
+func NewFileStorage(filePath string, opts ...Option) (*FileStorage, error) {
+	o := newOptions(opts)
 	s := &FileStorage{
-		memory:   NewMemoryStorage(),
+		memory:   NewMemoryStorage(WithKeyGenerator(o.keyGenerator)),
 		filePath: filePath,
+		opts:     o,
+		clicks:   newClickCounter(),
+	}
+	s.nextCompactAt = s.opts.compactThreshold
+	if filePath == "" {
+		return s, nil
 	}
-	if filePath != "" {
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
-			if err := os.WriteFile(filePath, []byte{}, 0644); err != nil {
-				return nil, fmt.Errorf("cannot create storage file: %w", err)
-			}
+
+	if s.readOnly() {
+		if err := s.startFollower(); err != nil {
+			return nil, err
 		}
-		s.loadFromFile()
+		return s, nil
+	}
+
+	// Второй процесс с тем же файлом получит ErrLocked вместо порчи журнала.
+	// Блокируется отдельный файл: сам журнал подменяется через rename при сжатии.
+	lock, err := lockFile(filePath + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	s.lock = lock
+	if err := s.open(); err != nil {
+		lock.Close()
+		return nil, err
 	}
 	return s, nil
+}
 
+// open загружает журнал и запускает писателя. Вызывается владельцем блокировки.
+func (f *FileStorage) open() error {
+	// Новый или пустой файл сразу получает заголовок текущей версии формата
+	if info, err := os.Stat(f.filePath); os.IsNotExist(err) || (err == nil && info.Size() == 0) {
+		if err := os.WriteFile(f.filePath, encodeHeader(0), 0644); err != nil {
+			return fmt.Errorf("cannot create storage file: %w", err)
+		}
+	}
+	if err := f.loadFromFile(); err != nil {
+		return err
+	}
+	// Последовательный генератор продолжает с номера после уже выданных ключей.
+	// Журналы старых версий не хранят позицию - продолжаем с числа ссылок
+	if f.keyPosition == 0 {
+		f.keyPosition = uint64(len(f.memory.data))
+	}
+	seekKeys(f.opts.keyGenerator, f.keyPosition)
+	if info, err := os.Stat(f.filePath); err == nil {
+		f.fileSize = info.Size()
+	}
+	return f.startWriter()
 }
 
 // ShortURLJSON структура для хранения пар сокращенного и оригинального URL для конкретного пользователя.
+//
+// Запись с флагом IsDeleted является надгробием: она помечает ранее сохранённый ShortURL удалённым.
+// Запись без OriginalURL и без флага удаления обновляет счётчик Clicks ссылки с лимитом переходов.
+// Запись с ChangedAt хранит прежнюю версию ссылки из истории правок (см. Update).
 type ShortURLJSON struct {
-	UUID        int    `json:"uuid"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
-	UserID      string `json:"user_id"`
+	UUID        int        `json:"uuid"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url,omitempty"`
+	UserID      string     `json:"user_id"`
+	IsDeleted   bool       `json:"is_deleted,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"` // Момент создания, nil у записей старых версий
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"` // Момент удаления, nil у записей старых версий
+	Checksum    string     `json:"crc,omitempty"`        // CRC32 записи без этого поля, см. recordChecksum
+
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Срок действия ссылки, nil - бессрочная
+	MaxClicks int64      `json:"max_clicks,omitempty"` // Лимит переходов, 0 - без ограничения
+	Clicks    int64      `json:"clicks,omitempty"`     // Израсходованные переходы ссылки с лимитом
+
+	PasswordHash string `json:"password_hash,omitempty"` // Хеш пароля защищённой ссылки
+	RedirectType int    `json:"redirect_type,omitempty"` // Статус редиректа, 0 - по умолчанию сервера
+	CacheMaxAge  int64  `json:"cache_max_age,omitempty"` // Время кеширования редиректа, 0 - по умолчанию сервера
+
+	ChangedAt *time.Time `json:"changed_at,omitempty"` // Момент правки, заменившей эту версию ссылки
+
+	KeyPosition uint64 `json:"key_position,omitempty"` // Позиция генератора ключей после сохранения ссылки
+}
+
+// newRecord составляет запись журнала для ссылки key с данными data.
+func newRecord(key string, data URLData) ShortURLJSON {
+	record := ShortURLJSON{
+		ShortURL:     key,
+		OriginalURL:  data.OriginalURL,
+		UserID:       data.UserID,
+		IsDeleted:    data.Deleted,
+		MaxClicks:    data.MaxClicks,
+		Clicks:       data.Clicks,
+		PasswordHash: data.PasswordHash,
+		RedirectType: data.RedirectType,
+		CacheMaxAge:  data.CacheMaxAge,
+	}
+	if !data.CreatedAt.IsZero() {
+		createdAt := data.CreatedAt.UTC()
+		record.CreatedAt = &createdAt
+	}
+	if !data.ExpiresAt.IsZero() {
+		expiresAt := data.ExpiresAt.UTC()
+		record.ExpiresAt = &expiresAt
+	}
+	if data.Deleted && !data.DeletedAt.IsZero() {
+		deletedAt := data.DeletedAt.UTC()
+		record.DeletedAt = &deletedAt
+	}
+	return record
+}
+
+// urlData возвращает данные ссылки, сохранённые в записи.
+func (r ShortURLJSON) urlData() URLData {
+	data := URLData{
+		OriginalURL:  r.OriginalURL,
+		UserID:       r.UserID,
+		Deleted:      r.IsDeleted,
+		MaxClicks:    r.MaxClicks,
+		Clicks:       r.Clicks,
+		PasswordHash: r.PasswordHash,
+		RedirectType: r.RedirectType,
+		CacheMaxAge:  r.CacheMaxAge,
+	}
+	if r.CreatedAt != nil {
+		data.CreatedAt = *r.CreatedAt
+	}
+	if r.ExpiresAt != nil {
+		data.ExpiresAt = *r.ExpiresAt
+	}
+	if r.IsDeleted && r.DeletedAt != nil {
+		data.DeletedAt = *r.DeletedAt
+	}
+	return data
 }
 
 // Save - используется для сохранения URL в файл.
@@ -55,106 +201,219 @@ func (f *FileStorage) Save(ctx context.Context, url string, userID string) (stri
 	default:
 	}
 
+	if f.readOnly() {
+		return "", ErrReadOnly
+	}
+
 	if key, err := f.GetByURL(ctx, url, userID); err == nil && key != "" {
 		return key, ErrAlreadyHasKey
 	}
+	return f.SaveLink(ctx, Link{OriginalURL: url, UserID: userID})
+}
 
-	key, err := f.memory.Save(ctx, url, userID)
-	if err != nil {
-		fmt.Printf("Memory save error: %v\n", err)
+// SaveLink сохраняет ссылку с атрибутами в память и дописывает её запись в файл.
+func (f *FileStorage) SaveLink(ctx context.Context, link Link) (string, error) {
+	if err := ctx.Err(); err != nil {
 		return "", err
 	}
+	if f.readOnly() {
+		return "", ErrReadOnly
+	}
 
-	if f.filePath != "" {
-		if err := f.saveToFile(key, url, userID); err != nil {
-			fmt.Printf("File save error: %v\n", err) // Логируем ошибку записи
-			return "", fmt.Errorf("failed to save to file: %w", err)
-		}
+	f.saveMutex.Lock()
+	key, err := f.memory.SaveLink(ctx, link)
+	if err != nil {
+		f.saveMutex.Unlock()
+		fmt.Printf("Memory save error: %v\n", err)
+		return key, err
+	}
+	if f.filePath == "" {
+		f.saveMutex.Unlock()
+		return key, nil
+	}
+	f.memory.mu.RLock()
+	data := f.memory.data[key]
+	f.memory.mu.RUnlock()
+	done, err := f.saveToFile(key, data)
+	f.saveMutex.Unlock()
 
+	if err == nil {
+		err = <-done
+	}
+	if err != nil {
+		fmt.Printf("File save error: %v\n", err) // Логируем ошибку записи
+		return "", fmt.Errorf("failed to save to file: %w", err)
 	}
 	return key, nil
 }
 
 // Get выдает полный URL по его сокращенному варианту.
+//
+// Переход по ссылке с лимитом сохраняется в журнал до ответа, поэтому лимит переживает перезапуск.
+// Ведомый такие ссылки не открывает и возвращает ErrReadOnly.
 func (f *FileStorage) Get(ctx context.Context, key string) (string, error) {
+	link, err := f.GetLink(ctx, key, nil)
+	return link.OriginalURL, err
+}
+
+// GetLink выдает ссылку с атрибутами, проверяя пароль защищённой ссылки функцией verify.
+func (f *FileStorage) GetLink(ctx context.Context, key string, verify VerifyFunc) (Link, error) {
 	select {
 	case <-ctx.Done():
-		return "", ctx.Err()
+		return Link{}, ctx.Err()
 	default:
 	}
-	return f.memory.Get(ctx, key)
-}
 
-// Доп метод для сохранения в файл
-func (f *FileStorage) saveToFile(key, url string, userID string) error {
+	f.memory.mu.RLock()
+	data, err := f.memory.lookup(key)
+	f.memory.mu.RUnlock()
+	if err != nil {
+		return Link{}, err
+	}
+	if err := checkPassword(data.PasswordHash, verify); err != nil {
+		return Link{}, err
+	}
+	if data.MaxClicks == 0 {
+		return data.link(key), nil
+	}
+	if f.filePath == "" {
+		if data, err = f.memory.useClick(key); err != nil {
+			return Link{}, err
+		}
+		return data.link(key), nil
+	}
+	if f.readOnly() {
+		return Link{}, ErrReadOnly
+	}
+
 	f.saveMutex.Lock()
-	defer f.saveMutex.Unlock()
+	data, err = f.memory.useClick(key)
+	if err != nil {
+		f.saveMutex.Unlock()
+		return Link{}, err
+	}
+	done, err := f.enqueue(&writeRequest{
+		records:  []ShortURLJSON{{ShortURL: key, UserID: data.UserID, Clicks: data.Clicks}},
+		rollback: func() { f.memory.unuseClick(key) },
+	})
+	if err != nil {
+		f.memory.unuseClick(key)
+	}
+	f.saveMutex.Unlock()
 
-	// 1. Открытие файла с правильными флагами
-	file, err := os.OpenFile(f.filePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err == nil {
+		err = <-done
+	}
 	if err != nil {
-		return fmt.Errorf("file open error: %w", err)
+		return Link{}, fmt.Errorf("failed to save click to file: %w", err)
 	}
-	defer file.Close()
+	return data.link(key), nil
+}
 
-	f.lastUUID++
-	record := ShortURLJSON{
-		UUID:        f.lastUUID,
-		ShortURL:    key,
-		OriginalURL: url,
-		UserID:      userID,
+// PeekLink выдает ссылку с атрибутами, не расходуя переход и не проверяя пароль.
+func (f *FileStorage) PeekLink(ctx context.Context, key string) (Link, error) {
+	return f.memory.PeekLink(ctx, key)
+}
+
+// HasKey сообщает, занят ли ключ, не расходуя переходы.
+func (f *FileStorage) HasKey(ctx context.Context, key string) (bool, error) {
+	return f.memory.HasKey(ctx, key)
+}
+
+// KeysByURL возвращает ключи тех из urls, что уже сокращены, в том числе чужих и удалённых ссылок.
+func (f *FileStorage) KeysByURL(ctx context.Context, urls []string) (map[string]string, error) {
+	return f.memory.KeysByURL(ctx, urls)
+}
+
+// Доп метод для сохранения в файл: ставит запись в очередь писателя. Вызывающий должен удерживать saveMutex.
+func (f *FileStorage) saveToFile(key string, data URLData) (<-chan error, error) {
+	record := newRecord(key, data)
+	record.KeyPosition = keyPosition(f.opts.keyGenerator)
+	done, err := f.enqueue(&writeRequest{
+		records:  []ShortURLJSON{record},
+		rollback: func() { f.memory.remove([]string{key}) },
+	})
+	if err != nil {
+		f.memory.remove([]string{key})
+	}
+	return done, err
+}
+
+// appendRecords атомарно дописывает записи в файл.
+//
+// Все записи кодируются в один буфер и пишутся одним вызовом с последующим fsync.
+// При ошибке файл обрезается до исходного размера, а счётчик UUID откатывается,
+// поэтому в журнал попадают либо все записи пакета, либо ни одной.
+// Вызывается только из горутины писателя.
+func (f *FileStorage) appendRecords(records []ShortURLJSON) error {
+	// 1. Файл открыт писателем на всё время работы хранилища
+	file := f.file
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("file stat error: %w", err)
+	}
+
+	// 2. Кодируем весь пакет в буфер
+	lastUUID := f.lastUUID
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, record := range records {
+		lastUUID++
+		record.UUID = lastUUID
+		if err := encoder.Encode(sealRecord(record)); err != nil {
+			return fmt.Errorf("failed to encode JSON: %v", err)
+		}
 	}
 
-	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(record); err != nil {
-		return fmt.Errorf("failed to encode JSON: %v", err)
+	// 3. Записываем одним вызовом и синхронизируем
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Truncate(info.Size())
+		return fmt.Errorf("write error: %w", err)
 	}
-	// 4. Синхронизация записи
 	if err := file.Sync(); err != nil {
+		file.Truncate(info.Size())
 		return fmt.Errorf("sync error: %w", err)
 	}
+	f.lastUUID = lastUUID
+	f.fileSize = info.Size() + int64(buf.Len())
+
+	// 4. Журнал разросся - сжимаем его в фоне
+	if f.nextCompactAt > 0 && f.fileSize >= f.nextCompactAt {
+		go f.compactInBackground()
+	}
 	return nil
 }
 
-// Доп. метод для того, чтобы вытащить последнюю запись из файла и найти последний UUID
-func (f *FileStorage) loadFromFile() {
-	file, err := os.Open(f.filePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return
-		}
-		fmt.Printf("error opening file: %v\n", err)
+// applyRecord применяет запись журнала к памяти: сохраняет URL, помечает его удалённым,
+// обновляет счётчик переходов или дополняет историю правок.
+//
+// Запись с флагом удаления и оригинальным URL (так пишет снимок после сжатия) восстанавливает удалённый URL целиком.
+// Удалённые записи старых версий, у которых нет момента удаления, считаются удалёнными при загрузке,
+// поэтому получают полный срок хранения в корзине.
+func (f *FileStorage) applyRecord(record ShortURLJSON) {
+	if record.ChangedAt != nil {
+		f.memory.revisions[record.ShortURL] = append(f.memory.revisions[record.ShortURL], record.revision())
 		return
 	}
-	defer file.Close()
-
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return
+	if record.IsDeleted && record.DeletedAt == nil {
+		now := time.Now().UTC()
+		record.DeletedAt = &now
 	}
-	if fileInfo.Size() == 0 {
+	if record.IsDeleted && record.OriginalURL == "" {
+		if data, exists := f.memory.data[record.ShortURL]; exists && data.UserID == record.UserID {
+			data.Deleted, data.DeletedAt = true, *record.DeletedAt
+			f.memory.put(record.ShortURL, data)
+		}
 		return
 	}
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if len(line) == 0 {
-			continue // Пропускаем пустые строки
-		}
-		var record ShortURLJSON
-		if err := json.Unmarshal(line, &record); err != nil {
-			fmt.Printf("error parsing JSON: %v\n", err)
-			continue
-		}
-		f.memory.data[record.ShortURL] = URLData{
-			OriginalURL: record.OriginalURL,
-			UserID:      record.UserID,
-		}
-		if record.UUID > f.lastUUID {
-			f.lastUUID = record.UUID
+	if record.OriginalURL == "" {
+		if data, exists := f.memory.data[record.ShortURL]; exists && data.UserID == record.UserID && record.Clicks > data.Clicks {
+			data.Clicks = record.Clicks
+			f.memory.data[record.ShortURL] = data
 		}
+		return
 	}
+	f.memory.put(record.ShortURL, record.urlData())
 }
 
 // FileStorage.Ping используется для проверки соединения с БД.
@@ -169,17 +428,46 @@ func (f *FileStorage) Ping(ctx context.Context) error {
 
 // SaveInBatch позволяет сократить и сохранить в базу сразу несколько URL.
 //
-// Возвращает срез сокращенных URL
+// Все записи пакета дописываются в файл атомарно. Возвращает срез сокращенных URL
 func (f *FileStorage) SaveInBatch(ctx context.Context, urls []string, userID string) ([]string, error) {
 	// Проверяем, не отменен ли контекст
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
+	if f.readOnly() {
+		return nil, ErrReadOnly
+	}
+
+	f.saveMutex.Lock()
+	keys, err := f.memory.SaveInBatch(ctx, urls, userID)
+	if err != nil || f.filePath == "" {
+		f.saveMutex.Unlock()
+		return keys, err
+	}
+
+	records := make([]ShortURLJSON, len(keys))
+	f.memory.mu.RLock()
+	for i, key := range keys {
+		records[i] = newRecord(key, f.memory.data[key])
+	}
+	f.memory.mu.RUnlock()
+	if len(records) > 0 {
+		records[len(records)-1].KeyPosition = keyPosition(f.opts.keyGenerator)
+	}
+	done, err := f.enqueue(&writeRequest{
+		records:  records,
+		rollback: func() { f.memory.remove(keys) },
+	})
+	if err != nil {
+		f.memory.remove(keys)
+	}
+	f.saveMutex.Unlock()
 
-	// Заглушка: просто возвращаем уникальные ключи
-	keys := make([]string, len(urls))
-	for i := range keys {
-		keys[i] = fmt.Sprintf("fake_key_%d", i) // Генерируем уникальный ключ
+	if err == nil {
+		err = <-done
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save batch to file: %w", err)
 	}
 
 	return keys, nil
@@ -196,15 +484,17 @@ func (f *FileStorage) GetByURL(ctx context.Context, originalURL string, userID s
 	f.memory.mu.RLock()
 	defer f.memory.mu.RUnlock()
 
-	for short, url := range f.memory.data {
-		if url.OriginalURL == originalURL {
-			if url.UserID != userID {
-				return "", nil
-			}
+	keys, exists := f.memory.byURL[originalURL]
+	if !exists {
+		return "", ErrNotFound
+	}
+	for short := range keys {
+		if f.memory.data[short].UserID == userID {
 			return short, nil
 		}
 	}
-	return "", ErrNotFound
+	// URL сокращён другим пользователем
+	return "", nil
 }
 
 // GetUserURLS выдает все пары (сокращенные URL и его оригинал), отправленные  когда-либо пользователем.
@@ -215,20 +505,111 @@ func (f *FileStorage) GetUserURLS(ctx context.Context, userID string) (map[strin
 	default:
 	}
 
-	result := make(map[string]string)
-	f.memory.mu.RLock()
-	defer f.memory.mu.RUnlock()
+	return f.memory.GetUserURLS(ctx, userID)
+}
+
+// GetUserURLsPage выдает страницу ссылок пользователя из памяти.
+func (f *FileStorage) GetUserURLsPage(ctx context.Context, q UserURLsQuery) (UserURLsPage, error) {
+	return f.memory.GetUserURLsPage(ctx, q)
+}
+
+// MarkAsDeleted помечает URL для удаления в фоновом выполнении.
+//
+// Для каждого удаляемого URL пользователя в файл дописывается запись-надгробие,
+// поэтому после перезапуска Get продолжает возвращать ErrDeleted.
+// Если надгробия записать не удалось, пометка удаления в памяти откатывается.
+func (f *FileStorage) MarkAsDeleted(ctx context.Context, urls []string, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if f.readOnly() {
+		return ErrReadOnly
+	}
 
-	for short, data := range f.memory.data {
-		if data.UserID == userID {
-			result[short] = data.OriginalURL
+	f.saveMutex.Lock()
+	owned := f.memory.ownedKeys(urls, userID)
+	if len(owned) == 0 {
+		f.saveMutex.Unlock()
+		return nil
+	}
+	// Память меняется первой: снимок при сжатии никогда не отстаёт от журнала
+	if err := f.memory.MarkAsDeleted(ctx, owned, userID); err != nil || f.filePath == "" {
+		f.saveMutex.Unlock()
+		return err
+	}
+
+	records := make([]ShortURLJSON, len(owned))
+	f.memory.mu.RLock()
+	for i, key := range owned {
+		deletedAt := f.memory.data[key].DeletedAt.UTC()
+		records[i] = ShortURLJSON{
+			ShortURL:  key,
+			UserID:    userID,
+			IsDeleted: true,
+			DeletedAt: &deletedAt,
 		}
 	}
+	f.memory.mu.RUnlock()
+	done, err := f.enqueue(&writeRequest{
+		records:  records,
+		rollback: func() { f.memory.undelete(owned) },
+	})
+	if err != nil {
+		f.memory.undelete(owned)
+	}
+	f.saveMutex.Unlock()
 
-	return result, nil
+	if err == nil {
+		err = <-done
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save tombstones to file: %w", err)
+	}
+	return nil
 }
 
-// MarkAsDeleted помечает URL для удаления в фоновом выполнении
-func (f *FileStorage) MarkAsDeleted(ctx context.Context, urls []string, userID string) error {
-	return nil
+// ExpireLinks помечает удалёнными не больше limit истёкших ссылок и дописывает для них надгробия.
+func (f *FileStorage) ExpireLinks(ctx context.Context, now time.Time, limit int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if f.readOnly() {
+		return 0, ErrReadOnly
+	}
+
+	f.saveMutex.Lock()
+	keys := f.memory.expire(now, limit)
+	if len(keys) == 0 || f.filePath == "" {
+		f.saveMutex.Unlock()
+		return len(keys), nil
+	}
+
+	records := make([]ShortURLJSON, len(keys))
+	deletedAt := now.UTC()
+	f.memory.mu.RLock()
+	for i, key := range keys {
+		records[i] = ShortURLJSON{
+			ShortURL:  key,
+			UserID:    f.memory.data[key].UserID,
+			IsDeleted: true,
+			DeletedAt: &deletedAt,
+		}
+	}
+	f.memory.mu.RUnlock()
+	done, err := f.enqueue(&writeRequest{
+		records:  records,
+		rollback: func() { f.memory.undelete(keys) },
+	})
+	if err != nil {
+		f.memory.undelete(keys)
+	}
+	f.saveMutex.Unlock()
+
+	if err == nil {
+		err = <-done
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to save tombstones to file: %w", err)
+	}
+	return len(keys), nil
 }
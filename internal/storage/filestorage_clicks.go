@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// clicksPath возвращает путь журнала переходов рядом с журналом ссылок.
+func (f *FileStorage) clicksPath() string {
+	return f.filePath + ".clicks"
+}
+
+// RecordClicks дописывает события переходов в журнал <файл>.clicks одной записью.
+//
+// Журнал переходов отделён от журнала ссылок и переписывается только при окончательном
+// удалении ссылок; дописывать в него может и ведомый: O_APPEND не даёт записям разных процессов перемешаться.
+// Без файла события учитываются только в памяти.
+func (f *FileStorage) RecordClicks(ctx context.Context, clicks []Click) error {
+	if f.filePath == "" {
+		return f.memory.RecordClicks(ctx, clicks)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(clicks) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, click := range clicks {
+		if err := enc.Encode(click); err != nil {
+			return fmt.Errorf("cannot encode click: %w", err)
+		}
+	}
+
+	f.clicksMutex.Lock()
+	defer f.clicksMutex.Unlock()
+
+	file, err := os.OpenFile(f.clicksPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open clicks file: %w", err)
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return fmt.Errorf("cannot write clicks: %w", err)
+	}
+	return file.Close()
+}
+
+// maxClickLine ограничивает строку журнала переходов. Referer и User-Agent обрезаются
+// при учёте перехода, поэтому длиннее бывают только строки, записанные до этого.
+const maxClickLine = 64 << 10
+
+// ClickStats считает статистику ссылки по журналу переходов.
+//
+// Агрегаты прочитанной части журнала держатся в памяти, и каждый вызов дочитывает только
+// записанное после прошлого. Повреждённые и слишком длинные строки пропускаются,
+// недописанная последняя строка дочитывается, когда её допишут.
+func (f *FileStorage) ClickStats(ctx context.Context, key string, topReferrers int) (ClickStats, error) {
+	if f.filePath == "" {
+		return f.memory.ClickStats(ctx, key, topReferrers)
+	}
+	if err := ctx.Err(); err != nil {
+		return ClickStats{}, err
+	}
+
+	f.clicksMutex.Lock()
+	defer f.clicksMutex.Unlock()
+
+	if err := f.readClicks(); err != nil {
+		return ClickStats{}, err
+	}
+	return f.clicks.stats(key, topReferrers), nil
+}
+
+// readClicks дочитывает журнал переходов в агрегаты с места прошлого чтения.
+// Подменённый или укороченный журнал читается заново. Вызывается при удерживаемом clicksMutex.
+func (f *FileStorage) readClicks() error {
+	file, err := os.Open(f.clicksPath())
+	if os.IsNotExist(err) {
+		f.clicks, f.clicksOffset, f.clicksInfo = newClickCounter(), 0, nil
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot open clicks file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("cannot stat clicks file: %w", err)
+	}
+	if f.clicksInfo == nil || !os.SameFile(f.clicksInfo, info) || info.Size() < f.clicksOffset {
+		f.clicks, f.clicksOffset = newClickCounter(), 0
+	}
+	f.clicksInfo = info
+	if info.Size() == f.clicksOffset {
+		return nil
+	}
+	if _, err := file.Seek(f.clicksOffset, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot seek clicks file: %w", err)
+	}
+
+	var clicks []Click
+	n, err := readClickLines(file, func(click Click) { clicks = append(clicks, click) })
+	f.clicks.add(clicks)
+	f.clicksOffset += n
+	if err != nil {
+		return fmt.Errorf("cannot read clicks file: %w", err)
+	}
+	return nil
+}
+
+// readClickLines разбирает события из завершённых строк r и возвращает число прочитанных байт
+// этих строк. Строки длиннее maxClickLine и строки, которые не разбираются, пропускаются.
+func readClickLines(r io.Reader, fn func(Click)) (int64, error) {
+	reader := bufio.NewReaderSize(r, maxClickLine)
+	var (
+		read      int64 // Байты завершённых строк
+		line      int64 // Уже прочитанные байты текущей строки
+		oversized bool
+	)
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line += int64(len(chunk))
+		switch {
+		case err == bufio.ErrBufferFull:
+			oversized = true
+			continue
+		case err == io.EOF:
+			return read, nil
+		case err != nil:
+			return read, err
+		}
+		read, line = read+line, 0
+
+		var click Click
+		if !oversized && json.Unmarshal(chunk, &click) == nil {
+			fn(click)
+		}
+		oversized = false
+	}
+}
+
+// dropClicks переписывает журнал переходов без событий ссылок keys: ключ окончательно удалённой
+// ссылки может достаться новой ссылке, и её владелец не должен видеть чужие переходы.
+//
+// Новый журнал пишется во временный файл и подменяет старый через rename. События, которые
+// ведомый допишет в старый файл во время переписывания, теряются.
+func (f *FileStorage) dropClicks(keys []string) error {
+	f.clicksMutex.Lock()
+	defer f.clicksMutex.Unlock()
+
+	file, err := os.Open(f.clicksPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot open clicks file: %w", err)
+	}
+	defer file.Close()
+
+	drop := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		drop[key] = true
+	}
+
+	dir := filepath.Dir(f.filePath)
+	tmp, err := os.CreateTemp(dir, filepath.Base(f.clicksPath())+".purge-*")
+	if err != nil {
+		return fmt.Errorf("create clicks file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	w := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(w)
+	_, err = readClickLines(file, func(click Click) {
+		if !drop[click.Key] {
+			// Ошибка записи сохраняется в w и возвращается Flush
+			encoder.Encode(click)
+		}
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write clicks file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, f.clicksPath()); err != nil {
+		return fmt.Errorf("replace clicks file: %w", err)
+	}
+	syncDir(dir)
+	f.clicks, f.clicksOffset, f.clicksInfo = newClickCounter(), 0, nil
+	return nil
+}
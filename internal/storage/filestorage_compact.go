@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Compact переписывает журнал FileStorage в снимок, содержащий по одной записи на каждый ключ
+// и записи его истории правок.
+//
+// Надгробия сливаются с исходными записями, UUID перенумеровываются с единицы.
+// Снимок пишется во временный файл рядом с журналом, синхронизируется на диск
+// и атомарно подменяет журнал через rename, поэтому сбой в любой момент
+// оставляет на диске либо старый журнал, либо новый снимок целиком.
+func (f *FileStorage) Compact(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if f.filePath == "" {
+		return nil
+	}
+	if f.readOnly() {
+		return ErrReadOnly
+	}
+
+	// saveMutex не даёт менять память, пока писатель дописывает очередь и снимает снимок
+	f.saveMutex.Lock()
+	defer f.saveMutex.Unlock()
+
+	done, err := f.enqueue(&writeRequest{job: f.compactLocked})
+	if err != nil {
+		return err
+	}
+	return <-done
+}
+
+// compactLocked выполняет сжатие. Вызывается писателем при удерживаемом saveMutex.
+func (f *FileStorage) compactLocked() error {
+	records := f.snapshotRecords()
+
+	dir := filepath.Dir(f.filePath)
+	tmp, err := os.CreateTemp(dir, filepath.Base(f.filePath)+".compact-*")
+	if err != nil {
+		return fmt.Errorf("create snapshot file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	// Если что-то пошло не так, временный файл удаляется, журнал остаётся нетронутым
+	defer os.Remove(tmpPath)
+
+	w := bufio.NewWriter(tmp)
+	// Очистка корзины убирает записи с позициями генератора - сохраняем её в заголовке
+	w.Write(encodeHeader(keyPosition(f.opts.keyGenerator)))
+	encoder := json.NewEncoder(w)
+	for _, record := range records {
+		if err := encoder.Encode(sealRecord(record)); err != nil {
+			tmp.Close()
+			return fmt.Errorf("encode snapshot record: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync snapshot: %w", err)
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("stat snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, f.filePath); err != nil {
+		return fmt.Errorf("replace log with snapshot: %w", err)
+	}
+	syncDir(dir)
+
+	// Открытый писателем дескриптор указывает на старый журнал - переоткрываем
+	file, err := os.OpenFile(f.filePath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("reopen log after compaction: %w", err)
+	}
+	f.file.Close()
+	f.file = file
+
+	f.lastUUID = len(records)
+	f.fileSize = info.Size()
+	// Не даём журналу сжиматься на каждой записи, если живых данных уже больше порога
+	if f.opts.compactThreshold > 0 {
+		f.nextCompactAt = max(f.opts.compactThreshold, 2*f.fileSize)
+	}
+	return nil
+}
+
+// snapshotRecords возвращает по одной записи на каждый ключ в порядке ключей.
+// Записи истории правок ключа идут перед его записью.
+func (f *FileStorage) snapshotRecords() []ShortURLJSON {
+	f.memory.mu.RLock()
+	defer f.memory.mu.RUnlock()
+
+	keys := make([]string, 0, len(f.memory.data))
+	for key := range f.memory.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	records := make([]ShortURLJSON, 0, len(keys))
+	for _, key := range keys {
+		data := f.memory.data[key]
+		for _, rev := range f.memory.revisions[key] {
+			records = append(records, newRevisionRecord(key, data.UserID, rev))
+		}
+		records = append(records, newRecord(key, data))
+	}
+	for i := range records {
+		records[i].UUID = i + 1
+	}
+	return records
+}
+
+// compactInBackground запускает сжатие по порогу размера, если оно ещё не идёт.
+func (f *FileStorage) compactInBackground() {
+	if !f.compactRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer f.compactRunning.Store(false)
+
+	if err := f.Compact(context.Background()); err != nil {
+		fmt.Printf("background compaction error: %v\n", err)
+	}
+}
+
+// syncDir синхронизирует каталог, чтобы rename пережил сбой питания.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	d.Sync()
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Ошибки совместного доступа к файлу хранилища.
+var (
+	// ErrLocked возвращается, если файл хранилища уже открыт на запись другим процессом.
+	ErrLocked = errors.New("storage file is locked by another process")
+
+	// ErrReadOnly возвращается при попытке изменить хранилище в режиме ведомого.
+	ErrReadOnly = errors.New("storage is read-only")
+)
+
+// readOnly сообщает, открыто ли хранилище ведомым только для чтения.
+func (f *FileStorage) readOnly() bool {
+	return f.opts.followInterval > 0
+}
+
+// startFollower загружает журнал без блокировки и запускает его отслеживание.
+//
+// Ведомый никогда не пишет в файл: не создаёт его, не чинит хвост и не сжимает,
+// этим занимается единственный процесс-владелец блокировки.
+func (f *FileStorage) startFollower() error {
+	if err := f.loadFromFile(); err != nil {
+		return err
+	}
+	f.stopFollow = make(chan struct{})
+	f.followDone = make(chan struct{})
+	go f.runFollower()
+	return nil
+}
+
+// runFollower раз в followInterval подхватывает новые записи журнала.
+func (f *FileStorage) runFollower() {
+	defer close(f.followDone)
+
+	ticker := time.NewTicker(f.opts.followInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stopFollow:
+			return
+		case <-ticker.C:
+			if err := f.tail(); err != nil {
+				fmt.Printf("follow storage file error: %v\n", err)
+			}
+		}
+	}
+}
+
+// tail дочитывает журнал с последней обработанной позиции.
+//
+// Если файл подменён (сжатие владельцем делает rename) или укорочен,
+// состояние перечитывается целиком. Незавершённая последняя строка
+// не обрабатывается, пока владелец её не допишет.
+func (f *FileStorage) tail() error {
+	file, err := os.Open(f.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if f.followInfo == nil || !os.SameFile(info, f.followInfo) || info.Size() < f.followOffset {
+		return f.reload()
+	}
+	if info.Size() == f.followOffset {
+		return nil
+	}
+	if _, err := file.Seek(f.followOffset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start := f.followOffset
+		f.followOffset += int64(len(line))
+
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) == 0 {
+			continue
+		}
+		if start == 0 {
+			if h, ok := parseHeader(trimmed); ok {
+				f.version = h.Version
+				continue
+			}
+		}
+		record, err := decodeRecord(trimmed, f.version)
+		if err != nil {
+			fmt.Printf("follow storage file: skipping damaged record at offset %d: %v\n", start, err)
+			continue
+		}
+
+		f.memory.mu.Lock()
+		f.applyRecord(record)
+		f.memory.mu.Unlock()
+		if record.UUID > f.lastUUID {
+			f.lastUUID = record.UUID
+		}
+	}
+}
+
+// reload перечитывает журнал целиком и атомарно подменяет данные в памяти.
+func (f *FileStorage) reload() error {
+	fresh := &FileStorage{
+		memory:   NewMemoryStorage(),
+		filePath: f.filePath,
+		opts:     f.opts,
+	}
+	if err := fresh.loadFromFile(); err != nil {
+		return err
+	}
+
+	f.memory.replace(fresh.memory)
+
+	f.lastUUID = fresh.lastUUID
+	f.version = fresh.version
+	f.followOffset = fresh.followOffset
+	f.followInfo = fresh.followInfo
+	return nil
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Формат журнала FileStorage.
+//
+// Версия 1 - исходный формат: JSON-записи по одной на строку без заголовка и контрольных сумм.
+// Версия 2 - первой строкой идёт заголовок {"format":"shortener-log","version":2},
+// а каждая запись несёт CRC32 своего содержимого в поле crc.
+// Позиция последовательного генератора ключей пишется в поле key_position записей новых ссылок
+// и заголовка снимка после сжатия; журналы без неё продолжают последовательность с числа ссылок.
+// Файлы версии 1 читаются как есть, новые записи в них дописываются уже с контрольной суммой,
+// а сжатие переписывает журнал в текущую версию.
+const (
+	logFormatName    = "shortener-log"
+	logFormatVersion = 2
+)
+
+// ErrCorrupted возвращается, если в середине журнала найдены повреждённые записи.
+var ErrCorrupted = errors.New("storage file is corrupted")
+
+// logHeader - заголовок журнала.
+type logHeader struct {
+	Format      string `json:"format"`
+	Version     int    `json:"version"`
+	KeyPosition uint64 `json:"key_position,omitempty"` // Позиция генератора ключей на момент сжатия
+}
+
+// encodeHeader возвращает строку заголовка текущей версии с позицией генератора ключей.
+func encodeHeader(keyPosition uint64) []byte {
+	data, _ := json.Marshal(logHeader{Format: logFormatName, Version: logFormatVersion, KeyPosition: keyPosition})
+	return append(data, '\n')
+}
+
+// parseHeader распознаёт строку заголовка.
+func parseHeader(line []byte) (logHeader, bool) {
+	var h logHeader
+	if err := json.Unmarshal(line, &h); err != nil || h.Format != logFormatName {
+		return logHeader{}, false
+	}
+	return h, true
+}
+
+// recordChecksum вычисляет CRC32 записи, закодированной без поля crc.
+func recordChecksum(record ShortURLJSON) string {
+	record.Checksum = ""
+	data, _ := json.Marshal(record)
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE(data))
+}
+
+// sealRecord проставляет записи контрольную сумму.
+func sealRecord(record ShortURLJSON) ShortURLJSON {
+	record.Checksum = recordChecksum(record)
+	return record
+}
+
+// decodeRecord разбирает и проверяет строку журнала заданной версии.
+func decodeRecord(line []byte, version int) (ShortURLJSON, error) {
+	var record ShortURLJSON
+	if err := json.Unmarshal(line, &record); err != nil {
+		return ShortURLJSON{}, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if record.ShortURL == "" {
+		return ShortURLJSON{}, errors.New("record without short_url")
+	}
+	switch {
+	case record.Checksum == "" && version >= 2:
+		return ShortURLJSON{}, errors.New("missing checksum")
+	case record.Checksum != "" && record.Checksum != recordChecksum(record):
+		return ShortURLJSON{}, errors.New("checksum mismatch")
+	}
+	return record, nil
+}
+
+// loadFromFile восстанавливает состояние из журнала.
+//
+// Повреждённые строки в конце файла считаются недописанным хвостом после сбоя:
+// файл обрезается до последней целой записи. Повреждения в середине файла
+// означают потерю данных: в строгом режиме загрузка завершается ошибкой ErrCorrupted,
+// иначе повреждённые строки пропускаются с предупреждением.
+// В режиме ведомого файл не изменяется.
+func (f *FileStorage) loadFromFile() error {
+	file, err := os.Open(f.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+
+	var (
+		version     = 1
+		offset      int64
+		lineNo      int
+		tailStart   int64    = -1 // Начало подряд идущих повреждённых строк
+		pending     []string      // Повреждённые строки, после которых ещё не было целой записи
+		corrupted   []string      // Повреждённые строки в середине файла
+		needNewline bool          // Последняя целая запись не завершена переводом строки
+	)
+
+	reader := bufio.NewReader(file)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("error reading file: %w", readErr)
+		}
+		if len(line) == 0 {
+			break
+		}
+		lineNo++
+		start := offset
+		offset += int64(len(line))
+		needNewline = readErr == io.EOF
+
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) == 0 {
+			continue // Пропускаем пустые строки
+		}
+		if lineNo == 1 {
+			if h, ok := parseHeader(trimmed); ok {
+				if h.Version > logFormatVersion {
+					return fmt.Errorf("unsupported storage format version %d", h.Version)
+				}
+				version = h.Version
+				f.keyPosition = max(f.keyPosition, h.KeyPosition)
+				continue
+			}
+		}
+
+		record, err := decodeRecord(trimmed, version)
+		if err != nil {
+			if tailStart < 0 {
+				tailStart = start
+			}
+			pending = append(pending, fmt.Sprintf("line %d: %v", lineNo, err))
+			continue
+		}
+
+		// Целая запись после повреждённых - значит, повреждение не в хвосте
+		corrupted = append(corrupted, pending...)
+		tailStart, pending = -1, nil
+
+		f.applyRecord(record)
+		if record.UUID > f.lastUUID {
+			f.lastUUID = record.UUID
+		}
+		f.keyPosition = max(f.keyPosition, record.KeyPosition)
+	}
+
+	if len(corrupted) > 0 {
+		err := fmt.Errorf("%w: %s: %d damaged record(s), first at %s",
+			ErrCorrupted, f.filePath, len(corrupted), corrupted[0])
+		if f.opts.strict {
+			return err
+		}
+		fmt.Printf("warning: %v; damaged records skipped\n", err)
+	}
+
+	// Ведомый файл не трогает: недописанный хвост дочитает отслеживание
+	if f.readOnly() {
+		f.version = version
+		f.followOffset = offset
+		if tailStart >= 0 {
+			f.followOffset = tailStart
+		}
+		f.followInfo, err = file.Stat()
+		return err
+	}
+
+	// Недописанный хвост после сбоя отрезаем, чтобы новые записи не склеились с мусором
+	if tailStart >= 0 {
+		fmt.Printf("repairing torn tail of %s: dropping %d byte(s) from %s\n",
+			f.filePath, offset-tailStart, pending[0])
+		if err := os.Truncate(f.filePath, tailStart); err != nil {
+			return fmt.Errorf("truncate torn tail: %w", err)
+		}
+		return nil
+	}
+	if needNewline {
+		return f.appendNewline()
+	}
+	return nil
+}
+
+// appendNewline завершает последнюю запись переводом строки.
+func (f *FileStorage) appendNewline() error {
+	file, err := os.OpenFile(f.filePath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("file open error: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write([]byte{'\n'}); err != nil {
+		return fmt.Errorf("write error: %w", err)
+	}
+	return file.Sync()
+}
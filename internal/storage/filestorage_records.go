@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// ScanRecords выдаёт записи всех пользователей, включая удалённые, с ключами больше after.
+func (f *FileStorage) ScanRecords(ctx context.Context, after string, limit int) ([]Record, error) {
+	return f.memory.ScanRecords(ctx, after, limit)
+}
+
+// LoadRecords сохраняет записи с их ключами в память и атомарно дописывает их в файл.
+func (f *FileStorage) LoadRecords(ctx context.Context, records []Record) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if f.readOnly() {
+		return ErrReadOnly
+	}
+
+	f.saveMutex.Lock()
+	f.memory.mu.Lock()
+	keys, err := f.memory.load(records)
+	journal := make([]ShortURLJSON, len(keys))
+	for i, key := range keys {
+		journal[i] = newRecord(key, f.memory.data[key])
+	}
+	f.memory.mu.Unlock()
+	if err != nil || f.filePath == "" || len(keys) == 0 {
+		f.saveMutex.Unlock()
+		return err
+	}
+
+	done, err := f.enqueue(&writeRequest{
+		records:  journal,
+		rollback: func() { f.memory.remove(keys) },
+	})
+	if err != nil {
+		f.memory.remove(keys)
+	}
+	f.saveMutex.Unlock()
+
+	if err == nil {
+		err = <-done
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save records to file: %w", err)
+	}
+	return nil
+}
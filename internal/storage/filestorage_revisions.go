@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// newRevisionRecord составляет запись журнала для прежней версии rev ссылки key.
+func newRevisionRecord(key string, userID string, rev Revision) ShortURLJSON {
+	changedAt := rev.ChangedAt.UTC()
+	record := ShortURLJSON{
+		ShortURL:     key,
+		OriginalURL:  rev.OriginalURL,
+		UserID:       userID,
+		RedirectType: rev.RedirectType,
+		ChangedAt:    &changedAt,
+	}
+	if !rev.ExpiresAt.IsZero() {
+		expiresAt := rev.ExpiresAt.UTC()
+		record.ExpiresAt = &expiresAt
+	}
+	return record
+}
+
+// revision возвращает прежнюю версию ссылки, сохранённую в записи.
+func (r ShortURLJSON) revision() Revision {
+	rev := Revision{
+		OriginalURL:  r.OriginalURL,
+		RedirectType: r.RedirectType,
+	}
+	if r.ExpiresAt != nil {
+		rev.ExpiresAt = *r.ExpiresAt
+	}
+	if r.ChangedAt != nil {
+		rev.ChangedAt = *r.ChangedAt
+	}
+	return rev
+}
+
+// Update правит ссылку владельца в памяти и дописывает в файл её прежнюю версию и новую запись.
+//
+// Обе записи попадают в журнал одним пакетом; если записать их не удалось, правка в памяти откатывается.
+func (f *FileStorage) Update(ctx context.Context, key string, userID string, upd LinkUpdate) (Link, error) {
+	if err := ctx.Err(); err != nil {
+		return Link{}, err
+	}
+	if f.readOnly() {
+		return Link{}, ErrReadOnly
+	}
+
+	f.saveMutex.Lock()
+	f.memory.mu.Lock()
+	link, old, err := f.memory.update(key, userID, upd, time.Now())
+	var records []ShortURLJSON
+	if err == nil {
+		revisions := f.memory.revisions[key]
+		records = []ShortURLJSON{
+			newRevisionRecord(key, userID, revisions[len(revisions)-1]),
+			newRecord(key, f.memory.data[key]),
+		}
+	}
+	f.memory.mu.Unlock()
+	if err != nil || f.filePath == "" {
+		f.saveMutex.Unlock()
+		return link, err
+	}
+
+	done, err := f.enqueue(&writeRequest{
+		records:  records,
+		rollback: func() { f.memory.revert(key, old) },
+	})
+	if err != nil {
+		f.memory.revert(key, old)
+	}
+	f.saveMutex.Unlock()
+
+	if err == nil {
+		err = <-done
+	}
+	if err != nil {
+		return Link{}, fmt.Errorf("failed to save update to file: %w", err)
+	}
+	return link, nil
+}
+
+// Revisions возвращает прежние версии ссылки пользователя.
+func (f *FileStorage) Revisions(ctx context.Context, key string, userID string) ([]Revision, error) {
+	return f.memory.Revisions(ctx, key, userID)
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// GetDeletedURLS выдает удалённые ссылки пользователя из корзины.
+func (f *FileStorage) GetDeletedURLS(ctx context.Context, userID string) ([]DeletedURL, error) {
+	return f.memory.GetDeletedURLS(ctx, userID)
+}
+
+// RestoreURLs восстанавливает удалённые ссылки пользователя и дописывает в файл их полные записи.
+//
+// Если записи сохранить не удалось, ссылки возвращаются в корзину.
+func (f *FileStorage) RestoreURLs(ctx context.Context, urls []string, userID string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if f.readOnly() {
+		return nil, ErrReadOnly
+	}
+
+	f.saveMutex.Lock()
+	restored, err := f.memory.restore(urls, userID, time.Now())
+	if err != nil {
+		f.saveMutex.Unlock()
+		return nil, err
+	}
+	keys := make([]string, len(restored))
+	for i, link := range restored {
+		keys[i] = link.Key
+	}
+	if len(restored) == 0 || f.filePath == "" {
+		f.saveMutex.Unlock()
+		return keys, nil
+	}
+
+	records := make([]ShortURLJSON, len(keys))
+	f.memory.mu.RLock()
+	for i, key := range keys {
+		records[i] = newRecord(key, f.memory.data[key])
+	}
+	f.memory.mu.RUnlock()
+	done, err := f.enqueue(&writeRequest{
+		records:  records,
+		rollback: func() { f.memory.redelete(restored) },
+	})
+	if err != nil {
+		f.memory.redelete(restored)
+	}
+	f.saveMutex.Unlock()
+
+	if err == nil {
+		err = <-done
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save restored URLs to file: %w", err)
+	}
+	return keys, nil
+}
+
+// PurgeDeleted окончательно удаляет не больше limit ссылок, удалённых раньше before,
+// и сразу сжимает журнал, чтобы их записи не вернулись после перезапуска. Затем из журнала
+// переходов убираются события этих ссылок.
+//
+// Если сжать журнал не удалось, ссылки возвращаются в память.
+func (f *FileStorage) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if f.readOnly() {
+		return 0, ErrReadOnly
+	}
+
+	f.saveMutex.Lock()
+	defer f.saveMutex.Unlock()
+
+	purged := f.memory.purge(before, limit)
+	if len(purged) == 0 || f.filePath == "" {
+		return len(purged), nil
+	}
+	done, err := f.enqueue(&writeRequest{job: f.compactLocked})
+	if err == nil {
+		err = <-done
+	}
+	if err != nil {
+		f.memory.unpurge(purged)
+		return 0, fmt.Errorf("failed to compact purged URLs: %w", err)
+	}
+
+	keys := make([]string, len(purged))
+	for i, link := range purged {
+		keys[i] = link.key
+	}
+	if err := f.dropClicks(keys); err != nil {
+		return len(purged), fmt.Errorf("failed to drop clicks of purged URLs: %w", err)
+	}
+	return len(purged), nil
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// defaultBatchSize - число записей, после которого пакет пишется не дожидаясь интервала.
+const defaultBatchSize = 256
+
+// ErrClosed возвращается при обращении к закрытому FileStorage.
+var ErrClosed = errors.New("storage is closed")
+
+// writeRequest - заявка писателю журнала.
+//
+// Обычная заявка содержит записи для дописывания; заявка с job выполняет служебную
+// операцию над файлом (сжатие) строго между пакетами.
+type writeRequest struct {
+	records  []ShortURLJSON
+	rollback func()       // Откат памяти при ошибке записи, вызывается писателем до подтверждения
+	job      func() error // Служебная операция вместо записи
+	done     chan error
+}
+
+// startWriter открывает журнал на дозапись и запускает горутину группового коммита.
+func (f *FileStorage) startWriter() error {
+	file, err := os.OpenFile(f.filePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("file open error: %w", err)
+	}
+	f.file = file
+	f.writeCh = make(chan *writeRequest, f.opts.batchSize)
+	f.writerDone = make(chan struct{})
+	go f.runWriter()
+	return nil
+}
+
+// enqueue ставит заявку в очередь писателя и возвращает канал подтверждения.
+//
+// Вызывающий должен удерживать saveMutex: порядок заявок в очереди совпадает
+// с порядком изменений памяти, поэтому журнал воспроизводит их в том же порядке.
+// Ждать подтверждения следует уже после освобождения saveMutex, иначе параллельные
+// записи не смогут попасть в один пакет.
+func (f *FileStorage) enqueue(req *writeRequest) (<-chan error, error) {
+	if f.closed {
+		return nil, ErrClosed
+	}
+	req.done = make(chan error, 1)
+	f.writeCh <- req
+	return req.done, nil
+}
+
+// runWriter собирает заявки в пакеты и пишет каждый пакет одним вызовом write+fsync.
+//
+// Пакет закрывается, когда набрано batchSize записей, истёк flushInterval
+// или очередь опустела (при нулевом интервале). Все заявки пакета подтверждаются
+// только после того, как пакет стал устойчивым на диске.
+func (f *FileStorage) runWriter() {
+	defer close(f.writerDone)
+
+	var next *writeRequest
+	for {
+		req := next
+		next = nil
+		if req == nil {
+			var ok bool
+			if req, ok = <-f.writeCh; !ok {
+				return
+			}
+		}
+		if req.job != nil {
+			req.done <- req.job()
+			continue
+		}
+
+		batch := []*writeRequest{req}
+		batch, next = f.collect(batch, len(req.records))
+		f.flush(batch)
+	}
+}
+
+// collect добирает в пакет заявки из очереди.
+//
+// Возвращает пакет и служебную заявку, на которой сбор был прерван, если такая встретилась.
+func (f *FileStorage) collect(batch []*writeRequest, n int) ([]*writeRequest, *writeRequest) {
+	var timeout <-chan time.Time
+	if f.opts.flushInterval > 0 {
+		timer := time.NewTimer(f.opts.flushInterval)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for n < f.opts.batchSize {
+		var (
+			req *writeRequest
+			ok  bool
+		)
+		if timeout == nil {
+			select {
+			case req, ok = <-f.writeCh:
+			default:
+				return batch, nil
+			}
+		} else {
+			select {
+			case req, ok = <-f.writeCh:
+			case <-timeout:
+				return batch, nil
+			}
+		}
+		if !ok {
+			return batch, nil
+		}
+		if req.job != nil {
+			return batch, req
+		}
+		batch = append(batch, req)
+		n += len(req.records)
+	}
+	return batch, nil
+}
+
+// flush пишет пакет и подтверждает заявки. При ошибке откатывает память каждой заявки.
+func (f *FileStorage) flush(batch []*writeRequest) {
+	var records []ShortURLJSON
+	for _, req := range batch {
+		records = append(records, req.records...)
+	}
+
+	err := f.appendRecords(records)
+	for _, req := range batch {
+		if err != nil && req.rollback != nil {
+			req.rollback()
+		}
+		req.done <- err
+	}
+}
+
+// Close дожидается записи всех принятых заявок, закрывает файл журнала и снимает блокировку.
+func (f *FileStorage) Close() error {
+	if f.filePath == "" {
+		return nil
+	}
+	if f.readOnly() {
+		return f.closeFollower()
+	}
+
+	f.saveMutex.Lock()
+	if f.closed {
+		f.saveMutex.Unlock()
+		return nil
+	}
+	f.closed = true
+	close(f.writeCh)
+	f.saveMutex.Unlock()
+
+	<-f.writerDone
+	err := f.file.Close()
+	// Блокировка снимается последней, когда все записи уже на диске
+	f.lock.Close()
+	return err
+}
+
+// closeFollower останавливает отслеживание журнала.
+func (f *FileStorage) closeFollower() error {
+	f.saveMutex.Lock()
+	defer f.saveMutex.Unlock()
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	close(f.stopFollow)
+	<-f.followDone
+	return nil
+}
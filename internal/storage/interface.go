@@ -3,6 +3,9 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 )
 
 // Типизированные ошибки, используемые при работе с хранилищем URL.
@@ -15,9 +18,121 @@ var (
 
 	// ErrDeleted означает, что URL был помечен как удалённый.
 	ErrDeleted = errors.New("url deleted")
+
+	// ErrKeyTaken возникает, если заданный клиентом ключ уже занят другой ссылкой.
+	ErrKeyTaken = errors.New("short key already taken")
+
+	// ErrExpired означает, что срок действия ссылки истёк.
+	ErrExpired = errors.New("url expired")
+
+	// ErrClickLimitReached означает, что ссылка уже открыта разрешённое число раз.
+	ErrClickLimitReached = errors.New("url click limit reached")
+
+	// ErrPasswordRequired означает, что ссылка защищена паролем и открыть её можно только через GetLink.
+	ErrPasswordRequired = errors.New("url is password protected")
+
+	// ErrWrongPassword означает, что пароль защищённой ссылки не подошёл.
+	ErrWrongPassword = errors.New("wrong password")
 )
 
+// Link - сохраняемая ссылка вместе с необязательными атрибутами.
+type Link struct {
+	Key         string // Ключ, выбранный клиентом (алиас). Пустой - ключ генерируется
+	OriginalURL string
+	UserID      string
+	ExpiresAt   time.Time // Момент, после которого ссылка перестаёт работать. Нулевой - бессрочная
+	MaxClicks   int64     // Сколько раз ссылку можно открыть. Нулевой - без ограничения
+
+	// PasswordHash - хеш пароля защищённой ссылки. Хранилище его не вычисляет и не проверяет
+	// само, а только передаёт в функцию проверки GetLink. Пустой - ссылка открыта всем.
+	PasswordHash string
+
+	RedirectType int   // HTTP-статус редиректа: 301, 302, 307 или 308. Нулевой - по умолчанию сервера
+	CacheMaxAge  int64 // Сколько секунд клиенты могут кешировать редирект. 0 - по умолчанию сервера, -1 - не кешировать
+}
+
+// LinkUpdate - правка ссылки владельцем. Поля со значением nil остаются прежними.
+type LinkUpdate struct {
+	OriginalURL  *string
+	RedirectType *int       // Нулевой статус возвращает ссылке статус по умолчанию сервера
+	ExpiresAt    *time.Time // Нулевое время делает ссылку бессрочной
+}
+
+// apply возвращает данные ссылки data после правки.
+func (u LinkUpdate) apply(data URLData) URLData {
+	if u.OriginalURL != nil {
+		data.OriginalURL = *u.OriginalURL
+	}
+	if u.RedirectType != nil {
+		data.RedirectType = *u.RedirectType
+	}
+	if u.ExpiresAt != nil {
+		data.ExpiresAt = *u.ExpiresAt
+	}
+	return data
+}
+
+// Revision - прежняя версия ссылки, заменённая правкой в момент ChangedAt.
+type Revision struct {
+	OriginalURL  string
+	RedirectType int
+	ExpiresAt    time.Time
+	ChangedAt    time.Time
+}
+
+// revisionOf возвращает версию ссылки с данными data, заменённую в момент changedAt.
+func revisionOf(data URLData, changedAt time.Time) Revision {
+	return Revision{
+		OriginalURL:  data.OriginalURL,
+		RedirectType: data.RedirectType,
+		ExpiresAt:    data.ExpiresAt,
+		ChangedAt:    changedAt,
+	}
+}
+
+// DeletedURL - удалённая ссылка в корзине пользователя.
+type DeletedURL struct {
+	Key         string
+	OriginalURL string
+	DeletedAt   time.Time
+}
+
+// VerifyFunc проверяет пароль защищённой ссылки по его хешу.
+type VerifyFunc func(passwordHash string) bool
+
+// checkPassword проверяет пароль ссылки с хешем passwordHash функцией verify.
+func checkPassword(passwordHash string, verify VerifyFunc) error {
+	switch {
+	case passwordHash == "":
+		return nil
+	case verify == nil:
+		return ErrPasswordRequired
+	case !verify(passwordHash):
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// exhausted сообщает, что ссылка с лимитом maxClicks уже открыта clicks раз.
+func exhausted(maxClicks, clicks int64) bool {
+	return maxClicks > 0 && clicks >= maxClicks
+}
+
+// expired сообщает, истёк ли к моменту now срок ссылки со сроком expiresAt.
+func expired(expiresAt time.Time, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// errRestoreExpired возвращает ошибку восстановления ссылок keys, срок которых истёк.
+func errRestoreExpired(keys []string) error {
+	return fmt.Errorf("%w and cannot be restored: %s", ErrExpired, strings.Join(keys, ", "))
+}
+
 // BasicStorage определяет базовые операции сохранения и получения URL.
+//
+// Get - путь редиректа: у ссылки с лимитом переходов он атомарно расходует один переход,
+// а исчерпав лимит, возвращает ErrClickLimitReached. Для защищённой паролем ссылки
+// возвращает ErrPasswordRequired.
 type BasicStorage interface {
 	Save(ctx context.Context, url string, userID string) (string, error)
 	Get(ctx context.Context, key string) (string, error)
@@ -29,21 +144,134 @@ type BatchStorage interface {
 	SaveInBatch(ctx context.Context, urls []string, userID string) ([]string, error)
 }
 
+// LinkSaver описывает сохранение ссылки с атрибутами: алиасом, сроком действия.
+//
+// Как и Save, при уже сокращённом URL возвращает существующий ключ и ErrAlreadyHasKey.
+// Если заданный ключ занят, возвращает ErrKeyTaken.
+type LinkSaver interface {
+	SaveLink(ctx context.Context, link Link) (string, error)
+}
+
+// LinkGetter описывает открытие ссылки вместе с её атрибутами.
+//
+// GetLink ведёт себя как Get, но возвращает ссылку целиком, а у защищённой ссылки сначала
+// вызывает verify с хешем пароля и при отказе возвращает ErrWrongPassword, не расходуя переход.
+// При nil verify защищённая ссылка не открывается и возвращается ErrPasswordRequired.
+type LinkGetter interface {
+	GetLink(ctx context.Context, key string, verify VerifyFunc) (Link, error)
+}
+
+// LinkUpdater описывает правку ссылки её владельцем с историей правок.
+//
+// Update меняет ссылку key пользователя userID, сохраняя её прежнюю версию в истории,
+// и возвращает ссылку после правки. Для чужого или неизвестного ключа возвращает ErrNotFound,
+// для удалённой ссылки - ErrDeleted. Если новый оригинальный URL уже сокращён,
+// возвращает ссылку с его ключом и ErrAlreadyHasKey.
+//
+// Revisions возвращает прежние версии ссылки пользователя от старых к новым.
+type LinkUpdater interface {
+	Update(ctx context.Context, key string, userID string, upd LinkUpdate) (Link, error)
+	Revisions(ctx context.Context, key string, userID string) ([]Revision, error)
+}
+
 // URLFinder определяет методы поиска URL по оригинальному адресу или по ID пользователя.
 type URLFinder interface {
 	GetByURL(ctx context.Context, url string, userID string) (string, error)
 	GetUserURLS(ctx context.Context, userID string) (map[string]string, error)
 }
 
+// URLPager описывает постраничную выдачу ссылок пользователя с фильтрами и сортировкой.
+//
+// Страница продолжается с позиции q.After; следующая страница запрашивается с курсором UserURLsPage.Next.
+type URLPager interface {
+	GetUserURLsPage(ctx context.Context, q UserURLsQuery) (UserURLsPage, error)
+}
+
 // URLDeleter описывает возможность для удаления URL из памяти.
 type URLDeleter interface {
 	MarkAsDeleted(ctx context.Context, urls []string, userID string) error
 }
 
+// URLRestorer описывает корзину пользователя: список удалённых ссылок и их восстановление.
+//
+// RestoreURLs снимает пометку удаления со ссылок пользователя из urls и возвращает ключи
+// восстановленных; чужие, неизвестные и неудалённые ключи пропускаются. Если срок
+// какой-либо из удалённых ссылок уже истёк, не восстанавливается ничего и возвращается
+// ошибка, обёртывающая ErrExpired: иначе чистильщик тут же снова удалил бы её.
+type URLRestorer interface {
+	GetDeletedURLS(ctx context.Context, userID string) ([]DeletedURL, error)
+	RestoreURLs(ctx context.Context, urls []string, userID string) ([]string, error)
+}
+
 // Storage объединяет все интерфейсы для работы с сокращёнными URL.
 type Storage interface {
 	BasicStorage
 	BatchStorage
+	LinkSaver
+	LinkGetter
+	LinkUpdater
 	URLFinder
+	URLPager
 	URLDeleter
+	URLRestorer
+}
+
+// KeyChecker описывает хранилища, умеющие проверить, занят ли ключ, не расходуя переходы.
+//
+// Ключ удалённой или истёкшей ссылки тоже считается занятым.
+type KeyChecker interface {
+	HasKey(ctx context.Context, key string) (bool, error)
+}
+
+// URLChecker описывает хранилища, умеющие одним вызовом найти уже сокращённые URL.
+//
+// KeysByURL возвращает для каждого уже сокращённого URL из urls один из его ключей. Учитываются
+// ссылки всех пользователей, в том числе удалённые: такой URL Save и SaveLink тоже отклоняют
+// с ErrAlreadyHasKey.
+type URLChecker interface {
+	KeysByURL(ctx context.Context, urls []string) (map[string]string, error)
+}
+
+// LinkPeeker описывает чтение ссылки без побочных эффектов: без расхода перехода и проверки пароля.
+//
+// PeekLink возвращает ссылку вместе с хешем пароля, даже если её срок истёк или лимит переходов
+// исчерпан. Для неизвестного ключа возвращает ErrNotFound, для удалённой ссылки - ErrDeleted.
+type LinkPeeker interface {
+	PeekLink(ctx context.Context, key string) (Link, error)
+}
+
+// Compactor описывает хранилища, журнал которых можно сжать до снимка живых записей.
+type Compactor interface {
+	Compact(ctx context.Context) error
+}
+
+// Expirer описывает хранилища, умеющие помечать удалёнными ссылки с истёкшим сроком.
+//
+// ExpireLinks обрабатывает не больше limit ссылок за вызов и возвращает их число.
+type Expirer interface {
+	ExpireLinks(ctx context.Context, now time.Time, limit int) (int, error)
+}
+
+// Purger описывает хранилища, умеющие окончательно удалять ссылки, удалённые раньше момента before.
+//
+// PurgeDeleted удаляет не больше limit ссылок за вызов вместе с их историей и возвращает их число.
+type Purger interface {
+	PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+// RecordScanner описывает хранилища, из которых можно выгрузить все записи для переноса.
+//
+// ScanRecords возвращает не больше limit записей всех пользователей, включая удалённые,
+// с ключами больше after по побайтовому возрастанию ключа. Нулевой limit - без ограничения.
+type RecordScanner interface {
+	ScanRecords(ctx context.Context, after string, limit int) ([]Record, error)
+}
+
+// RecordLoader описывает хранилища, в которые можно загрузить записи с готовыми ключами.
+//
+// LoadRecords сохраняет записи как есть. Запись, ключ которой уже сохранён с теми же данными,
+// пропускается, поэтому прерванную загрузку можно повторить. Если ключ занят другими данными,
+// возвращается ErrKeyTaken и не сохраняется ни одна запись вызова.
+type RecordLoader interface {
+	LoadRecords(ctx context.Context, records []Record) error
 }
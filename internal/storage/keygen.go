@@ -0,0 +1,329 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"sync/atomic"
+)
+
+// Параметры коротких ключей по умолчанию.
+const (
+	DefaultKeyLength   = 8
+	DefaultKeyAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	// maxKeyAttempts - сколько раз хранилище перегенерирует ключ при коллизии.
+	maxKeyAttempts = 16
+)
+
+// Стратегии генерации ключей для NewKeyGenerator.
+const (
+	KeyGeneratorRandom  = "random"
+	KeyGeneratorCounter = "counter"
+	KeyGeneratorFeistel = "feistel"
+)
+
+var (
+	// ErrKeyCollision возвращается, если за maxKeyAttempts попыток не удалось получить свободный ключ.
+	ErrKeyCollision = errors.New("could not generate a unique short key")
+
+	// ErrKeySpaceExhausted возвращается последовательными генераторами, когда все ключи заданной длины выданы.
+	ErrKeySpaceExhausted = errors.New("short key space exhausted")
+)
+
+// KeyGenerator выдаёт короткие ключи для новых ссылок.
+//
+// Реализации должны быть безопасны для параллельного использования.
+// Уникальность гарантирует хранилище: при коллизии оно запрашивает следующий ключ.
+type KeyGenerator interface {
+	Generate() (string, error)
+}
+
+// defaultKeyGenerator - генератор хранилищ без WithKeyGenerator: 8 случайных символов base62.
+var defaultKeyGenerator KeyGenerator = &RandomKeyGenerator{
+	format: keyFormat{length: DefaultKeyLength, alphabet: DefaultKeyAlphabet},
+	max:    big.NewInt(int64(len(DefaultKeyAlphabet))),
+}
+
+// keySeeker реализуют последовательные генераторы. Хранилище сохраняет позицию последовательности
+// вместе с ключами и после перезапуска продолжает с неё, чтобы не выдавать уже выданные ключи.
+//
+// Число записей для этого не годится: алиасы и импорт его увеличивают, а очистка корзины уменьшает.
+type keySeeker interface {
+	Seek(n uint64)
+	Position() uint64
+}
+
+// NewKeyGenerator создает генератор по имени стратегии: random, counter или feistel.
+//
+// Пустые length и alphabet заменяются значениями по умолчанию, secret используется только feistel.
+func NewKeyGenerator(kind string, length int, alphabet string, secret []byte) (KeyGenerator, error) {
+	switch kind {
+	case "", KeyGeneratorRandom:
+		return NewRandomKeyGenerator(length, alphabet)
+	case KeyGeneratorCounter:
+		return NewCounterKeyGenerator(length, alphabet)
+	case KeyGeneratorFeistel:
+		return NewFeistelKeyGenerator(length, alphabet, secret)
+	}
+	return nil, fmt.Errorf("unknown key generator %q", kind)
+}
+
+// uniqueKey запрашивает у генератора ключи, пока taken не сообщит, что ключ свободен.
+//
+// После maxKeyAttempts занятых ключей подряд возвращает ErrKeyCollision.
+func uniqueKey(gen KeyGenerator, taken func(key string) bool) (string, error) {
+	for i := 0; i < maxKeyAttempts; i++ {
+		key, err := gen.Generate()
+		if err != nil {
+			return "", err
+		}
+		if !taken(key) {
+			return key, nil
+		}
+	}
+	return "", ErrKeyCollision
+}
+
+// seekKeys сдвигает последовательный генератор на n уже выданных ключей.
+func seekKeys(gen KeyGenerator, n uint64) {
+	if s, ok := gen.(keySeeker); ok {
+		s.Seek(n)
+	}
+}
+
+// keyPosition возвращает номер следующего ключа последовательного генератора, 0 - у случайного.
+func keyPosition(gen KeyGenerator) uint64 {
+	if s, ok := gen.(keySeeker); ok {
+		return s.Position()
+	}
+	return 0
+}
+
+// keyFormat - длина и алфавит ключей.
+type keyFormat struct {
+	length   int
+	alphabet string
+}
+
+func newKeyFormat(length int, alphabet string) (keyFormat, error) {
+	if length == 0 {
+		length = DefaultKeyLength
+	}
+	if alphabet == "" {
+		alphabet = DefaultKeyAlphabet
+	}
+	if length < 0 {
+		return keyFormat{}, fmt.Errorf("invalid key length %d", length)
+	}
+	if len(alphabet) < 2 {
+		return keyFormat{}, errors.New("key alphabet must contain at least two characters")
+	}
+	seen := make(map[byte]bool, len(alphabet))
+	for i := 0; i < len(alphabet); i++ {
+		c := alphabet[i]
+		if c >= 0x80 || seen[c] {
+			return keyFormat{}, fmt.Errorf("key alphabet must consist of unique ASCII characters, got %q", alphabet)
+		}
+		seen[c] = true
+	}
+	return keyFormat{length: length, alphabet: alphabet}, nil
+}
+
+// space возвращает число ключей заданной длины или false, если оно не помещается в uint64.
+func (f keyFormat) space() (uint64, bool) {
+	n := uint64(1)
+	for i := 0; i < f.length; i++ {
+		hi, lo := bits.Mul64(n, uint64(len(f.alphabet)))
+		if hi != 0 {
+			return 0, false
+		}
+		n = lo
+	}
+	return n, true
+}
+
+// encode записывает n в системе счисления алфавита, дополняя слева до длины ключа.
+func (f keyFormat) encode(n uint64) string {
+	base := uint64(len(f.alphabet))
+	key := make([]byte, f.length)
+	for i := f.length - 1; i >= 0; i-- {
+		key[i] = f.alphabet[n%base]
+		n /= base
+	}
+	return string(key)
+}
+
+// RandomKeyGenerator выдаёт криптографически случайные ключи.
+type RandomKeyGenerator struct {
+	format keyFormat
+	max    *big.Int
+}
+
+// NewRandomKeyGenerator создает генератор случайных ключей длины length из символов alphabet.
+func NewRandomKeyGenerator(length int, alphabet string) (*RandomKeyGenerator, error) {
+	format, err := newKeyFormat(length, alphabet)
+	if err != nil {
+		return nil, err
+	}
+	return &RandomKeyGenerator{format: format, max: big.NewInt(int64(len(format.alphabet)))}, nil
+}
+
+// Generate возвращает случайный ключ. Символы выбираются равновероятно.
+func (g *RandomKeyGenerator) Generate() (string, error) {
+	key := make([]byte, g.format.length)
+	for i := range key {
+		n, err := rand.Int(rand.Reader, g.max)
+		if err != nil {
+			return "", fmt.Errorf("read random: %w", err)
+		}
+		key[i] = g.format.alphabet[n.Int64()]
+	}
+	return string(key), nil
+}
+
+// CounterKeyGenerator выдаёт ключи по порядку: значение счётчика в системе счисления алфавита.
+//
+// Ключи короткие и гарантированно различны в пределах процесса, но легко угадываются.
+type CounterKeyGenerator struct {
+	format keyFormat
+	space  uint64
+	next   atomic.Uint64
+}
+
+// NewCounterKeyGenerator создает генератор последовательных ключей.
+func NewCounterKeyGenerator(length int, alphabet string) (*CounterKeyGenerator, error) {
+	format, err := newKeyFormat(length, alphabet)
+	if err != nil {
+		return nil, err
+	}
+	space, ok := format.space()
+	if !ok {
+		space = math.MaxUint64
+	}
+	return &CounterKeyGenerator{format: format, space: space}, nil
+}
+
+// Generate возвращает следующий ключ последовательности.
+func (g *CounterKeyGenerator) Generate() (string, error) {
+	n := g.next.Add(1) - 1
+	if n >= g.space {
+		return "", ErrKeySpaceExhausted
+	}
+	return g.format.encode(n), nil
+}
+
+// Seek продолжает последовательность с n-го ключа, если она ещё не ушла дальше.
+func (g *CounterKeyGenerator) Seek(n uint64) {
+	for {
+		cur := g.next.Load()
+		if cur >= n || g.next.CompareAndSwap(cur, n) {
+			return
+		}
+	}
+}
+
+// Position возвращает номер следующего ключа последовательности.
+func (g *CounterKeyGenerator) Position() uint64 {
+	return g.next.Load()
+}
+
+// FeistelKeyGenerator выдаёт ключи, переставляя номера счётчика секретной сетью Фейстеля.
+//
+// Перестановка взаимно однозначна на всём пространстве ключей заданной длины,
+// поэтому ключи не повторяются, пока не исчерпано пространство, а без секрета
+// по одному ключу нельзя предсказать соседние.
+type FeistelKeyGenerator struct {
+	counter  *CounterKeyGenerator
+	space    uint64
+	halfBits uint
+	roundKey [feistelRounds]uint64
+}
+
+const feistelRounds = 4
+
+// NewFeistelKeyGenerator создает генератор неугадываемых ключей без коллизий.
+//
+// Пространство ключей (len(alphabet)^length) должно помещаться в 62 бита, а secret - быть непустым
+// и не меняться между запусками.
+func NewFeistelKeyGenerator(length int, alphabet string, secret []byte) (*FeistelKeyGenerator, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("feistel generator requires a secret")
+	}
+	counter, err := NewCounterKeyGenerator(length, alphabet)
+	if err != nil {
+		return nil, err
+	}
+	space, ok := counter.format.space()
+	if !ok || space > 1<<62 {
+		return nil, fmt.Errorf("key space %d^%d is too large for feistel generator", len(counter.format.alphabet), counter.format.length)
+	}
+
+	// Сеть работает над 2*halfBits битами - наименьшим чётным числом бит, покрывающим пространство
+	halfBits := uint(bits.Len64(space-1)+1) / 2
+	if halfBits == 0 {
+		halfBits = 1
+	}
+	g := &FeistelKeyGenerator{counter: counter, space: space, halfBits: halfBits}
+	for i := range g.roundKey {
+		sum := sha256.Sum256(append([]byte{byte(i)}, secret...))
+		g.roundKey[i] = binary.BigEndian.Uint64(sum[:8])
+	}
+	return g, nil
+}
+
+// Generate возвращает ключ для следующего номера последовательности.
+func (g *FeistelKeyGenerator) Generate() (string, error) {
+	n := g.counter.next.Add(1) - 1
+	if n >= g.space {
+		return "", ErrKeySpaceExhausted
+	}
+	return g.counter.format.encode(g.permute(n)), nil
+}
+
+// Seek продолжает последовательность с n-го номера.
+func (g *FeistelKeyGenerator) Seek(n uint64) {
+	g.counter.Seek(n)
+}
+
+// Position возвращает следующий номер последовательности.
+func (g *FeistelKeyGenerator) Position() uint64 {
+	return g.counter.Position()
+}
+
+// permute отображает n из [0, space) в [0, space) взаимно однозначно.
+//
+// Сеть Фейстеля переставляет все 2*halfBits-битные числа; результаты за пределами
+// пространства повторно прогоняются через сеть (cycle walking), пока не попадут в него.
+func (g *FeistelKeyGenerator) permute(n uint64) uint64 {
+	for {
+		n = g.round(n)
+		if n < g.space {
+			return n
+		}
+	}
+}
+
+func (g *FeistelKeyGenerator) round(n uint64) uint64 {
+	mask := uint64(1)<<g.halfBits - 1
+	left, right := n>>g.halfBits, n&mask
+	for _, k := range g.roundKey {
+		left, right = right, left^(mix(right^k)&mask)
+	}
+	return left<<g.halfBits | right
+}
+
+// mix - раундовая функция (финализатор splitmix64).
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
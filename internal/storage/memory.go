@@ -3,33 +3,212 @@
 package storage
 
 import (
+	"container/heap"
 	"context"
 	"errors"
-	"math/rand"
+	"fmt"
+	"slices"
 	"sync"
+	"time"
 )
 
 // MemoryStorage — in-memory хранилище сокращённых URL.
 // Использует мапу и мьютекс для потокобезопасного доступа.
+//
+// Вторичные индексы по оригинальному URL и по пользователю избавляют Save, GetByURL
+// и GetUserURLS от полного перебора data. Удалённые записи остаются в индексах:
+// повторное сокращение удалённого URL по-прежнему возвращает ErrAlreadyHasKey.
+// Индексы по сроку действия и по моменту удаления позволяют ExpireLinks и PurgeDeleted
+// перебирать только ссылки, которые пора обработать.
 type MemoryStorage struct {
-	data map[string]URLData
-	mu   sync.RWMutex //Для потокобезопасности
+	data       map[string]URLData
+	byURL      index        // Оригинальный URL -> ключи
+	byUser     index        // ID пользователя -> ключи
+	byExpiry   timeIndex    // Срок действия неудалённых ссылок
+	byDeletion timeIndex    // Момент удаления удалённых ссылок
+	mu         sync.RWMutex //Для потокобезопасности
+	keys       KeyGenerator // Генератор новых ключей
+	clicks     *clickCounter
+
+	revisions map[string][]Revision // Ключ -> прежние версии ссылки
+}
+
+// index - вторичный индекс: значение поля -> множество ключей с этим значением.
+type index map[string]map[string]struct{}
+
+func (i index) add(value, key string) {
+	keys, ok := i[value]
+	if !ok {
+		keys = make(map[string]struct{}, 1)
+		i[value] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+func (i index) del(value, key string) {
+	if keys, ok := i[value]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(i, value)
+		}
+	}
+}
+
+// timeEntry - запись индекса по моменту.
+type timeEntry struct {
+	at  time.Time
+	key string
+}
+
+// timeIndex - индекс ключей по моменту: двоичная куча с ближайшим моментом в корне.
+//
+// При изменении или удалении ссылки её запись не ищется в куче: устаревшие записи
+// отбрасываются при извлечении, а когда их становится больше живых, индекс перестраивается.
+type timeIndex []timeEntry
+
+func (h timeIndex) Len() int           { return len(h) }
+func (h timeIndex) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h timeIndex) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *timeIndex) Push(x any)        { *h = append(*h, x.(timeEntry)) }
+func (h *timeIndex) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// add добавляет ключ с моментом at.
+func (h *timeIndex) add(at time.Time, key string) {
+	heap.Push(h, timeEntry{at: at, key: key})
 }
 
-// URLData содержит информацию об оригинальном URL, ID пользователя и флаг удаления.
+// due извлекает из индекса ключи с моментом раньше before, пока live не подтвердит limit из них.
+// Записи, которые live не подтверждает, устарели и отбрасываются.
+func (h *timeIndex) due(before time.Time, limit int, live func(timeEntry) bool) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for len(*h) > 0 && len(keys) < limit && (*h)[0].at.Before(before) {
+		e := heap.Pop(h).(timeEntry)
+		if !seen[e.key] && live(e) {
+			seen[e.key] = true
+			keys = append(keys, e.key)
+		}
+	}
+	return keys
+}
+
+// URLData содержит информацию об оригинальном URL, ID пользователя, флаг удаления и срок действия.
 type URLData struct {
 	OriginalURL string
 	UserID      string
 	Deleted     bool
+	CreatedAt   time.Time // Момент создания, нулевой у записей старых версий файла
+	DeletedAt   time.Time // Момент удаления, учитывается только при Deleted
+	ExpiresAt   time.Time // Нулевой - бессрочная ссылка
+	MaxClicks   int64     // Нулевой - без ограничения переходов
+	Clicks      int64     // Сколько раз ссылка уже открыта, учитывается только при MaxClicks > 0
+
+	PasswordHash string // Пустой - ссылка без пароля
+	RedirectType int    // Нулевой - статус редиректа по умолчанию
+	CacheMaxAge  int64  // Нулевой - кеширование по умолчанию
+}
+
+// link возвращает данные как ссылку с ключом key.
+func (d URLData) link(key string) Link {
+	return Link{
+		Key:          key,
+		OriginalURL:  d.OriginalURL,
+		UserID:       d.UserID,
+		ExpiresAt:    d.ExpiresAt,
+		MaxClicks:    d.MaxClicks,
+		PasswordHash: d.PasswordHash,
+		RedirectType: d.RedirectType,
+		CacheMaxAge:  d.CacheMaxAge,
+	}
 }
 
 // NewMemoryStorage создает новое in-memory хранилище URL.
-func NewMemoryStorage() *MemoryStorage {
+func NewMemoryStorage(opts ...Option) *MemoryStorage {
+	o := newOptions(opts)
 	return &MemoryStorage{
-		data: make(map[string]URLData),
+		data:   make(map[string]URLData),
+		byURL:  make(index),
+		byUser: make(index),
+		keys:   o.keyGenerator,
+		clicks: newClickCounter(),
+
+		revisions: make(map[string][]Revision),
 	}
 }
 
+// newKey выдаёт ключ, которого ещё нет в хранилище. Вызывающий должен удерживать mu.
+func (s *MemoryStorage) newKey() (string, error) {
+	return uniqueKey(s.keys, func(key string) bool {
+		_, exists := s.data[key]
+		return exists
+	})
+}
+
+// put сохраняет запись под ключом и обновляет индексы. Вызывающий должен удерживать mu на запись.
+//
+// Все изменения пометки удаления и срока действия проходят через put, иначе индекс по сроку их не увидит.
+func (s *MemoryStorage) put(key string, data URLData) {
+	old, exists := s.data[key]
+	if exists {
+		s.byURL.del(old.OriginalURL, key)
+		s.byUser.del(old.UserID, key)
+	}
+	s.data[key] = data
+	s.byURL.add(data.OriginalURL, key)
+	s.byUser.add(data.UserID, key)
+
+	if !data.Deleted && !data.ExpiresAt.IsZero() && (!exists || old.Deleted || !old.ExpiresAt.Equal(data.ExpiresAt)) {
+		s.byExpiry.add(data.ExpiresAt, key)
+	}
+	if data.Deleted && (!exists || !old.Deleted || !old.DeletedAt.Equal(data.DeletedAt)) {
+		s.byDeletion.add(data.DeletedAt, key)
+	}
+	if len(s.byExpiry)+len(s.byDeletion) > 2*len(s.data)+timeIndexSlack {
+		s.reindex()
+	}
+}
+
+// timeIndexSlack - сколько устаревших записей индексов по моменту допускается сверх удвоенного числа ссылок.
+const timeIndexSlack = 1024
+
+// reindex перестраивает индексы по моменту, отбрасывая устаревшие записи. Вызывающий должен удерживать mu на запись.
+func (s *MemoryStorage) reindex() {
+	s.byExpiry, s.byDeletion = s.byExpiry[:0], s.byDeletion[:0]
+	for key, data := range s.data {
+		switch {
+		case data.Deleted:
+			s.byDeletion = append(s.byDeletion, timeEntry{at: data.DeletedAt, key: key})
+		case !data.ExpiresAt.IsZero():
+			s.byExpiry = append(s.byExpiry, timeEntry{at: data.ExpiresAt, key: key})
+		}
+	}
+	heap.Init(&s.byExpiry)
+	heap.Init(&s.byDeletion)
+}
+
+// drop удаляет запись и её следы в индексах. Вызывающий должен удерживать mu на запись.
+func (s *MemoryStorage) drop(key string) {
+	if old, exists := s.data[key]; exists {
+		s.byURL.del(old.OriginalURL, key)
+		s.byUser.del(old.UserID, key)
+		delete(s.data, key)
+		delete(s.revisions, key)
+	}
+}
+
+// keyByURL возвращает любой ключ с таким оригинальным URL. Вызывающий должен удерживать mu.
+func (s *MemoryStorage) keyByURL(url string) (string, bool) {
+	for key := range s.byURL[url] {
+		return key, true
+	}
+	return "", false
+}
+
 // Save сохраняет оригинальный URL и его сокращение в память.
 //
 // Если URL уже существует — возвращает уже существующий короткий ключ.
@@ -40,44 +219,229 @@ func (s *MemoryStorage) Save(ctx context.Context, url string, userID string) (st
 	default:
 	}
 
+	return s.SaveLink(ctx, Link{OriginalURL: url, UserID: userID})
+}
+
+// SaveLink сохраняет ссылку с атрибутами под заданным или сгенерированным ключом.
+func (s *MemoryStorage) SaveLink(ctx context.Context, link Link) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for short, original := range s.data {
-		if original.OriginalURL == url {
-			return short, ErrAlreadyHasKey // Возвращаем существующий ключ
-		}
+	if short, exists := s.keyByURL(link.OriginalURL); exists {
+		return short, ErrAlreadyHasKey // Возвращаем существующий ключ
 	}
-	key := generateShortCode()
-	s.data[key] = URLData{
-		OriginalURL: url,
-		UserID:      userID,
+	key := link.Key
+	if key == "" {
+		var err error
+		if key, err = s.newKey(); err != nil {
+			return "", err
+		}
+	} else if _, exists := s.data[key]; exists {
+		return "", ErrKeyTaken
 	}
+	s.put(key, URLData{
+		OriginalURL:  link.OriginalURL,
+		UserID:       link.UserID,
+		CreatedAt:    time.Now(),
+		ExpiresAt:    link.ExpiresAt,
+		MaxClicks:    link.MaxClicks,
+		PasswordHash: link.PasswordHash,
+		RedirectType: link.RedirectType,
+		CacheMaxAge:  link.CacheMaxAge,
+	})
 	return key, nil
-
 }
 
 // Get выдает полный URL по его сокращенному варианту.
 func (s *MemoryStorage) Get(ctx context.Context, key string) (string, error) {
+	link, err := s.GetLink(ctx, key, nil)
+	return link.OriginalURL, err
+}
+
+// GetLink выдает ссылку с атрибутами, проверяя пароль защищённой ссылки функцией verify.
+func (s *MemoryStorage) GetLink(ctx context.Context, key string, verify VerifyFunc) (Link, error) {
 	select {
 	case <-ctx.Done():
-		return "", ctx.Err()
+		return Link{}, ctx.Err()
 	default:
 	}
 
+	s.mu.RLock()
+	url, err := s.lookup(key)
+	s.mu.RUnlock()
+	if err != nil {
+		return Link{}, err
+	}
+	// Проверка пароля медленная, поэтому выполняется без блокировки
+	if err := checkPassword(url.PasswordHash, verify); err != nil {
+		return Link{}, err
+	}
+	if url.MaxClicks == 0 {
+		return url.link(key), nil
+	}
+	// Счётчик переходов ограниченной ссылки меняется под блокировкой на запись
+	if url, err = s.useClick(key); err != nil {
+		return Link{}, err
+	}
+	return url.link(key), nil
+}
+
+// PeekLink выдает ссылку с атрибутами, не расходуя переход и не проверяя пароль.
+func (s *MemoryStorage) PeekLink(ctx context.Context, key string) (Link, error) {
+	if err := ctx.Err(); err != nil {
+		return Link{}, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	url, exists := s.data[key]
+	switch {
+	case !exists:
+		return Link{}, ErrNotFound
+	case url.Deleted:
+		return Link{}, ErrDeleted
+	}
+	return url.link(key), nil
+}
 
+// lookup возвращает данные работающей ссылки. Вызывающий должен удерживать mu.
+func (s *MemoryStorage) lookup(key string) (URLData, error) {
 	url, exists := s.data[key]
+	switch {
+	case !exists:
+		return URLData{}, ErrNotFound
+	case url.Deleted:
+		return URLData{}, ErrDeleted
+	case expired(url.ExpiresAt, time.Now()):
+		return URLData{}, ErrExpired
+	case exhausted(url.MaxClicks, url.Clicks):
+		return URLData{}, ErrClickLimitReached
+	}
+	return url, nil
+}
+
+// useClick расходует один переход ссылки с лимитом и возвращает её данные.
+func (s *MemoryStorage) useClick(key string) (URLData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	url, err := s.lookup(key)
+	if err != nil {
+		return URLData{}, err
+	}
+	if url.MaxClicks > 0 {
+		url.Clicks++
+		s.data[key] = url
+	}
+	return url, nil
+}
+
+// unuseClick возвращает переход, израсходованный useClick, если его не удалось сохранить.
+func (s *MemoryStorage) unuseClick(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if url, exists := s.data[key]; exists && url.Clicks > 0 {
+		url.Clicks--
+		s.data[key] = url
+	}
+}
+
+// HasKey сообщает, занят ли ключ, не расходуя переходы.
+func (s *MemoryStorage) HasKey(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, exists := s.data[key]
+	return exists, nil
+}
+
+// KeysByURL возвращает ключи тех из urls, что уже сокращены, в том числе чужих и удалённых ссылок.
+func (s *MemoryStorage) KeysByURL(ctx context.Context, urls []string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make(map[string]string)
+	for _, url := range urls {
+		if key, exists := s.keyByURL(url); exists {
+			keys[url] = key
+		}
+	}
+	return keys, nil
+}
+
+// Update правит ссылку владельца и сохраняет её прежнюю версию в истории.
+func (s *MemoryStorage) Update(ctx context.Context, key string, userID string, upd LinkUpdate) (Link, error) {
+	if err := ctx.Err(); err != nil {
+		return Link{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link, _, err := s.update(key, userID, upd, time.Now())
+	return link, err
+}
+
+// update применяет правку и дописывает прежнюю версию ссылки в историю. Вызывающий должен удерживать mu на запись.
+//
+// Возвращает ссылку после правки и её прежние данные.
+func (s *MemoryStorage) update(key string, userID string, upd LinkUpdate, now time.Time) (Link, URLData, error) {
+	old, exists := s.data[key]
+	switch {
+	case !exists || old.UserID != userID:
+		return Link{}, URLData{}, ErrNotFound
+	case old.Deleted:
+		return Link{}, URLData{}, ErrDeleted
+	}
+	data := upd.apply(old)
+	if data.OriginalURL != old.OriginalURL {
+		if other, taken := s.keyByURL(data.OriginalURL); taken {
+			return Link{Key: other}, URLData{}, ErrAlreadyHasKey
+		}
+	}
+	s.put(key, data)
+	s.revisions[key] = append(s.revisions[key], revisionOf(old, now))
+	return data.link(key), old, nil
+}
+
+// revert отменяет последнюю правку ссылки, возвращая ей данные old. Используется для отката неудачной записи.
+func (s *MemoryStorage) revert(key string, old URLData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, exists := s.data[key]
 	if !exists {
-		return "", ErrNotFound
+		return
+	}
+	data.OriginalURL, data.RedirectType, data.ExpiresAt = old.OriginalURL, old.RedirectType, old.ExpiresAt
+	s.put(key, data)
+	if revisions := s.revisions[key]; len(revisions) > 0 {
+		s.revisions[key] = revisions[:len(revisions)-1]
 	}
+}
 
-	if url.Deleted {
-		return "", ErrDeleted
+// Revisions возвращает прежние версии ссылки пользователя.
+func (s *MemoryStorage) Revisions(ctx context.Context, key string, userID string) ([]Revision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	return url.OriginalURL, nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if data, exists := s.data[key]; !exists || data.UserID != userID {
+		return nil, ErrNotFound
+	}
+	return slices.Clone(s.revisions[key]), nil
 }
 
 // MemoryStorage.Ping используется для проверки соединения с БД.
@@ -85,14 +449,12 @@ func (s *MemoryStorage) Ping(ctx context.Context) error {
 	return ctx.Err()
 }
 
+// generateShortCode возвращает случайный ключ генератора по умолчанию.
+//
+// crypto/rand не возвращает ошибок чтения на поддерживаемых платформах, поэтому ошибка опускается.
 func generateShortCode() string {
-	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	code := make([]byte, 8)
-
-	for i := range code {
-		code[i] = chars[rand.Intn(len(chars))]
-	}
-	return string(code)
+	code, _ := defaultKeyGenerator.Generate()
+	return code
 }
 
 // SaveInBatch позволяет сократить и сохранить в базу сразу несколько URL.
@@ -103,14 +465,25 @@ func (s *MemoryStorage) SaveInBatch(ctx context.Context, urls []string, userID s
 		return nil, err
 	}
 
-	keys := make([]URLData, len(urls))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
 	result := make([]string, len(urls))
-	for i := range keys {
-		key := generateShortCode()
-		s.data[key] = URLData{
-			OriginalURL: urls[i], // Генерируем уникальный ключ.
-			UserID:      userID,
+	for i := range urls {
+		key, err := s.newKey() // Генерируем уникальный ключ.
+		if err != nil {
+			// Откатываем уже сохранённую часть пакета
+			for _, saved := range result[:i] {
+				s.drop(saved)
+			}
+			return nil, err
 		}
+		s.put(key, URLData{
+			OriginalURL: urls[i],
+			UserID:      userID,
+			CreatedAt:   now,
+		})
 		result[i] = key
 	}
 
@@ -127,11 +500,9 @@ func (s *MemoryStorage) GetByURL(ctx context.Context, OriginalURL string, userID
 
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	for shortURL, url := range s.data {
-		if url.OriginalURL == OriginalURL && url.UserID == userID {
-			if !url.Deleted {
-				return shortURL, nil
-			}
+	for shortURL := range s.byURL[OriginalURL] {
+		if url := s.data[shortURL]; url.UserID == userID && !url.Deleted {
+			return shortURL, nil
 		}
 	}
 	return "", nil
@@ -148,24 +519,326 @@ func (s *MemoryStorage) GetUserURLS(ctx context.Context, userID string) (map[str
 	defer s.mu.RUnlock()
 
 	AllURLS := map[string]string{}
-	for short, url := range s.data {
-		if url.UserID == userID && !url.Deleted {
+	for short := range s.byUser[userID] {
+		if url := s.data[short]; !url.Deleted {
 			AllURLS[short] = url.OriginalURL
 		}
 	}
 	return AllURLS, nil
 }
 
-// MarkAsDeleted помечает URL для удаления в фоновом выполнении
+// GetUserURLsPage выдает страницу ссылок пользователя, отбирая и сортируя их в памяти.
+func (s *MemoryStorage) GetUserURLsPage(ctx context.Context, q UserURLsQuery) (UserURLsPage, error) {
+	if err := ctx.Err(); err != nil {
+		return UserURLsPage{}, err
+	}
+
+	s.mu.RLock()
+	urls := make([]UserURL, 0, len(s.byUser[q.UserID]))
+	for key := range s.byUser[q.UserID] {
+		urls = append(urls, s.data[key].userURL(key))
+	}
+	s.mu.RUnlock()
+	return q.page(urls), nil
+}
+
+// MarkAsDeleted помечает URL для удаления в фоновом выполнении.
+//
+// Момент удаления уже удалённой ссылки не меняется: срок хранения в корзине отсчитывается от первого удаления.
 func (s *MemoryStorage) MarkAsDeleted(ctx context.Context, urls []string, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
 	for _, shortURL := range urls {
 		data, exists := s.data[shortURL]
 		if exists && data.UserID == userID {
-			data.Deleted = true
-			s.data[shortURL] = data
+			if !data.Deleted {
+				data.Deleted, data.DeletedAt = true, now
+				s.put(shortURL, data)
+			}
 		} else {
 			return errors.New("err not found")
 		}
 	}
 	return nil
 }
+
+// ExpireLinks помечает удалёнными не больше limit ссылок, срок которых истёк к моменту now.
+func (s *MemoryStorage) ExpireLinks(ctx context.Context, now time.Time, limit int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return len(s.expire(now, limit)), nil
+}
+
+// expire помечает удалёнными истёкшие ссылки и возвращает их ключи.
+//
+// Истёкшие ссылки берутся из начала индекса по сроку действия, поэтому живые ссылки не перебираются.
+func (s *MemoryStorage) expire(now time.Time, limit int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := s.byExpiry.due(now.Add(time.Nanosecond), limit, func(e timeEntry) bool {
+		data, exists := s.data[e.key]
+		return exists && !data.Deleted && data.ExpiresAt.Equal(e.at)
+	})
+	for _, key := range keys {
+		data := s.data[key]
+		data.Deleted, data.DeletedAt = true, now
+		s.put(key, data)
+	}
+	return keys
+}
+
+// ownedKeys возвращает ключи из urls, которые принадлежат пользователю и ещё не удалены.
+func (s *MemoryStorage) ownedKeys(urls []string, userID string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	owned := make([]string, 0, len(urls))
+	for _, shortURL := range urls {
+		if data, exists := s.data[shortURL]; exists && data.UserID == userID && !data.Deleted {
+			owned = append(owned, shortURL)
+		}
+	}
+	return owned
+}
+
+// remove удаляет ключи из памяти. Используется для отката неудачной записи.
+func (s *MemoryStorage) remove(keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		s.drop(key)
+	}
+}
+
+// undelete снимает пометку удаления с ключей. Используется для отката неудачной записи надгробий.
+func (s *MemoryStorage) undelete(keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		if data, exists := s.data[key]; exists {
+			data.Deleted, data.DeletedAt = false, time.Time{}
+			s.put(key, data)
+		}
+	}
+}
+
+// GetDeletedURLS выдает удалённые ссылки пользователя из корзины.
+func (s *MemoryStorage) GetDeletedURLS(ctx context.Context, userID string) ([]DeletedURL, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var deleted []DeletedURL
+	for key := range s.byUser[userID] {
+		if data := s.data[key]; data.Deleted {
+			deleted = append(deleted, DeletedURL{Key: key, OriginalURL: data.OriginalURL, DeletedAt: data.DeletedAt})
+		}
+	}
+	return deleted, nil
+}
+
+// RestoreURLs снимает пометку удаления с удалённых ссылок пользователя.
+func (s *MemoryStorage) RestoreURLs(ctx context.Context, urls []string, userID string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	restored, err := s.restore(urls, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(restored))
+	for i, link := range restored {
+		keys[i] = link.Key
+	}
+	return keys, nil
+}
+
+// restore восстанавливает удалённые ссылки пользователя из urls и возвращает их такими, какими они были в корзине.
+// Если срок какой-либо из них истёк к моменту now, не восстанавливает ничего.
+func (s *MemoryStorage) restore(urls []string, userID string, now time.Time) ([]DeletedURL, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expiredKeys []string
+	for _, key := range urls {
+		if data, exists := s.data[key]; exists && data.UserID == userID && data.Deleted && expired(data.ExpiresAt, now) {
+			expiredKeys = append(expiredKeys, key)
+		}
+	}
+	if len(expiredKeys) > 0 {
+		return nil, errRestoreExpired(expiredKeys)
+	}
+
+	var restored []DeletedURL
+	for _, key := range urls {
+		if data, exists := s.data[key]; exists && data.UserID == userID && data.Deleted {
+			restored = append(restored, DeletedURL{Key: key, OriginalURL: data.OriginalURL, DeletedAt: data.DeletedAt})
+			data.Deleted, data.DeletedAt = false, time.Time{}
+			s.put(key, data)
+		}
+	}
+	return restored, nil
+}
+
+// redelete возвращает в корзину ссылки, восстановленные restore. Используется для отката неудачной записи.
+func (s *MemoryStorage) redelete(restored []DeletedURL) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, link := range restored {
+		if data, exists := s.data[link.Key]; exists {
+			data.Deleted, data.DeletedAt = true, link.DeletedAt
+			s.put(link.Key, data)
+		}
+	}
+}
+
+// purgedLink - окончательно удалённая ссылка вместе с историей правок.
+type purgedLink struct {
+	key       string
+	data      URLData
+	revisions []Revision
+}
+
+// PurgeDeleted окончательно удаляет не больше limit ссылок, удалённых раньше before.
+func (s *MemoryStorage) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return len(s.purge(before, limit)), nil
+}
+
+// purge удаляет из памяти ссылки, удалённые раньше before, вместе с их переходами и возвращает их.
+//
+// Ссылки берутся из начала индекса по моменту удаления, поэтому остальные ссылки не перебираются.
+func (s *MemoryStorage) purge(before time.Time, limit int) []purgedLink {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := s.byDeletion.due(before, limit, func(e timeEntry) bool {
+		data, exists := s.data[e.key]
+		return exists && data.Deleted && data.DeletedAt.Equal(e.at)
+	})
+	purged := make([]purgedLink, len(keys))
+	for i, key := range keys {
+		purged[i] = purgedLink{key: key, data: s.data[key], revisions: s.revisions[key]}
+	}
+	for _, link := range purged {
+		s.drop(link.key)
+		s.clicks.forget(link.key)
+	}
+	return purged
+}
+
+// unpurge возвращает в память ссылки, удалённые purge. Используется для отката неудачного сжатия.
+func (s *MemoryStorage) unpurge(purged []purgedLink) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, link := range purged {
+		s.put(link.key, link.data)
+		if len(link.revisions) > 0 {
+			s.revisions[link.key] = link.revisions
+		}
+	}
+}
+
+// replace атомарно подменяет содержимое хранилища содержимым other.
+func (s *MemoryStorage) replace(other *MemoryStorage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data, s.byURL, s.byUser, s.revisions = other.data, other.byURL, other.byUser, other.revisions
+	s.byExpiry, s.byDeletion = other.byExpiry, other.byDeletion
+}
+
+// RecordClicks учитывает переходы в счётчиках в памяти.
+func (s *MemoryStorage) RecordClicks(ctx context.Context, clicks []Click) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.clicks.add(clicks)
+	return nil
+}
+
+// ClickStats возвращает статистику переходов по ключу.
+func (s *MemoryStorage) ClickStats(ctx context.Context, key string, topReferrers int) (ClickStats, error) {
+	if err := ctx.Err(); err != nil {
+		return ClickStats{}, err
+	}
+	return s.clicks.stats(key, topReferrers), nil
+}
+
+// ScanRecords выдаёт записи всех пользователей, включая удалённые, с ключами больше after.
+func (s *MemoryStorage) ScanRecords(ctx context.Context, after string, limit int) ([]Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0)
+	for key := range s.data {
+		if key > after {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	records := make([]Record, len(keys))
+	for i, key := range keys {
+		records[i] = Record{Key: key, URLData: s.data[key]}
+	}
+	return records, nil
+}
+
+// LoadRecords сохраняет записи с их ключами. Повторы оригинальных URL допускаются, как и в SaveInBatch.
+func (s *MemoryStorage) LoadRecords(ctx context.Context, records []Record) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.load(records)
+	return err
+}
+
+// load сохраняет записи, ключей которых ещё нет, и возвращает эти ключи.
+// Вызывающий должен удерживать mu на запись.
+func (s *MemoryStorage) load(records []Record) ([]string, error) {
+	// Сначала проверяем все записи, чтобы при конфликте не сохранить ни одной
+	fresh := make([]Record, 0, len(records))
+	seen := make(map[string]bool, len(records))
+	for _, r := range records {
+		if old, exists := s.data[r.Key]; exists {
+			if !r.Equal(Record{Key: r.Key, URLData: old}) {
+				return nil, fmt.Errorf("%w: %s", ErrKeyTaken, r.Key)
+			}
+			continue
+		}
+		if seen[r.Key] {
+			return nil, fmt.Errorf("%w: %s is repeated", ErrKeyTaken, r.Key)
+		}
+		seen[r.Key] = true
+		fresh = append(fresh, r)
+	}
+
+	keys := make([]string, len(fresh))
+	for i, r := range fresh {
+		s.put(r.Key, r.URLData)
+		keys[i] = r.Key
+	}
+	return keys, nil
+}
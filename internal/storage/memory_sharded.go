@@ -0,0 +1,755 @@
+package storage
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShardedMemoryStorage — in-memory хранилище, разбитое на независимые шарды.
+//
+// Ключи распределяются по шардам хешем; каждый шард хранит записи в sync.Map,
+// поэтому Get на пути редиректа не берёт блокировок вовсе. Индексы по оригинальному URL
+// и по пользователю тоже разбиты на шарды со своими мьютексами, так что параллельные
+// сокращения разных URL почти не мешают друг другу. Семантика совпадает с MemoryStorage.
+type ShardedMemoryStorage struct {
+	keys   []sync.Map   // Ключ -> *shardedEntry
+	byURL  []indexShard // Оригинальный URL -> ключи
+	byUser []indexShard // ID пользователя -> ключи
+	times  []timeShard  // Индексы по моменту, шарды совпадают с шардами ключей
+	gen    KeyGenerator // Генератор новых ключей
+	clicks *clickCounter
+}
+
+// shardedEntry - запись шардированного хранилища.
+//
+// Изменяемы только флаг удаления, счётчик переходов и правимая владельцем цель ссылки.
+// Цель подменяется целиком, поэтому путь редиректа читает её без блокировок.
+type shardedEntry struct {
+	userID      string
+	maxClicks   int64
+	password    string // Хеш пароля, пустой - ссылка без пароля
+	cacheMaxAge int64
+	createdAt   time.Time
+	target      atomic.Pointer[shardedTarget]
+	clicks      atomic.Int64
+	deleted     atomic.Bool
+	deletedAt   atomic.Int64 // Момент удаления в наносекундах Unix, учитывается только при deleted
+
+	mu        sync.Mutex // Сериализует правки ссылки
+	revisions []Revision // Прежние версии ссылки, защищено mu
+}
+
+// shardedTarget - правимая владельцем часть ссылки.
+type shardedTarget struct {
+	originalURL string
+	expiresAt   time.Time
+	redirect    int
+}
+
+// newShardedEntry создаёт запись шардированного хранилища для ссылки link.
+func newShardedEntry(link Link) *shardedEntry {
+	entry := &shardedEntry{
+		userID:      link.UserID,
+		maxClicks:   link.MaxClicks,
+		password:    link.PasswordHash,
+		cacheMaxAge: link.CacheMaxAge,
+		createdAt:   time.Now(),
+	}
+	entry.target.Store(&shardedTarget{originalURL: link.OriginalURL, expiresAt: link.ExpiresAt, redirect: link.RedirectType})
+	return entry
+}
+
+// link возвращает запись как ссылку с ключом key.
+func (e *shardedEntry) link(key string) Link {
+	target := e.target.Load()
+	return Link{
+		Key:          key,
+		OriginalURL:  target.originalURL,
+		UserID:       e.userID,
+		ExpiresAt:    target.expiresAt,
+		MaxClicks:    e.maxClicks,
+		PasswordHash: e.password,
+		RedirectType: target.redirect,
+		CacheMaxAge:  e.cacheMaxAge,
+	}
+}
+
+// useClick расходует один переход ссылки с лимитом. Возвращает false, если лимит исчерпан.
+func (e *shardedEntry) useClick() bool {
+	if e.maxClicks == 0 {
+		return true
+	}
+	for {
+		n := e.clicks.Load()
+		if exhausted(e.maxClicks, n) {
+			return false
+		}
+		if e.clicks.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// indexShard - шард вторичного индекса.
+type indexShard struct {
+	mu sync.RWMutex
+	m  map[string][]string
+}
+
+// timeShard - индексы по моменту одного шарда ключей, устроенные как в MemoryStorage.
+type timeShard struct {
+	mu         sync.Mutex
+	byExpiry   timeIndex // Срок действия неудалённых ссылок
+	byDeletion timeIndex // Момент удаления удалённых ссылок
+	limit      int       // Размер индексов, после которого они перестраиваются
+}
+
+// NewShardedMemoryStorage создает шардированное in-memory хранилище из shards шардов.
+//
+// При shards <= 0 число шардов выбирается по числу процессоров.
+func NewShardedMemoryStorage(shards int, opts ...Option) *ShardedMemoryStorage {
+	if shards <= 0 {
+		shards = 4 * runtime.GOMAXPROCS(0)
+	}
+	s := &ShardedMemoryStorage{
+		keys:   make([]sync.Map, shards),
+		byURL:  make([]indexShard, shards),
+		byUser: make([]indexShard, shards),
+		times:  make([]timeShard, shards),
+		gen:    newOptions(opts).keyGenerator,
+		clicks: newClickCounter(),
+	}
+	for i := 0; i < shards; i++ {
+		s.byURL[i].m = make(map[string][]string)
+		s.byUser[i].m = make(map[string][]string)
+		s.times[i].limit = timeIndexSlack
+	}
+	return s
+}
+
+// shard возвращает номер шарда для значения по хешу FNV-1a (без аллокаций на пути чтения).
+func (s *ShardedMemoryStorage) shard(value string) int {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	h := uint32(offset32)
+	for i := 0; i < len(value); i++ {
+		h ^= uint32(value[i])
+		h *= prime32
+	}
+	return int(h % uint32(len(s.keys)))
+}
+
+// lookup возвращает запись по ключу без блокировок.
+func (s *ShardedMemoryStorage) lookup(key string) (*shardedEntry, bool) {
+	v, ok := s.keys[s.shard(key)].Load(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(*shardedEntry), true
+}
+
+// insert сохраняет запись под новым уникальным ключом и добавляет её в индекс пользователя.
+//
+// Индекс URL обновляет вызывающий, удерживая мьютекс шарда этого URL.
+func (s *ShardedMemoryStorage) insert(entry *shardedEntry) (string, error) {
+	key, err := uniqueKey(s.gen, func(key string) bool {
+		_, loaded := s.keys[s.shard(key)].LoadOrStore(key, entry)
+		return loaded
+	})
+	if err != nil {
+		return "", err
+	}
+	s.addUserKey(entry.userID, key)
+	return key, nil
+}
+
+// addUserKey добавляет ключ в индекс пользователя.
+func (s *ShardedMemoryStorage) addUserKey(userID, key string) {
+	us := &s.byUser[s.shard(userID)]
+	us.mu.Lock()
+	us.m[userID] = append(us.m[userID], key)
+	us.mu.Unlock()
+}
+
+// addExpiry добавляет срок действия неудалённой ссылки в индекс её шарда.
+// Бессрочные ссылки в индекс не попадают.
+func (s *ShardedMemoryStorage) addExpiry(key string, at time.Time) {
+	if !at.IsZero() {
+		s.addTime(key, func(ts *timeShard) { ts.byExpiry.add(at, key) })
+	}
+}
+
+// addDeletion добавляет момент удаления ссылки в индекс её шарда.
+func (s *ShardedMemoryStorage) addDeletion(key string, at time.Time) {
+	s.addTime(key, func(ts *timeShard) { ts.byDeletion.add(at, key) })
+}
+
+// addTime дополняет индексы шарда ключа функцией add и перестраивает их,
+// если устаревших записей накопилось слишком много.
+func (s *ShardedMemoryStorage) addTime(key string, add func(*timeShard)) {
+	i := s.shard(key)
+	ts := &s.times[i]
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	add(ts)
+	if len(ts.byExpiry)+len(ts.byDeletion) > ts.limit {
+		s.reindex(i)
+	}
+}
+
+// reindex перестраивает индексы по моменту шарда i, отбрасывая устаревшие записи.
+// Вызывающий должен удерживать мьютекс индексов шарда.
+//
+// sync.Map не знает числа записей, поэтому следующая перестройка назначается на удвоенный
+// размер живых индексов: перебор шарда окупается записями, добавленными с прошлой перестройки.
+func (s *ShardedMemoryStorage) reindex(i int) {
+	ts := &s.times[i]
+	ts.byExpiry, ts.byDeletion = ts.byExpiry[:0], ts.byDeletion[:0]
+	s.keys[i].Range(func(k, v any) bool {
+		key, entry := k.(string), v.(*shardedEntry)
+		if entry.deleted.Load() {
+			ts.byDeletion = append(ts.byDeletion, timeEntry{at: time.Unix(0, entry.deletedAt.Load()), key: key})
+		} else if expiresAt := entry.target.Load().expiresAt; !expiresAt.IsZero() {
+			ts.byExpiry = append(ts.byExpiry, timeEntry{at: expiresAt, key: key})
+		}
+		return true
+	})
+	heap.Init(&ts.byExpiry)
+	heap.Init(&ts.byDeletion)
+	ts.limit = 2*(len(ts.byExpiry)+len(ts.byDeletion)) + timeIndexSlack
+}
+
+// Save сохраняет оригинальный URL и его сокращение в память.
+//
+// Если URL уже существует — возвращает уже существующий короткий ключ.
+func (s *ShardedMemoryStorage) Save(ctx context.Context, url string, userID string) (string, error) {
+	return s.SaveLink(ctx, Link{OriginalURL: url, UserID: userID})
+}
+
+// SaveLink сохраняет ссылку с атрибутами под заданным или сгенерированным ключом.
+func (s *ShardedMemoryStorage) SaveLink(ctx context.Context, link Link) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	// Блокировка шарда URL не даёт двум запросам сократить один URL дважды
+	us := &s.byURL[s.shard(link.OriginalURL)]
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if keys := us.m[link.OriginalURL]; len(keys) > 0 {
+		return keys[0], ErrAlreadyHasKey // Возвращаем существующий ключ
+	}
+	entry := newShardedEntry(link)
+	key := link.Key
+	if key == "" {
+		var err error
+		if key, err = s.insert(entry); err != nil {
+			return "", err
+		}
+	} else {
+		if _, loaded := s.keys[s.shard(key)].LoadOrStore(key, entry); loaded {
+			return "", ErrKeyTaken
+		}
+		s.addUserKey(link.UserID, key)
+	}
+	us.m[link.OriginalURL] = append(us.m[link.OriginalURL], key)
+	s.addExpiry(key, link.ExpiresAt)
+	return key, nil
+}
+
+// Get выдает полный URL по его сокращенному варианту.
+func (s *ShardedMemoryStorage) Get(ctx context.Context, key string) (string, error) {
+	link, err := s.GetLink(ctx, key, nil)
+	return link.OriginalURL, err
+}
+
+// GetLink выдает ссылку с атрибутами, проверяя пароль защищённой ссылки функцией verify.
+func (s *ShardedMemoryStorage) GetLink(ctx context.Context, key string, verify VerifyFunc) (Link, error) {
+	if err := ctx.Err(); err != nil {
+		return Link{}, err
+	}
+
+	entry, exists := s.lookup(key)
+	if !exists {
+		return Link{}, ErrNotFound
+	}
+	if entry.deleted.Load() {
+		return Link{}, ErrDeleted
+	}
+	link := entry.link(key)
+	if expired(link.ExpiresAt, time.Now()) {
+		return Link{}, ErrExpired
+	}
+	// Как и в MemoryStorage, исчерпанный лимит проверяется до пароля: пароль к такой ссылке
+	// нельзя подбирать, а ответ не зависит от того, подошёл ли он
+	if exhausted(entry.maxClicks, entry.clicks.Load()) {
+		return Link{}, ErrClickLimitReached
+	}
+	if err := checkPassword(entry.password, verify); err != nil {
+		return Link{}, err
+	}
+	if !entry.useClick() {
+		return Link{}, ErrClickLimitReached
+	}
+	return link, nil
+}
+
+// PeekLink выдает ссылку с атрибутами, не расходуя переход и не проверяя пароль.
+func (s *ShardedMemoryStorage) PeekLink(ctx context.Context, key string) (Link, error) {
+	if err := ctx.Err(); err != nil {
+		return Link{}, err
+	}
+
+	entry, exists := s.lookup(key)
+	switch {
+	case !exists:
+		return Link{}, ErrNotFound
+	case entry.deleted.Load():
+		return Link{}, ErrDeleted
+	}
+	return entry.link(key), nil
+}
+
+// Update правит ссылку владельца и сохраняет её прежнюю версию в истории.
+//
+// При смене оригинального URL блокируются шарды индекса обоих URL,
+// чтобы параллельный Save не сократил новый URL ещё раз.
+func (s *ShardedMemoryStorage) Update(ctx context.Context, key string, userID string, upd LinkUpdate) (Link, error) {
+	if err := ctx.Err(); err != nil {
+		return Link{}, err
+	}
+
+	entry, exists := s.lookup(key)
+	if !exists || entry.userID != userID {
+		return Link{}, ErrNotFound
+	}
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.deleted.Load() {
+		return Link{}, ErrDeleted
+	}
+
+	old := entry.target.Load()
+	target := *old
+	if upd.OriginalURL != nil {
+		target.originalURL = *upd.OriginalURL
+	}
+	if upd.RedirectType != nil {
+		target.redirect = *upd.RedirectType
+	}
+	if upd.ExpiresAt != nil {
+		target.expiresAt = *upd.ExpiresAt
+	}
+
+	if target.originalURL != old.originalURL {
+		from, to := s.shard(old.originalURL), s.shard(target.originalURL)
+		unlock := s.lockURLShards(from, to)
+		defer unlock()
+
+		if keys := s.byURL[to].m[target.originalURL]; len(keys) > 0 {
+			return Link{Key: keys[0]}, ErrAlreadyHasKey
+		}
+		if keys := without(s.byURL[from].m[old.originalURL], key); len(keys) == 0 {
+			delete(s.byURL[from].m, old.originalURL)
+		} else {
+			s.byURL[from].m[old.originalURL] = keys
+		}
+		s.byURL[to].m[target.originalURL] = append(s.byURL[to].m[target.originalURL], key)
+	}
+
+	entry.target.Store(&target)
+	if !target.expiresAt.Equal(old.expiresAt) {
+		s.addExpiry(key, target.expiresAt)
+	}
+	entry.revisions = append(entry.revisions, Revision{
+		OriginalURL:  old.originalURL,
+		RedirectType: old.redirect,
+		ExpiresAt:    old.expiresAt,
+		ChangedAt:    time.Now(),
+	})
+	return entry.link(key), nil
+}
+
+// lockURLShards блокирует на запись шарды индекса URL с номерами i и j в порядке номеров
+// и возвращает функцию снятия блокировок.
+func (s *ShardedMemoryStorage) lockURLShards(i, j int) (unlock func()) {
+	if i > j {
+		i, j = j, i
+	}
+	s.byURL[i].mu.Lock()
+	if i == j {
+		return s.byURL[i].mu.Unlock
+	}
+	s.byURL[j].mu.Lock()
+	return func() {
+		s.byURL[j].mu.Unlock()
+		s.byURL[i].mu.Unlock()
+	}
+}
+
+// Revisions возвращает прежние версии ссылки пользователя.
+func (s *ShardedMemoryStorage) Revisions(ctx context.Context, key string, userID string) ([]Revision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	entry, exists := s.lookup(key)
+	if !exists || entry.userID != userID {
+		return nil, ErrNotFound
+	}
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	return slices.Clone(entry.revisions), nil
+}
+
+// HasKey сообщает, занят ли ключ, не расходуя переходы.
+func (s *ShardedMemoryStorage) HasKey(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	_, exists := s.lookup(key)
+	return exists, nil
+}
+
+// KeysByURL возвращает ключи тех из urls, что уже сокращены, в том числе чужих и удалённых ссылок.
+func (s *ShardedMemoryStorage) KeysByURL(ctx context.Context, urls []string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]string)
+	for _, url := range urls {
+		us := &s.byURL[s.shard(url)]
+		us.mu.RLock()
+		if existing := us.m[url]; len(existing) > 0 {
+			keys[url] = existing[0]
+		}
+		us.mu.RUnlock()
+	}
+	return keys, nil
+}
+
+// Ping используется для проверки доступности хранилища.
+func (s *ShardedMemoryStorage) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// SaveInBatch позволяет сократить и сохранить сразу несколько URL.
+//
+// Как и MemoryStorage, не проверяет повторы: каждый URL пакета получает новый ключ.
+func (s *ShardedMemoryStorage) SaveInBatch(ctx context.Context, urls []string, userID string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]string, len(urls))
+	for i, url := range urls {
+		us := &s.byURL[s.shard(url)]
+		us.mu.Lock()
+		key, err := s.insert(newShardedEntry(Link{OriginalURL: url, UserID: userID}))
+		if err == nil {
+			us.m[url] = append(us.m[url], key)
+		}
+		us.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		result[i] = key
+	}
+	return result, nil
+}
+
+// GetByURL позволяет получить сокращенный URL пользователя по его оригиналу.
+func (s *ShardedMemoryStorage) GetByURL(ctx context.Context, originalURL string, userID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	us := &s.byURL[s.shard(originalURL)]
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	for _, key := range us.m[originalURL] {
+		if entry, ok := s.lookup(key); ok && entry.userID == userID && !entry.deleted.Load() {
+			return key, nil
+		}
+	}
+	return "", nil
+}
+
+// GetUserURLS выдает все неудалённые пары (сокращенный URL и его оригинал) пользователя.
+func (s *ShardedMemoryStorage) GetUserURLS(ctx context.Context, userID string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	us := &s.byUser[s.shard(userID)]
+	us.mu.RLock()
+	keys := us.m[userID]
+	us.mu.RUnlock()
+
+	// Срез ключей только дописывается или подменяется копией, поэтому его можно читать без блокировки
+	result := map[string]string{}
+	for _, key := range keys {
+		if entry, ok := s.lookup(key); ok && !entry.deleted.Load() {
+			result[key] = entry.target.Load().originalURL
+		}
+	}
+	return result, nil
+}
+
+// GetUserURLsPage выдает страницу ссылок пользователя, отбирая и сортируя их в памяти.
+func (s *ShardedMemoryStorage) GetUserURLsPage(ctx context.Context, q UserURLsQuery) (UserURLsPage, error) {
+	if err := ctx.Err(); err != nil {
+		return UserURLsPage{}, err
+	}
+
+	us := &s.byUser[s.shard(q.UserID)]
+	us.mu.RLock()
+	keys := us.m[q.UserID]
+	us.mu.RUnlock()
+
+	urls := make([]UserURL, 0, len(keys))
+	for _, key := range keys {
+		if entry, ok := s.lookup(key); ok {
+			link := entry.link(key)
+			urls = append(urls, UserURL{
+				Key:          key,
+				OriginalURL:  link.OriginalURL,
+				CreatedAt:    entry.createdAt,
+				Deleted:      entry.deleted.Load(),
+				ExpiresAt:    link.ExpiresAt,
+				MaxClicks:    link.MaxClicks,
+				Clicks:       entry.clicks.Load(),
+				RedirectType: link.RedirectType,
+				CacheMaxAge:  link.CacheMaxAge,
+				Protected:    link.PasswordHash != "",
+			})
+		}
+	}
+	return q.page(urls), nil
+}
+
+// MarkAsDeleted помечает URL пользователя удалёнными.
+//
+// Как и MemoryStorage, прерывается с ошибкой на первом чужом или неизвестном ключе.
+func (s *ShardedMemoryStorage) MarkAsDeleted(ctx context.Context, urls []string, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, shortURL := range urls {
+		entry, exists := s.lookup(shortURL)
+		if !exists || entry.userID != userID {
+			return errors.New("err not found")
+		}
+		if now := time.Now(); entry.markDeleted(now) {
+			s.addDeletion(shortURL, now)
+		}
+	}
+	return nil
+}
+
+// markDeleted помечает запись удалённой в момент now, если она ещё не удалена.
+func (e *shardedEntry) markDeleted(now time.Time) bool {
+	// Момент удаления записывается под мьютексом записи, чтобы восстановление не перемешалось с удалением
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.deleteLocked(now)
+}
+
+// expire помечает запись удалённой в момент now, если она ещё не удалена и её срок к этому моменту истёк.
+func (e *shardedEntry) expire(now time.Time) bool {
+	// Срок проверяется под мьютексом записи: параллельное продление не должно потеряться
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return expired(e.target.Load().expiresAt, now) && e.deleteLocked(now)
+}
+
+// deleteLocked помечает запись удалённой в момент now. Вызывающий должен удерживать mu.
+func (e *shardedEntry) deleteLocked(now time.Time) bool {
+	if !e.deleted.CompareAndSwap(false, true) {
+		return false
+	}
+	e.deletedAt.Store(now.UnixNano())
+	return true
+}
+
+// GetDeletedURLS выдает удалённые ссылки пользователя из корзины.
+func (s *ShardedMemoryStorage) GetDeletedURLS(ctx context.Context, userID string) ([]DeletedURL, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	us := &s.byUser[s.shard(userID)]
+	us.mu.RLock()
+	keys := us.m[userID]
+	us.mu.RUnlock()
+
+	var deleted []DeletedURL
+	for _, key := range keys {
+		if entry, ok := s.lookup(key); ok && entry.deleted.Load() {
+			deleted = append(deleted, DeletedURL{
+				Key:         key,
+				OriginalURL: entry.target.Load().originalURL,
+				DeletedAt:   time.Unix(0, entry.deletedAt.Load()),
+			})
+		}
+	}
+	return deleted, nil
+}
+
+// RestoreURLs снимает пометку удаления с удалённых ссылок пользователя.
+func (s *ShardedMemoryStorage) RestoreURLs(ctx context.Context, urls []string, userID string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var expiredKeys []string
+	for _, key := range urls {
+		if entry, exists := s.lookup(key); exists && entry.userID == userID && entry.deleted.Load() &&
+			expired(entry.target.Load().expiresAt, now) {
+			expiredKeys = append(expiredKeys, key)
+		}
+	}
+	if len(expiredKeys) > 0 {
+		return nil, errRestoreExpired(expiredKeys)
+	}
+
+	var restored []string
+	for _, key := range urls {
+		entry, exists := s.lookup(key)
+		if !exists || entry.userID != userID {
+			continue
+		}
+		entry.mu.Lock()
+		// Срок ссылки могли сократить после проверки - такую ссылку оставляем в корзине
+		if !expired(entry.target.Load().expiresAt, now) && entry.deleted.CompareAndSwap(true, false) {
+			entry.deletedAt.Store(0)
+			s.addExpiry(key, entry.target.Load().expiresAt)
+			restored = append(restored, key)
+		}
+		entry.mu.Unlock()
+	}
+	return restored, nil
+}
+
+// PurgeDeleted окончательно удаляет не больше limit ссылок, удалённых раньше before.
+//
+// Ссылки берутся из начала индексов шардов по моменту удаления, поэтому остальные ссылки
+// не перебираются. Ключ убирается из индексов копированием срезов: GetUserURLS читает их без блокировки.
+func (s *ShardedMemoryStorage) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for i := 0; i < len(s.times) && purged < limit; i++ {
+		ts := &s.times[i]
+		ts.mu.Lock()
+		keys := ts.byDeletion.due(before, limit-purged, func(e timeEntry) bool {
+			entry, exists := s.lookup(e.key)
+			return exists && entry.deleted.Load() && entry.deletedAt.Load() == e.at.UnixNano()
+		})
+		ts.mu.Unlock()
+
+		// Ссылку могли восстановить после извлечения из индекса - purge проверяет её ещё раз
+		for _, key := range keys {
+			if entry, exists := s.lookup(key); exists && s.purge(key, entry, before.UnixNano()) {
+				s.clicks.forget(key)
+				purged++
+			}
+		}
+	}
+	return purged, nil
+}
+
+// purge удаляет запись, если она удалена раньше момента before (в наносекундах Unix).
+func (s *ShardedMemoryStorage) purge(key string, entry *shardedEntry, before int64) bool {
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if !entry.deleted.Load() || entry.deletedAt.Load() >= before {
+		return false
+	}
+
+	originalURL := entry.target.Load().originalURL
+	us := &s.byURL[s.shard(originalURL)]
+	us.mu.Lock()
+	s.keys[s.shard(key)].Delete(key)
+	us.m[originalURL] = without(us.m[originalURL], key)
+	if len(us.m[originalURL]) == 0 {
+		delete(us.m, originalURL)
+	}
+	us.mu.Unlock()
+
+	uu := &s.byUser[s.shard(entry.userID)]
+	uu.mu.Lock()
+	uu.m[entry.userID] = without(uu.m[entry.userID], key)
+	if len(uu.m[entry.userID]) == 0 {
+		delete(uu.m, entry.userID)
+	}
+	uu.mu.Unlock()
+	return true
+}
+
+// without возвращает копию keys без key, не меняя исходный срез.
+func without(keys []string, key string) []string {
+	return slices.DeleteFunc(slices.Clone(keys), func(k string) bool { return k == key })
+}
+
+// ExpireLinks помечает удалёнными не больше limit ссылок, срок которых истёк к моменту now.
+//
+// Истёкшие ссылки берутся из начала индексов шардов по сроку действия, поэтому живые ссылки не перебираются.
+func (s *ShardedMemoryStorage) ExpireLinks(ctx context.Context, now time.Time, limit int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	expiredCount := 0
+	for i := 0; i < len(s.times) && expiredCount < limit; i++ {
+		ts := &s.times[i]
+		ts.mu.Lock()
+		keys := ts.byExpiry.due(now.Add(time.Nanosecond), limit-expiredCount, func(e timeEntry) bool {
+			entry, exists := s.lookup(e.key)
+			return exists && !entry.deleted.Load() && entry.target.Load().expiresAt.Equal(e.at)
+		})
+		ts.mu.Unlock()
+
+		// Ссылку могли продлить или удалить после извлечения из индекса - expire проверяет её ещё раз
+		for _, key := range keys {
+			if entry, exists := s.lookup(key); exists && entry.expire(now) {
+				s.addDeletion(key, now)
+				expiredCount++
+			}
+		}
+	}
+	return expiredCount, nil
+}
+
+// RecordClicks учитывает переходы в счётчиках в памяти.
+func (s *ShardedMemoryStorage) RecordClicks(ctx context.Context, clicks []Click) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.clicks.add(clicks)
+	return nil
+}
+
+// ClickStats возвращает статистику переходов по ключу.
+func (s *ShardedMemoryStorage) ClickStats(ctx context.Context, key string, topReferrers int) (ClickStats, error) {
+	if err := ctx.Err(); err != nil {
+		return ClickStats{}, err
+	}
+	return s.clicks.stats(key, topReferrers), nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import "time"
+
+// options содержит необязательные параметры хранилищ.
+type options struct {
+	// compactThreshold - размер файла в байтах, после которого FileStorage сжимает журнал. 0 - выключено.
+	compactThreshold int64
+	// strict - FileStorage отказывается загружать журнал с повреждениями в середине файла.
+	strict bool
+	// flushInterval - сколько писатель FileStorage ждёт попутные записи перед fsync. 0 - не ждать.
+	flushInterval time.Duration
+	// batchSize - максимальное число записей в одном пакете FileStorage.
+	batchSize int
+	// followInterval - период опроса журнала ведомым FileStorage. 0 - обычный режим владельца.
+	followInterval time.Duration
+	// keyGenerator - генератор коротких ключей новых записей.
+	keyGenerator KeyGenerator
+}
+
+// Option настраивает хранилище при создании.
+type Option func(*options)
+
+// WithCompactThreshold включает автоматическое сжатие журнала FileStorage,
+// когда размер файла превышает threshold байт.
+func WithCompactThreshold(threshold int64) Option {
+	return func(o *options) {
+		o.compactThreshold = threshold
+	}
+}
+
+// WithStrict включает строгий режим загрузки FileStorage: повреждённые записи
+// в середине журнала приводят к ошибке ErrCorrupted вместо пропуска.
+func WithStrict(strict bool) Option {
+	return func(o *options) {
+		o.strict = strict
+	}
+}
+
+// WithFlushInterval задаёт, сколько писатель FileStorage копит попутные записи
+// перед одним общим fsync. При нулевом интервале в пакет попадает всё,
+// что успело накопиться в очереди, пока шла предыдущая запись.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.flushInterval = interval
+	}
+}
+
+// WithBatchSize ограничивает число записей FileStorage в одном пакете write+fsync.
+func WithBatchSize(size int) Option {
+	return func(o *options) {
+		o.batchSize = size
+	}
+}
+
+// WithFollower открывает FileStorage ведомым только для чтения: блокировка не берётся,
+// изменения возвращают ErrReadOnly, а новые записи владельца подхватываются раз в interval.
+func WithFollower(interval time.Duration) Option {
+	return func(o *options) {
+		o.followInterval = interval
+	}
+}
+
+// WithKeyGenerator задаёт генератор коротких ключей. По умолчанию ключ - 8 случайных символов base62.
+func WithKeyGenerator(gen KeyGenerator) Option {
+	return func(o *options) {
+		o.keyGenerator = gen
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{batchSize: defaultBatchSize, keyGenerator: defaultKeyGenerator}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.batchSize <= 0 {
+		o.batchSize = defaultBatchSize
+	}
+	if o.keyGenerator == nil {
+		o.keyGenerator = defaultKeyGenerator
+	}
+	return o
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UserURLsSort - поле, по которому упорядочена страница ссылок пользователя.
+type UserURLsSort int
+
+// Порядки страниц ссылок пользователя. При равном моменте создания ссылки упорядочены по ключу.
+const (
+	SortByKey UserURLsSort = iota
+	SortByCreated
+)
+
+// PageCursor - позиция последней ссылки страницы, с которой продолжается следующая.
+type PageCursor struct {
+	CreatedAt time.Time // Учитывается только при SortByCreated
+	Key       string
+}
+
+// UserURLsQuery - запрос страницы ссылок пользователя.
+type UserURLsQuery struct {
+	UserID string
+	Limit  int // Максимум ссылок в странице. Нулевой - без ограничения
+	Sort   UserURLsSort
+	Desc   bool        // Обратный порядок
+	After  *PageCursor // Позиция, после которой начинается страница. nil - с начала
+
+	CreatedAfter   time.Time // Только ссылки, созданные позже. Нулевой - без фильтра
+	Domain         string    // Только ссылки на этот хост, в нижнем регистре. Пустой - без фильтра
+	IncludeDeleted bool      // Включать ли удалённые ссылки
+}
+
+// UserURL - ссылка пользователя в странице вместе с атрибутами.
+type UserURL struct {
+	Key         string
+	OriginalURL string
+	CreatedAt   time.Time // Нулевой у ссылок, сохранённых до учёта момента создания
+	Deleted     bool
+
+	ExpiresAt    time.Time
+	MaxClicks    int64
+	Clicks       int64 // Сколько переходов уже израсходовано
+	RedirectType int
+	CacheMaxAge  int64
+	Protected    bool // Ссылка защищена паролем; сам хеш пароля в страницу не попадает
+}
+
+// UserURLsPage - страница ссылок пользователя.
+type UserURLsPage struct {
+	URLs []UserURL
+	Next *PageCursor // Позиция для следующей страницы. nil - страница последняя
+}
+
+// LinkDomain возвращает хост оригинального URL в нижнем регистре, по которому фильтрует UserURLsQuery.Domain.
+func LinkDomain(originalURL string) string {
+	u, err := url.Parse(originalURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// userURL возвращает данные data ссылки key как ссылку страницы.
+func (d URLData) userURL(key string) UserURL {
+	return UserURL{
+		Key:          key,
+		OriginalURL:  d.OriginalURL,
+		CreatedAt:    d.CreatedAt,
+		Deleted:      d.Deleted,
+		ExpiresAt:    d.ExpiresAt,
+		MaxClicks:    d.MaxClicks,
+		Clicks:       d.Clicks,
+		RedirectType: d.RedirectType,
+		CacheMaxAge:  d.CacheMaxAge,
+		Protected:    d.PasswordHash != "",
+	}
+}
+
+// cursor возвращает позицию ссылки u в порядке q.
+func (q UserURLsQuery) cursor(u UserURL) *PageCursor {
+	if q.Sort == SortByCreated {
+		return &PageCursor{CreatedAt: u.CreatedAt, Key: u.Key}
+	}
+	return &PageCursor{Key: u.Key}
+}
+
+// less сообщает, стоит ли позиция a раньше b в порядке q без учёта Desc.
+func (q UserURLsQuery) less(a, b PageCursor) bool {
+	if q.Sort == SortByCreated && !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.Key < b.Key
+}
+
+// before сообщает, идёт ли позиция a раньше b в порядке q.
+func (q UserURLsQuery) before(a, b PageCursor) bool {
+	if q.Desc {
+		return q.less(b, a)
+	}
+	return q.less(a, b)
+}
+
+// match сообщает, проходит ли ссылка u фильтры q и стоит ли она после курсора.
+func (q UserURLsQuery) match(u UserURL) bool {
+	switch {
+	case u.Deleted && !q.IncludeDeleted:
+		return false
+	case !q.CreatedAfter.IsZero() && !u.CreatedAt.After(q.CreatedAfter):
+		return false
+	case q.Domain != "" && LinkDomain(u.OriginalURL) != q.Domain:
+		return false
+	case q.After != nil && !q.before(*q.After, *q.cursor(u)):
+		return false
+	}
+	return true
+}
+
+// page отбирает из ссылок пользователя страницу по запросу q. Используется хранилищами в памяти.
+func (q UserURLsQuery) page(urls []UserURL) UserURLsPage {
+	matched := urls[:0]
+	for _, u := range urls {
+		if q.match(u) {
+			matched = append(matched, u)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return q.before(*q.cursor(matched[i]), *q.cursor(matched[j]))
+	})
+
+	var page UserURLsPage
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[:q.Limit]
+		page.Next = q.cursor(matched[len(matched)-1])
+	}
+	page.URLs = matched
+	return page
+}
@@ -6,43 +6,146 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/lib/pq"
 )
 
 // DataBaseStorage - PostgreSQL хранилище для сокращенных URL.
 type DataBaseStorage struct {
-	db *sql.DB
+	db   *sql.DB
+	keys KeyGenerator
 }
 
+// Имена ограничений уникальности из миграции 000001.
+const (
+	shortURLConstraint    = "short_urls_short_url_key"
+	originalURLConstraint = "short_urls_original_url_key"
+)
+
 // SQLQueries содержит SQL-запросы, используемые в DataBaseStorage.
 var (
 	// SelectShortURL - запрос для получения короткого URL по оригиналу и ID пользователя.
 	SelectShortURL string = "SELECT short_url FROM short_urls WHERE original_url = $1 AND user_id = $2"
 	// InsertOriginalAndShortURL - запрос для добавления в БД пары сокращенного и оригинального URL.
-	InsertOriginalAndShortURL string = "INSERT INTO short_urls (original_url, short_url, user_id) VALUES ($1, $2, $3)"
+	InsertOriginalAndShortURL string = `INSERT INTO short_urls (original_url, short_url, user_id, expires_at, max_clicks, password_hash, redirect_type, cache_max_age)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
 	// PrepareSQL -  запрос для добавления в БД пары сокращенного и оригинального URL.
 	PrepareSQL string = `INSERT INTO short_urls (original_url, short_url, user_id)
     VALUES ($1, $2, $3)
-    ON CONFLICT (original_url) DO NOTHING
+    ON CONFLICT DO NOTHING
     RETURNING short_url`
+	// SelectShortURLByOriginal - запрос для получения короткого URL по оригиналу у любого пользователя.
+	SelectShortURLByOriginal string = "SELECT short_url FROM short_urls WHERE original_url = $1"
+	// SelectShortURLsByOriginal - запрос коротких URL для списка оригиналов у любого пользователя.
+	SelectShortURLsByOriginal string = "SELECT original_url, short_url FROM short_urls WHERE original_url = ANY($1)"
+	// SelectKeyPosition - запрос позиции последовательного генератора ключей. Пока позиция
+	// не сохранялась, генератор продолжает с числа сохранённых URL.
+	SelectKeyPosition string = "SELECT COALESCE((SELECT position FROM key_position), (SELECT count(*) FROM short_urls))"
+	// UpdateKeyPosition - запрос, сдвигающий позицию генератора ключей вперёд. Назад позиция не уходит.
+	UpdateKeyPosition string = `INSERT INTO key_position (position) VALUES ($1)
+    ON CONFLICT (id) DO UPDATE SET position = GREATEST(key_position.position, EXCLUDED.position)`
 	// SelectOriginalURL - запрос на получение оригинала URL по сокращенному URL.
 	SelectOriginalURL string = `SELECT original_url FROM short_urls WHERE short_url = $1`
 	// SelectAllOriginalURL - запрос на получение всех пар сокращения и оригиналов URL для конкретного пользователя.
 	SelectAllOriginalURL string = "SELECT short_url, original_url FROM short_urls WHERE user_id = $1"
+	// SelectUserURLsPage - начало запроса страницы ссылок пользователя; фильтры, курсор и порядок
+	// дописывает userURLsPageSQL.
+	SelectUserURLsPage string = `SELECT short_url, original_url, created_at, is_deleted, expires_at, max_clicks, click_count, redirect_type,
+    cache_max_age, password_hash IS NOT NULL FROM short_urls WHERE user_id = $1`
 	// IsDeletedSQL - запрос на обновление флага удаления для конкретного пользователя.
-	IsDeletedSQL string = "UPDATE short_urls SET is_deleted = true WHERE short_url = ANY($1) AND user_id = $2;"
-	// SelectOriginalURLWithFlag - запрос на получение пар URL с флагом удаления.
-	SelectOriginalURLWithFlag string = "SELECT original_url, is_deleted FROM short_urls WHERE short_url = $1"
+	// Момент удаления уже удалённых ссылок не меняется.
+	IsDeletedSQL string = `UPDATE short_urls SET is_deleted = true, deleted_at = NOW()
+    WHERE short_url = ANY($1) AND user_id = $2 AND is_deleted IS NOT TRUE;`
+	// SelectOriginalURLWithFlag - запрос на получение ссылки с флагом удаления и атрибутами.
+	SelectOriginalURLWithFlag string = `SELECT original_url, user_id, is_deleted, expires_at, max_clicks, click_count, password_hash, redirect_type,
+    cache_max_age FROM short_urls WHERE short_url = $1`
+	// UseClickSQL - запрос, атомарно расходующий один переход ссылки с лимитом.
+	UseClickSQL string = `UPDATE short_urls SET click_count = click_count + 1
+    WHERE short_url = $1 AND click_count < max_clicks AND is_deleted IS NOT TRUE
+    RETURNING original_url`
+	// HasShortURL - запрос на проверку, занят ли короткий URL.
+	HasShortURL string = "SELECT EXISTS (SELECT 1 FROM short_urls WHERE short_url = $1)"
+	// ExpireLinksSQL - запрос на пометку удалёнными пачки ссылок с истёкшим сроком.
+	ExpireLinksSQL string = `UPDATE short_urls SET is_deleted = true, deleted_at = $1 WHERE id IN (
+    SELECT id FROM short_urls
+    WHERE expires_at <= $1 AND is_deleted IS NOT TRUE
+    ORDER BY expires_at
+    LIMIT $2
+    FOR UPDATE SKIP LOCKED)`
+	// SelectLinkForUpdate - запрос на получение ссылки с блокировкой строки до конца транзакции.
+	SelectLinkForUpdate string = `SELECT original_url, user_id, is_deleted, expires_at, max_clicks, password_hash, redirect_type, cache_max_age
+    FROM short_urls WHERE short_url = $1 FOR UPDATE`
+	// UpdateLinkSQL - запрос на правку оригинального URL, статуса редиректа и срока действия ссылки.
+	UpdateLinkSQL string = "UPDATE short_urls SET original_url = $2, redirect_type = $3, expires_at = $4 WHERE short_url = $1"
+	// InsertRevision - запрос на добавление прежней версии ссылки в историю правок.
+	InsertRevision string = `INSERT INTO link_revisions (short_url, original_url, redirect_type, expires_at, changed_at)
+    VALUES ($1, $2, $3, $4, $5)`
+	// SelectLinkOwner - запрос на получение владельца ссылки.
+	SelectLinkOwner string = "SELECT user_id FROM short_urls WHERE short_url = $1"
+	// SelectRevisions - запрос на получение истории правок ссылки от старых версий к новым.
+	SelectRevisions string = `SELECT original_url, redirect_type, expires_at, changed_at
+    FROM link_revisions WHERE short_url = $1 ORDER BY id`
+	// SelectDeletedURLs - запрос на получение удалённых ссылок пользователя.
+	SelectDeletedURLs string = "SELECT short_url, original_url, deleted_at FROM short_urls WHERE user_id = $1 AND is_deleted"
+	// RestoreURLsSQL - запрос на снятие флага удаления с удалённых ссылок пользователя.
+	RestoreURLsSQL string = `UPDATE short_urls SET is_deleted = false, deleted_at = NULL
+    WHERE short_url = ANY($1) AND user_id = $2 AND is_deleted AND (expires_at IS NULL OR expires_at > $3)
+    RETURNING short_url`
+	// SelectExpiredDeletedSQL - запрос на удалённые ссылки пользователя, срок которых истёк к моменту $3.
+	SelectExpiredDeletedSQL string = `SELECT short_url FROM short_urls
+    WHERE short_url = ANY($1) AND user_id = $2 AND is_deleted AND expires_at <= $3`
+	// PurgeDeletedSQL - запрос на окончательное удаление пачки ссылок, удалённых раньше $1, вместе с их историей и переходами.
+	PurgeDeletedSQL string = `WITH purged AS (
+        DELETE FROM short_urls WHERE id IN (
+            SELECT id FROM short_urls
+            WHERE is_deleted AND deleted_at < $1
+            ORDER BY deleted_at
+            LIMIT $2
+            FOR UPDATE SKIP LOCKED)
+        RETURNING short_url
+    ), revisions AS (
+        DELETE FROM link_revisions WHERE short_url IN (SELECT short_url FROM purged)
+    ), purged_clicks AS (
+        DELETE FROM clicks WHERE short_url IN (SELECT short_url FROM purged)
+    )
+    SELECT count(*) FROM purged`
+	// InsertClick - запрос на добавление события перехода.
+	InsertClick string = "INSERT INTO clicks (short_url, clicked_at, referrer, user_agent, ip_hash) VALUES ($1, $2, $3, $4, $5)"
+	// SelectClicksPerDay - запрос на получение числа переходов по дням (UTC).
+	SelectClicksPerDay string = `SELECT to_char(clicked_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day, count(*)
+    FROM clicks WHERE short_url = $1
+    GROUP BY day ORDER BY day`
+	// SelectTopReferrers - запрос на получение самых частых источников переходов.
+	SelectTopReferrers string = `SELECT referrer, count(*) AS n
+    FROM clicks WHERE short_url = $1 AND referrer <> ''
+    GROUP BY referrer ORDER BY n DESC, referrer
+    LIMIT $2`
+	// SelectRecords - запрос на получение записей всех пользователей с ключами больше $1
+	// в побайтовом порядке ключа (индекс из миграции 000010).
+	SelectRecords string = `SELECT ` + recordColumns + ` FROM short_urls
+    WHERE short_url COLLATE "C" > $1 ORDER BY short_url COLLATE "C" LIMIT $2`
+	// SelectRecord - запрос на получение записи по ключу.
+	SelectRecord string = `SELECT ` + recordColumns + ` FROM short_urls WHERE short_url = $1`
+	// InsertRecord - запрос на добавление записи как есть. Запись, конфликтующая по ключу или URL, пропускается.
+	InsertRecord string = `INSERT INTO short_urls (` + recordColumns + `)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+    ON CONFLICT DO NOTHING`
 )
 
+// recordColumns - колонки записи в порядке scanRecord.
+const recordColumns = `short_url, original_url, user_id, is_deleted, created_at, deleted_at, expires_at, max_clicks, click_count,
+    password_hash, redirect_type, cache_max_age`
+
 // NewDataBaseStorage создает новое PostgreSQL хранилище URL.
-func NewDataBaseStorage(dsn string) (*DataBaseStorage, error) {
+func NewDataBaseStorage(dsn string, opts ...Option) (*DataBaseStorage, error) {
 	db, err := sql.Open("pgx", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to PostgreSQL: %v", err)
@@ -72,7 +175,43 @@ func NewDataBaseStorage(dsn string) (*DataBaseStorage, error) {
 		return nil, fmt.Errorf("failed to apply migrations: %w", err)
 	}
 
-	return &DataBaseStorage{db: db}, nil
+	// Последовательный генератор продолжает с номера после уже выданных ключей
+	o := newOptions(opts)
+	var position uint64
+	if err := db.QueryRowContext(ctx, SelectKeyPosition).Scan(&position); err != nil {
+		return nil, fmt.Errorf("failed to get key position: %w", err)
+	}
+	seekKeys(o.keyGenerator, position)
+
+	return &DataBaseStorage{db: db, keys: o.keyGenerator}, nil
+}
+
+// execer - общее у *sql.DB и *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// saveKeyPosition сохраняет позицию последовательного генератора ключей.
+func (d *DataBaseStorage) saveKeyPosition(ctx context.Context, db execer) error {
+	position := keyPosition(d.keys)
+	if position == 0 {
+		return nil
+	}
+	if _, err := db.ExecContext(ctx, UpdateKeyPosition, int64(position)); err != nil {
+		return fmt.Errorf("failed to save key position: %w", err)
+	}
+	return nil
+}
+
+// isUniqueViolation сообщает, что вставка отклонена ограничением уникальности constraint.
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint
+}
+
+// isKeyConflict сообщает, что вставка отклонена из-за уже занятого short_url.
+func isKeyConflict(err error) bool {
+	return isUniqueViolation(err, shortURLConstraint)
 }
 
 // Save сохраняет оригинальный URL и его сокращение в БД.
@@ -86,13 +225,7 @@ func (d *DataBaseStorage) Save(ctx context.Context, url string, userID string) (
 	err := row.Scan(&key)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			// Генерация нового ключа
-			key = generateShortCode()
-			_, err = d.db.ExecContext(ctx, InsertOriginalAndShortURL, url, key, userID)
-			if err != nil {
-				return "", fmt.Errorf("failed to save URL: %v", err)
-			}
-			return key, nil
+			return d.SaveLink(ctx, Link{OriginalURL: url, UserID: userID})
 		}
 		return "", fmt.Errorf("failed to check URL existence: %v", err) //  Возвращаю ошибку, если это не ErrNoRows
 	}
@@ -100,23 +233,159 @@ func (d *DataBaseStorage) Save(ctx context.Context, url string, userID string) (
 	return key, ErrAlreadyHasKey // URL уже существует у нас в баще, возвращаем его short_url
 }
 
+// SaveLink сохраняет ссылку с атрибутами под заданным или сгенерированным ключом.
+func (d *DataBaseStorage) SaveLink(ctx context.Context, link Link) (string, error) {
+	expiresAt := sql.NullTime{Time: link.ExpiresAt, Valid: !link.ExpiresAt.IsZero()}
+	maxClicks := sql.NullInt64{Int64: link.MaxClicks, Valid: link.MaxClicks > 0}
+	passwordHash := sql.NullString{String: link.PasswordHash, Valid: link.PasswordHash != ""}
+	redirectType := sql.NullInt64{Int64: int64(link.RedirectType), Valid: link.RedirectType != 0}
+	cacheMaxAge := sql.NullInt64{Int64: link.CacheMaxAge, Valid: link.CacheMaxAge != 0}
+	insert := func(key string) error {
+		_, err := d.db.ExecContext(ctx, InsertOriginalAndShortURL, link.OriginalURL, key, link.UserID, expiresAt, maxClicks,
+			passwordHash, redirectType, cacheMaxAge)
+		return err
+	}
+
+	key := link.Key
+	var err error
+	if key == "" {
+		// Генерация нового ключа: при занятом short_url пробуем следующий.
+		// Позиция генератора сохраняется до вставки, чтобы после перезапуска ключ не выдавался повторно
+		var saveErr error
+		key, err = uniqueKey(d.keys, func(candidate string) bool {
+			if saveErr = d.saveKeyPosition(ctx, d.db); saveErr != nil {
+				return false
+			}
+			saveErr = insert(candidate)
+			return isKeyConflict(saveErr)
+		})
+		if err != nil {
+			return "", err
+		}
+		err = saveErr
+	} else {
+		err = insert(key)
+	}
+
+	switch {
+	case err == nil:
+		return key, nil
+	case isKeyConflict(err):
+		return "", ErrKeyTaken
+	case isUniqueViolation(err, originalURLConstraint):
+		var existing string
+		if err := d.db.QueryRowContext(ctx, SelectShortURLByOriginal, link.OriginalURL).Scan(&existing); err != nil {
+			return "", fmt.Errorf("failed to get existing URL: %w", err)
+		}
+		return existing, ErrAlreadyHasKey
+	}
+	return "", fmt.Errorf("failed to save URL: %w", err)
+}
+
 // Get выдает полный URL по его сокращенному варианту.
+//
+// Переход по ссылке с лимитом расходуется одним атомарным UPDATE, поэтому параллельные
+// редиректы, в том числе с разных экземпляров сервиса, не превысят лимит.
 func (d *DataBaseStorage) Get(ctx context.Context, key string) (string, error) {
-	var originalURL string
-	var isDeleted bool
+	link, err := d.GetLink(ctx, key, nil)
+	return link.OriginalURL, err
+}
+
+// GetLink выдает ссылку с атрибутами, проверяя пароль защищённой ссылки функцией verify.
+//
+// Как и в остальных хранилищах, исчерпанный лимит проверяется до пароля. Переход всё равно
+// расходуется атомарным UPDATE: лимит могли исчерпать после чтения ссылки.
+func (d *DataBaseStorage) GetLink(ctx context.Context, key string, verify VerifyFunc) (Link, error) {
+	link, clicks, err := d.peek(ctx, key)
+	if err != nil {
+		return Link{}, err
+	}
+	if expired(link.ExpiresAt, time.Now()) {
+		return Link{}, ErrExpired
+	}
+	if exhausted(link.MaxClicks, clicks) {
+		return Link{}, ErrClickLimitReached
+	}
+	if err := checkPassword(link.PasswordHash, verify); err != nil {
+		return Link{}, err
+	}
+	if link.MaxClicks == 0 {
+		return link, nil
+	}
+
+	err = d.db.QueryRowContext(ctx, UseClickSQL, key).Scan(&link.OriginalURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Link{}, ErrClickLimitReached
+	}
+	if err != nil {
+		return Link{}, fmt.Errorf("failed to use click: %w", err)
+	}
+	return link, nil
+}
+
+// PeekLink выдает ссылку с атрибутами, не расходуя переход и не проверяя пароль.
+func (d *DataBaseStorage) PeekLink(ctx context.Context, key string) (Link, error) {
+	link, _, err := d.peek(ctx, key)
+	return link, err
+}
+
+// peek читает ссылку с атрибутами и числом израсходованных переходов.
+func (d *DataBaseStorage) peek(ctx context.Context, key string) (Link, int64, error) {
+	var isDeleted sql.NullBool
+	var expiresAt sql.NullTime
+	var maxClicks, clicks, redirectType, cacheMaxAge sql.NullInt64
+	var passwordHash sql.NullString
 
+	link := Link{Key: key}
 	row := d.db.QueryRowContext(ctx, SelectOriginalURLWithFlag, key)
-	err := row.Scan(&originalURL, &isDeleted)
+	err := row.Scan(&link.OriginalURL, &link.UserID, &isDeleted, &expiresAt, &maxClicks, &clicks, &passwordHash,
+		&redirectType, &cacheMaxAge)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", ErrNotFound
+			return Link{}, 0, ErrNotFound
+		}
+		return Link{}, 0, fmt.Errorf("failed to get URL: %v", err)
+	}
+	if isDeleted.Bool {
+		return Link{}, 0, ErrDeleted
+	}
+	link.ExpiresAt = expiresAt.Time
+	link.MaxClicks = maxClicks.Int64
+	link.PasswordHash = passwordHash.String
+	link.RedirectType = int(redirectType.Int64)
+	link.CacheMaxAge = cacheMaxAge.Int64
+	return link, clicks.Int64, nil
+}
+
+// HasKey сообщает, занят ли ключ, не расходуя переходы.
+func (d *DataBaseStorage) HasKey(ctx context.Context, key string) (bool, error) {
+	var exists bool
+	if err := d.db.QueryRowContext(ctx, HasShortURL, key).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check short URL: %w", err)
+	}
+	return exists, nil
+}
+
+// KeysByURL возвращает ключи тех из urls, что уже сокращены, в том числе чужих и удалённых ссылок.
+func (d *DataBaseStorage) KeysByURL(ctx context.Context, urls []string) (map[string]string, error) {
+	rows, err := d.db.QueryContext(ctx, SelectShortURLsByOriginal, pq.Array(urls))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get short URLs: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make(map[string]string)
+	for rows.Next() {
+		var original, key string
+		if err := rows.Scan(&original, &key); err != nil {
+			return nil, fmt.Errorf("failed to scan short URL: %w", err)
 		}
-		return "", fmt.Errorf("failed to get URL: %v", err)
+		keys[original] = key
 	}
-	if isDeleted {
-		return "", ErrDeleted
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get short URLs: %w", err)
 	}
-	return originalURL, nil
+	return keys, nil
 }
 
 // Close используется для закрытия PostgreSQL БД и освобождения ресурс
@@ -160,23 +429,34 @@ func (d *DataBaseStorage) SaveInBatch(ctx context.Context, urls []string, userID
 	var conflictErr error
 	for _, u := range urls {
 		var key string
-		err := stmt.QueryRowContext(ctx, u, generateShortCode(), userID).Scan(&key)
-		if err == sql.ErrNoRows {
-
-			// URL уже существует, получаем его ключ
-			err = tx.QueryRowContext(ctx, SelectShortURL, u).Scan(&key)
-			if err != nil {
-				return nil, fmt.Errorf("failed to get existing URL: %v", err)
+		var saveErr error
+		var existed bool
+		_, err := uniqueKey(d.keys, func(candidate string) bool {
+			saveErr = stmt.QueryRowContext(ctx, u, candidate, userID).Scan(&key)
+			if saveErr != sql.ErrNoRows {
+				return false
 			}
-			if conflictErr == nil {
-				conflictErr = ErrAlreadyHasKey
-			}
-		} else if err != nil {
-			return nil, fmt.Errorf("failed to save URL: %v", err)
+
+			// Конфликт без вставки: либо URL уже существует, либо занят ключ
+			saveErr = tx.QueryRowContext(ctx, SelectShortURLByOriginal, u).Scan(&key)
+			existed = saveErr == nil
+			return saveErr == sql.ErrNoRows
+		})
+		if err != nil {
+			return nil, err
+		}
+		if saveErr != nil {
+			return nil, fmt.Errorf("failed to save URL: %v", saveErr)
+		}
+		if existed && conflictErr == nil {
+			conflictErr = ErrAlreadyHasKey
 		}
 
 		keys = append(keys, key)
 	}
+	if err := d.saveKeyPosition(ctx, tx); err != nil {
+		return nil, err
+	}
 	if err := tx.Commit(); err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %v", err)
 	}
@@ -184,6 +464,97 @@ func (d *DataBaseStorage) SaveInBatch(ctx context.Context, urls []string, userID
 
 }
 
+// Update правит ссылку владельца и сохраняет её прежнюю версию в link_revisions в одной транзакции.
+//
+// Строка ссылки блокируется до конца транзакции, поэтому параллельные правки не теряют версии.
+func (d *DataBaseStorage) Update(ctx context.Context, key string, userID string, upd LinkUpdate) (Link, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Link{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var old URLData
+	var expiresAt sql.NullTime
+	var maxClicks, redirectType, cacheMaxAge sql.NullInt64
+	var passwordHash sql.NullString
+	err = tx.QueryRowContext(ctx, SelectLinkForUpdate, key).Scan(&old.OriginalURL, &old.UserID, &old.Deleted, &expiresAt,
+		&maxClicks, &passwordHash, &redirectType, &cacheMaxAge)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return Link{}, ErrNotFound
+	case err != nil:
+		return Link{}, fmt.Errorf("failed to get URL: %w", err)
+	case old.UserID != userID:
+		return Link{}, ErrNotFound
+	case old.Deleted:
+		return Link{}, ErrDeleted
+	}
+	old.ExpiresAt = expiresAt.Time
+	old.MaxClicks = maxClicks.Int64
+	old.PasswordHash = passwordHash.String
+	old.RedirectType = int(redirectType.Int64)
+	old.CacheMaxAge = cacheMaxAge.Int64
+	data := upd.apply(old)
+
+	if _, err := tx.ExecContext(ctx, InsertRevision, key, old.OriginalURL, redirectType, expiresAt, time.Now()); err != nil {
+		return Link{}, fmt.Errorf("failed to save revision: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, UpdateLinkSQL, key, data.OriginalURL,
+		sql.NullInt64{Int64: int64(data.RedirectType), Valid: data.RedirectType != 0},
+		sql.NullTime{Time: data.ExpiresAt, Valid: !data.ExpiresAt.IsZero()})
+	if isUniqueViolation(err, originalURLConstraint) {
+		tx.Rollback()
+		var existing string
+		if err := d.db.QueryRowContext(ctx, SelectShortURLByOriginal, data.OriginalURL).Scan(&existing); err != nil {
+			return Link{}, fmt.Errorf("failed to get existing URL: %w", err)
+		}
+		return Link{Key: existing}, ErrAlreadyHasKey
+	}
+	if err != nil {
+		return Link{}, fmt.Errorf("failed to update URL: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return Link{}, fmt.Errorf("failed to commit update: %w", err)
+	}
+	return data.link(key), nil
+}
+
+// Revisions возвращает прежние версии ссылки пользователя.
+func (d *DataBaseStorage) Revisions(ctx context.Context, key string, userID string) ([]Revision, error) {
+	var owner string
+	err := d.db.QueryRowContext(ctx, SelectLinkOwner, key).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && owner != userID) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get URL: %w", err)
+	}
+
+	rows, err := d.db.QueryContext(ctx, SelectRevisions, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []Revision
+	for rows.Next() {
+		var rev Revision
+		var redirectType sql.NullInt64
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&rev.OriginalURL, &redirectType, &expiresAt, &rev.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan revision: %w", err)
+		}
+		rev.RedirectType = int(redirectType.Int64)
+		rev.ExpiresAt = expiresAt.Time
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get revisions: %w", err)
+	}
+	return revisions, nil
+}
+
 // GetByURL позволяет получить сокращенный URL по его оригиналу.
 func (d *DataBaseStorage) GetByURL(ctx context.Context, originalURL string, userID string) (string, error) {
 	var shortURL string
@@ -228,6 +599,85 @@ func (d *DataBaseStorage) GetUserURLS(ctx context.Context, userID string) (map[s
 	return result, nil
 }
 
+// GetUserURLsPage выдает страницу ссылок пользователя keyset-запросом по индексам
+// (user_id, short_url) и (user_id, created_at, short_url): страница читается от курсора, а не смещения.
+func (d *DataBaseStorage) GetUserURLsPage(ctx context.Context, q UserURLsQuery) (UserURLsPage, error) {
+	query, args := userURLsPageSQL(q)
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return UserURLsPage{}, fmt.Errorf("failed to get user URLs page: %w", err)
+	}
+	defer rows.Close()
+
+	var page UserURLsPage
+	for rows.Next() {
+		var u UserURL
+		var deleted sql.NullBool
+		var expiresAt sql.NullTime
+		var maxClicks, redirectType, cacheMaxAge sql.NullInt64
+		if err := rows.Scan(&u.Key, &u.OriginalURL, &u.CreatedAt, &deleted, &expiresAt, &maxClicks, &u.Clicks,
+			&redirectType, &cacheMaxAge, &u.Protected); err != nil {
+			return UserURLsPage{}, fmt.Errorf("failed to scan user URL: %w", err)
+		}
+		u.Deleted = deleted.Bool
+		u.ExpiresAt, u.MaxClicks = expiresAt.Time, maxClicks.Int64
+		u.RedirectType, u.CacheMaxAge = int(redirectType.Int64), cacheMaxAge.Int64
+		page.URLs = append(page.URLs, u)
+	}
+	if err := rows.Err(); err != nil {
+		return UserURLsPage{}, fmt.Errorf("failed to get user URLs page: %w", err)
+	}
+	// Запрос читает на одну ссылку больше лимита, чтобы узнать, есть ли следующая страница
+	if q.Limit > 0 && len(page.URLs) > q.Limit {
+		page.URLs = page.URLs[:q.Limit]
+		page.Next = q.cursor(page.URLs[q.Limit-1])
+	}
+	return page, nil
+}
+
+// userURLsPageSQL составляет запрос страницы ссылок по q и его аргументы.
+//
+// Ключи сравниваются с COLLATE "C", то есть побайтово, как в хранилищах в памяти.
+func userURLsPageSQL(q UserURLsQuery) (string, []any) {
+	var b strings.Builder
+	b.WriteString(SelectUserURLsPage)
+	args := []any{q.UserID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if !q.IncludeDeleted {
+		b.WriteString(" AND is_deleted IS NOT TRUE")
+	}
+	if !q.CreatedAfter.IsZero() {
+		b.WriteString(" AND created_at > " + arg(q.CreatedAfter))
+	}
+	if q.Domain != "" {
+		b.WriteString(" AND domain = " + arg(q.Domain))
+	}
+
+	op, dir := ">", ""
+	if q.Desc {
+		op, dir = "<", " DESC"
+	}
+	if q.Sort == SortByCreated {
+		if q.After != nil {
+			fmt.Fprintf(&b, ` AND (created_at, short_url COLLATE "C") %s (%s, %s)`, op, arg(q.After.CreatedAt), arg(q.After.Key))
+		}
+		fmt.Fprintf(&b, ` ORDER BY created_at%s, short_url COLLATE "C"%s`, dir, dir)
+	} else {
+		if q.After != nil {
+			fmt.Fprintf(&b, ` AND short_url COLLATE "C" %s %s`, op, arg(q.After.Key))
+		}
+		fmt.Fprintf(&b, ` ORDER BY short_url COLLATE "C"%s`, dir)
+	}
+	if q.Limit > 0 {
+		b.WriteString(" LIMIT " + arg(q.Limit+1))
+	}
+	return b.String(), args
+}
+
 // MarkAsDeleted помечает URL для удаления в фоновом выполнении
 func (d *DataBaseStorage) MarkAsDeleted(ctx context.Context, urls []string, userID string) error {
 	select {
@@ -243,3 +693,253 @@ func (d *DataBaseStorage) MarkAsDeleted(ctx context.Context, urls []string, user
 	}
 	return nil
 }
+
+// ExpireLinks помечает удалёнными не больше limit ссылок, срок которых истёк к моменту now.
+//
+// Строки, уже заблокированные другим экземпляром сервиса, пропускаются.
+func (d *DataBaseStorage) ExpireLinks(ctx context.Context, now time.Time, limit int) (int, error) {
+	res, err := d.db.ExecContext(ctx, ExpireLinksSQL, now, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire URLs: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire URLs: %w", err)
+	}
+	return int(n), nil
+}
+
+// GetDeletedURLS выдает удалённые ссылки пользователя из корзины.
+func (d *DataBaseStorage) GetDeletedURLS(ctx context.Context, userID string) ([]DeletedURL, error) {
+	rows, err := d.db.QueryContext(ctx, SelectDeletedURLs, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deleted URLs: %w", err)
+	}
+	defer rows.Close()
+
+	var deleted []DeletedURL
+	for rows.Next() {
+		var link DeletedURL
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&link.Key, &link.OriginalURL, &deletedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan deleted URL: %w", err)
+		}
+		link.DeletedAt = deletedAt.Time
+		deleted = append(deleted, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get deleted URLs: %w", err)
+	}
+	return deleted, nil
+}
+
+// RestoreURLs снимает пометку удаления с удалённых ссылок пользователя одним запросом.
+//
+// Истёкшие ссылки сначала ищутся отдельным запросом; условие на срок в самом UPDATE
+// не даёт восстановить ссылку, срок которой истёк между запросами.
+func (d *DataBaseStorage) RestoreURLs(ctx context.Context, urls []string, userID string) ([]string, error) {
+	now := time.Now()
+	expiredKeys, err := d.queryKeys(ctx, SelectExpiredDeletedSQL, pq.Array(urls), userID, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check expired URLs: %w", err)
+	}
+	if len(expiredKeys) > 0 {
+		return nil, errRestoreExpired(expiredKeys)
+	}
+
+	restored, err := d.queryKeys(ctx, RestoreURLsSQL, pq.Array(urls), userID, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore URLs: %w", err)
+	}
+	return restored, nil
+}
+
+// queryKeys выполняет запрос query, возвращающий столбец ключей, и собирает их в срез.
+func (d *DataBaseStorage) queryKeys(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// PurgeDeleted окончательно удаляет не больше limit ссылок, удалённых раньше before,
+// вместе с их историей правок и переходами.
+//
+// Строки, уже заблокированные другим экземпляром сервиса, пропускаются.
+func (d *DataBaseStorage) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	var n int
+	if err := d.db.QueryRowContext(ctx, PurgeDeletedSQL, before, limit).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to purge URLs: %w", err)
+	}
+	return n, nil
+}
+
+// RecordClicks сохраняет пачку событий переходов в одной транзакции.
+func (d *DataBaseStorage) RecordClicks(ctx context.Context, clicks []Click) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, InsertClick)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, click := range clicks {
+		if _, err := stmt.ExecContext(ctx, click.Key, click.At, click.Referrer, click.UserAgent, click.IPHash); err != nil {
+			return fmt.Errorf("failed to save click: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ClickStats возвращает статистику переходов по ключу, агрегируя её в БД.
+func (d *DataBaseStorage) ClickStats(ctx context.Context, key string, topReferrers int) (ClickStats, error) {
+	var stats ClickStats
+
+	rows, err := d.db.QueryContext(ctx, SelectClicksPerDay, key)
+	if err != nil {
+		return ClickStats{}, fmt.Errorf("failed to get clicks: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day DayClicks
+		if err := rows.Scan(&day.Date, &day.Clicks); err != nil {
+			return ClickStats{}, fmt.Errorf("failed to scan clicks: %w", err)
+		}
+		stats.Total += day.Clicks
+		stats.PerDay = append(stats.PerDay, day)
+	}
+	if err := rows.Err(); err != nil {
+		return ClickStats{}, fmt.Errorf("failed to get clicks: %w", err)
+	}
+
+	refRows, err := d.db.QueryContext(ctx, SelectTopReferrers, key, topReferrers)
+	if err != nil {
+		return ClickStats{}, fmt.Errorf("failed to get referrers: %w", err)
+	}
+	defer refRows.Close()
+	for refRows.Next() {
+		var ref ReferrerClicks
+		if err := refRows.Scan(&ref.Referrer, &ref.Clicks); err != nil {
+			return ClickStats{}, fmt.Errorf("failed to scan referrers: %w", err)
+		}
+		stats.TopReferrers = append(stats.TopReferrers, ref)
+	}
+	if err := refRows.Err(); err != nil {
+		return ClickStats{}, fmt.Errorf("failed to get referrers: %w", err)
+	}
+	return stats, nil
+}
+
+// ScanRecords выдаёт записи всех пользователей, включая удалённые, с ключами больше after.
+func (d *DataBaseStorage) ScanRecords(ctx context.Context, after string, limit int) ([]Record, error) {
+	rows, err := d.db.QueryContext(ctx, SelectRecords, after, sql.NullInt64{Int64: int64(limit), Valid: limit > 0})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get records: %w", err)
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		r, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get records: %w", err)
+	}
+	return records, nil
+}
+
+// LoadRecords сохраняет записи с их ключами в одной транзакции.
+//
+// Оригинальный URL в таблице уникален, поэтому запись с URL, уже сохранённым под другим ключом,
+// отклоняется с ErrAlreadyHasKey.
+func (d *DataBaseStorage) LoadRecords(ctx context.Context, records []Record) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, InsertRecord)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, r := range records {
+		res, err := stmt.ExecContext(ctx, r.Key, r.OriginalURL, r.UserID, r.Deleted, r.CreatedAt,
+			sql.NullTime{Time: r.DeletedAt, Valid: r.Deleted && !r.DeletedAt.IsZero()},
+			sql.NullTime{Time: r.ExpiresAt, Valid: !r.ExpiresAt.IsZero()},
+			sql.NullInt64{Int64: r.MaxClicks, Valid: r.MaxClicks > 0}, r.Clicks,
+			sql.NullString{String: r.PasswordHash, Valid: r.PasswordHash != ""},
+			sql.NullInt64{Int64: int64(r.RedirectType), Valid: r.RedirectType != 0},
+			sql.NullInt64{Int64: r.CacheMaxAge, Valid: r.CacheMaxAge != 0})
+		if err != nil {
+			return fmt.Errorf("failed to load record %s: %w", r.Key, err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n > 0 {
+			continue
+		}
+
+		// Вставка пропущена: запись уже загружена, ключ занят или URL сохранён под другим ключом
+		existing, err := scanRecord(tx.QueryRowContext(ctx, SelectRecord, r.Key))
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return fmt.Errorf("%w: %s (key %s)", ErrAlreadyHasKey, r.OriginalURL, r.Key)
+		case err != nil:
+			return err
+		case !existing.Equal(r):
+			return fmt.Errorf("%w: %s", ErrKeyTaken, r.Key)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// scanRecord читает запись из строки с колонками recordColumns.
+func scanRecord(row interface{ Scan(dest ...any) error }) (Record, error) {
+	var r Record
+	var deleted sql.NullBool
+	var deletedAt, expiresAt sql.NullTime
+	var maxClicks, redirectType, cacheMaxAge sql.NullInt64
+	var passwordHash sql.NullString
+	err := row.Scan(&r.Key, &r.OriginalURL, &r.UserID, &deleted, &r.CreatedAt, &deletedAt, &expiresAt, &maxClicks,
+		&r.Clicks, &passwordHash, &redirectType, &cacheMaxAge)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Record{}, err
+	}
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to scan record: %w", err)
+	}
+	r.Deleted, r.DeletedAt, r.ExpiresAt = deleted.Bool, deletedAt.Time, expiresAt.Time
+	r.MaxClicks, r.PasswordHash = maxClicks.Int64, passwordHash.String
+	r.RedirectType, r.CacheMaxAge = int(redirectType.Int64), cacheMaxAge.Int64
+	return r, nil
+}
@@ -0,0 +1,39 @@
+package storage
+
+import "time"
+
+// Record - запись хранилища целиком: ключ и все данные ссылки, включая пометку удаления
+// и израсходованные переходы. Используется для переноса данных между хранилищами.
+type Record struct {
+	Key string
+	URLData
+}
+
+// Normalize возвращает запись в виде, одинаковом для всех хранилищ: моменты в UTC с точностью
+// до микросекунд, как их хранит PostgreSQL, без момента удаления у неудалённой записи
+// и без счётчика переходов у ссылки без лимита.
+func (r Record) Normalize() Record {
+	r.CreatedAt = storedTime(r.CreatedAt)
+	r.ExpiresAt = storedTime(r.ExpiresAt)
+	r.DeletedAt = storedTime(r.DeletedAt)
+	if !r.Deleted {
+		r.DeletedAt = time.Time{}
+	}
+	if r.MaxClicks <= 0 {
+		r.MaxClicks, r.Clicks = 0, 0
+	}
+	return r
+}
+
+// Equal сообщает, совпадают ли записи с точностью, с которой их хранят все хранилища.
+func (r Record) Equal(o Record) bool {
+	return r.Normalize() == o.Normalize()
+}
+
+// storedTime приводит момент к UTC с точностью до микросекунд.
+func storedTime(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Time{}
+	}
+	return t.UTC().Truncate(time.Microsecond)
+}
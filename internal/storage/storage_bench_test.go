@@ -2,10 +2,29 @@ package storage
 
 import (
 	"context"
+	"path/filepath"
 	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// benchSizes - объёмы предзаполненного хранилища: небольшой и близкий к боевому.
+var benchSizes = []int{1000, 200000}
+
+// prefilledMemory возвращает хранилище с n ссылками, распределёнными между 1000 пользователями.
+func prefilledMemory(b *testing.B, n int) *MemoryStorage {
+	b.Helper()
+	mem := NewMemoryStorage()
+	for i := 0; i < n; i++ {
+		url := "http://prefilled.com/" + strconv.Itoa(i)
+		if _, err := mem.Save(context.Background(), url, strconv.Itoa(i%1000)); err != nil {
+			b.Fatalf("setup Save failed: %v", err)
+		}
+	}
+	return mem
+}
+
 func BenchmarkSaveMemory(b *testing.B) {
 	arrURL := make([]string, 1000)
 	for i := 0; i < 1000; i++ {
@@ -20,56 +39,182 @@ func BenchmarkSaveMemory(b *testing.B) {
 			mem.Save(context.Background(), url+strconv.Itoa(i), strconv.Itoa(i))
 		}
 	})
+
+	for _, size := range benchSizes {
+		b.Run("save/prefilled="+strconv.Itoa(size), func(b *testing.B) {
+			mem := prefilledMemory(b, size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				mem.Save(context.Background(), arrURL[i%1000]+strconv.Itoa(i), strconv.Itoa(i))
+			}
+		})
+	}
 }
 
 func BenchmarkGetByURL(b *testing.B) {
-	mem := NewMemoryStorage()
+	b.Run("single-user", func(b *testing.B) {
+		mem := NewMemoryStorage()
 
-	// Подготовка данных: 1000 уникальных URL и userID
-	urls := make([]string, 1000)
-	userID := "42"
+		// Подготовка данных: 1000 уникальных URL и userID
+		urls := make([]string, 1000)
+		userID := "42"
 
-	for i := 0; i < len(urls); i++ {
-		url := "http://example.com/" + strconv.Itoa(i)
-		urls[i] = url
-		_, err := mem.Save(context.Background(), url, userID)
-		if err != nil {
-			b.Fatalf("setup Save failed: %v", err)
+		for i := 0; i < len(urls); i++ {
+			url := "http://example.com/" + strconv.Itoa(i)
+			urls[i] = url
+			_, err := mem.Save(context.Background(), url, userID)
+			if err != nil {
+				b.Fatalf("setup Save failed: %v", err)
+			}
 		}
-	}
 
-	b.ResetTimer()
+		b.ResetTimer()
 
-	// Бенчмаркинг
-	for i := 0; i < b.N; i++ {
-		url := urls[i%len(urls)]
-		_, err := mem.GetByURL(context.Background(), url, userID)
-		if err != nil {
-			b.Fatalf("GetByURL error: %v", err)
+		// Бенчмаркинг
+		for i := 0; i < b.N; i++ {
+			url := urls[i%len(urls)]
+			_, err := mem.GetByURL(context.Background(), url, userID)
+			if err != nil {
+				b.Fatalf("GetByURL error: %v", err)
+			}
 		}
+	})
+
+	for _, size := range benchSizes {
+		b.Run("prefilled="+strconv.Itoa(size), func(b *testing.B) {
+			mem := prefilledMemory(b, size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				n := i % size
+				url := "http://prefilled.com/" + strconv.Itoa(n)
+				if _, err := mem.GetByURL(context.Background(), url, strconv.Itoa(n%1000)); err != nil {
+					b.Fatalf("GetByURL error: %v", err)
+				}
+			}
+		})
 	}
 }
 
 func BenchmarkGetUsersURLS(b *testing.B) {
-	mem := NewMemoryStorage()
-	urls := make([]string, 10000)
-	userID := "11"
+	b.Run("single-user", func(b *testing.B) {
+		mem := NewMemoryStorage()
+		urls := make([]string, 10000)
+		userID := "11"
 
-	for i := 0; i < len(urls); i++ {
-		url := "http://example.com/" + strconv.Itoa(i)
-		_, err := mem.Save(context.Background(), url, userID)
-		if err != nil {
-			b.Fatalf("setup Save failed: %v", err)
+		for i := 0; i < len(urls); i++ {
+			url := "http://example.com/" + strconv.Itoa(i)
+			_, err := mem.Save(context.Background(), url, userID)
+			if err != nil {
+				b.Fatalf("setup Save failed: %v", err)
+			}
 		}
-	}
 
-	b.ResetTimer()
+		b.ResetTimer()
 
-	for i := 0; i < b.N; i++ {
-		_, err := mem.GetUserURLS(context.Background(), userID)
-		if err != nil {
-			b.Fatalf("GetUsersURLs error: %v", err)
+		for i := 0; i < b.N; i++ {
+			_, err := mem.GetUserURLS(context.Background(), userID)
+			if err != nil {
+				b.Fatalf("GetUsersURLs error: %v", err)
+			}
 		}
+	})
+
+	// У каждого из 1000 пользователей size/1000 ссылок среди всех size
+	for _, size := range benchSizes {
+		b.Run("prefilled="+strconv.Itoa(size), func(b *testing.B) {
+			mem := prefilledMemory(b, size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := mem.GetUserURLS(context.Background(), strconv.Itoa(i%1000)); err != nil {
+					b.Fatalf("GetUsersURLs error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFileStorageSave сравнивает запись с fsync на каждую ссылку (batch=1)
+// и групповой коммит, когда параллельные Save делят один write+fsync.
+func BenchmarkFileStorageSave(b *testing.B) {
+	cases := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "batch=1", opts: []Option{WithBatchSize(1)}},
+		{name: "group", opts: nil},
+		{name: "group+interval=1ms", opts: []Option{WithFlushInterval(time.Millisecond)}},
 	}
 
+	for _, tc := range cases {
+		b.Run(tc.name, func(b *testing.B) {
+			s, err := NewFileStorage(filepath.Join(b.TempDir(), "storage.json"), tc.opts...)
+			if err != nil {
+				b.Fatalf("NewFileStorage: %v", err)
+			}
+			defer s.Close()
+
+			var counter atomic.Int64
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					url := "http://example.com/" + strconv.FormatInt(counter.Add(1), 10)
+					if _, err := s.Save(context.Background(), url, "42"); err != nil {
+						b.Errorf("Save error: %v", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkMemoryParallel сравнивает MemoryStorage с одним мьютексом и ShardedMemoryStorage
+// под параллельной нагрузкой: редиректы (Get) вперемешку с сокращениями (каждый writeEvery-й запрос).
+func BenchmarkMemoryParallel(b *testing.B) {
+	const prefilled = 100000
+	impls := []struct {
+		name string
+		new  func() Storage
+	}{
+		{name: "mutex", new: func() Storage { return NewMemoryStorage() }},
+		{name: "sharded", new: func() Storage { return NewShardedMemoryStorage(0) }},
+	}
+
+	for _, writeEvery := range []int64{0, 10} {
+		for _, impl := range impls {
+			name := impl.name + "/reads-only"
+			if writeEvery > 0 {
+				name = impl.name + "/writes=1of" + strconv.FormatInt(writeEvery, 10)
+			}
+			b.Run(name, func(b *testing.B) {
+				s := impl.new()
+				keys := make([]string, prefilled)
+				for i := range keys {
+					key, err := s.Save(context.Background(), "http://prefilled.com/"+strconv.Itoa(i), "42")
+					if err != nil {
+						b.Fatalf("setup Save failed: %v", err)
+					}
+					keys[i] = key
+				}
+
+				var counter atomic.Int64
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					ctx := context.Background()
+					for pb.Next() {
+						n := counter.Add(1)
+						if writeEvery > 0 && n%writeEvery == 0 {
+							s.Save(ctx, "http://new.com/"+strconv.FormatInt(n, 10), "42")
+							continue
+						}
+						if _, err := s.Get(ctx, keys[n%prefilled]); err != nil {
+							b.Errorf("Get error: %v", err)
+							return
+						}
+					}
+				})
+			})
+		}
+	}
 }
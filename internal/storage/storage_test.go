@@ -2,10 +2,21 @@ package storage
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -78,12 +89,1219 @@ func TestInMemoryStorage(t *testing.T) {
 	})
 }
 
+func TestMemoryStorageIndexes(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage()
+
+	keys, err := s.SaveInBatch(ctx, []string{"http://a.com", "http://b.com"}, "user1")
+	require.NoError(t, err)
+	other, err := s.Save(ctx, "http://c.com", "user2")
+	require.NoError(t, err)
+
+	urls, err := s.GetUserURLS(ctx, "user1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{keys[0]: "http://a.com", keys[1]: "http://b.com"}, urls)
+
+	// Удалённый URL пропадает из списка пользователя, но остаётся занятым
+	require.NoError(t, s.MarkAsDeleted(ctx, keys[:1], "user1"))
+	urls, err = s.GetUserURLS(ctx, "user1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{keys[1]: "http://b.com"}, urls)
+	key, err := s.Save(ctx, "http://a.com", "user1")
+	assert.ErrorIs(t, err, ErrAlreadyHasKey)
+	assert.Equal(t, keys[0], key)
+
+	// Чужой URL не находится по GetByURL другого пользователя
+	key, err = s.GetByURL(ctx, "http://c.com", "user1")
+	require.NoError(t, err)
+	assert.Empty(t, key)
+
+	// Откат записи чистит индексы
+	s.remove([]string{other})
+	key, err = s.Save(ctx, "http://c.com", "user1")
+	require.NoError(t, err)
+	assert.NotEqual(t, other, key)
+	urls, err = s.GetUserURLS(ctx, "user2")
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+
+	// Перезапись ключа переносит его между индексами
+	s.mu.Lock()
+	s.put(key, URLData{OriginalURL: "http://d.com", UserID: "user2"})
+	s.mu.Unlock()
+	_, exists := s.byURL["http://c.com"]
+	assert.False(t, exists)
+	assert.Contains(t, s.byUser["user2"], key)
+	assert.NotContains(t, s.byUser["user1"], key)
+}
+
+func TestMemoryStorageExpiryIndex(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage()
+	now := time.Now()
+	save := func(url string, expiresAt time.Time) string {
+		key, err := s.SaveLink(ctx, Link{OriginalURL: url, UserID: "user1", ExpiresAt: expiresAt})
+		require.NoError(t, err)
+		return key
+	}
+	expiredKey := save("http://expired.com", now.Add(-time.Minute))
+	live := save("http://live.com", now.Add(time.Hour))
+	restored := save("http://restored.com", now.Add(30*time.Minute))
+	prolonged := save("http://prolonged.com", now.Add(-time.Minute))
+	save("http://deleted.com", now.Add(-time.Minute))
+	_, err := s.Save(ctx, "http://forever.com", "user1")
+	require.NoError(t, err)
+
+	// Удалённая и восстановленная ссылка снова в индексе, продлённая - под новым сроком,
+	// а записи удалённой и прежний срок продлённой устарели
+	require.NoError(t, s.MarkAsDeleted(ctx, []string{restored}, "user1"))
+	_, err = s.RestoreURLs(ctx, []string{restored}, "user1")
+	require.NoError(t, err)
+	later := now.Add(time.Hour)
+	_, err = s.Update(ctx, prolonged, "user1", LinkUpdate{ExpiresAt: &later})
+	require.NoError(t, err)
+	deleted, err := s.GetByURL(ctx, "http://deleted.com", "user1")
+	require.NoError(t, err)
+	require.NoError(t, s.MarkAsDeleted(ctx, []string{deleted}, "user1"))
+
+	keys := s.expire(now, 10)
+	assert.Equal(t, []string{expiredKey}, keys)
+	assert.Len(t, s.byExpiry, 4, "due entries are popped, stale ones dropped; restore re-adds its entry")
+	assert.Empty(t, s.expire(now, 10))
+	assert.ElementsMatch(t, []string{live, restored, prolonged}, s.expire(now.Add(time.Hour), 10))
+
+	// Перестройка оставляет только живые записи
+	s.mu.Lock()
+	s.put(live, URLData{OriginalURL: "http://live.com", UserID: "user1", ExpiresAt: now.Add(2 * time.Hour)})
+	s.byExpiry.add(now, "stale")
+	s.reindex()
+	s.mu.Unlock()
+	assert.Equal(t, timeIndex{{at: now.Add(2 * time.Hour), key: live}}, s.byExpiry)
+}
+
+func TestMemoryStorageDeletionIndex(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage()
+	keys, err := s.SaveInBatch(ctx, []string{"http://a.com", "http://b.com", "http://c.com", "http://d.com"}, "user1")
+	require.NoError(t, err)
+	old, restored, fresh, live := keys[0], keys[1], keys[2], keys[3]
+
+	require.NoError(t, s.MarkAsDeleted(ctx, []string{old, restored}, "user1"))
+	_, err = s.RestoreURLs(ctx, []string{restored}, "user1")
+	require.NoError(t, err)
+	cutoff := time.Now().Add(time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	require.NoError(t, s.MarkAsDeleted(ctx, []string{fresh}, "user1"))
+
+	// Восстановленная ссылка и удалённая после cutoff не удаляются окончательно
+	purged := s.purge(cutoff, 10)
+	require.Len(t, purged, 1)
+	assert.Equal(t, old, purged[0].key)
+	assert.Len(t, s.byDeletion, 1, "due entries are popped, stale ones dropped")
+	assert.Empty(t, s.purge(cutoff, 10))
+
+	// Откат возвращает ссылку в индекс
+	s.unpurge(purged)
+	assert.Len(t, s.purge(time.Now().Add(time.Second), 10), 2)
+	taken, err := s.HasKey(ctx, live)
+	require.NoError(t, err)
+	assert.True(t, taken)
+}
+
+func TestShardedMemoryStorageTimeIndex(t *testing.T) {
+	ctx := context.Background()
+	s := NewShardedMemoryStorage(1)
+	ts := &s.times[0]
+	now := time.Now()
+	save := func(url string, expiresAt time.Time) string {
+		key, err := s.SaveLink(ctx, Link{OriginalURL: url, UserID: "user1", ExpiresAt: expiresAt})
+		require.NoError(t, err)
+		return key
+	}
+	expiredKey := save("http://expired.com", now.Add(-time.Minute))
+	live := save("http://live.com", now.Add(time.Hour))
+	restored := save("http://restored.com", now.Add(30*time.Minute))
+	prolonged := save("http://prolonged.com", now.Add(-time.Minute))
+	deleted := save("http://deleted.com", now.Add(-time.Minute))
+	_, err := s.Save(ctx, "http://forever.com", "user1")
+	require.NoError(t, err)
+
+	// Как и в MemoryStorage, устаревшие записи индекса отбрасываются при извлечении
+	require.NoError(t, s.MarkAsDeleted(ctx, []string{restored}, "user1"))
+	_, err = s.RestoreURLs(ctx, []string{restored}, "user1")
+	require.NoError(t, err)
+	later := now.Add(time.Hour)
+	_, err = s.Update(ctx, prolonged, "user1", LinkUpdate{ExpiresAt: &later})
+	require.NoError(t, err)
+	cutoff := time.Now().Add(time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	require.NoError(t, s.MarkAsDeleted(ctx, []string{deleted}, "user1"))
+
+	n, err := s.ExpireLinks(ctx, now, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = s.Get(ctx, expiredKey)
+	assert.ErrorIs(t, err, ErrDeleted)
+	assert.Len(t, ts.byExpiry, 4, "due entries are popped, stale ones dropped; restore re-adds its entry")
+	n, err = s.ExpireLinks(ctx, now.Add(time.Hour), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n, "live, restored and prolonged links expire under their current expiry")
+	_, err = s.Get(ctx, live)
+	assert.ErrorIs(t, err, ErrDeleted)
+
+	// До cutoff удалена только истёкшая в момент now: ссылка, удалённая после cutoff,
+	// и прежнее удаление restored не учитываются
+	n, err = s.PurgeDeleted(ctx, cutoff, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = s.PurgeDeleted(ctx, now.Add(2*time.Hour), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Empty(t, ts.byDeletion)
+
+	// Перестройка оставляет только живые записи
+	ts.mu.Lock()
+	ts.byExpiry.add(now, "stale")
+	s.reindex(0)
+	ts.mu.Unlock()
+	assert.Empty(t, ts.byExpiry)
+	assert.Equal(t, timeIndexSlack, ts.limit)
+}
+
+func TestShardedMemoryStorage(t *testing.T) {
+	ctx := context.Background()
+	s := NewShardedMemoryStorage(8)
+
+	key, err := s.Save(ctx, "http://example.com", "user1")
+	require.NoError(t, err)
+	val, err := s.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com", val)
+
+	existing, err := s.Save(ctx, "http://example.com", "user2")
+	assert.ErrorIs(t, err, ErrAlreadyHasKey)
+	assert.Equal(t, key, existing)
+
+	_, err = s.Get(ctx, "nonexistent")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	found, err := s.GetByURL(ctx, "http://example.com", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, key, found)
+	found, err = s.GetByURL(ctx, "http://example.com", "user2")
+	require.NoError(t, err)
+	assert.Empty(t, found)
+
+	keys, err := s.SaveInBatch(ctx, []string{"http://a.com", "http://b.com"}, "user1")
+	require.NoError(t, err)
+	urls, err := s.GetUserURLS(ctx, "user1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{key: "http://example.com", keys[0]: "http://a.com", keys[1]: "http://b.com"}, urls)
+
+	assert.Error(t, s.MarkAsDeleted(ctx, []string{key}, "user2"), "foreign keys are not deleted")
+	require.NoError(t, s.MarkAsDeleted(ctx, []string{key}, "user1"))
+	_, err = s.Get(ctx, key)
+	assert.ErrorIs(t, err, ErrDeleted)
+	urls, err = s.GetUserURLS(ctx, "user1")
+	require.NoError(t, err)
+	assert.NotContains(t, urls, key)
+	_, err = s.Save(ctx, "http://example.com", "user1")
+	assert.ErrorIs(t, err, ErrAlreadyHasKey, "deleted URL stays taken as in MemoryStorage")
+
+	// Параллельное сокращение одного URL даёт ровно один ключ
+	var (
+		wg      sync.WaitGroup
+		created atomic.Int32
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Save(ctx, "http://race.com", "user1"); err == nil {
+				created.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), created.Load())
+}
+
+// stubKeys выдаёт ключи по списку, повторяя последний.
+type stubKeys struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (g *stubKeys) Generate() (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := g.keys[0]
+	if len(g.keys) > 1 {
+		g.keys = g.keys[1:]
+	}
+	return key, nil
+}
+
+// openBolt открывает BoltStorage во временном каталоге теста.
+func openBolt(t *testing.T, opts ...Option) *BoltStorage {
+	s, err := NewBoltStorage(filepath.Join(t.TempDir(), "storage.db"), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestKeyGenerators(t *testing.T) {
+	random, err := NewRandomKeyGenerator(12, "xyz")
+	require.NoError(t, err)
+	key, err := random.Generate()
+	require.NoError(t, err)
+	assert.Regexp(t, "^[xyz]{12}$", key)
+
+	counter, err := NewCounterKeyGenerator(3, "ab")
+	require.NoError(t, err)
+	var seq []string
+	for i := 0; i < 4; i++ {
+		key, err := counter.Generate()
+		require.NoError(t, err)
+		seq = append(seq, key)
+	}
+	assert.Equal(t, []string{"aaa", "aab", "aba", "abb"}, seq)
+	counter.Seek(7)
+	key, err = counter.Generate()
+	require.NoError(t, err)
+	assert.Equal(t, "bbb", key)
+	_, err = counter.Generate()
+	assert.ErrorIs(t, err, ErrKeySpaceExhausted)
+
+	// Перестановка Фейстеля обходит всё пространство без повторов
+	feistel, err := NewFeistelKeyGenerator(5, "abc", []byte("secret"))
+	require.NoError(t, err)
+	seen := make(map[string]bool)
+	var order []string
+	for i := 0; i < 243; i++ {
+		key, err := feistel.Generate()
+		require.NoError(t, err)
+		assert.Regexp(t, "^[abc]{5}$", key)
+		assert.False(t, seen[key], "duplicate key %s", key)
+		seen[key] = true
+		order = append(order, key)
+	}
+	_, err = feistel.Generate()
+	assert.ErrorIs(t, err, ErrKeySpaceExhausted)
+	assert.NotEqual(t, "aaaaa", order[0], "sequence must be permuted")
+
+	other, err := NewFeistelKeyGenerator(5, "abc", []byte("other"))
+	require.NoError(t, err)
+	var otherOrder []string
+	for i := 0; i < 10; i++ {
+		key, err := other.Generate()
+		require.NoError(t, err)
+		otherOrder = append(otherOrder, key)
+	}
+	assert.NotEqual(t, order[:10], otherOrder, "secret must change the permutation")
+
+	_, err = NewKeyGenerator("unknown", 0, "", nil)
+	assert.Error(t, err)
+	_, err = NewRandomKeyGenerator(8, "aa")
+	assert.Error(t, err, "duplicate characters in alphabet")
+	_, err = NewFeistelKeyGenerator(20, "", []byte("secret"))
+	assert.Error(t, err, "key space does not fit feistel network")
+	_, err = NewKeyGenerator(KeyGeneratorFeistel, 0, "", nil)
+	assert.Error(t, err, "feistel permutation needs a secret")
+}
+
+func TestKeyCollisionRetry(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	file, err := NewFileStorage(path, WithKeyGenerator(&stubKeys{keys: []string{"dup", "dup", "dup", "fresh"}}))
+	require.NoError(t, err)
+	defer file.Close()
+
+	storages := map[string]Storage{
+		"memory":  NewMemoryStorage(WithKeyGenerator(&stubKeys{keys: []string{"dup", "dup", "dup", "fresh"}})),
+		"sharded": NewShardedMemoryStorage(4, WithKeyGenerator(&stubKeys{keys: []string{"dup", "dup", "dup", "fresh"}})),
+		"file":    file,
+		"bolt":    openBolt(t, WithKeyGenerator(&stubKeys{keys: []string{"dup", "dup", "dup", "fresh"}})),
+	}
+	for name, s := range storages {
+		t.Run(name, func(t *testing.T) {
+			first, err := s.Save(ctx, "http://first.com", "user1")
+			require.NoError(t, err)
+			assert.Equal(t, "dup", first)
+
+			keys, err := s.SaveInBatch(ctx, []string{"http://second.com"}, "user1")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"fresh"}, keys, "taken key must be regenerated")
+
+			val, err := s.Get(ctx, "dup")
+			require.NoError(t, err)
+			assert.Equal(t, "http://first.com", val, "existing entry must not be overwritten")
+
+			_, err = s.Save(ctx, "http://third.com", "user1")
+			assert.ErrorIs(t, err, ErrKeyCollision)
+		})
+	}
+}
+
+func TestKeyPositionSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	counter := func() KeyGenerator {
+		gen, err := NewCounterKeyGenerator(3, "ab")
+		require.NoError(t, err)
+		return gen
+	}
+	open := map[string]func() Storage{
+		"file": func() Storage {
+			s, err := NewFileStorage(filepath.Join(dir, "storage.json"), WithKeyGenerator(counter()))
+			require.NoError(t, err)
+			return s
+		},
+		"bolt": func() Storage {
+			s, err := NewBoltStorage(filepath.Join(dir, "storage.db"), WithKeyGenerator(counter()))
+			require.NoError(t, err)
+			return s
+		},
+	}
+	for name, open := range open {
+		t.Run(name, func(t *testing.T) {
+			s := open()
+			for _, u := range []string{"http://a.com", "http://b.com", "http://c.com"} {
+				_, err := s.Save(ctx, u, "user1")
+				require.NoError(t, err)
+			}
+			_, err := s.SaveLink(ctx, Link{Key: "bbb", OriginalURL: "http://alias.com", UserID: "user1"})
+			require.NoError(t, err)
+
+			// Очистка корзины уменьшает число ссылок, алиас его увеличивает, но позиция генератора сохраняется
+			require.NoError(t, s.MarkAsDeleted(ctx, []string{"aab", "aba"}, "user1"))
+			_, err = s.(Purger).PurgeDeleted(ctx, time.Now().Add(time.Second), 10)
+			require.NoError(t, err)
+			if c, ok := s.(Compactor); ok {
+				require.NoError(t, c.Compact(ctx))
+			}
+			require.NoError(t, s.(io.Closer).Close())
+
+			s = open()
+			defer s.(io.Closer).Close()
+			key, err := s.Save(ctx, "http://d.com", "user1")
+			require.NoError(t, err)
+			assert.Equal(t, "abb", key, "purged keys must not be issued again")
+		})
+	}
+}
+
+func TestSaveLink(t *testing.T) {
+	ctx := context.Background()
+	file, err := NewFileStorage(filepath.Join(t.TempDir(), "storage.json"))
+	require.NoError(t, err)
+	defer file.Close()
+
+	storages := map[string]Storage{
+		"memory":  NewMemoryStorage(),
+		"sharded": NewShardedMemoryStorage(4),
+		"file":    file,
+		"bolt":    openBolt(t),
+	}
+	for name, s := range storages {
+		t.Run(name, func(t *testing.T) {
+			key, err := s.SaveLink(ctx, Link{Key: "spring-sale", OriginalURL: "http://example.com/sale", UserID: "user1"})
+			require.NoError(t, err)
+			assert.Equal(t, "spring-sale", key)
+			val, err := s.Get(ctx, "spring-sale")
+			require.NoError(t, err)
+			assert.Equal(t, "http://example.com/sale", val)
+
+			_, err = s.SaveLink(ctx, Link{Key: "spring-sale", OriginalURL: "http://example.com/other", UserID: "user2"})
+			assert.ErrorIs(t, err, ErrKeyTaken)
+
+			existing, err := s.SaveLink(ctx, Link{Key: "another", OriginalURL: "http://example.com/sale", UserID: "user2"})
+			assert.ErrorIs(t, err, ErrAlreadyHasKey)
+			assert.Equal(t, "spring-sale", existing)
+
+			urls, err := s.GetUserURLS(ctx, "user1")
+			require.NoError(t, err)
+			assert.Equal(t, map[string]string{"spring-sale": "http://example.com/sale"}, urls)
+		})
+	}
+
+	// Алиас переживает перезапуск файлового хранилища
+	require.NoError(t, file.Close())
+	reopened, err := NewFileStorage(file.filePath)
+	require.NoError(t, err)
+	defer reopened.Close()
+	val, err := reopened.Get(ctx, "spring-sale")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/sale", val)
+}
+
+func TestExpireLinks(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	file, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	now := time.Now()
+	storages := map[string]Storage{
+		"memory":  NewMemoryStorage(),
+		"sharded": NewShardedMemoryStorage(4),
+		"file":    file,
+		"bolt":    openBolt(t),
+	}
+	for name, s := range storages {
+		t.Run(name, func(t *testing.T) {
+			var expiredKeys []string
+			for i := 0; i < 3; i++ {
+				key, err := s.SaveLink(ctx, Link{
+					OriginalURL: "http://expired.com/" + strconv.Itoa(i),
+					UserID:      "user1",
+					ExpiresAt:   now.Add(-time.Minute),
+				})
+				require.NoError(t, err)
+				expiredKeys = append(expiredKeys, key)
+			}
+			live, err := s.SaveLink(ctx, Link{OriginalURL: "http://live.com", UserID: "user1", ExpiresAt: now.Add(time.Hour)})
+			require.NoError(t, err)
+			forever, err := s.Save(ctx, "http://forever.com", "user1")
+			require.NoError(t, err)
+
+			_, err = s.Get(ctx, expiredKeys[0])
+			assert.ErrorIs(t, err, ErrExpired, "expired link stops working before the reaper runs")
+			_, err = s.Get(ctx, live)
+			assert.NoError(t, err)
+
+			expirer := s.(Expirer)
+			n, err := expirer.ExpireLinks(ctx, now, 2)
+			require.NoError(t, err)
+			assert.Equal(t, 2, n, "batch is limited")
+			n, err = expirer.ExpireLinks(ctx, now, 2)
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+			n, err = expirer.ExpireLinks(ctx, now, 2)
+			require.NoError(t, err)
+			assert.Zero(t, n)
+
+			for _, key := range expiredKeys {
+				_, err = s.Get(ctx, key)
+				assert.ErrorIs(t, err, ErrDeleted)
+			}
+			urls, err := s.GetUserURLS(ctx, "user1")
+			require.NoError(t, err)
+			assert.Equal(t, map[string]string{live: "http://live.com", forever: "http://forever.com"}, urls)
+		})
+	}
+
+	// Срок действия и надгробия переживают перезапуск файлового хранилища
+	live, err := file.GetByURL(ctx, "http://live.com", "user1")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	reopened, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+	assert.Equal(t, now.Add(time.Hour).UTC().Truncate(time.Second), reopened.memory.data[live].ExpiresAt.Truncate(time.Second))
+	urls, err := reopened.GetUserURLS(ctx, "user1")
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+}
+
+func TestKeysByURL(t *testing.T) {
+	ctx := context.Background()
+	file, err := NewFileStorage(filepath.Join(t.TempDir(), "storage.json"))
+	require.NoError(t, err)
+	defer file.Close()
+
+	storages := map[string]Storage{
+		"memory":  NewMemoryStorage(),
+		"sharded": NewShardedMemoryStorage(4),
+		"file":    file,
+		"bolt":    openBolt(t),
+	}
+	for name, s := range storages {
+		t.Run(name, func(t *testing.T) {
+			own, err := s.Save(ctx, "http://own.com", "user1")
+			require.NoError(t, err)
+			other, err := s.Save(ctx, "http://other.com", "user2")
+			require.NoError(t, err)
+			deleted, err := s.Save(ctx, "http://deleted.com", "user1")
+			require.NoError(t, err)
+			require.NoError(t, s.MarkAsDeleted(ctx, []string{deleted}, "user1"))
+
+			keys, err := s.(URLChecker).KeysByURL(ctx, []string{"http://own.com", "http://other.com", "http://deleted.com", "http://new.com"})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]string{
+				"http://own.com":     own,
+				"http://other.com":   other,
+				"http://deleted.com": deleted,
+			}, keys)
+		})
+	}
+}
+
+func TestClickLimit(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	file, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	storages := map[string]Storage{
+		"memory":  NewMemoryStorage(),
+		"sharded": NewShardedMemoryStorage(4),
+		"file":    file,
+		"bolt":    openBolt(t),
+	}
+	for name, s := range storages {
+		t.Run(name, func(t *testing.T) {
+			key, err := s.SaveLink(ctx, Link{OriginalURL: "http://limited.com", UserID: "user1", MaxClicks: 5})
+			require.NoError(t, err)
+
+			// Проверка ключа не расходует переходы
+			taken, err := s.(KeyChecker).HasKey(ctx, key)
+			require.NoError(t, err)
+			assert.True(t, taken)
+
+			// Параллельные переходы не превышают лимит
+			var wg sync.WaitGroup
+			var opened, refused atomic.Int64
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					url, err := s.Get(ctx, key)
+					switch {
+					case err == nil:
+						assert.Equal(t, "http://limited.com", url)
+						opened.Add(1)
+					case errors.Is(err, ErrClickLimitReached):
+						refused.Add(1)
+					default:
+						t.Errorf("unexpected error: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+			assert.Equal(t, int64(5), opened.Load())
+			assert.Equal(t, int64(15), refused.Load())
+
+			unlimited, err := s.Save(ctx, "http://unlimited.com", "user1")
+			require.NoError(t, err)
+			for i := 0; i < 10; i++ {
+				_, err := s.Get(ctx, unlimited)
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	// Израсходованные переходы переживают перезапуск файлового хранилища
+	onceKey, err := file.SaveLink(ctx, Link{OriginalURL: "http://once.com", UserID: "user1", MaxClicks: 2})
+	require.NoError(t, err)
+	_, err = file.Get(ctx, onceKey)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	reopened, err := NewFileStorage(path)
+	require.NoError(t, err)
+	_, err = reopened.Get(ctx, onceKey)
+	require.NoError(t, err)
+	_, err = reopened.Get(ctx, onceKey)
+	assert.ErrorIs(t, err, ErrClickLimitReached)
+
+	// Сжатие сохраняет счётчик в снимке
+	require.NoError(t, reopened.Compact(ctx))
+	require.NoError(t, reopened.Close())
+	compacted, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer compacted.Close()
+	_, err = compacted.Get(ctx, onceKey)
+	assert.ErrorIs(t, err, ErrClickLimitReached)
+}
+
+func TestGetLinkCheckOrder(t *testing.T) {
+	ctx := context.Background()
+	file, err := NewFileStorage(filepath.Join(t.TempDir(), "storage.json"))
+	require.NoError(t, err)
+	defer file.Close()
+
+	allow := func(string) bool { return true }
+	storages := map[string]Storage{
+		"memory":  NewMemoryStorage(),
+		"sharded": NewShardedMemoryStorage(4),
+		"file":    file,
+		"bolt":    openBolt(t),
+	}
+	// PostgreSQL проверяется, если задана тестовая база
+	if dsn := os.Getenv("TEST_DATABASE_DSN"); dsn != "" {
+		db, err := NewDataBaseStorage(dsn)
+		require.NoError(t, err)
+		defer db.Close()
+		_, err = db.db.ExecContext(ctx, "TRUNCATE TABLE short_urls")
+		require.NoError(t, err)
+		storages["postgres"] = db
+	}
+	tests := []struct {
+		name    string
+		link    Link
+		prepare func(t *testing.T, s Storage, key string)
+		wantErr error
+		checked bool // Вызывается ли проверка пароля
+	}{
+		{
+			name:    "live",
+			link:    Link{MaxClicks: 2},
+			wantErr: ErrWrongPassword,
+			checked: true,
+		},
+		{
+			name: "deleted",
+			prepare: func(t *testing.T, s Storage, key string) {
+				require.NoError(t, s.MarkAsDeleted(ctx, []string{key}, "user1"))
+			},
+			wantErr: ErrDeleted,
+		},
+		{
+			name:    "expired",
+			link:    Link{ExpiresAt: time.Now().Add(-time.Minute)},
+			wantErr: ErrExpired,
+		},
+		{
+			name: "click limit reached",
+			link: Link{MaxClicks: 1},
+			prepare: func(t *testing.T, s Storage, key string) {
+				_, err := s.GetLink(ctx, key, allow)
+				require.NoError(t, err)
+			},
+			wantErr: ErrClickLimitReached,
+		},
+	}
+	for name, s := range storages {
+		for i, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				link := tt.link
+				link.OriginalURL, link.UserID, link.PasswordHash = fmt.Sprintf("http://%s/%d", name, i), "user1", "hash"
+				key, err := s.SaveLink(ctx, link)
+				require.NoError(t, err)
+				if tt.prepare != nil {
+					tt.prepare(t, s, key)
+				}
+
+				// Недоступная ссылка отклоняется до проверки пароля, каким бы он ни был
+				checked := false
+				_, err = s.GetLink(ctx, key, func(string) bool {
+					checked = true
+					return false
+				})
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.Equal(t, tt.checked, checked)
+				_, err = s.GetLink(ctx, key, nil)
+				if tt.checked {
+					assert.ErrorIs(t, err, ErrPasswordRequired)
+				} else {
+					assert.ErrorIs(t, err, tt.wantErr)
+				}
+			})
+		}
+	}
+}
+
+func TestProtectedLinks(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	file, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	allow := func(hash string) bool { return hash == "secret-hash" }
+	deny := func(string) bool { return false }
+
+	storages := map[string]Storage{
+		"memory":  NewMemoryStorage(),
+		"sharded": NewShardedMemoryStorage(4),
+		"file":    file,
+		"bolt":    openBolt(t),
+	}
+	for name, s := range storages {
+		t.Run(name, func(t *testing.T) {
+			key, err := s.SaveLink(ctx, Link{OriginalURL: "http://private.com", UserID: "user1", MaxClicks: 1, PasswordHash: "secret-hash"})
+			require.NoError(t, err)
+
+			_, err = s.Get(ctx, key)
+			assert.ErrorIs(t, err, ErrPasswordRequired)
+			_, err = s.GetLink(ctx, key, nil)
+			assert.ErrorIs(t, err, ErrPasswordRequired)
+			_, err = s.GetLink(ctx, key, deny)
+			assert.ErrorIs(t, err, ErrWrongPassword)
+
+			// Неверный пароль не расходует единственный переход
+			link, err := s.GetLink(ctx, key, allow)
+			require.NoError(t, err)
+			assert.Equal(t, "http://private.com", link.OriginalURL)
+			assert.Equal(t, "secret-hash", link.PasswordHash)
+			_, err = s.GetLink(ctx, key, allow)
+			assert.ErrorIs(t, err, ErrClickLimitReached)
+
+			// Ссылки без пароля открываются и без функции проверки
+			open, err := s.Save(ctx, "http://public.com", "user1")
+			require.NoError(t, err)
+			link, err = s.GetLink(ctx, open, deny)
+			require.NoError(t, err)
+			assert.Equal(t, "http://public.com", link.OriginalURL)
+		})
+	}
+
+	// Хеш пароля переживает перезапуск файлового хранилища
+	key, err := file.SaveLink(ctx, Link{OriginalURL: "http://private2.com", UserID: "user1", PasswordHash: "secret-hash"})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	reopened, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+	_, err = reopened.Get(ctx, key)
+	assert.ErrorIs(t, err, ErrPasswordRequired)
+	link, err := reopened.GetLink(ctx, key, allow)
+	require.NoError(t, err)
+	assert.Equal(t, "http://private2.com", link.OriginalURL)
+}
+
+func TestRedirectPolicyStored(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	file, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	storages := map[string]Storage{
+		"memory":  NewMemoryStorage(),
+		"sharded": NewShardedMemoryStorage(4),
+		"file":    file,
+		"bolt":    openBolt(t),
+	}
+	for name, s := range storages {
+		t.Run(name, func(t *testing.T) {
+			key, err := s.SaveLink(ctx, Link{OriginalURL: "http://moved.com", UserID: "user1", RedirectType: 301, CacheMaxAge: 3600})
+			require.NoError(t, err)
+			link, err := s.GetLink(ctx, key, nil)
+			require.NoError(t, err)
+			assert.Equal(t, key, link.Key)
+			assert.Equal(t, 301, link.RedirectType)
+			assert.Equal(t, int64(3600), link.CacheMaxAge)
+
+			// Ссылки без политики оставляют выбор серверу
+			plain, err := s.Save(ctx, "http://plain.com", "user1")
+			require.NoError(t, err)
+			link, err = s.GetLink(ctx, plain, nil)
+			require.NoError(t, err)
+			assert.Zero(t, link.RedirectType)
+			assert.Zero(t, link.CacheMaxAge)
+		})
+	}
+
+	// Политика переживает перезапуск файлового хранилища
+	key, err := file.SaveLink(ctx, Link{OriginalURL: "http://moved2.com", UserID: "user1", RedirectType: 308, CacheMaxAge: -1})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	reopened, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+	link, err := reopened.GetLink(ctx, key, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 308, link.RedirectType)
+	assert.Equal(t, int64(-1), link.CacheMaxAge)
+}
+
+func TestUpdateLink(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	file, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	storages := map[string]Storage{
+		"memory":  NewMemoryStorage(),
+		"sharded": NewShardedMemoryStorage(4),
+		"file":    file,
+		"bolt":    openBolt(t),
+	}
+	for name, s := range storages {
+		t.Run(name, func(t *testing.T) {
+			key, err := s.SaveLink(ctx, Link{OriginalURL: "http://old.com", UserID: "user1", MaxClicks: 10})
+			require.NoError(t, err)
+			_, err = s.Save(ctx, "http://taken.com", "user2")
+			require.NoError(t, err)
+
+			newURL, redirect := "http://new.com", 308
+			expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+			_, err = s.Update(ctx, key, "user2", LinkUpdate{OriginalURL: &newURL})
+			assert.ErrorIs(t, err, ErrNotFound)
+			_, err = s.Update(ctx, "missing", "user1", LinkUpdate{OriginalURL: &newURL})
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			link, err := s.Update(ctx, key, "user1", LinkUpdate{OriginalURL: &newURL, RedirectType: &redirect, ExpiresAt: &expiresAt})
+			require.NoError(t, err)
+			assert.Equal(t, Link{Key: key, OriginalURL: newURL, UserID: "user1", ExpiresAt: expiresAt, MaxClicks: 10, RedirectType: redirect}, link)
+
+			// Ключ прежний, индексы по URL обновлены
+			url, err := s.Get(ctx, key)
+			require.NoError(t, err)
+			assert.Equal(t, newURL, url)
+			short, err := s.GetByURL(ctx, newURL, "user1")
+			require.NoError(t, err)
+			assert.Equal(t, key, short)
+			_, err = s.Save(ctx, "http://old.com", "user1")
+			assert.NoError(t, err, "old URL is free again")
+
+			taken := "http://taken.com"
+			link, err = s.Update(ctx, key, "user1", LinkUpdate{OriginalURL: &taken})
+			assert.ErrorIs(t, err, ErrAlreadyHasKey)
+			assert.NotEqual(t, key, link.Key)
+
+			noRedirect := 0
+			_, err = s.Update(ctx, key, "user1", LinkUpdate{RedirectType: &noRedirect})
+			require.NoError(t, err)
+
+			revisions, err := s.Revisions(ctx, key, "user1")
+			require.NoError(t, err)
+			require.Len(t, revisions, 2)
+			assert.Equal(t, "http://old.com", revisions[0].OriginalURL)
+			assert.True(t, revisions[0].ExpiresAt.IsZero())
+			assert.Equal(t, newURL, revisions[1].OriginalURL)
+			assert.Equal(t, 308, revisions[1].RedirectType)
+			assert.False(t, revisions[1].ChangedAt.Before(revisions[0].ChangedAt))
+			_, err = s.Revisions(ctx, key, "user2")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			require.NoError(t, s.MarkAsDeleted(ctx, []string{key}, "user1"))
+			_, err = s.Update(ctx, key, "user1", LinkUpdate{OriginalURL: &newURL})
+			assert.ErrorIs(t, err, ErrDeleted)
+		})
+	}
+
+	// Правки и их история переживают перезапуск и сжатие журнала
+	key, err := file.Save(ctx, "http://v1.com", "user1")
+	require.NoError(t, err)
+	v2 := "http://v2.com"
+	_, err = file.Update(ctx, key, "user1", LinkUpdate{OriginalURL: &v2})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	for _, compact := range []bool{false, true} {
+		reopened, err := NewFileStorage(path)
+		require.NoError(t, err)
+		url, err := reopened.Get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, v2, url)
+		revisions, err := reopened.Revisions(ctx, key, "user1")
+		require.NoError(t, err)
+		require.Len(t, revisions, 1)
+		assert.Equal(t, "http://v1.com", revisions[0].OriginalURL)
+		if compact {
+			require.NoError(t, reopened.Compact(ctx))
+		}
+		require.NoError(t, reopened.Close())
+	}
+	reopened, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+	revisions, err := reopened.Revisions(ctx, key, "user1")
+	require.NoError(t, err)
+	assert.Len(t, revisions, 1)
+}
+
+func TestTrash(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	file, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	storages := map[string]interface {
+		Storage
+		Purger
+	}{
+		"memory":  NewMemoryStorage(),
+		"sharded": NewShardedMemoryStorage(4),
+		"file":    file,
+		"bolt":    openBolt(t),
+	}
+	for name, s := range storages {
+		t.Run(name, func(t *testing.T) {
+			kept, err := s.Save(ctx, "http://kept.com", "user1")
+			require.NoError(t, err)
+			gone, err := s.Save(ctx, "http://gone.com", "user1")
+			require.NoError(t, err)
+			live, err := s.Save(ctx, "http://live.com", "user1")
+			require.NoError(t, err)
+
+			before := time.Now()
+			require.NoError(t, s.MarkAsDeleted(ctx, []string{kept, gone}, "user1"))
+			deleted, err := s.GetDeletedURLS(ctx, "user1")
+			require.NoError(t, err)
+			require.Len(t, deleted, 2)
+			for _, link := range deleted {
+				assert.False(t, link.DeletedAt.Before(before))
+			}
+
+			// Восстанавливаются только свои удалённые ссылки
+			restored, err := s.RestoreURLs(ctx, []string{kept, live, "missing"}, "user2")
+			require.NoError(t, err)
+			assert.Empty(t, restored)
+			restored, err = s.RestoreURLs(ctx, []string{kept, live, "missing"}, "user1")
+			require.NoError(t, err)
+			assert.Equal(t, []string{kept}, restored)
+			url, err := s.Get(ctx, kept)
+			require.NoError(t, err)
+			assert.Equal(t, "http://kept.com", url)
+
+			// Истёкшую ссылку чистильщик тут же удалил бы снова, поэтому не восстанавливается ничего
+			stale, err := s.SaveLink(ctx, Link{OriginalURL: "http://stale.com", UserID: "user1", ExpiresAt: before.Add(-time.Minute)})
+			require.NoError(t, err)
+			require.NoError(t, s.MarkAsDeleted(ctx, []string{stale}, "user1"))
+			restored, err = s.RestoreURLs(ctx, []string{gone, stale}, "user1")
+			assert.ErrorIs(t, err, ErrExpired)
+			assert.ErrorContains(t, err, stale)
+			assert.Empty(t, restored)
+			_, err = s.Get(ctx, gone)
+			assert.ErrorIs(t, err, ErrDeleted)
+
+			clicks := s.(ClickStorage)
+			require.NoError(t, clicks.RecordClicks(ctx, []Click{{Key: gone, At: before}, {Key: live, At: before}}))
+
+			// Удалённые позже момента before не трогаются
+			n, err := s.PurgeDeleted(ctx, before, 10)
+			require.NoError(t, err)
+			assert.Zero(t, n)
+			n, err = s.PurgeDeleted(ctx, time.Now().Add(time.Second), 10)
+			require.NoError(t, err)
+			assert.Equal(t, 2, n)
+			_, err = s.Get(ctx, gone)
+			assert.ErrorIs(t, err, ErrNotFound)
+			deleted, err = s.GetDeletedURLS(ctx, "user1")
+			require.NoError(t, err)
+			assert.Empty(t, deleted)
+
+			// Переходы удалены вместе со ссылкой и не достанутся новой ссылке с тем же ключом
+			stats, err := clicks.ClickStats(ctx, gone, 10)
+			require.NoError(t, err)
+			assert.Zero(t, stats.Total)
+			stats, err = clicks.ClickStats(ctx, live, 10)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), stats.Total)
+
+			// URL окончательно удалённой ссылки можно сократить заново
+			_, err = s.Save(ctx, "http://gone.com", "user1")
+			assert.NoError(t, err)
+		})
+	}
+
+	// Момент удаления переживает перезапуск, а окончательно удалённые ссылки не возвращаются
+	key, err := file.Save(ctx, "http://trash.com", "user1")
+	require.NoError(t, err)
+	require.NoError(t, file.MarkAsDeleted(ctx, []string{key}, "user1"))
+	deleted, err := file.GetDeletedURLS(ctx, "user1")
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+	require.NoError(t, file.Close())
+
+	reopened, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+	after, err := reopened.GetDeletedURLS(ctx, "user1")
+	require.NoError(t, err)
+	require.Len(t, after, 1)
+	assert.True(t, deleted[0].DeletedAt.Equal(after[0].DeletedAt))
+	_, err = reopened.Get(ctx, "gone")
+	assert.ErrorIs(t, err, ErrNotFound)
+	clicks, err := os.ReadFile(path + ".clicks")
+	require.NoError(t, err)
+	assert.Equal(t, 1, bytes.Count(clicks, []byte("\n")), "only the live link's click is left")
+}
+
+func TestUserURLsPage(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	file, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	storages := map[string]Storage{
+		"memory":  NewMemoryStorage(),
+		"sharded": NewShardedMemoryStorage(4),
+		"file":    file,
+		"bolt":    openBolt(t),
+	}
+	for name, s := range storages {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now()
+			var keys []string
+			for i := 0; i < 5; i++ {
+				key, err := s.Save(ctx, fmt.Sprintf("https://Example.com/%d", i), "user1")
+				require.NoError(t, err)
+				keys = append(keys, key)
+			}
+			other, err := s.SaveInBatch(ctx, []string{"https://other.org/a", "https://other.org/b"}, "user1")
+			require.NoError(t, err)
+			keys = append(keys, other...)
+			_, err = s.Save(ctx, "https://example.com/foreign", "user2")
+			require.NoError(t, err)
+			require.NoError(t, s.MarkAsDeleted(ctx, keys[:1], "user1"))
+
+			// Страницы по курсору покрывают все ссылки ровно по одному разу в заданном порядке
+			collect := func(q UserURLsQuery) []UserURL {
+				var all []UserURL
+				for {
+					page, err := s.GetUserURLsPage(ctx, q)
+					require.NoError(t, err)
+					require.LessOrEqual(t, len(page.URLs), q.Limit)
+					all = append(all, page.URLs...)
+					if page.Next == nil {
+						return all
+					}
+					q.After = page.Next
+				}
+			}
+
+			byKey := collect(UserURLsQuery{UserID: "user1", Limit: 2})
+			require.Len(t, byKey, 6)
+			assert.True(t, sort.SliceIsSorted(byKey, func(i, j int) bool { return byKey[i].Key < byKey[j].Key }))
+			for _, u := range byKey {
+				assert.NotEqual(t, keys[0], u.Key, "deleted links are skipped by default")
+				assert.False(t, u.CreatedAt.Before(start))
+			}
+
+			byCreated := collect(UserURLsQuery{UserID: "user1", Limit: 3, Sort: SortByCreated, Desc: true, IncludeDeleted: true})
+			require.Len(t, byCreated, 7)
+			assert.True(t, sort.SliceIsSorted(byCreated, func(i, j int) bool {
+				return byCreated[i].CreatedAt.After(byCreated[j].CreatedAt) ||
+					byCreated[i].CreatedAt.Equal(byCreated[j].CreatedAt) && byCreated[i].Key > byCreated[j].Key
+			}))
+			assert.Equal(t, keys[0], byCreated[len(byCreated)-1].Key)
+			assert.True(t, byCreated[len(byCreated)-1].Deleted)
+
+			domain := collect(UserURLsQuery{UserID: "user1", Limit: 10, Domain: "other.org"})
+			require.Len(t, domain, 2)
+			assert.ElementsMatch(t, other, []string{domain[0].Key, domain[1].Key})
+
+			after := collect(UserURLsQuery{UserID: "user1", Limit: 10, CreatedAfter: time.Now()})
+			assert.Empty(t, after)
+		})
+	}
+
+	// Момент создания переживает перезапуск
+	before, err := file.GetUserURLsPage(ctx, UserURLsQuery{UserID: "user1"})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	reopened, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+	after, err := reopened.GetUserURLsPage(ctx, UserURLsQuery{UserID: "user1"})
+	require.NoError(t, err)
+	require.Len(t, after.URLs, len(before.URLs))
+	for i := range before.URLs {
+		assert.True(t, before.URLs[i].CreatedAt.Equal(after.URLs[i].CreatedAt))
+	}
+}
+
+func TestUserURLsPageSQL(t *testing.T) {
+	created := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	query, args := userURLsPageSQL(UserURLsQuery{
+		UserID:       "user1",
+		Limit:        10,
+		Sort:         SortByCreated,
+		Desc:         true,
+		After:        &PageCursor{CreatedAt: created, Key: "abc"},
+		CreatedAfter: created.Add(-time.Hour),
+		Domain:       "example.com",
+	})
+	assert.Equal(t, SelectUserURLsPage+` AND is_deleted IS NOT TRUE AND created_at > $2 AND domain = $3`+
+		` AND (created_at, short_url COLLATE "C") < ($4, $5) ORDER BY created_at DESC, short_url COLLATE "C" DESC LIMIT $6`, query)
+	assert.Equal(t, []any{"user1", created.Add(-time.Hour), "example.com", created, "abc", 11}, args)
+
+	query, args = userURLsPageSQL(UserURLsQuery{UserID: "user1", IncludeDeleted: true, After: &PageCursor{Key: "abc"}})
+	assert.Equal(t, SelectUserURLsPage+` AND short_url COLLATE "C" > $2 ORDER BY short_url COLLATE "C"`, query)
+	assert.Equal(t, []any{"user1", "abc"}, args)
+}
+
+func TestClickStats(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	file, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	day1 := time.Date(2026, 10, 16, 23, 30, 0, 0, time.UTC)
+	day2 := day1.Add(time.Hour)
+	clicks := []Click{
+		{Key: "abc", At: day1, Referrer: "https://a.example", IPHash: "h1"},
+		{Key: "abc", At: day2, Referrer: "https://b.example", IPHash: "h2"},
+		{Key: "abc", At: day2, Referrer: "https://b.example", IPHash: "h1"},
+		{Key: "abc", At: day2},
+		{Key: "other", At: day2, Referrer: "https://a.example"},
+	}
+	want := ClickStats{
+		Total:        4,
+		PerDay:       []DayClicks{{Date: "2026-10-16", Clicks: 1}, {Date: "2026-10-17", Clicks: 3}},
+		TopReferrers: []ReferrerClicks{{Referrer: "https://b.example", Clicks: 2}},
+	}
+
+	storages := map[string]ClickStorage{
+		"memory":  NewMemoryStorage(),
+		"sharded": NewShardedMemoryStorage(4),
+		"file":    file,
+		"bolt":    openBolt(t),
+	}
+	for name, s := range storages {
+		t.Run(name, func(t *testing.T) {
+			// События приходят несколькими пачками
+			require.NoError(t, s.RecordClicks(ctx, clicks[:2]))
+			require.NoError(t, s.RecordClicks(ctx, clicks[2:]))
+
+			stats, err := s.ClickStats(ctx, "abc", 1)
+			require.NoError(t, err)
+			assert.Equal(t, want, stats)
+
+			stats, err = s.ClickStats(ctx, "unknown", 1)
+			require.NoError(t, err)
+			assert.Zero(t, stats.Total)
+		})
+	}
+
+	// Журнал переходов переживает перезапуск, а недописанная строка пропускается
+	require.NoError(t, file.Close())
+	f, err := os.OpenFile(path+".clicks", os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"short_url":"abc","at":`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	reopened, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+	stats, err := reopened.ClickStats(ctx, "abc", 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), stats.Total)
+	assert.Len(t, stats.TopReferrers, 2)
+
+	// Слишком длинная строка пропускается, не ломая чтение следующих
+	f, err = os.OpenFile(path+".clicks", os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = fmt.Fprintf(f, "\n{\"short_url\":\"abc\",\"referrer\":%q}\n", strings.Repeat("r", 2*maxClickLine))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, reopened.RecordClicks(ctx, clicks[:1]))
+
+	stats, err = reopened.ClickStats(ctx, "abc", 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), stats.Total)
+	assert.Equal(t, int64(2), stats.TopReferrers[0].Clicks)
+}
+
 // Для хранения в файле
 
 func TestFileStorage(t *testing.T) {
 	tmpFile, err := os.CreateTemp("", "test-storage-*.json")
 	require.NoError(t, err)
 	defer os.Remove(tmpFile.Name())
+	defer os.Remove(tmpFile.Name() + ".lock")
 
 	// Подготовка тестовых данных
 	testRecords := []ShortURLJSON{
@@ -102,7 +1320,8 @@ func TestFileStorage(t *testing.T) {
 
 	t.Run("Initialization and load from file", func(t *testing.T) {
 		s, err := NewFileStorage(tmpFile.Name())
-		assert.NoError(t, err)
+		require.NoError(t, err)
+		defer s.Close()
 
 		val, err := s.Get(context.Background(), "test123")
 		assert.NoError(t, err)
@@ -117,7 +1336,9 @@ func TestFileStorage(t *testing.T) {
 	})
 
 	t.Run("Save New URL", func(t *testing.T) {
-		s, _ := NewFileStorage(tmpFile.Name())
+		s, err := NewFileStorage(tmpFile.Name())
+		require.NoError(t, err)
+		defer s.Close()
 		userID := "user1"
 		url := "http://new-example.com"
 		key, err := s.Save(context.Background(), url, userID)
@@ -151,10 +1372,12 @@ func TestFileStorage(t *testing.T) {
 	t.Run("Load from non-existent file", func(t *testing.T) {
 		nonExistentFile := "non-existent-file.json"
 		defer os.Remove(nonExistentFile)
+		defer os.Remove(nonExistentFile + ".lock")
 
 		s, err := NewFileStorage(nonExistentFile)
 		assert.NoError(t, err)
-		assert.NotNil(t, s)
+		require.NotNil(t, s)
+		defer s.Close()
 
 		// Проверяем что можем сохранять/получать несмотря на отсутствие файла
 		userID := "user1"
@@ -167,6 +1390,310 @@ func TestFileStorage(t *testing.T) {
 	})
 }
 
+func TestFileStorageBatchAndDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	ctx := context.Background()
+	userID := "user1"
+
+	s, err := NewFileStorage(path)
+	require.NoError(t, err)
+
+	urls := []string{"http://batch.com/1", "http://batch.com/2", "http://batch.com/3"}
+	keys, err := s.SaveInBatch(ctx, urls, userID)
+	require.NoError(t, err)
+	require.Len(t, keys, len(urls))
+	assert.Equal(t, 3, s.lastUUID)
+
+	require.NoError(t, s.MarkAsDeleted(ctx, []string{keys[0], "unknown"}, userID))
+	// Чужие ссылки не удаляются
+	require.NoError(t, s.MarkAsDeleted(ctx, []string{keys[1]}, "user2"))
+	require.NoError(t, s.Close())
+
+	t.Run("Reload after restart", func(t *testing.T) {
+		reloaded, err := NewFileStorage(path)
+		require.NoError(t, err)
+		assert.Equal(t, 4, reloaded.lastUUID)
+
+		_, err = reloaded.Get(ctx, keys[0])
+		assert.ErrorIs(t, err, ErrDeleted)
+
+		for i := 1; i < len(keys); i++ {
+			val, err := reloaded.Get(ctx, keys[i])
+			require.NoError(t, err)
+			assert.Equal(t, urls[i], val)
+		}
+
+		userURLs, err := reloaded.GetUserURLS(ctx, userID)
+		require.NoError(t, err)
+		assert.Len(t, userURLs, 2)
+		assert.NotContains(t, userURLs, keys[0])
+	})
+}
+
+func TestFileStorageCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	ctx := context.Background()
+	userID := "user1"
+
+	s, err := NewFileStorage(path)
+	require.NoError(t, err)
+
+	keys, err := s.SaveInBatch(ctx, []string{"http://a.com", "http://b.com", "http://c.com"}, userID)
+	require.NoError(t, err)
+	require.NoError(t, s.MarkAsDeleted(ctx, keys[:1], userID))
+	require.NoError(t, s.MarkAsDeleted(ctx, keys[1:2], userID))
+	assert.Equal(t, 5, s.lastUUID)
+
+	require.NoError(t, s.Compact(ctx))
+	assert.Equal(t, 3, s.lastUUID)
+	assert.Equal(t, 1+3, countLines(t, path), "snapshot must contain the header and one record per key")
+
+	// После сжатия запись продолжается с корректным UUID
+	key, err := s.Save(ctx, "http://d.com", userID)
+	require.NoError(t, err)
+	assert.Equal(t, 4, s.lastUUID)
+	require.NoError(t, s.Close())
+
+	reloaded, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer reloaded.Close()
+	assert.Equal(t, 4, reloaded.lastUUID)
+
+	_, err = reloaded.Get(ctx, keys[0])
+	assert.ErrorIs(t, err, ErrDeleted)
+	_, err = reloaded.Get(ctx, keys[1])
+	assert.ErrorIs(t, err, ErrDeleted)
+	val, err := reloaded.Get(ctx, keys[2])
+	require.NoError(t, err)
+	assert.Equal(t, "http://c.com", val)
+	val, err = reloaded.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, "http://d.com", val)
+
+	matches, err := filepath.Glob(path + ".compact-*")
+	require.NoError(t, err)
+	assert.Empty(t, matches, "temporary snapshot files must be cleaned up")
+}
+
+func TestFileStorageCompactThreshold(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	ctx := context.Background()
+
+	s, err := NewFileStorage(path, WithCompactThreshold(512))
+	require.NoError(t, err)
+	defer s.Close()
+
+	key, err := s.Save(ctx, "http://example.com", "user1")
+	require.NoError(t, err)
+	// Повторные надгробия раздувают журнал, не меняя живых данных
+	for i := 0; i < 20; i++ {
+		s.saveMutex.Lock()
+		done, err := s.enqueue(&writeRequest{records: []ShortURLJSON{{ShortURL: key, UserID: "user1", IsDeleted: true}}})
+		s.saveMutex.Unlock()
+		require.NoError(t, err)
+		require.NoError(t, <-done)
+	}
+
+	assert.Eventually(t, func() bool {
+		info, err := os.Stat(path)
+		return err == nil && info.Size() < 512
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestFileStorageTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	ctx := context.Background()
+
+	s, err := NewFileStorage(path)
+	require.NoError(t, err)
+	keys, err := s.SaveInBatch(ctx, []string{"http://a.com", "http://b.com"}, "user1")
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	// Имитируем сбой посреди записи: в конце файла оборванная строка
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"uuid":3,"short_url":"torn","original_url":"http://c`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	reloaded, err := NewFileStorage(path, WithStrict(true))
+	require.NoError(t, err, "torn tail must be repaired even in strict mode")
+	assert.Equal(t, 2, reloaded.lastUUID)
+	for _, key := range keys {
+		_, err := reloaded.Get(ctx, key)
+		assert.NoError(t, err)
+	}
+
+	repaired, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), repaired.Size(), "file must be truncated to the last complete record")
+
+	// После ремонта запись продолжается с новой строки
+	key, err := reloaded.Save(ctx, "http://d.com", "user1")
+	require.NoError(t, err)
+	require.NoError(t, reloaded.Close())
+	again, err := NewFileStorage(path, WithStrict(true))
+	require.NoError(t, err)
+	defer again.Close()
+	val, err := again.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, "http://d.com", val)
+}
+
+func TestFileStorageCorruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	ctx := context.Background()
+
+	s, err := NewFileStorage(path)
+	require.NoError(t, err)
+	keys, err := s.SaveInBatch(ctx, []string{"http://a.com", "http://b.com", "http://c.com"}, "user1")
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// Портим URL во второй записи, не трогая контрольную сумму
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data = bytes.Replace(data, []byte("http://b.com"), []byte("http://x.com"), 1)
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	_, err = NewFileStorage(path, WithStrict(true))
+	assert.ErrorIs(t, err, ErrCorrupted)
+
+	// Без строгого режима повреждённая запись пропускается, остальные доступны
+	lenient, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer lenient.Close()
+	_, err = lenient.Get(ctx, keys[1])
+	assert.ErrorIs(t, err, ErrNotFound)
+	for _, key := range []string{keys[0], keys[2]} {
+		_, err := lenient.Get(ctx, key)
+		assert.NoError(t, err)
+	}
+
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, data, after, "corruption in the middle must not be truncated away")
+}
+
+func TestFileStorageGroupCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	ctx := context.Background()
+
+	s, err := NewFileStorage(path, WithFlushInterval(5*time.Millisecond), WithBatchSize(16))
+	require.NoError(t, err)
+
+	const n = 100
+	keys := make([]string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key, err := s.Save(ctx, "http://example.com/"+strconv.Itoa(i), "user1")
+			assert.NoError(t, err)
+			keys[i] = key
+		}(i)
+	}
+	wg.Wait()
+	require.NoError(t, s.Close())
+
+	// Подтверждённые записи устойчивы: после переоткрытия все на месте
+	reloaded, err := NewFileStorage(path, WithStrict(true))
+	require.NoError(t, err)
+	defer reloaded.Close()
+	assert.Equal(t, n, reloaded.lastUUID)
+	for i, key := range keys {
+		val, err := reloaded.Get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com/"+strconv.Itoa(i), val)
+	}
+
+	_, err = s.Save(ctx, "http://late.com", "user1")
+	assert.ErrorIs(t, err, ErrClosed)
+	_, err = s.GetByURL(ctx, "http://late.com", "user1")
+	assert.ErrorIs(t, err, ErrNotFound, "rejected save must not stay in memory")
+}
+
+func TestFileStorageLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+
+	owner, err := NewFileStorage(path)
+	require.NoError(t, err)
+
+	_, err = NewFileStorage(path)
+	assert.ErrorIs(t, err, ErrLocked, "second writer must not open a locked file")
+
+	require.NoError(t, owner.Close())
+	again, err := NewFileStorage(path)
+	require.NoError(t, err, "lock must be released on Close")
+	require.NoError(t, again.Close())
+}
+
+func TestFileStorageFollower(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	ctx := context.Background()
+
+	owner, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer owner.Close()
+	first, err := owner.Save(ctx, "http://a.com", "user1")
+	require.NoError(t, err)
+
+	follower, err := NewFileStorage(path, WithFollower(5*time.Millisecond))
+	require.NoError(t, err, "follower opens a locked file")
+	defer follower.Close()
+
+	val, err := follower.Get(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, "http://a.com", val)
+
+	_, err = follower.Save(ctx, "http://b.com", "user1")
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.ErrorIs(t, follower.MarkAsDeleted(ctx, []string{first}, "user1"), ErrReadOnly)
+	assert.ErrorIs(t, follower.Compact(ctx), ErrReadOnly)
+
+	// Новые записи владельца подхватываются
+	second, err := owner.Save(ctx, "http://b.com", "user1")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		val, err := follower.Get(ctx, second)
+		return err == nil && val == "http://b.com"
+	}, time.Second, 5*time.Millisecond)
+
+	// Сжатие подменяет файл - ведомый перечитывает его и продолжает следить
+	require.NoError(t, owner.MarkAsDeleted(ctx, []string{first}, "user1"))
+	require.NoError(t, owner.Compact(ctx))
+	third, err := owner.Save(ctx, "http://c.com", "user1")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		_, errDeleted := follower.Get(ctx, first)
+		val, err := follower.Get(ctx, third)
+		return errors.Is(errDeleted, ErrDeleted) && err == nil && val == "http://c.com"
+	}, time.Second, 5*time.Millisecond)
+}
+
+// countLines возвращает число непустых строк в файле.
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	n := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			n++
+		}
+	}
+	require.NoError(t, scanner.Err())
+	return n
+}
+
 func TestPostgresStorage(t *testing.T) {
 	dsn := os.Getenv("TEST_DATABASE_DSN")
 	if dsn == "" {
@@ -277,3 +1804,273 @@ func TestMarkAsDeleted(t *testing.T) {
 		require.Error(t, err)
 	})
 }
+
+func TestRecords(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	file, err := NewFileStorage(path)
+	require.NoError(t, err)
+
+	created := time.Date(2026, 10, 17, 12, 0, 0, 123456789, time.UTC)
+	records := []Record{
+		{Key: "b", URLData: URLData{OriginalURL: "http://b.com", UserID: "user1", CreatedAt: created}},
+		{Key: "a", URLData: URLData{OriginalURL: "http://a.com", UserID: "user2", Deleted: true, DeletedAt: created}},
+		{Key: "c", URLData: URLData{OriginalURL: "http://c.com", UserID: "user1", MaxClicks: 3, Clicks: 2, PasswordHash: "hash"}},
+	}
+
+	storages := map[string]interface {
+		Storage
+		RecordScanner
+		RecordLoader
+		KeyChecker
+	}{
+		"memory": NewMemoryStorage(),
+		"file":   file,
+		"bolt":   openBolt(t),
+	}
+	for name, s := range storages {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, s.LoadRecords(ctx, records))
+			// Повторная загрузка тех же записей ничего не меняет
+			require.NoError(t, s.LoadRecords(ctx, records[:1]))
+
+			all, err := s.ScanRecords(ctx, "", 0)
+			require.NoError(t, err)
+			require.Len(t, all, 3)
+			assert.Equal(t, []string{"a", "b", "c"}, []string{all[0].Key, all[1].Key, all[2].Key})
+			assert.True(t, all[0].Deleted, "deleted records are scanned too")
+			assert.True(t, all[1].Equal(records[0]))
+			assert.Equal(t, int64(2), all[2].Clicks)
+
+			page, err := s.ScanRecords(ctx, "a", 1)
+			require.NoError(t, err)
+			require.Len(t, page, 1)
+			assert.Equal(t, "b", page[0].Key)
+
+			// Конфликт по ключу отклоняет весь вызов
+			err = s.LoadRecords(ctx, []Record{
+				{Key: "d", URLData: URLData{OriginalURL: "http://d.com", UserID: "user1"}},
+				{Key: "b", URLData: URLData{OriginalURL: "http://other.com", UserID: "user1"}},
+			})
+			assert.ErrorIs(t, err, ErrKeyTaken)
+			ok, err := s.HasKey(ctx, "d")
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			_, err = s.Get(ctx, "a")
+			assert.ErrorIs(t, err, ErrDeleted)
+			url, err := s.Get(ctx, "b")
+			require.NoError(t, err)
+			assert.Equal(t, "http://b.com", url)
+		})
+	}
+
+	// Загруженные записи переживают перезапуск файлового хранилища
+	require.NoError(t, file.Close())
+	reopened, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+	all, err := reopened.ScanRecords(ctx, "", 0)
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	for i, r := range []Record{records[1], records[0], records[2]} {
+		assert.True(t, all[i].Equal(r), "record %s: %+v", r.Key, all[i])
+	}
+}
+
+func TestRecordNormalize(t *testing.T) {
+	at := time.Date(2026, 10, 17, 12, 0, 0, 123456789, time.FixedZone("MSK", 3*60*60))
+	r := Record{Key: "k", URLData: URLData{CreatedAt: at, DeletedAt: at, Clicks: 5}}
+	n := r.Normalize()
+	assert.Equal(t, at.UTC().Truncate(time.Microsecond), n.CreatedAt)
+	assert.True(t, n.DeletedAt.IsZero(), "not deleted")
+	assert.Zero(t, n.Clicks, "no click limit")
+	assert.True(t, r.Equal(Record{Key: "k", URLData: URLData{CreatedAt: at.UTC().Truncate(time.Microsecond)}}))
+}
+
+// slowPeeker считает чтения PeekLink и задерживает их до закрытия release.
+type slowPeeker struct {
+	*MemoryStorage
+	peeks   atomic.Int64
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *slowPeeker) PeekLink(ctx context.Context, key string) (Link, error) {
+	if s.peeks.Add(1) == 1 {
+		close(s.started)
+	}
+	<-s.release
+	return s.MemoryStorage.PeekLink(ctx, key)
+}
+
+func TestBoltStorage(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.db")
+	s, err := NewBoltStorage(path, WithKeyGenerator(&stubKeys{keys: []string{"first", "limited", "batch1", "first"}}))
+	require.NoError(t, err)
+
+	_, err = NewBoltStorage(path)
+	assert.ErrorIs(t, err, ErrLocked, "second process cannot open the same file")
+
+	key, err := s.Save(ctx, "http://first.com", "user1")
+	require.NoError(t, err)
+	limited, err := s.SaveLink(ctx, Link{OriginalURL: "http://limited.com", UserID: "user1", MaxClicks: 2})
+	require.NoError(t, err)
+	_, err = s.Get(ctx, limited)
+	require.NoError(t, err)
+	v2 := "http://second.com"
+	_, err = s.Update(ctx, key, "user1", LinkUpdate{OriginalURL: &v2})
+	require.NoError(t, err)
+	require.NoError(t, s.MarkAsDeleted(ctx, []string{limited}, "user1"))
+	require.NoError(t, s.RecordClicks(ctx, []Click{{Key: key, At: time.Now()}}))
+
+	// Пакет сохраняется в одной транзакции: ошибка на втором URL откатывает первый
+	_, err = s.SaveInBatch(ctx, []string{"http://batch1.com", "http://batch2.com"}, "user1")
+	assert.ErrorIs(t, err, ErrKeyCollision)
+	taken, err := s.HasKey(ctx, "batch1")
+	require.NoError(t, err)
+	assert.False(t, taken)
+	short, err := s.GetByURL(ctx, "http://batch1.com", "user1")
+	require.NoError(t, err)
+	assert.Empty(t, short)
+
+	// Ссылки, правки, корзина, переходы и индексы переживают перезапуск
+	require.NoError(t, s.Close())
+	reopened, err := NewBoltStorage(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	url, err := reopened.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, v2, url)
+	short, err = reopened.GetByURL(ctx, v2, "user1")
+	require.NoError(t, err)
+	assert.Equal(t, key, short)
+	revisions, err := reopened.Revisions(ctx, key, "user1")
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	assert.Equal(t, "http://first.com", revisions[0].OriginalURL)
+	deleted, err := reopened.GetDeletedURLS(ctx, "user1")
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+	assert.Equal(t, limited, deleted[0].Key)
+	stats, err := reopened.ClickStats(ctx, key, 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Total)
+
+	records, err := reopened.ScanRecords(ctx, "", 0)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, int64(1), records[1].Clicks, "spent clicks are stored")
+}
+
+func TestCachedStorage(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStorage()
+	c := NewCachedStorage(inner, 2, time.Minute, time.Minute)
+
+	key, err := c.Save(ctx, "http://example.com", "user1")
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		url, err := c.Get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com", url)
+	}
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 1, Entries: 1}, c.CacheStats())
+
+	// Отрицательный ответ кешируется и сбрасывается при сохранении под этим ключом
+	_, err = c.Get(ctx, "alias")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = c.Get(ctx, "alias")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, int64(1), c.CacheStats().Hits-2)
+	_, err = c.SaveLink(ctx, Link{Key: "alias", OriginalURL: "http://alias.com", UserID: "user1"})
+	require.NoError(t, err)
+	url, err := c.Get(ctx, "alias")
+	require.NoError(t, err)
+	assert.Equal(t, "http://alias.com", url)
+
+	// Удаление и восстановление сбрасывают запись
+	require.NoError(t, c.MarkAsDeleted(ctx, []string{key}, "user1"))
+	_, err = c.Get(ctx, key)
+	assert.ErrorIs(t, err, ErrDeleted)
+	_, err = c.RestoreURLs(ctx, []string{key}, "user1")
+	require.NoError(t, err)
+	_, err = c.Get(ctx, key)
+	require.NoError(t, err)
+
+	// Правка сбрасывает запись
+	newURL := "http://example.com/new"
+	_, err = c.Update(ctx, key, "user1", LinkUpdate{OriginalURL: &newURL})
+	require.NoError(t, err)
+	url, err = c.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, newURL, url)
+
+	// Кеш ограничен: давно не использованные записи вытесняются
+	assert.Equal(t, 2, c.CacheStats().Entries)
+	_, err = c.Get(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 2, c.CacheStats().Entries)
+}
+
+func TestCachedStorageLinkRules(t *testing.T) {
+	ctx := context.Background()
+	c := NewCachedStorage(NewMemoryStorage(), 10, 0, 0)
+
+	limited, err := c.SaveLink(ctx, Link{OriginalURL: "http://limited.com", UserID: "user1", MaxClicks: 2})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err := c.Get(ctx, limited)
+		require.NoError(t, err)
+	}
+	_, err = c.Get(ctx, limited)
+	assert.ErrorIs(t, err, ErrClickLimitReached, "clicks are spent in the storage, not in the cache")
+
+	protected, err := c.SaveLink(ctx, Link{OriginalURL: "http://secret.com", UserID: "user1", PasswordHash: "hash"})
+	require.NoError(t, err)
+	_, err = c.Get(ctx, protected)
+	assert.ErrorIs(t, err, ErrPasswordRequired)
+	_, err = c.GetLink(ctx, protected, func(hash string) bool { return false })
+	assert.ErrorIs(t, err, ErrWrongPassword)
+	link, err := c.GetLink(ctx, protected, func(hash string) bool { return hash == "hash" })
+	require.NoError(t, err)
+	assert.Equal(t, "http://secret.com", link.OriginalURL)
+
+	expiring, err := c.SaveLink(ctx, Link{OriginalURL: "http://expiring.com", UserID: "user1", ExpiresAt: time.Now().Add(50 * time.Millisecond)})
+	require.NoError(t, err)
+	_, err = c.Get(ctx, expiring)
+	require.NoError(t, err)
+	time.Sleep(60 * time.Millisecond)
+	_, err = c.Get(ctx, expiring)
+	assert.ErrorIs(t, err, ErrExpired, "expiry is checked on cache hits")
+}
+
+func TestCachedStorageCoalescesMisses(t *testing.T) {
+	ctx := context.Background()
+	inner := &slowPeeker{MemoryStorage: NewMemoryStorage(), started: make(chan struct{}), release: make(chan struct{})}
+	key, err := inner.Save(ctx, "http://example.com", "user1")
+	require.NoError(t, err)
+	c := NewCachedStorage(inner, 10, time.Minute, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			url, err := c.Get(ctx, key)
+			assert.NoError(t, err)
+			assert.Equal(t, "http://example.com", url)
+		}()
+	}
+	<-inner.started
+	time.Sleep(20 * time.Millisecond)
+	close(inner.release)
+	wg.Wait()
+
+	assert.Equal(t, int64(1), inner.peeks.Load(), "concurrent misses read the storage once")
+	stats := c.CacheStats()
+	assert.Equal(t, int64(1), stats.Misses)
+	assert.Equal(t, int64(9), stats.Hits+stats.Coalesced)
+}
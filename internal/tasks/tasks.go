@@ -1,8 +1,21 @@
-// Package tasks используется для образования структуры фонового удаления URL.
+// Package tasks описывает задачи фоновой обработки: удаление URL и запись переходов.
 package tasks
 
+import "time"
+
 // DeleteTask представляет задачу на удаление URL по конкретному пользователю.
 type DeleteTask struct {
 	UserID    string
 	ShortURLs []string
 }
+
+// ClickTask представляет событие перехода по короткой ссылке для фоновой записи.
+//
+// IP клиента хешируется перед сохранением и в хранилище не попадает.
+type ClickTask struct {
+	Key       string
+	At        time.Time
+	Referrer  string
+	UserAgent string
+	IP        string
+}
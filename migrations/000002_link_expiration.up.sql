@@ -0,0 +1,3 @@
+ALTER TABLE short_urls ADD COLUMN expires_at TIMESTAMPTZ;
+
+CREATE INDEX idx_short_urls_expires_at ON short_urls (expires_at) WHERE expires_at IS NOT NULL;
@@ -0,0 +1,10 @@
+CREATE TABLE clicks (
+    id BIGSERIAL PRIMARY KEY,
+    short_url TEXT NOT NULL,
+    clicked_at TIMESTAMPTZ NOT NULL,
+    referrer TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_hash TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_clicks_short_url ON clicks (short_url, clicked_at);
@@ -0,0 +1,2 @@
+ALTER TABLE short_urls ADD COLUMN max_clicks INTEGER;
+ALTER TABLE short_urls ADD COLUMN click_count INTEGER NOT NULL DEFAULT 0;
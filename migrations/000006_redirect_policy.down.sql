@@ -0,0 +1,2 @@
+ALTER TABLE short_urls DROP COLUMN IF EXISTS cache_max_age;
+ALTER TABLE short_urls DROP COLUMN IF EXISTS redirect_type;
@@ -0,0 +1,2 @@
+ALTER TABLE short_urls ADD COLUMN redirect_type SMALLINT;
+ALTER TABLE short_urls ADD COLUMN cache_max_age INTEGER;
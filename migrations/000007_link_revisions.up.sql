@@ -0,0 +1,10 @@
+CREATE TABLE link_revisions (
+    id BIGSERIAL PRIMARY KEY,
+    short_url TEXT NOT NULL,
+    original_url TEXT NOT NULL,
+    redirect_type SMALLINT,
+    expires_at TIMESTAMPTZ,
+    changed_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_link_revisions_short_url ON link_revisions (short_url, id);
@@ -0,0 +1,6 @@
+ALTER TABLE short_urls ADD COLUMN deleted_at TIMESTAMPTZ;
+
+-- Удалённые раньше ссылки получают полный срок хранения в корзине с момента миграции
+UPDATE short_urls SET deleted_at = NOW() WHERE is_deleted;
+
+CREATE INDEX idx_short_urls_deleted_at ON short_urls (deleted_at) WHERE is_deleted;
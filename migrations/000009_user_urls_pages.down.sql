@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_short_urls_user_domain;
+DROP INDEX IF EXISTS idx_short_urls_user_created;
+DROP INDEX IF EXISTS idx_short_urls_user_key;
+ALTER TABLE short_urls DROP COLUMN IF EXISTS domain;
+ALTER TABLE short_urls DROP COLUMN IF EXISTS created_at;
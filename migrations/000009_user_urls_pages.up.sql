@@ -0,0 +1,11 @@
+-- Ссылки, сохранённые до миграции, получают момент создания, равный моменту миграции
+ALTER TABLE short_urls ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+-- Хост оригинального URL в нижнем регистре для фильтра по домену (как storage.LinkDomain)
+ALTER TABLE short_urls ADD COLUMN domain TEXT
+    GENERATED ALWAYS AS (lower(substring(original_url FROM '^[A-Za-z][A-Za-z0-9+.-]*://(?:[^/?#]*@)?([^/?#:]*)'))) STORED;
+
+-- Ключи сравниваются побайтово, как в хранилищах в памяти
+CREATE INDEX idx_short_urls_user_key ON short_urls (user_id, short_url COLLATE "C");
+CREATE INDEX idx_short_urls_user_created ON short_urls (user_id, created_at, short_url COLLATE "C");
+CREATE INDEX idx_short_urls_user_domain ON short_urls (user_id, domain, created_at, short_url COLLATE "C");
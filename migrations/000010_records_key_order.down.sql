@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_short_urls_key;
@@ -0,0 +1,2 @@
+-- Перенос записей между хранилищами читает их в побайтовом порядке ключа, как хранилища в памяти
+CREATE INDEX idx_short_urls_key ON short_urls (short_url COLLATE "C");
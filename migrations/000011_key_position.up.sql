@@ -0,0 +1,7 @@
+-- Позиция последовательного генератора ключей: число ссылок для неё не годится,
+-- его увеличивают алиасы и импорт и уменьшает очистка корзины.
+-- Пока строки нет (база заполнена до миграции или переносом), генератор продолжает с числа ссылок
+CREATE TABLE key_position (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    position BIGINT NOT NULL
+);
@@ -6,29 +6,101 @@ package config
 
 import (
 	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 )
 
 // Config holds application configuration parameters.
 //
-// Включает адрес сервера защищенного и простого, базовый URL, путь к файлу хранения, строку подключения к БД
-// и секретный ключ для cookie.
+// Включает адрес сервера защищенного и простого, адрес gRPC-сервера, базовый URL, путь к файлу хранения, строку подключения к БД
+// ключи подписи cookie, доверенную подсеть и параметры файлового хранилища.
 type Config struct {
 	EnableHTTPS     bool   `env:"ENABLE_HTTPS" json:"enable_https"`
 	CertFile        string `env:"TLS_CERT_FILE" json:"tls_cert_file"`
 	KeyFile         string `env:"TLS_KEY_FILE" json:"tls_key_file"`
 	RunAddr         string `env:"SERVER_ADDRESS" json:"server_address"`
+	GRPCAddr        string `env:"GRPC_ADDRESS" json:"grpc_address"`
 	BaseURL         string `env:"BASE_URL" json:"base_url"`
 	SaveInFile      string `env:"FILE_STORAGE_PATH" json:"file_storage_path"`
 	DataBase        string `env:"DATABASE_DSN" json:"database_dsn"`
-	CookieSecretKey []byte `env:"COOKIE_SECRET_KEY" json:"cookie_secret_key"`
+	CookieSecretKey string `env:"COOKIE_SECRET_KEY" json:"cookie_secret_key"`
+	TrustedSubnet   string `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
 	Config          string `env:"CONFIG"`
+
+	// CookieSecretKeys - упорядоченный список ключей подписи cookie: первый основной,
+	// остальные (устаревшие) принимаются только для проверки.
+	CookieSecretKeys []string `env:"COOKIE_SECRET_KEYS" envSeparator:"," json:"cookie_secret_keys"`
+
+	// FileCompactThreshold - размер файла хранилища в байтах, после которого журнал сжимается. 0 - выключено.
+	FileCompactThreshold int64 `env:"FILE_COMPACT_THRESHOLD" json:"file_compact_threshold"`
+
+	// StrictStorage - не запускать сервер, если в файле хранилища найдены повреждения.
+	StrictStorage bool `env:"STRICT_STORAGE" json:"strict_storage"`
+
+	// FileFlushInterval - сколько файловое хранилище копит параллельные записи перед общим fsync.
+	// FileBatchSize - максимальное число записей в одном пакете (0 - значение по умолчанию).
+	FileFlushInterval time.Duration `env:"FILE_FLUSH_INTERVAL" json:"file_flush_interval"`
+	FileBatchSize     int           `env:"FILE_BATCH_SIZE" json:"file_batch_size"`
+
+	// FileFollowInterval - если задан, файл хранилища открывается ведомым только для чтения
+	// и опрашивается с этим периодом; запись ведёт другой экземпляр, владеющий блокировкой файла.
+	FileFollowInterval time.Duration `env:"FILE_FOLLOW_INTERVAL" json:"file_follow_interval"`
+
+	// BoltStoragePath - путь к файлу встроенной базы bbolt. Используется, если не задан FileStorage.
+	BoltStoragePath string `env:"BOLT_STORAGE_PATH" json:"bolt_storage_path"`
+
+	// MemoryShards - число шардов in-memory хранилища. 0 - одна мапа под общим мьютексом,
+	// отрицательное значение - число шардов по числу процессоров.
+	MemoryShards int `env:"MEMORY_SHARDS" json:"memory_shards"`
+
+	// KeyGenerator - стратегия коротких ключей: random, counter или feistel.
+	// KeyLength и KeyAlphabet задают формат ключа (пусто - 8 символов base62).
+	// KeySecret - секрет перестановки feistel, обязателен для неё. Ключи подписи cookie
+	// не подходят: они ротируются, а перестановка должна оставаться прежней.
+	KeyGenerator string `env:"KEY_GENERATOR" json:"key_generator"`
+	KeyLength    int    `env:"KEY_LENGTH" json:"key_length"`
+	KeyAlphabet  string `env:"KEY_ALPHABET" json:"key_alphabet"`
+	KeySecret    string `env:"KEY_SECRET" json:"key_secret"`
+
+	// ReaperInterval - период фоновой пометки истёкших ссылок удалёнными (0 - по умолчанию, минута;
+	// отрицательный - выключено). ReaperBatchSize - число ссылок за один запрос к хранилищу.
+	ReaperInterval  time.Duration `env:"REAPER_INTERVAL" json:"reaper_interval"`
+	ReaperBatchSize int           `env:"REAPER_BATCH_SIZE" json:"reaper_batch_size"`
+
+	// TrashRetention - сколько удалённые ссылки хранятся в корзине до окончательного удаления
+	// (0 и отрицательный - бессрочно, по умолчанию удаление выключено). TrashPurgeInterval - период удаления (по умолчанию час).
+	TrashRetention     time.Duration `env:"TRASH_RETENTION" json:"trash_retention"`
+	TrashPurgeInterval time.Duration `env:"TRASH_PURGE_INTERVAL" json:"trash_purge_interval"`
+
+	// AnalyticsBufferSize - размер буфера событий переходов (0 - по умолчанию; отрицательный - учёт
+	// выключен). AnalyticsFlushInterval - период записи накопленных событий в хранилище.
+	AnalyticsBufferSize    int           `env:"ANALYTICS_BUFFER_SIZE" json:"analytics_buffer_size"`
+	AnalyticsFlushInterval time.Duration `env:"ANALYTICS_FLUSH_INTERVAL" json:"analytics_flush_interval"`
+	// AnalyticsSecret - секрет HMAC, которым хешируются IP клиентов в событиях переходов. Он не связан
+	// с ключами подписи cookie и должен оставаться прежним, иначе хеши тех же адресов изменятся.
+	// Пустой - IP в событиях не сохраняются.
+	AnalyticsSecret string `env:"ANALYTICS_SECRET" json:"analytics_secret"`
+
+	// RedirectType - статус редиректа для ссылок без своего redirect_type: 301, 302, 307 (по умолчанию) или 308.
+	// RedirectCacheMaxAge - сколько секунд клиенты могут кешировать такие редиректы (0 - не кешировать).
+	RedirectType        int   `env:"REDIRECT_TYPE" json:"redirect_type"`
+	RedirectCacheMaxAge int64 `env:"REDIRECT_CACHE_MAX_AGE" json:"redirect_cache_max_age"`
+
+	// CacheSize - сколько ссылок хранит кеш редиректов перед PostgreSQL (0 - кеш выключен).
+	// CacheTTL и CacheNegativeTTL - сроки жизни найденной ссылки и ответа для неизвестного
+	// или удалённого ключа (0 - по умолчанию, минута и 5 секунд).
+	CacheSize        int           `env:"CACHE_SIZE" json:"cache_size"`
+	CacheTTL         time.Duration `env:"CACHE_TTL" json:"cache_ttl"`
+	CacheNegativeTTL time.Duration `env:"CACHE_NEGATIVE_TTL" json:"cache_negative_ttl"`
 }
 
 var (
@@ -36,10 +108,16 @@ var (
 	flagCert         = flag.String("cert", "", "path to TLS cert file")
 	flagKey          = flag.String("key", "", "path to TLS key file")
 	flagRunAddr      = flag.String("a", "", "address and port to run server")
+	flagGRPCAddr     = flag.String("g", "", "address and port to run gRPC server")
 	flagBaseURL      = flag.String("b", "", "base URL for short links")
 	flagSaveInFile   = flag.String("f", "", "if want to save short URL in file")
+	flagBolt         = flag.String("bolt", "", "path to the embedded bolt database file")
 	flagDataBase     = flag.String("d", "", "if want to save short URL in DataBase")
+	flagTrusted      = flag.String("t", "", "trusted subnet in CIDR notation for internal endpoints")
 	flagDataBaseLong = flag.String("database-dsn", "", "DSN to connect to the database")
+	flagSecretKey    = flag.String("k", "", "secret key for signing auth cookie")
+	flagStrict       = flag.Bool("strict", false, "refuse to start if the storage file is corrupted")
+	flagFollow       = flag.Duration("follow", 0, "open the storage file read-only and poll it with this interval")
 	flagCJSON        = flag.String("c", "", "config for the app")
 	flagConfigJSON   = flag.String("config", "", "config for the app")
 )
@@ -92,12 +170,38 @@ func NewConfig() (*Config, error) {
 	if *flagRunAddr != "" {
 		cfg.RunAddr = *flagRunAddr
 	}
+	if *flagGRPCAddr != "" {
+		cfg.GRPCAddr = *flagGRPCAddr
+	}
 	if *flagBaseURL != "" {
 		cfg.BaseURL = *flagBaseURL
 	}
 	if *flagSaveInFile != "" {
 		cfg.SaveInFile = *flagSaveInFile
 	}
+	if *flagBolt != "" {
+		cfg.BoltStoragePath = *flagBolt
+	}
+
+	if *flagStrict {
+		cfg.StrictStorage = true
+	}
+	if *flagFollow > 0 {
+		cfg.FileFollowInterval = *flagFollow
+	}
+
+	if *flagTrusted != "" {
+		cfg.TrustedSubnet = *flagTrusted
+	}
+	if cfg.TrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(cfg.TrustedSubnet); err != nil {
+			return nil, fmt.Errorf("invalid trusted subnet %q: %w", cfg.TrustedSubnet, err)
+		}
+	}
+
+	if *flagSecretKey != "" {
+		cfg.CookieSecretKey = *flagSecretKey
+	}
 
 	if *flagDataBaseLong != "" {
 		cfg.DataBase = *flagDataBaseLong
@@ -112,6 +216,10 @@ func NewConfig() (*Config, error) {
 		cfg.RunAddr = ":" + cfg.RunAddr
 	}
 
+	if cfg.GRPCAddr == "" {
+		cfg.GRPCAddr = ":3200"
+	}
+
 	if cfg.BaseURL == "" {
 		hostPort := cfg.RunAddr
 		if hostPort == ":" {
@@ -136,14 +244,77 @@ func NewConfig() (*Config, error) {
 		}
 	}
 
-	// Генерируем ключ ТОЛЬКО если он не задан через ENV
-	if len(cfg.CookieSecretKey) == 0 {
-		cfg.CookieSecretKey = GenerateKeyToken()
+	switch cfg.RedirectType {
+	case 0:
+		cfg.RedirectType = http.StatusTemporaryRedirect
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, fmt.Errorf("invalid redirect type %d: must be 301, 302, 307 or 308", cfg.RedirectType)
+	}
+	if cfg.RedirectCacheMaxAge < 0 {
+		return nil, fmt.Errorf("invalid redirect cache max age %d", cfg.RedirectCacheMaxAge)
+	}
+	if cfg.CacheSize < 0 {
+		return nil, fmt.Errorf("invalid cache size %d", cfg.CacheSize)
+	}
+	// С другим секретом сохранённая позиция генератора указывала бы на уже выданные ключи
+	if cfg.KeyGenerator == "feistel" && cfg.KeySecret == "" {
+		return nil, fmt.Errorf("key secret is required for the feistel key generator")
+	}
+
+	// Одиночный ключ считается основным и ставится в начало списка.
+	if cfg.CookieSecretKey != "" {
+		cfg.CookieSecretKeys = append([]string{cfg.CookieSecretKey}, cfg.CookieSecretKeys...)
+	}
+	// Пустые элементы списка (например, COOKIE_SECRET_KEYS=",") ключами не считаются.
+	// Заданный, но пустой список - ошибка, а не повод подписывать cookie случайным ключом.
+	configured := len(cfg.CookieSecretKeys) > 0
+	keys := cfg.CookieSecretKeys[:0]
+	for _, k := range cfg.CookieSecretKeys {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	cfg.CookieSecretKeys = keys
+	if configured && len(keys) == 0 {
+		return nil, fmt.Errorf("invalid cookie secret keys: all keys are empty")
 	}
+	// Генерируем ключ ТОЛЬКО если он не задан через файл, ENV или флаг.
+	// Сгенерированный ключ живёт только до перезапуска и не разделяется между экземплярами.
+	if len(cfg.CookieSecretKeys) == 0 {
+		cfg.CookieSecretKeys = []string{hex.EncodeToString(GenerateKeyToken())}
+	}
+	cfg.CookieSecretKey = cfg.CookieSecretKeys[0]
 
 	return cfg, nil
 }
 
+// SigningKeys возвращает ключи подписи cookie в порядке приоритета, основной ключ первый.
+func (c *Config) SigningKeys() [][]byte {
+	keys := make([][]byte, 0, len(c.CookieSecretKeys))
+	for _, k := range c.CookieSecretKeys {
+		keys = append(keys, []byte(k))
+	}
+	return keys
+}
+
+// TrustedNet возвращает доверенную подсеть или nil, если она не задана.
+func (c *Config) TrustedNet() *net.IPNet {
+	if c.TrustedSubnet == "" {
+		return nil
+	}
+	_, subnet, err := net.ParseCIDR(c.TrustedSubnet)
+	if err != nil {
+		return nil
+	}
+	return subnet
+}
+
+// KeySecretBytes возвращает секрет генератора ключей.
+func (c *Config) KeySecretBytes() []byte {
+	return []byte(c.KeySecret)
+}
+
 // GenerateKeyToken generates a random 32-byte key for signing cookies.
 func GenerateKeyToken() []byte {
 	key := make([]byte, 32)
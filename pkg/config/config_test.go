@@ -4,6 +4,7 @@ import (
 	"flag"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestMain(m *testing.M) {
@@ -43,6 +44,12 @@ func TestConfig(t *testing.T) {
 		if cfg.SaveInFile != "" {
 			t.Errorf("Expected empty SaveInFile, got %s", cfg.SaveInFile)
 		}
+		if cfg.GRPCAddr != ":3200" {
+			t.Errorf("Expected GRPCAddr :3200, got %s", cfg.GRPCAddr)
+		}
+		if cfg.RedirectType != 307 {
+			t.Errorf("Expected RedirectType 307, got %d", cfg.RedirectType)
+		}
 	})
 
 	t.Run("Environment variables", func(t *testing.T) {
@@ -93,4 +100,154 @@ func TestConfig(t *testing.T) {
 			t.Errorf("Expected SaveInFile flag.json, got %s", cfg.SaveInFile)
 		}
 	})
+	t.Run("Bolt storage", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("BOLT_STORAGE_PATH", "/tmp/env.db")
+		defer os.Clearenv()
+		*flagRunAddr = ""
+		*flagBaseURL = ""
+		*flagSaveInFile = ""
+		*flagBolt = "flag.db"
+		defer func() { *flagBolt = "" }()
+
+		cfg, err := NewConfig()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if cfg.BoltStoragePath != "flag.db" {
+			t.Errorf("Expected BoltStoragePath flag.db, got %s", cfg.BoltStoragePath)
+		}
+	})
+	t.Run("Invalid redirect type", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("REDIRECT_TYPE", "303")
+		defer os.Clearenv()
+		*flagRunAddr = ""
+		*flagBaseURL = ""
+		*flagSaveInFile = ""
+
+		if _, err := NewConfig(); err == nil {
+			t.Error("Expected error for redirect type 303")
+		}
+	})
+	t.Run("Cache", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("CACHE_SIZE", "10000")
+		os.Setenv("CACHE_TTL", "30s")
+		defer os.Clearenv()
+		*flagRunAddr = ""
+		*flagBaseURL = ""
+		*flagSaveInFile = ""
+
+		cfg, err := NewConfig()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if cfg.CacheSize != 10000 || cfg.CacheTTL != 30*time.Second {
+			t.Errorf("Expected cache of 10000 links for 30s, got %d for %v", cfg.CacheSize, cfg.CacheTTL)
+		}
+
+		os.Setenv("CACHE_SIZE", "-1")
+		if _, err := NewConfig(); err == nil {
+			t.Error("Expected error for negative cache size")
+		}
+	})
+
+	t.Run("Feistel key secret", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("KEY_GENERATOR", "feistel")
+		os.Setenv("COOKIE_SECRET_KEY", "cookie")
+		defer os.Clearenv()
+
+		if _, err := NewConfig(); err == nil {
+			t.Error("Expected error for feistel generator without key secret")
+		}
+
+		os.Setenv("KEY_SECRET", "secret")
+		cfg, err := NewConfig()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if string(cfg.KeySecretBytes()) != "secret" {
+			t.Errorf("Expected key secret secret, got %s", cfg.KeySecretBytes())
+		}
+	})
+}
+
+func TestConfigSigningKeys(t *testing.T) {
+	oldSecretKey := *flagSecretKey
+	defer func() { *flagSecretKey = oldSecretKey }()
+	*flagSecretKey = ""
+
+	t.Run("Generated key", func(t *testing.T) {
+		os.Clearenv()
+
+		cfg, err := NewConfig()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(cfg.SigningKeys()) != 1 {
+			t.Fatalf("Expected one generated key, got %d", len(cfg.SigningKeys()))
+		}
+	})
+
+	t.Run("Primary and old keys", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("COOKIE_SECRET_KEY", "primary")
+		os.Setenv("COOKIE_SECRET_KEYS", "old1,old2")
+		defer os.Clearenv()
+
+		cfg, err := NewConfig()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		keys := cfg.SigningKeys()
+		want := []string{"primary", "old1", "old2"}
+		if len(keys) != len(want) {
+			t.Fatalf("Expected %d keys, got %d", len(want), len(keys))
+		}
+		for i := range want {
+			if string(keys[i]) != want[i] {
+				t.Errorf("Expected key %d to be %s, got %s", i, want[i], keys[i])
+			}
+		}
+	})
+
+	t.Run("Empty keys are dropped", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("COOKIE_SECRET_KEYS", "new,,old")
+		defer os.Clearenv()
+
+		cfg, err := NewConfig()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(cfg.SigningKeys()) != 2 {
+			t.Errorf("Expected 2 keys, got %d", len(cfg.SigningKeys()))
+		}
+	})
+
+	t.Run("Only empty keys", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("COOKIE_SECRET_KEYS", ",")
+		defer os.Clearenv()
+
+		if _, err := NewConfig(); err == nil {
+			t.Error("Expected error for a keys list without keys")
+		}
+	})
+
+	t.Run("Keys list only", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("COOKIE_SECRET_KEYS", "new,old")
+		defer os.Clearenv()
+
+		cfg, err := NewConfig()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if cfg.CookieSecretKey != "new" {
+			t.Errorf("Expected primary key new, got %s", cfg.CookieSecretKey)
+		}
+	})
 }